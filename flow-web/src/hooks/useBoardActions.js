@@ -200,7 +200,7 @@ export function useBoardActions(cfg) {
       const resp = await fetch(`${API_BASE}/api/graph/process`, {
         method: 'POST',
         headers: { 'Content-Type': 'application/json', 'Accept': 'text/event-stream' },
-        body: JSON.stringify({ graph: lastGraphRef.current, verbose: false, stream: true })
+        body: JSON.stringify({ graph: lastGraphRef.current, verbose: false, stream: true, stream_format: 'legacy' })
       })
       if (!resp.ok) throw new Error('process failed')
       const reader = resp.body.getReader()
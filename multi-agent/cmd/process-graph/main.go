@@ -5,41 +5,184 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"time"
 
-	"github.com/joho/godotenv"
-
+	"multi-agent/internal/config"
 	"multi-agent/internal/graphproc"
+	"multi-agent/internal/imagestore"
+	"multi-agent/internal/orchestrator"
+	"multi-agent/internal/runstore"
+	"multi-agent/model"
 )
 
 func main() {
-	if err := godotenv.Load("./.env"); err != nil {
-		fmt.Fprintf(os.Stderr, "[ERROR] load env: %v\n", err)
-		os.Exit(1)
-	}
-
 	var file string
 	var verbose bool
-	flag.StringVar(&file, "file", "../agent-graph.json", "Path to agent graph JSON file")
+	var criticalPath bool
+	var maxAttempts int
+	var attemptTimeout time.Duration
+	var onFailure string
+	var routerMode string
+	var save bool
+	var cassette string
+	var cassetteMode string
+	var promptVersion string
+	var language string
+	flag.StringVar(&file, "file", "", "Path to agent graph JSON file (default: config graph_file, $AGENT_GRAPH_FILE or "+config.DefaultGraphFile+")")
 	flag.BoolVar(&verbose, "verbose", true, "Enable verbose streaming debug output")
+	flag.BoolVar(&criticalPath, "critical-path", false, "Prioritize ready nodes by critical-path length")
+	flag.IntVar(&maxAttempts, "max-attempts", graphproc.DefaultRetryPolicy().MaxAttempts, "Max attempts per model call (1 disables retries)")
+	flag.DurationVar(&attemptTimeout, "attempt-timeout", 0, "Timeout of a single model call attempt (0 = none)")
+	flag.StringVar(&onFailure, "on-failure", graphproc.FailureContinue, "Failure propagation mode: continue, skip-downstream or fail-fast")
+	flag.StringVar(&routerMode, "router", graphproc.RouterHybrid, "Node router: hybrid (rules first, LLM when ambiguous), rule or llm")
+	flag.BoolVar(&save, "save", false, "Persist the run to the run history shared with the HTTP server")
+	flag.StringVar(&cassette, "cassette", "", "Cassette file for recording/replaying model calls (default $"+model.CassetteEnv+")")
+	flag.StringVar(&cassetteMode, "cassette-mode", "", "Cassette mode: off, record or replay (default $"+model.CassetteModeEnv+")")
+	flag.StringVar(&promptVersion, "prompt-version", "", "Prompt template version (default: graph options.prompt_version, $"+graphproc.PromptVersionEnv+" or "+graphproc.DefaultPromptVersion+")")
+	flag.StringVar(&language, "lang", "", "Output and instruction language, e.g. zh or en (default: graph options.language, $"+graphproc.PromptLanguageEnv+" or "+graphproc.DefaultPromptLanguage+")")
+	// -concurrency / -runs-dir 与模型参数由配置层注册：参数 > 环境变量（含 .env）> 配置文件
+	flags := config.RegisterFlags(flag.CommandLine, "concurrency", "token-budget", "pricing-file", "runs-dir", "upload-dir", "vision-image-mode", "model-type", "model", "model-base-url", "max-completion-tokens")
 	flag.Parse()
 
+	cfg, err := config.Load(flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] config: %v\n", err)
+		os.Exit(1)
+	}
+	if file == "" {
+		file = cfg.GraphFile
+	}
+
+	failureMode, err := graphproc.ParseFailureMode(onFailure)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+		os.Exit(1)
+	}
+	if routerMode, err = graphproc.ParseRouterMode(routerMode); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+		os.Exit(1)
+	}
+
 	sg, err := graphproc.ReadSimpleGraph(file)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "[ERROR] read agent graph: %v\n", err)
 		os.Exit(1)
 	}
-	supervisorAgent, textAgent, visionAgent, err := graphproc.BuildAgents()
+	// 执行前校验：警告仅提示，错误直接退出（避免调用模型后才发现环路/悬空边）
+	validation := graphproc.ValidateGraph(sg)
+	for _, w := range validation.Warnings {
+		fmt.Fprintf(os.Stderr, "[WARN] %s: %s\n", w.Code, w.Message)
+	}
+	if !validation.Valid {
+		for _, e := range validation.Errors {
+			fmt.Fprintf(os.Stderr, "[ERROR] %s: %s\n", e.Code, e.Message)
+		}
+		fmt.Fprintf(os.Stderr, "[ERROR] invalid agent graph, refusing to run\n")
+		os.Exit(1)
+	}
+	if err := model.ConfigureCassette(cassetteMode, cassette); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+		os.Exit(1)
+	}
+	if mode, path := model.CassetteMode(); mode != model.CassetteOff {
+		fmt.Fprintf(os.Stderr, "[CASSETTE] mode=%s file=%s\n", mode, path)
+	}
+	agents, err := graphproc.LoadAgentRegistry(cfg.ModelOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] load agent registry: %v\n", err)
+		os.Exit(1)
+	}
+	prompts, err := graphproc.ResolvePromptSet(promptVersion, language, sg.Options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+		os.Exit(1)
+	}
+	supervisorAgent, textAgent, visionAgent, err := agents.BuildAgentsWith(prompts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "[ERROR] build agents: %v\n", err)
 		os.Exit(1)
 	}
 
-	ctx := context.Background()
+	// Ctrl+C 取消执行：停止调度新节点并中断进行中的模型调用
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 	sp := graphproc.NewStreamPrinter()
 	sp.EnableVerbose(verbose)
 
+	var store *runstore.Store
+	if save {
+		if store, err = runstore.Open(cfg.RunsDir); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	pricing, err := graphproc.LoadPricingFile(cfg.PricingFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+		os.Exit(1)
+	}
+
+	// 视觉节点读取与服务共享的图片存储（images.* / upload_dir）
+	images, err := imagestore.New(cfg.ImageStoreOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] image store: %v\n", err)
+		os.Exit(1)
+	}
+	imageLoader, err := graphproc.NewImageLoader(images, cfg.Images.MaxBytes, cfg.Images.MaxSide, cfg.Images.VisionMode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+		os.Exit(1)
+	}
+	// 配置了与服务相同的签名密钥时，url 模式下发给模型的图片链接带短期签名（服务启用认证时需要）
+	if cfg.Images.URLSigningKey != "" {
+		imageLoader.Signer = cfg.ImageURLSigner()
+	}
+
 	results := make(map[string]graphproc.NodeResult, len(sg.Nodes))
-	if err := graphproc.ProcessGraph(ctx, sg, supervisorAgent, textAgent, visionAgent, results, sp); err != nil {
+	opts := graphproc.RunOptions{
+		RunID:                graphproc.NewRunID(),
+		MaxConcurrency:       cfg.MaxConcurrency,
+		TokenBudget:          cfg.TokenBudget,
+		CriticalPathPriority: criticalPath,
+		Retry: &orchestrator.RetryPolicy{
+			MaxAttempts:      maxAttempts,
+			AttemptTimeoutMs: int(attemptTimeout.Milliseconds()),
+		},
+		FailureMode:   failureMode,
+		Router:        routerMode,
+		PromptVersion: prompts.Version,
+		Language:      prompts.Language,
+		Agents:        agents,
+		Images:        imageLoader,
+		Pricing:       pricing,
+	}
+	startedAt := time.Now()
+	err = graphproc.ProcessGraph(ctx, sg, supervisorAgent, textAgent, visionAgent, results, sp, opts)
+	if store != nil {
+		run := runstore.NewRun(opts.RunID, runstore.SourceCLI, sg, opts, results, err, startedAt, time.Now())
+		if serr := store.Save(run); serr != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] save run: %v\n", serr)
+		} else {
+			fmt.Fprintf(os.Stderr, "[RUN] saved id=%s status=%s dir=%s\n", run.ID, run.Status, store.Dir())
+		}
+	}
+	for _, s := range graphproc.SkippedNodes(results) {
+		fmt.Fprintf(os.Stderr, "[SKIPPED] node=%s cause=%s: %s\n", s.NodeID, s.CauseNode, s.Reason)
+	}
+	usage := graphproc.SummarizeUsage(results)
+	fmt.Fprintf(os.Stderr, "[ROUTER] mode=%s rule_routed=%d llm_routed=%d router_tokens=%d\n", routerMode, usage.RuleRoutedNodes, usage.LLMRoutedNodes, usage.SupervisorTotalTokens)
+	if usage.TotalTokens > 0 {
+		fmt.Fprintf(os.Stderr, "[USAGE] router=%d (%s) subagent=%d (%s) total=%d (%s) estimated=%d\n", usage.SupervisorTotalTokens, usage.SupervisorUsageSource, usage.SubAgentTotalTokens, usage.SubAgentUsageSource, usage.TotalTokens, usage.TotalUsageSource, usage.EstimatedTokens)
+	}
+	if usage.TotalCost > 0 {
+		fmt.Fprintf(os.Stderr, "[COST] router=%g subagent=%g total=%g %s\n", usage.SupervisorCost, usage.SubAgentCost, usage.TotalCost, pricing.Currency)
+	}
+	if opts.TokenBudget > 0 {
+		fmt.Fprintf(os.Stderr, "[BUDGET] charged=%d budget=%d\n", graphproc.ChargedTokens(results), opts.TokenBudget)
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "[ERROR] process graph: %v\n", err)
 		os.Exit(1)
 	}
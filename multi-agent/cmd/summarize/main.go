@@ -6,6 +6,7 @@ import (
     "fmt"
     "os"
 
+    "multi-agent/internal/config"
     "multi-agent/internal/orchestrator"
 )
 
@@ -13,9 +14,19 @@ func main() {
     var file string
     var agentOut string
     flag.StringVar(&file, "file", "../board-export.json", "Path to board export JSON file")
-    flag.StringVar(&agentOut, "agent_out", "../agent-graph.json", "Path to write minimal agent graph JSON")
+    flag.StringVar(&agentOut, "agent_out", "", "Path to write minimal agent graph JSON (default: config graph_file, $AGENT_GRAPH_FILE or "+config.DefaultGraphFile+")")
+    flags := config.RegisterFlags(flag.CommandLine)
     flag.Parse()
 
+    cfg, err := config.Load(flags)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "[ERROR] config: %v\n", err)
+        os.Exit(1)
+    }
+    if agentOut == "" {
+        agentOut = cfg.GraphFile
+    }
+
     canon, err := orchestrator.ParseBoardExport(file)
     if err != nil {
         fmt.Fprintf(os.Stderr, "[ERROR] parse board export: %v\n", err)
@@ -0,0 +1,335 @@
+package auth
+
+// 本包实现 HTTP API 的认证：
+// - 静态 API Key：AUTH_API_KEYS（"key:user[:role],..."）或 AUTH_API_KEYS_FILE（JSON，可只保存 key 的 SHA-256）；
+// - 签名 JWT：HS256/384/512（AUTH_JWT_SECRET）或 RS/ES 系列（AUTH_JWT_PUBLIC_KEY_FILE），sub 为用户 ID，
+//   必须携带 exp，role 或 roles 声明包含 admin 时为管理员，可选校验 iss / aud；
+// 凭据来自 "Authorization: Bearer <token>" 或 "X-API-Key: <key>"；
+// 未配置任何认证方式时为匿名模式（所有请求视为管理员 Anonymous，与未启用认证前的行为一致）。
+// 认证得到的 User 通过 WithUser 写入请求上下文，图执行与视觉代理据此检查资源归属。
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// 角色
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// 认证方式
+const (
+	MethodNone   = "none"
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+var (
+	// ErrNoCredentials 请求未携带凭据
+	ErrNoCredentials = errors.New("missing credentials")
+	// ErrInvalidCredentials 凭据无效（未知 key、签名错误、过期等）
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// User 请求的身份
+type User struct {
+	ID     string `json:"id"`
+	Role   string `json:"role"`
+	Method string `json:"method"`
+}
+
+// Anonymous 匿名模式下的身份（管理员）
+var Anonymous = &User{ID: "anonymous", Role: RoleAdmin, Method: MethodNone}
+
+// IsAdmin 是否为管理员
+func (u *User) IsAdmin() bool { return u != nil && u.Role == RoleAdmin }
+
+// CanAccess 是否可访问 owner 所有的资源：管理员可访问全部，其它用户仅可访问自己的资源（无所有者的资源仅管理员可见）
+func (u *User) CanAccess(owner string) bool {
+	if u == nil {
+		return false
+	}
+	return u.IsAdmin() || (owner != "" && owner == u.ID)
+}
+
+type userKey struct{}
+
+// WithUser 将身份写入上下文
+func WithUser(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, userKey{}, u)
+}
+
+// FromContext 返回上下文中的身份；未经认证的上下文（如 CLI）返回 nil
+func FromContext(ctx context.Context) *User {
+	u, _ := ctx.Value(userKey{}).(*User)
+	return u
+}
+
+// Options 认证配置；全部为空时为匿名模式
+type Options struct {
+	// APIKeys "key:user[:role]" 逗号分隔
+	APIKeys string
+	// APIKeysFile JSON 文件 {"keys":[{"key" 或 "key_sha256", "user", "role"}]}
+	APIKeysFile string
+	// JWTSecret HMAC 签名密钥
+	JWTSecret string
+	// JWTPublicKeyFile RSA / ECDSA 公钥（PEM）
+	JWTPublicKeyFile string
+	// JWTIssuer / JWTAudience 非空时要求 iss / aud 匹配
+	JWTIssuer   string
+	JWTAudience string
+}
+
+// Service 认证服务，并发安全（构建后只读）
+type Service struct {
+	// keys API Key 的 SHA-256（十六进制）→ 身份；按摘要查找，避免逐字节比较泄露时序
+	keys map[string]*User
+	jwt  *jwtVerifier
+}
+
+// New 按配置创建认证服务
+func New(opts Options) (*Service, error) {
+	s := &Service{keys: map[string]*User{}}
+	if err := s.addKeySpec(opts.APIKeys); err != nil {
+		return nil, err
+	}
+	if path := strings.TrimSpace(opts.APIKeysFile); path != "" {
+		if err := s.addKeyFile(path); err != nil {
+			return nil, err
+		}
+	}
+	v, err := newJWTVerifier(opts)
+	if err != nil {
+		return nil, err
+	}
+	s.jwt = v
+	return s, nil
+}
+
+// Enabled 是否配置了任一认证方式
+func (s *Service) Enabled() bool { return len(s.keys) > 0 || s.jwt != nil }
+
+// Methods 返回已启用的认证方式
+func (s *Service) Methods() []string {
+	methods := []string{}
+	if len(s.keys) > 0 {
+		methods = append(methods, MethodAPIKey)
+	}
+	if s.jwt != nil {
+		methods = append(methods, MethodJWT)
+	}
+	return methods
+}
+
+// Authenticate 认证请求；匿名模式下返回 Anonymous
+func (s *Service) Authenticate(r *http.Request) (*User, error) {
+	if !s.Enabled() {
+		return Anonymous, nil
+	}
+	if key := strings.TrimSpace(r.Header.Get("X-API-Key")); key != "" {
+		return s.apiKey(key)
+	}
+	h := strings.TrimSpace(r.Header.Get("Authorization"))
+	if h == "" {
+		return nil, ErrNoCredentials
+	}
+	scheme, token, ok := strings.Cut(h, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return nil, ErrInvalidCredentials
+	}
+	token = strings.TrimSpace(token)
+	// JWT 为三段式（header.payload.signature），其余按 API Key 处理
+	if s.jwt != nil && strings.Count(token, ".") == 2 {
+		return s.jwt.verify(token)
+	}
+	return s.apiKey(token)
+}
+
+func (s *Service) apiKey(key string) (*User, error) {
+	if u, ok := s.keys[keyDigest(key)]; ok {
+		return u, nil
+	}
+	return nil, ErrInvalidCredentials
+}
+
+func keyDigest(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// addKeySpec 解析 "key:user[:role],..."
+func (s *Service) addKeySpec(spec string) error {
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		parts := strings.Split(item, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return fmt.Errorf("invalid API key entry (want key:user[:role])")
+		}
+		role := ""
+		if len(parts) == 3 {
+			role = parts[2]
+		}
+		if err := s.addKey(keyDigest(strings.TrimSpace(parts[0])), parts[1], role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyFile API Key 文件；key 与 key_sha256 二选一（推荐后者，文件中不保存明文）
+type keyFile struct {
+	Keys []struct {
+		Key       string `json:"key"`
+		KeySHA256 string `json:"key_sha256"`
+		User      string `json:"user"`
+		Role      string `json:"role"`
+	} `json:"keys"`
+}
+
+func (s *Service) addKeyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read API key file: %w", err)
+	}
+	var f keyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("parse API key file %s: %w", path, err)
+	}
+	for i, k := range f.Keys {
+		digest := strings.ToLower(strings.TrimSpace(k.KeySHA256))
+		switch {
+		case k.Key != "" && digest != "":
+			return fmt.Errorf("API key file %s: keys[%d] has both key and key_sha256", path, i)
+		case k.Key != "":
+			digest = keyDigest(k.Key)
+		case len(digest) != sha256.Size*2:
+			return fmt.Errorf("API key file %s: keys[%d] needs key or a hex key_sha256", path, i)
+		}
+		if err := s.addKey(digest, k.User, k.Role); err != nil {
+			return fmt.Errorf("API key file %s: keys[%d]: %w", path, i, err)
+		}
+	}
+	return nil
+}
+
+func (s *Service) addKey(digest, user, role string) error {
+	user = strings.TrimSpace(user)
+	if user == "" {
+		return fmt.Errorf("API key without user")
+	}
+	role, err := parseRole(role)
+	if err != nil {
+		return err
+	}
+	if _, dup := s.keys[digest]; dup {
+		return fmt.Errorf("duplicate API key for user %q", user)
+	}
+	s.keys[digest] = &User{ID: user, Role: role, Method: MethodAPIKey}
+	return nil
+}
+
+func parseRole(role string) (string, error) {
+	switch r := strings.ToLower(strings.TrimSpace(role)); r {
+	case "", RoleUser:
+		return RoleUser, nil
+	case RoleAdmin:
+		return RoleAdmin, nil
+	default:
+		return "", fmt.Errorf("unknown role %q (want %s or %s)", role, RoleUser, RoleAdmin)
+	}
+}
+
+// jwtVerifier 校验签名 JWT
+type jwtVerifier struct {
+	secret    []byte
+	publicKey interface{}
+	issuer    string
+	audience  string
+}
+
+func newJWTVerifier(opts Options) (*jwtVerifier, error) {
+	v := &jwtVerifier{issuer: strings.TrimSpace(opts.JWTIssuer), audience: strings.TrimSpace(opts.JWTAudience)}
+	if opts.JWTSecret != "" {
+		v.secret = []byte(opts.JWTSecret)
+	}
+	if path := strings.TrimSpace(opts.JWTPublicKeyFile); path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read JWT public key: %w", err)
+		}
+		if key, err := jwt.ParseRSAPublicKeyFromPEM(pem); err == nil {
+			v.publicKey = key
+		} else if key, err := jwt.ParseECPublicKeyFromPEM(pem); err == nil {
+			v.publicKey = key
+		} else {
+			return nil, fmt.Errorf("JWT public key %s is neither an RSA nor an ECDSA PEM key", path)
+		}
+	}
+	if v.secret == nil && v.publicKey == nil {
+		return nil, nil
+	}
+	return v, nil
+}
+
+// keyFunc 按签名算法选择密钥；算法与已配置的密钥类型不符时拒绝（防止 alg 混淆）
+func (v *jwtVerifier) keyFunc(t *jwt.Token) (interface{}, error) {
+	switch t.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if v.secret != nil {
+			return v.secret, nil
+		}
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		if v.publicKey != nil {
+			return v.publicKey, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected signing method %s", t.Header["alg"])
+}
+
+// verify 校验签名与 exp / nbf / iat；没有 exp 的令牌永不过期，一律拒绝
+func (v *jwtVerifier) verify(token string) (*User, error) {
+	claims := jwt.MapClaims{}
+	t, err := jwt.ParseWithClaims(token, claims, v.keyFunc)
+	if err != nil || !t.Valid {
+		return nil, ErrInvalidCredentials
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, ErrInvalidCredentials
+	}
+	if v.issuer != "" && !claims.VerifyIssuer(v.issuer, true) {
+		return nil, ErrInvalidCredentials
+	}
+	if v.audience != "" && !claims.VerifyAudience(v.audience, true) {
+		return nil, ErrInvalidCredentials
+	}
+	sub, _ := claims["sub"].(string)
+	if strings.TrimSpace(sub) == "" {
+		return nil, ErrInvalidCredentials
+	}
+	u := &User{ID: sub, Role: RoleUser, Method: MethodJWT}
+	if role, _ := claims["role"].(string); strings.EqualFold(role, RoleAdmin) {
+		u.Role = RoleAdmin
+	}
+	if roles, ok := claims["roles"].([]interface{}); ok {
+		for _, r := range roles {
+			if s, _ := r.(string); strings.EqualFold(s, RoleAdmin) {
+				u.Role = RoleAdmin
+			}
+		}
+	}
+	return u, nil
+}
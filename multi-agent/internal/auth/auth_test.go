@@ -0,0 +1,358 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// request 构建带认证头的请求；header 为 "Name: value"
+func request(header string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "/api/me", nil)
+	if name, value, ok := strings.Cut(header, ": "); ok {
+		r.Header.Set(name, value)
+	}
+	return r
+}
+
+func bearer(token string) *http.Request { return request("Authorization: Bearer " + token) }
+
+func mustNew(t *testing.T, opts Options) *Service {
+	t.Helper()
+	s, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return s
+}
+
+func TestAPIKeySpec(t *testing.T) {
+	s := mustNew(t, Options{APIKeys: "k1:alice, k2:root:admin ,,k3 : bob : ADMIN,k4:carol:user"})
+	tests := []struct {
+		key, user, role string
+	}{
+		{"k1", "alice", RoleUser},
+		{"k2", "root", RoleAdmin},
+		{"k3", "bob", RoleAdmin},
+		{"k4", "carol", RoleUser},
+	}
+	for _, tt := range tests {
+		for _, r := range []*http.Request{bearer(tt.key), request("X-API-Key: " + tt.key)} {
+			u, err := s.Authenticate(r)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.key, err)
+			}
+			if *u != (User{ID: tt.user, Role: tt.role, Method: MethodAPIKey}) {
+				t.Errorf("%s: got %+v, want %s/%s", tt.key, u, tt.user, tt.role)
+			}
+		}
+	}
+	if _, err := s.Authenticate(bearer("k5")); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("unknown key: %v", err)
+	}
+	if got := s.Methods(); len(got) != 1 || got[0] != MethodAPIKey {
+		t.Errorf("Methods = %v", got)
+	}
+
+	for _, spec := range []string{"k1", "k1:alice:admin:extra", "k1:alice:owner", "k1: ", "k1:alice,k1:bob"} {
+		if _, err := New(Options{APIKeys: spec}); err == nil {
+			t.Errorf("spec %q: want error", spec)
+		} else if strings.Contains(err.Error(), "k1") {
+			t.Errorf("spec %q: error %q leaks the key", spec, err)
+		}
+	}
+}
+
+func TestAuthenticateHeaders(t *testing.T) {
+	anon := mustNew(t, Options{})
+	if anon.Enabled() {
+		t.Fatal("no options: want anonymous mode")
+	}
+	if u, err := anon.Authenticate(request("")); err != nil || u != Anonymous || !u.IsAdmin() {
+		t.Errorf("anonymous mode: %+v, %v", u, err)
+	}
+
+	s := mustNew(t, Options{APIKeys: "k1:alice"})
+	tests := []struct {
+		header string
+		err    error
+	}{
+		{"", ErrNoCredentials},
+		{"Authorization: Basic azE6", ErrInvalidCredentials},
+		{"Authorization: Bearer", ErrInvalidCredentials},
+		{"Authorization: Bearer    ", ErrInvalidCredentials},
+		{"Authorization: bearer k1", nil},
+		{"Authorization: Bearer k1 ", nil},
+		{"X-API-Key: wrong", ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		_, err := s.Authenticate(request(tt.header))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%q: err = %v, want %v", tt.header, err, tt.err)
+		}
+	}
+}
+
+func TestAPIKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	// 文件只保存 key 的 SHA-256（大写十六进制同样接受），明文 key 仅由客户端持有
+	digest := strings.ToUpper(keyDigest("secret-key"))
+	path := write("keys.json", `{"keys": [
+		{"key_sha256": "`+digest+`", "user": "alice", "role": "admin"},
+		{"key": "plain-key", "user": "bob"}
+	]}`)
+	s := mustNew(t, Options{APIKeysFile: path, APIKeys: "k1:carol"})
+	for key, want := range map[string]User{
+		"secret-key": {ID: "alice", Role: RoleAdmin, Method: MethodAPIKey},
+		"plain-key":  {ID: "bob", Role: RoleUser, Method: MethodAPIKey},
+		"k1":         {ID: "carol", Role: RoleUser, Method: MethodAPIKey},
+	} {
+		u, err := s.Authenticate(bearer(key))
+		if err != nil || *u != want {
+			t.Errorf("%s: %+v, %v; want %+v", key, u, err, want)
+		}
+	}
+	// 摘要本身不能作为 key 使用
+	if _, err := s.Authenticate(bearer(digest)); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("digest as key: %v", err)
+	}
+
+	for name, content := range map[string]string{
+		"both":      `{"keys": [{"key": "a", "key_sha256": "` + keyDigest("a") + `", "user": "u"}]}`,
+		"short":     `{"keys": [{"key_sha256": "abcd", "user": "u"}]}`,
+		"neither":   `{"keys": [{"user": "u"}]}`,
+		"no user":   `{"keys": [{"key": "a"}]}`,
+		"bad role":  `{"keys": [{"key": "a", "user": "u", "role": "root"}]}`,
+		"duplicate": `{"keys": [{"key": "a", "user": "u"}, {"key_sha256": "` + keyDigest("a") + `", "user": "v"}]}`,
+		"json":      `{"keys": `,
+	} {
+		if _, err := New(Options{APIKeysFile: write(name+".json", content)}); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+	if _, err := New(Options{APIKeysFile: filepath.Join(dir, "missing.json")}); err == nil {
+		t.Error("missing file: want error")
+	}
+}
+
+// sign 以 method 与 key 签发令牌
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	s, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return s
+}
+
+func TestJWTClaims(t *testing.T) {
+	secret := []byte("hmac-secret")
+	s := mustNew(t, Options{JWTSecret: string(secret)})
+	now := time.Now()
+	exp := now.Add(time.Hour).Unix()
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		want   *User
+	}{
+		{name: "user", claims: jwt.MapClaims{"sub": "alice", "exp": exp}, want: &User{ID: "alice", Role: RoleUser, Method: MethodJWT}},
+		{name: "role admin", claims: jwt.MapClaims{"sub": "root", "exp": exp, "role": "Admin"}, want: &User{ID: "root", Role: RoleAdmin, Method: MethodJWT}},
+		{name: "roles admin", claims: jwt.MapClaims{"sub": "root", "exp": exp, "roles": []string{"user", "admin"}}, want: &User{ID: "root", Role: RoleAdmin, Method: MethodJWT}},
+		{name: "other roles", claims: jwt.MapClaims{"sub": "bob", "exp": exp, "role": "editor", "roles": []string{"viewer"}}, want: &User{ID: "bob", Role: RoleUser, Method: MethodJWT}},
+		{name: "missing exp", claims: jwt.MapClaims{"sub": "alice"}},
+		{name: "expired", claims: jwt.MapClaims{"sub": "alice", "exp": now.Add(-time.Minute).Unix()}},
+		{name: "exp not a number", claims: jwt.MapClaims{"sub": "alice", "exp": "tomorrow"}},
+		{name: "not yet valid", claims: jwt.MapClaims{"sub": "alice", "exp": exp, "nbf": now.Add(time.Hour).Unix()}},
+		{name: "issued in the future", claims: jwt.MapClaims{"sub": "alice", "exp": exp, "iat": now.Add(time.Hour).Unix()}},
+		{name: "missing sub", claims: jwt.MapClaims{"exp": exp}},
+		{name: "blank sub", claims: jwt.MapClaims{"sub": " ", "exp": exp}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := s.Authenticate(bearer(sign(t, jwt.SigningMethodHS256, secret, tt.claims)))
+			if tt.want == nil {
+				if !errors.Is(err, ErrInvalidCredentials) {
+					t.Fatalf("got %+v, %v; want invalid credentials", u, err)
+				}
+				return
+			}
+			if err != nil || *u != *tt.want {
+				t.Fatalf("got %+v, %v; want %+v", u, err, tt.want)
+			}
+		})
+	}
+
+	// 其它 HMAC 强度与错误密钥
+	claims := jwt.MapClaims{"sub": "alice", "exp": exp}
+	if _, err := s.Authenticate(bearer(sign(t, jwt.SigningMethodHS512, secret, claims))); err != nil {
+		t.Errorf("HS512: %v", err)
+	}
+	if _, err := s.Authenticate(bearer(sign(t, jwt.SigningMethodHS256, []byte("other"), claims))); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("wrong secret: %v", err)
+	}
+}
+
+func TestJWTIssuerAudience(t *testing.T) {
+	secret := []byte("hmac-secret")
+	s := mustNew(t, Options{JWTSecret: string(secret), JWTIssuer: "https://idp.example.com", JWTAudience: "multi-agent"})
+	exp := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		ok     bool
+	}{
+		{name: "match", claims: jwt.MapClaims{"iss": "https://idp.example.com", "aud": "multi-agent"}, ok: true},
+		{name: "audience list", claims: jwt.MapClaims{"iss": "https://idp.example.com", "aud": []string{"other", "multi-agent"}}, ok: true},
+		{name: "missing iss", claims: jwt.MapClaims{"aud": "multi-agent"}},
+		{name: "wrong iss", claims: jwt.MapClaims{"iss": "https://evil.example.com", "aud": "multi-agent"}},
+		{name: "missing aud", claims: jwt.MapClaims{"iss": "https://idp.example.com"}},
+		{name: "wrong aud", claims: jwt.MapClaims{"iss": "https://idp.example.com", "aud": "other"}},
+		{name: "audience list without match", claims: jwt.MapClaims{"iss": "https://idp.example.com", "aud": []string{"a", "b"}}},
+	}
+	for _, tt := range tests {
+		tt.claims["sub"], tt.claims["exp"] = "alice", exp
+		_, err := s.Authenticate(bearer(sign(t, jwt.SigningMethodHS256, secret, tt.claims)))
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%s: err = %v, want invalid credentials", tt.name, err)
+		}
+	}
+}
+
+// writePublicKey 将公钥以 PKIX PEM 写入临时文件
+func writePublicKey(t *testing.T, pub interface{}) (string, []byte) {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+func TestJWTPublicKeys(t *testing.T) {
+	claims := jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPath, rsaPEM := writePublicKey(t, &rsaKey.PublicKey)
+	s := mustNew(t, Options{JWTPublicKeyFile: rsaPath})
+	for _, m := range []jwt.SigningMethod{jwt.SigningMethodRS256, jwt.SigningMethodPS256} {
+		if _, err := s.Authenticate(bearer(sign(t, m, rsaKey, claims))); err != nil {
+			t.Errorf("%s: %v", m.Alg(), err)
+		}
+	}
+	// alg 混淆：以公钥 PEM 作为 HMAC 密钥签发的 HS256 令牌须被拒绝
+	if _, err := s.Authenticate(bearer(sign(t, jwt.SigningMethodHS256, rsaPEM, claims))); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("HS256 signed with the RSA public key: %v", err)
+	}
+	// alg=none
+	none := sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims)
+	if _, err := s.Authenticate(bearer(none)); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("alg none: %v", err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPath, _ := writePublicKey(t, &ecKey.PublicKey)
+	s = mustNew(t, Options{JWTPublicKeyFile: ecPath})
+	if _, err := s.Authenticate(bearer(sign(t, jwt.SigningMethodES256, ecKey, claims))); err != nil {
+		t.Errorf("ES256: %v", err)
+	}
+	// 仅配置 HMAC 密钥时拒绝非对称算法的令牌
+	s = mustNew(t, Options{JWTSecret: "hmac-secret"})
+	if _, err := s.Authenticate(bearer(sign(t, jwt.SigningMethodES256, ecKey, claims))); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("ES256 with only a secret configured: %v", err)
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.pem")
+	os.WriteFile(bad, []byte("not a key"), 0o600)
+	if _, err := New(Options{JWTPublicKeyFile: bad}); err == nil {
+		t.Error("invalid PEM: want error")
+	}
+}
+
+func TestKeyFunc(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		v      *jwtVerifier
+		method jwt.SigningMethod
+		ok     bool
+	}{
+		{name: "hmac with secret", v: &jwtVerifier{secret: []byte("s")}, method: jwt.SigningMethodHS256, ok: true},
+		{name: "rsa with secret", v: &jwtVerifier{secret: []byte("s")}, method: jwt.SigningMethodRS256},
+		{name: "hmac with public key", v: &jwtVerifier{publicKey: &rsaKey.PublicKey}, method: jwt.SigningMethodHS256},
+		{name: "rsa with public key", v: &jwtVerifier{publicKey: &rsaKey.PublicKey}, method: jwt.SigningMethodRS512, ok: true},
+		{name: "pss with public key", v: &jwtVerifier{publicKey: &rsaKey.PublicKey}, method: jwt.SigningMethodPS384, ok: true},
+		{name: "none", v: &jwtVerifier{secret: []byte("s"), publicKey: &rsaKey.PublicKey}, method: jwt.SigningMethodNone},
+	}
+	for _, tt := range tests {
+		token := &jwt.Token{Method: tt.method, Header: map[string]interface{}{"alg": tt.method.Alg()}}
+		key, err := tt.v.keyFunc(token)
+		if tt.ok != (err == nil) {
+			t.Errorf("%s: key=%v err=%v, want ok=%v", tt.name, key != nil, err, tt.ok)
+		}
+	}
+}
+
+func TestCanAccess(t *testing.T) {
+	alice := &User{ID: "alice", Role: RoleUser}
+	admin := &User{ID: "root", Role: RoleAdmin}
+	var nobody *User
+	tests := []struct {
+		user  *User
+		owner string
+		want  bool
+	}{
+		{alice, "alice", true},
+		{alice, "bob", false},
+		// 无所有者的资源（如启用认证前创建的 run）仅管理员可访问
+		{alice, "", false},
+		{admin, "", true},
+		{admin, "bob", true},
+		{Anonymous, "", true},
+		{nobody, "", false},
+		{nobody, "alice", false},
+	}
+	for _, tt := range tests {
+		if got := tt.user.CanAccess(tt.owner); got != tt.want {
+			t.Errorf("%+v.CanAccess(%q) = %v, want %v", tt.user, tt.owner, got, tt.want)
+		}
+	}
+	if nobody.IsAdmin() {
+		t.Error("nil user is admin")
+	}
+}
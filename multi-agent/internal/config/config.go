@@ -0,0 +1,823 @@
+package config
+
+// 本包集中管理服务与 CLI 的配置：
+// - 来源优先级：命令行参数 > 环境变量（含 .env）> 配置文件（JSON，-config 或 CONFIG_FILE）> 默认值；
+// - 覆盖监听地址、对外访问地址、CORS 来源、图片存储与上传限制、数据目录、模型设置、并发上限、认证、token 预算、用户额度与价格表；
+// - 生效配置由调用方显式传给各组件（模型工厂、图片存储、上传限制、图片读取器等），本包不修改进程环境变量；
+// - Public() 返回不含密钥的生效配置及每项来源，供 /api/config 展示。
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"multi-agent/internal/imagestore"
+	"multi-agent/model"
+
+	"github.com/joho/godotenv"
+)
+
+// FileEnv 配置文件路径的环境变量（-config 参数优先）
+const FileEnv = "CONFIG_FILE"
+
+// 默认值
+const (
+	DefaultListen    = ":8080"
+	DefaultUploadDir = "uploads"
+	DefaultDataDir   = "data"
+	DefaultGraphFile = "../agent-graph.json"
+	// DefaultMaxCompletionTokens 每次模型调用生成的 token 上限（也是预算与额度为每次调用预留的输出量）
+	DefaultMaxCompletionTokens = 4096
+)
+
+// 图片与上传限制的默认值
+const (
+	// DefaultImageURLTTL 图片签名链接的默认有效期
+	DefaultImageURLTTL = "15m"
+	// DefaultImageMaxBytes 附加到消息的图片字节上限（与 graphproc.DefaultImageMaxBytes 相同）
+	DefaultImageMaxBytes = 4 << 20
+	// DefaultImageMaxSide 图片最长边像素上限（与 graphproc.DefaultImageMaxSide 相同）
+	DefaultImageMaxSide      = 1568
+	DefaultUploadMaxBytes    = 10 << 20
+	DefaultFetchTimeout      = "15s"
+	DefaultFetchMaxRedirects = 3
+)
+
+// DefaultUploadAllowedTypes 默认允许上传与导入的图片 MIME 类型
+var DefaultUploadAllowedTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+// DefaultCORSOrigins 默认允许的前端来源（flow-web 开发服务器）
+var DefaultCORSOrigins = []string{"http://localhost:5173", "http://127.0.0.1:5173"}
+
+// 配置来源
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// ModelConfig 模型设置；Name / BaseURL / APIKey 对应的环境变量随 Type 变化（ARK_* / OPENAI_* / FAKE_MODEL）
+type ModelConfig struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	BaseURL string `json:"base_url"`
+	APIKey  string `json:"api_key"`
+	// ByAzure 使用 Azure OpenAI（仅 openai 类型）
+	ByAzure bool `json:"by_azure"`
+	// MaxCompletionTokens 每次调用生成的 token 上限；0 表示不限（由模型服务决定）
+	MaxCompletionTokens int `json:"max_completion_tokens"`
+}
+
+// ImagesConfig 图片存储（见 internal/imagestore，本地目录为 Config.UploadDir）与视觉节点的图片设置（见 graphproc/image.go）；
+// S3AccessKey、S3SecretKey 与 URLSigningKey 为密钥，不在 /api/config 中展示
+type ImagesConfig struct {
+	// Store 存储后端：local（默认）/ s3
+	Store string `json:"store"`
+	// S3Endpoint 等为 s3 后端的端点（含协议）、桶、区域、访问密钥、对象键前缀与路径风格寻址
+	S3Endpoint  string `json:"s3_endpoint"`
+	S3Bucket    string `json:"s3_bucket"`
+	S3Region    string `json:"s3_region"`
+	S3AccessKey string `json:"s3_access_key"`
+	S3SecretKey string `json:"s3_secret_key"`
+	S3Prefix    string `json:"s3_prefix"`
+	S3PathStyle bool   `json:"s3_path_style"`
+	// MaxBytes 附加到消息的图片字节上限；0 使用 DefaultImageMaxBytes
+	MaxBytes int `json:"max_bytes"`
+	// MaxSide 图片最长边像素上限，超出时等比缩小；0 表示不按尺寸缩小
+	MaxSide int `json:"max_side"`
+	// VisionMode 视觉节点的图片传递方式：url（默认）/ inline
+	VisionMode string `json:"vision_mode"`
+	// URLSigningKey 图片签名链接（见 imagestore.URLSigner）的 HMAC 密钥；为空时服务启动时随机生成（重启后已签发的链接失效）。
+	// CLI 须与服务配置相同的密钥，其签发的链接才能被服务接受
+	URLSigningKey string `json:"url_signing_key"`
+	// URLTTL 签名链接的有效期（Go duration，如 "15m"）
+	URLTTL string `json:"url_ttl"`
+}
+
+// UploadConfig 图片上传与 URL 导入的限制（见 httpserver/upload.go）
+type UploadConfig struct {
+	// MaxBytes 单张图片的字节上限（上传与 URL 导入）；0 使用 DefaultUploadMaxBytes
+	MaxBytes int `json:"max_bytes"`
+	// AllowedTypes 允许的图片 MIME 类型
+	AllowedTypes []string `json:"allowed_types"`
+	// FetchTimeout URL 导入的总超时（Go duration，如 "15s"）
+	FetchTimeout string `json:"fetch_timeout"`
+	// FetchMaxRedirects URL 导入允许的重定向次数
+	FetchMaxRedirects int `json:"fetch_max_redirects"`
+	// FetchAllowPrivate 为 true 时允许导入私有/回环地址（仅用于本地开发）
+	FetchAllowPrivate bool `json:"fetch_allow_private"`
+}
+
+// AuthConfig 认证设置（见 internal/auth）；全部为空时为匿名模式。APIKeys 与 JWTSecret 为密钥，不在 /api/config 中展示
+type AuthConfig struct {
+	// APIKeys "key:user[:role]" 逗号分隔
+	APIKeys string `json:"api_keys"`
+	// APIKeysFile API Key 文件（JSON）
+	APIKeysFile string `json:"api_keys_file"`
+	// JWTSecret HS256 等 HMAC 签名密钥
+	JWTSecret string `json:"jwt_secret"`
+	// JWTPublicKeyFile RS / ES 签名公钥（PEM）
+	JWTPublicKeyFile string `json:"jwt_public_key_file"`
+	JWTIssuer        string `json:"jwt_issuer"`
+	JWTAudience      string `json:"jwt_audience"`
+}
+
+// QuotaConfig 每个用户的 token 额度（见 internal/quota）；0 表示不限
+type QuotaConfig struct {
+	DailyTokens   int `json:"daily_tokens"`
+	MonthlyTokens int `json:"monthly_tokens"`
+}
+
+// Config 生效配置
+type Config struct {
+	// Listen HTTP 监听地址，如 ":8080"、"127.0.0.1:9000"
+	Listen string `json:"listen"`
+	// PublicBaseURL 对外访问地址（如 https://agents.example.com），用于生成图片等绝对链接；
+	// 为空时按请求的 X-Forwarded-Proto / X-Forwarded-Host（或 Host）推导
+	PublicBaseURL string `json:"public_base_url"`
+	// CORSOrigins 允许的跨域来源；包含 "*" 时允许任意来源（此时不允许携带凭据）
+	CORSOrigins []string `json:"cors_origins"`
+	// UploadDir 本地图片存储目录（images.store=local）
+	UploadDir string `json:"upload_dir"`
+	// Images 图片存储与视觉节点的图片设置
+	Images ImagesConfig `json:"images"`
+	// Upload 上传与 URL 导入限制
+	Upload UploadConfig `json:"upload"`
+	// DataDir 数据目录；RunsDir 未配置时为 <DataDir>/runs
+	DataDir string `json:"data_dir"`
+	// RunsDir 运行历史目录
+	RunsDir string `json:"runs_dir"`
+	// GraphFile CLI 读写的代理图文件（summarize 输出，process-graph 输入）
+	GraphFile string `json:"graph_file"`
+	// MaxConcurrency 请求未指定时的全局并发上限；<=0 使用 graphproc.DefaultMaxConcurrency
+	MaxConcurrency int `json:"max_concurrency"`
+	// TokenBudget 请求未指定时单次 run 的 token 预算；0 表示不限
+	TokenBudget int `json:"token_budget"`
+	// UsageFile 用户 token 用量文件；未配置时为 <DataDir>/usage.json
+	UsageFile string `json:"usage_file"`
+	// Quota 每个用户的日/月 token 额度
+	Quota QuotaConfig `json:"quota"`
+	// PricingFile 模型价格表（JSON，见 graphproc.Pricing）；为空时不计费
+	PricingFile string `json:"pricing_file"`
+	// Model 模型设置
+	Model ModelConfig `json:"model"`
+	// Auth 认证设置
+	Auth AuthConfig `json:"auth"`
+
+	// File 实际加载的配置文件路径（为空表示未使用配置文件）
+	File string `json:"-"`
+	// Sources 每项配置的来源（default / file / env / flag），键为配置项名称
+	Sources map[string]string `json:"-"`
+}
+
+// Defaults 返回默认配置
+func Defaults() Config {
+	return Config{
+		Listen:      DefaultListen,
+		CORSOrigins: append([]string(nil), DefaultCORSOrigins...),
+		UploadDir:   DefaultUploadDir,
+		Images: ImagesConfig{
+			Store:       imagestore.BackendLocal,
+			S3PathStyle: true,
+			MaxBytes:    DefaultImageMaxBytes,
+			MaxSide:     DefaultImageMaxSide,
+			VisionMode:  "url",
+			URLTTL:      DefaultImageURLTTL,
+		},
+		Upload: UploadConfig{
+			MaxBytes:          DefaultUploadMaxBytes,
+			AllowedTypes:      append([]string(nil), DefaultUploadAllowedTypes...),
+			FetchTimeout:      DefaultFetchTimeout,
+			FetchMaxRedirects: DefaultFetchMaxRedirects,
+		},
+		Model:     ModelConfig{MaxCompletionTokens: DefaultMaxCompletionTokens},
+		DataDir:   DefaultDataDir,
+		GraphFile: DefaultGraphFile,
+	}
+}
+
+// setting 一个可由环境变量与命令行参数覆盖的配置项
+type setting struct {
+	// key 配置项名称（与配置文件中的键一致，嵌套字段用 "."）
+	key string
+	// env 环境变量名；envFor 非空时按模型类型选择
+	env    string
+	envFor func(c *Config) string
+	// flag 命令行参数名；为空表示不提供参数（如密钥）
+	flag  string
+	usage string
+	get   func(c *Config) string
+	set   func(c *Config, v string) error
+}
+
+// settings 按顺序应用；model.type 须位于其它模型设置之前（后者的环境变量名取决于类型）
+var settings = []setting{
+	{key: "listen", env: "LISTEN_ADDR", flag: "listen", usage: "HTTP listen address",
+		get: func(c *Config) string { return c.Listen },
+		set: func(c *Config, v string) error { c.Listen = v; return nil }},
+	{key: "public_base_url", env: "PUBLIC_BASE_URL", flag: "public-url", usage: "Public base URL used for absolute links (default: derived from X-Forwarded-* / Host)",
+		get: func(c *Config) string { return c.PublicBaseURL },
+		set: func(c *Config, v string) error { c.PublicBaseURL = v; return nil }},
+	{key: "cors_origins", env: "CORS_ORIGINS", flag: "cors-origins", usage: "Comma-separated allowed CORS origins (* allows any)",
+		get: func(c *Config) string { return strings.Join(c.CORSOrigins, ",") },
+		set: func(c *Config, v string) error { c.CORSOrigins = splitList(v); return nil }},
+	{key: "upload_dir", env: "IMAGE_DIR", flag: "upload-dir", usage: "Local image store directory",
+		get: func(c *Config) string { return c.UploadDir },
+		set: func(c *Config, v string) error { c.UploadDir = v; return nil }},
+	{key: "images.store", env: "IMAGE_STORE", usage: "Image store backend: local or s3",
+		get: func(c *Config) string { return c.Images.Store },
+		set: func(c *Config, v string) error {
+			switch b := strings.ToLower(strings.TrimSpace(v)); b {
+			case "", imagestore.BackendLocal, imagestore.BackendS3:
+				c.Images.Store = b
+				return nil
+			default:
+				return fmt.Errorf("invalid images.store %q (want local or s3)", v)
+			}
+		}},
+	{key: "images.s3_endpoint", env: "IMAGE_S3_ENDPOINT",
+		get: func(c *Config) string { return c.Images.S3Endpoint },
+		set: func(c *Config, v string) error { c.Images.S3Endpoint = v; return nil }},
+	{key: "images.s3_bucket", env: "IMAGE_S3_BUCKET",
+		get: func(c *Config) string { return c.Images.S3Bucket },
+		set: func(c *Config, v string) error { c.Images.S3Bucket = v; return nil }},
+	{key: "images.s3_region", env: "IMAGE_S3_REGION",
+		get: func(c *Config) string { return c.Images.S3Region },
+		set: func(c *Config, v string) error { c.Images.S3Region = v; return nil }},
+	{key: "images.s3_access_key", env: "IMAGE_S3_ACCESS_KEY",
+		get: func(c *Config) string { return c.Images.S3AccessKey },
+		set: func(c *Config, v string) error { c.Images.S3AccessKey = v; return nil }},
+	{key: "images.s3_secret_key", env: "IMAGE_S3_SECRET_KEY",
+		get: func(c *Config) string { return c.Images.S3SecretKey },
+		set: func(c *Config, v string) error { c.Images.S3SecretKey = v; return nil }},
+	{key: "images.s3_prefix", env: "IMAGE_S3_PREFIX",
+		get: func(c *Config) string { return c.Images.S3Prefix },
+		set: func(c *Config, v string) error { c.Images.S3Prefix = v; return nil }},
+	boolSetting(setting{key: "images.s3_path_style", env: "IMAGE_S3_PATH_STYLE"},
+		func(c *Config) *bool { return &c.Images.S3PathStyle }),
+	intSetting(setting{key: "images.max_bytes", env: "IMAGE_MAX_BYTES", usage: "Max bytes of an image attached to a model message"},
+		func(c *Config) *int { return &c.Images.MaxBytes }),
+	intSetting(setting{key: "images.max_side", env: "IMAGE_MAX_SIDE", usage: "Max image side in pixels before downscaling (0 = no limit)"},
+		func(c *Config) *int { return &c.Images.MaxSide }),
+	{key: "images.vision_mode", env: "VISION_IMAGE_MODE", flag: "vision-image-mode", usage: "How vision nodes receive images: url or inline",
+		get: func(c *Config) string { return c.Images.VisionMode },
+		set: func(c *Config, v string) error {
+			switch m := strings.ToLower(strings.TrimSpace(v)); m {
+			case "", "url", "inline":
+				c.Images.VisionMode = m
+				return nil
+			default:
+				return fmt.Errorf("invalid images.vision_mode %q (want url or inline)", v)
+			}
+		}},
+	{key: "images.url_signing_key", env: "IMAGE_URL_SIGNING_KEY",
+		get: func(c *Config) string { return c.Images.URLSigningKey },
+		set: func(c *Config, v string) error { c.Images.URLSigningKey = v; return nil }},
+	durationSetting(setting{key: "images.url_ttl", env: "IMAGE_URL_TTL", usage: "Lifetime of signed image links (Go duration)"},
+		func(c *Config) *string { return &c.Images.URLTTL }),
+	intSetting(setting{key: "upload.max_bytes", env: "UPLOAD_MAX_BYTES", usage: "Max bytes of an uploaded or imported image"},
+		func(c *Config) *int { return &c.Upload.MaxBytes }),
+	{key: "upload.allowed_types", env: "UPLOAD_ALLOWED_TYPES", usage: "Comma-separated allowed image MIME types",
+		get: func(c *Config) string { return strings.Join(c.Upload.AllowedTypes, ",") },
+		set: func(c *Config, v string) error {
+			types := splitList(strings.ToLower(v))
+			for _, t := range types {
+				if !strings.HasPrefix(t, "image/") {
+					return fmt.Errorf("invalid upload.allowed_types: %q is not an image type", t)
+				}
+			}
+			c.Upload.AllowedTypes = types
+			return nil
+		}},
+	durationSetting(setting{key: "upload.fetch_timeout", env: "IMAGE_FETCH_TIMEOUT", usage: "Total timeout of a URL import (Go duration)"},
+		func(c *Config) *string { return &c.Upload.FetchTimeout }),
+	intSetting(setting{key: "upload.fetch_max_redirects", env: "IMAGE_FETCH_MAX_REDIRECTS", usage: "Max redirects followed by a URL import"},
+		func(c *Config) *int { return &c.Upload.FetchMaxRedirects }),
+	boolSetting(setting{key: "upload.fetch_allow_private", env: "IMAGE_FETCH_ALLOW_PRIVATE", usage: "Allow URL imports from private/loopback addresses (local development only)"},
+		func(c *Config) *bool { return &c.Upload.FetchAllowPrivate }),
+	{key: "data_dir", env: "DATA_DIR", flag: "data-dir", usage: "Data directory (run history defaults to <data-dir>/runs)",
+		get: func(c *Config) string { return c.DataDir },
+		set: func(c *Config, v string) error { c.DataDir = v; return nil }},
+	{key: "runs_dir", env: "RUN_STORE_DIR", flag: "runs-dir", usage: "Run history directory",
+		get: func(c *Config) string { return c.RunsDir },
+		set: func(c *Config, v string) error { c.RunsDir = v; return nil }},
+	{key: "graph_file", env: "AGENT_GRAPH_FILE", usage: "Agent graph JSON file used by the CLIs",
+		get: func(c *Config) string { return c.GraphFile },
+		set: func(c *Config, v string) error { c.GraphFile = v; return nil }},
+	intSetting(setting{key: "max_concurrency", env: "MAX_CONCURRENCY", flag: "concurrency", usage: "Max number of nodes executed concurrently (0 = built-in default)"},
+		func(c *Config) *int { return &c.MaxConcurrency }),
+	intSetting(setting{key: "token_budget", env: "TOKEN_BUDGET", flag: "token-budget", usage: "Default token budget per run (0 = unlimited)"},
+		func(c *Config) *int { return &c.TokenBudget }),
+	{key: "usage_file", env: "USAGE_FILE", usage: "Per-user token usage file",
+		get: func(c *Config) string { return c.UsageFile },
+		set: func(c *Config, v string) error { c.UsageFile = v; return nil }},
+	intSetting(setting{key: "quota.daily_tokens", env: "QUOTA_DAILY_TOKENS", flag: "quota-daily", usage: "Daily token quota per user (0 = unlimited)"},
+		func(c *Config) *int { return &c.Quota.DailyTokens }),
+	intSetting(setting{key: "quota.monthly_tokens", env: "QUOTA_MONTHLY_TOKENS", flag: "quota-monthly", usage: "Monthly token quota per user (0 = unlimited)"},
+		func(c *Config) *int { return &c.Quota.MonthlyTokens }),
+	{key: "pricing_file", env: "PRICING_FILE", flag: "pricing-file", usage: "Model pricing table (JSON)",
+		get: func(c *Config) string { return c.PricingFile },
+		set: func(c *Config, v string) error { c.PricingFile = v; return nil }},
+	{key: "model.type", env: "MODEL_TYPE", flag: "model-type", usage: "Model provider: openai, ark or fake",
+		get: func(c *Config) string { return c.Model.Type },
+		set: func(c *Config, v string) error {
+			switch t := strings.ToLower(strings.TrimSpace(v)); t {
+			case "", "openai", "ark", "fake":
+				c.Model.Type = t
+				return nil
+			default:
+				return fmt.Errorf("invalid model.type %q (want openai, ark or fake)", v)
+			}
+		}},
+	{key: "model.name", envFor: modelEnv("ARK_MODEL", "OPENAI_MODEL", "FAKE_MODEL"), flag: "model", usage: "Model name",
+		get: func(c *Config) string { return c.Model.Name },
+		set: func(c *Config, v string) error { c.Model.Name = v; return nil }},
+	{key: "model.base_url", envFor: modelEnv("ARK_BASE_URL", "OPENAI_BASE_URL", ""), flag: "model-base-url", usage: "Model API base URL",
+		get: func(c *Config) string { return c.Model.BaseURL },
+		set: func(c *Config, v string) error { c.Model.BaseURL = v; return nil }},
+	{key: "model.api_key", envFor: modelEnv("ARK_API_KEY", "OPENAI_API_KEY", ""),
+		get: func(c *Config) string { return c.Model.APIKey },
+		set: func(c *Config, v string) error { c.Model.APIKey = v; return nil }},
+	boolSetting(setting{key: "model.by_azure", envFor: modelEnv("", "OPENAI_BY_AZURE", "")},
+		func(c *Config) *bool { return &c.Model.ByAzure }),
+	intSetting(setting{key: "model.max_completion_tokens", env: model.MaxCompletionTokensEnv, flag: "max-completion-tokens", usage: "Max tokens generated per model call, also reserved per call by token budgets and quotas (0 = provider default)"},
+		func(c *Config) *int { return &c.Model.MaxCompletionTokens }),
+	{key: "auth.api_keys", env: "AUTH_API_KEYS",
+		get: func(c *Config) string { return c.Auth.APIKeys },
+		set: func(c *Config, v string) error { c.Auth.APIKeys = v; return nil }},
+	{key: "auth.api_keys_file", env: "AUTH_API_KEYS_FILE", flag: "auth-keys-file", usage: "API key file (JSON)",
+		get: func(c *Config) string { return c.Auth.APIKeysFile },
+		set: func(c *Config, v string) error { c.Auth.APIKeysFile = v; return nil }},
+	{key: "auth.jwt_secret", env: "AUTH_JWT_SECRET",
+		get: func(c *Config) string { return c.Auth.JWTSecret },
+		set: func(c *Config, v string) error { c.Auth.JWTSecret = v; return nil }},
+	{key: "auth.jwt_public_key_file", env: "AUTH_JWT_PUBLIC_KEY_FILE", flag: "jwt-public-key", usage: "PEM public key verifying RS/ES-signed JWTs",
+		get: func(c *Config) string { return c.Auth.JWTPublicKeyFile },
+		set: func(c *Config, v string) error { c.Auth.JWTPublicKeyFile = v; return nil }},
+	{key: "auth.jwt_issuer", env: "AUTH_JWT_ISSUER",
+		get: func(c *Config) string { return c.Auth.JWTIssuer },
+		set: func(c *Config, v string) error { c.Auth.JWTIssuer = v; return nil }},
+	{key: "auth.jwt_audience", env: "AUTH_JWT_AUDIENCE",
+		get: func(c *Config) string { return c.Auth.JWTAudience },
+		set: func(c *Config, v string) error { c.Auth.JWTAudience = v; return nil }},
+}
+
+// intSetting 为非负整数配置项补全 get / set
+func intSetting(s setting, field func(c *Config) *int) setting {
+	s.get = func(c *Config) string { return strconv.Itoa(*field(c)) }
+	s.set = func(c *Config, v string) error {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid %s %q", s.key, v)
+		}
+		*field(c) = n
+		return nil
+	}
+	return s
+}
+
+// boolSetting 为布尔配置项补全 get / set（接受 strconv.ParseBool 的取值）
+func boolSetting(s setting, field func(c *Config) *bool) setting {
+	s.get = func(c *Config) string { return strconv.FormatBool(*field(c)) }
+	s.set = func(c *Config, v string) error {
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", s.key, v)
+		}
+		*field(c) = b
+		return nil
+	}
+	return s
+}
+
+// durationSetting 为时长配置项补全 get / set（Go duration 字符串，须为正）
+func durationSetting(s setting, field func(c *Config) *string) setting {
+	s.get = func(c *Config) string { return *field(c) }
+	s.set = func(c *Config, v string) error {
+		v = strings.TrimSpace(v)
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			return fmt.Errorf("invalid %s %q", s.key, v)
+		}
+		*field(c) = v
+		return nil
+	}
+	return s
+}
+
+// modelEnv 按模型类型选择环境变量名（类型为空时为 openai）
+func modelEnv(ark, openai, fake string) func(c *Config) string {
+	return func(c *Config) string {
+		switch c.Model.Type {
+		case "ark":
+			return ark
+		case "fake":
+			return fake
+		default:
+			return openai
+		}
+	}
+}
+
+func (s setting) envName(c *Config) string {
+	if s.envFor != nil {
+		return s.envFor(c)
+	}
+	return s.env
+}
+
+// Flags 注册到 FlagSet 的配置参数；仅显式设置的参数覆盖其它来源
+type Flags struct {
+	fs     *flag.FlagSet
+	file   string
+	values map[string]*string
+}
+
+// RegisterFlags 注册 -config 与 names 指定的配置参数（如 "listen"、"model-type"）；须在 fs.Parse 之前调用
+func RegisterFlags(fs *flag.FlagSet, names ...string) *Flags {
+	f := &Flags{fs: fs, values: map[string]*string{}}
+	fs.StringVar(&f.file, "config", "", "Config file (JSON, default $"+FileEnv+")")
+	want := map[string]bool{}
+	for _, n := range names {
+		want[n] = true
+	}
+	for _, s := range settings {
+		if s.flag == "" || !want[s.flag] {
+			continue
+		}
+		f.values[s.flag] = fs.String(s.flag, "", s.usage+" (overrides config file and $"+s.envHint()+")")
+	}
+	return f
+}
+
+func (s setting) envHint() string {
+	if s.envFor == nil {
+		return s.env
+	}
+	var names []string
+	for _, t := range []string{"openai", "ark", "fake"} {
+		if n := s.envFor(&Config{Model: ModelConfig{Type: t}}); n != "" {
+			names = append(names, n)
+		}
+	}
+	return strings.Join(names, "/$")
+}
+
+// set 返回显式设置的参数值
+func (f *Flags) set(name string) (string, bool) {
+	if f == nil || f.values[name] == nil {
+		return "", false
+	}
+	found := false
+	f.fs.Visit(func(fl *flag.Flag) {
+		if fl.Name == name {
+			found = true
+		}
+	})
+	return *f.values[name], found
+}
+
+// Load 加载 .env（存在时，不覆盖已有环境变量）、配置文件、环境变量与命令行参数（须在 fs.Parse 之后调用）；flags 可为 nil
+func Load(flags *Flags) (Config, error) {
+	_ = godotenv.Load("./.env")
+
+	cfg := Defaults()
+	cfg.Sources = map[string]string{}
+	for _, s := range settings {
+		cfg.Sources[s.key] = SourceDefault
+	}
+
+	path := strings.TrimSpace(os.Getenv(FileEnv))
+	if flags != nil && strings.TrimSpace(flags.file) != "" {
+		path = strings.TrimSpace(flags.file)
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return cfg, err
+		}
+	}
+
+	for _, s := range settings {
+		if name := s.envName(&cfg); name != "" {
+			if v, ok := os.LookupEnv(name); ok && strings.TrimSpace(v) != "" {
+				if err := s.set(&cfg, strings.TrimSpace(v)); err != nil {
+					return cfg, fmt.Errorf("$%s: %w", name, err)
+				}
+				cfg.Sources[s.key] = SourceEnv
+			}
+		}
+		if v, ok := flags.set(s.flag); ok {
+			if err := s.set(&cfg, strings.TrimSpace(v)); err != nil {
+				return cfg, fmt.Errorf("-%s: %w", s.flag, err)
+			}
+			cfg.Sources[s.key] = SourceFlag
+		}
+	}
+
+	if err := cfg.normalize(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// loadFile 以 JSON 读取配置文件并覆盖默认值；未知字段视为错误（避免拼写错误被静默忽略）
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	for _, s := range settings {
+		// 嵌套配置项（model.* / auth.* / quota.*）在对应对象中查找
+		ok := false
+		if section, sub, nested := strings.Cut(s.key, "."); nested {
+			var obj map[string]json.RawMessage
+			if m, has := raw[section]; has && json.Unmarshal(m, &obj) == nil {
+				_, ok = obj[sub]
+			}
+		} else {
+			_, ok = raw[s.key]
+		}
+		if ok {
+			// 经 set 统一校验与规范化
+			if err := s.set(c, s.get(c)); err != nil {
+				return fmt.Errorf("config file %s: %w", path, err)
+			}
+			c.Sources[s.key] = SourceFile
+		}
+	}
+	c.File = path
+	return nil
+}
+
+// normalize 校验并补全派生值
+func (c *Config) normalize() error {
+	if strings.TrimSpace(c.Listen) == "" {
+		c.Listen = DefaultListen
+	}
+	if c.PublicBaseURL = strings.TrimRight(strings.TrimSpace(c.PublicBaseURL), "/"); c.PublicBaseURL != "" {
+		u, err := url.Parse(c.PublicBaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid public_base_url %q (want http(s)://host[/path])", c.PublicBaseURL)
+		}
+	}
+	if strings.TrimSpace(c.UploadDir) == "" {
+		c.UploadDir = DefaultUploadDir
+	}
+	if c.Images.Store == "" {
+		c.Images.Store = imagestore.BackendLocal
+	}
+	if c.Images.MaxBytes == 0 {
+		c.Images.MaxBytes = DefaultImageMaxBytes
+	}
+	if c.Images.VisionMode == "" {
+		c.Images.VisionMode = "url"
+	}
+	if strings.TrimSpace(c.Images.URLTTL) == "" {
+		c.Images.URLTTL = DefaultImageURLTTL
+	}
+	if c.Upload.MaxBytes == 0 {
+		c.Upload.MaxBytes = DefaultUploadMaxBytes
+	}
+	if len(c.Upload.AllowedTypes) == 0 {
+		c.Upload.AllowedTypes = append([]string(nil), DefaultUploadAllowedTypes...)
+	}
+	if strings.TrimSpace(c.Upload.FetchTimeout) == "" {
+		c.Upload.FetchTimeout = DefaultFetchTimeout
+	}
+	if strings.TrimSpace(c.DataDir) == "" {
+		c.DataDir = DefaultDataDir
+	}
+	if strings.TrimSpace(c.RunsDir) == "" {
+		c.RunsDir = filepath.Join(c.DataDir, "runs")
+	}
+	if strings.TrimSpace(c.UsageFile) == "" {
+		c.UsageFile = filepath.Join(c.DataDir, "usage.json")
+	}
+	if strings.TrimSpace(c.GraphFile) == "" {
+		c.GraphFile = DefaultGraphFile
+	}
+	return nil
+}
+
+// ModelOptions 返回模型工厂使用的模型设置（见 model.Config）
+func (c Config) ModelOptions() model.Config {
+	return model.Config{Type: c.Model.Type, Name: c.Model.Name, BaseURL: c.Model.BaseURL, APIKey: c.Model.APIKey, ByAzure: c.Model.ByAzure, MaxCompletionTokens: c.Model.MaxCompletionTokens}
+}
+
+// ImageURLSigner 返回图片签名链接的签名器（见 imagestore.URLSigner）
+func (c Config) ImageURLSigner() *imagestore.URLSigner {
+	ttl, _ := time.ParseDuration(c.Images.URLTTL)
+	return imagestore.NewURLSigner([]byte(c.Images.URLSigningKey), ttl)
+}
+
+// ImageStoreOptions 返回图片存储设置（见 imagestore.New）
+func (c Config) ImageStoreOptions() imagestore.Options {
+	return imagestore.Options{
+		Backend: c.Images.Store,
+		Dir:     c.UploadDir,
+		S3: imagestore.S3Config{
+			Endpoint:  c.Images.S3Endpoint,
+			Bucket:    c.Images.S3Bucket,
+			Region:    c.Images.S3Region,
+			AccessKey: c.Images.S3AccessKey,
+			SecretKey: c.Images.S3SecretKey,
+			Prefix:    c.Images.S3Prefix,
+			PathStyle: c.Images.S3PathStyle,
+		},
+	}
+}
+
+// Enabled 是否配置了任一认证方式
+func (a AuthConfig) Enabled() bool {
+	return strings.TrimSpace(a.APIKeys+a.APIKeysFile+a.JWTSecret+a.JWTPublicKeyFile) != ""
+}
+
+// AllowAllOrigins 是否允许任意跨域来源
+func (c Config) AllowAllOrigins() bool {
+	for _, o := range c.CORSOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// BaseURL 返回对外访问地址：优先使用配置的 public_base_url，否则按 X-Forwarded-Proto / X-Forwarded-Host /
+// X-Forwarded-Prefix（反向代理）或请求的 TLS 状态与 Host 推导
+func (c Config) BaseURL(r *http.Request) string {
+	if c.PublicBaseURL != "" {
+		return c.PublicBaseURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if p := firstHeaderValue(r.Header.Get("X-Forwarded-Proto")); p == "http" || p == "https" {
+		scheme = p
+	}
+	host := r.Host
+	if h := firstHeaderValue(r.Header.Get("X-Forwarded-Host")); h != "" {
+		host = h
+	}
+	base := scheme + "://" + host
+	if prefix := strings.TrimRight(firstHeaderValue(r.Header.Get("X-Forwarded-Prefix")), "/"); strings.HasPrefix(prefix, "/") {
+		base += prefix
+	}
+	return base
+}
+
+// firstHeaderValue 取逗号分隔头部的第一个值（多级代理时为最外层）
+func firstHeaderValue(v string) string {
+	if i := strings.Index(v, ","); i >= 0 {
+		v = v[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(v))
+}
+
+// PublicModel /api/config 中的模型设置（不含密钥）
+type PublicModel struct {
+	Type      string `json:"type"`
+	Name      string `json:"name,omitempty"`
+	BaseURL   string `json:"base_url,omitempty"`
+	APIKeySet bool   `json:"api_key_set"`
+	// MaxCompletionTokens 每次调用生成的 token 上限；0 表示不限
+	MaxCompletionTokens int `json:"max_completion_tokens"`
+}
+
+// PublicImages /api/config 中的图片设置（不含 S3 密钥）
+type PublicImages struct {
+	Store            string `json:"store"`
+	S3Endpoint       string `json:"s3_endpoint,omitempty"`
+	S3Bucket         string `json:"s3_bucket,omitempty"`
+	S3Region         string `json:"s3_region,omitempty"`
+	S3Prefix         string `json:"s3_prefix,omitempty"`
+	S3CredentialsSet bool   `json:"s3_credentials_set"`
+	MaxBytes         int    `json:"max_bytes"`
+	MaxSide          int    `json:"max_side"`
+	VisionMode       string `json:"vision_mode"`
+	URLSigningKeySet bool   `json:"url_signing_key_set"`
+	URLTTL           string `json:"url_ttl"`
+}
+
+// PublicAuth /api/config 中的认证设置（不含密钥）
+type PublicAuth struct {
+	Enabled          bool   `json:"enabled"`
+	APIKeys          bool   `json:"api_keys"`
+	JWT              bool   `json:"jwt"`
+	JWTPublicKeyFile string `json:"jwt_public_key_file,omitempty"`
+	JWTIssuer        string `json:"jwt_issuer,omitempty"`
+	JWTAudience      string `json:"jwt_audience,omitempty"`
+}
+
+// PublicConfig /api/config 返回的生效配置（不含密钥）
+type PublicConfig struct {
+	Listen         string            `json:"listen"`
+	PublicBaseURL  string            `json:"public_base_url"`
+	CORSOrigins    []string          `json:"cors_origins"`
+	UploadDir      string            `json:"upload_dir"`
+	Images         PublicImages      `json:"images"`
+	Upload         UploadConfig      `json:"upload"`
+	DataDir        string            `json:"data_dir"`
+	RunsDir        string            `json:"runs_dir"`
+	MaxConcurrency int               `json:"max_concurrency"`
+	TokenBudget    int               `json:"token_budget"`
+	UsageFile      string            `json:"usage_file"`
+	Quota          QuotaConfig       `json:"quota"`
+	PricingFile    string            `json:"pricing_file,omitempty"`
+	Model          PublicModel       `json:"model"`
+	Auth           PublicAuth        `json:"auth"`
+	ConfigFile     string            `json:"config_file,omitempty"`
+	Sources        map[string]string `json:"sources"`
+}
+
+// Public 返回可公开的生效配置
+func (c Config) Public() PublicConfig {
+	modelType := c.Model.Type
+	if modelType == "" {
+		modelType = "openai"
+	}
+	base := c.Model.BaseURL
+	if u, err := url.Parse(base); err == nil && u.User != nil {
+		// 地址中的用户信息可能含凭据
+		u.User = nil
+		base = u.String()
+	}
+	upload := c.Upload
+	upload.AllowedTypes = append([]string(nil), c.Upload.AllowedTypes...)
+	sources := make(map[string]string, len(c.Sources))
+	for k, v := range c.Sources {
+		sources[k] = v
+	}
+	return PublicConfig{
+		Listen:        c.Listen,
+		PublicBaseURL: c.PublicBaseURL,
+		CORSOrigins:   append([]string(nil), c.CORSOrigins...),
+		UploadDir:     c.UploadDir,
+		Images: PublicImages{
+			Store:            c.Images.Store,
+			S3Endpoint:       c.Images.S3Endpoint,
+			S3Bucket:         c.Images.S3Bucket,
+			S3Region:         c.Images.S3Region,
+			S3Prefix:         c.Images.S3Prefix,
+			S3CredentialsSet: c.Images.S3AccessKey != "" || c.Images.S3SecretKey != "",
+			MaxBytes:         c.Images.MaxBytes,
+			MaxSide:          c.Images.MaxSide,
+			VisionMode:       c.Images.VisionMode,
+			URLSigningKeySet: c.Images.URLSigningKey != "",
+			URLTTL:           c.Images.URLTTL,
+		},
+		Upload:         upload,
+		DataDir:        c.DataDir,
+		RunsDir:        c.RunsDir,
+		MaxConcurrency: c.MaxConcurrency,
+		TokenBudget:    c.TokenBudget,
+		UsageFile:      c.UsageFile,
+		Quota:          c.Quota,
+		PricingFile:    c.PricingFile,
+		Model: PublicModel{
+			Type:                modelType,
+			Name:                c.Model.Name,
+			BaseURL:             base,
+			APIKeySet:           c.Model.APIKey != "",
+			MaxCompletionTokens: c.Model.MaxCompletionTokens,
+		},
+		Auth: PublicAuth{
+			Enabled:          c.Auth.Enabled(),
+			APIKeys:          c.Auth.APIKeys != "" || c.Auth.APIKeysFile != "",
+			JWT:              c.Auth.JWTSecret != "" || c.Auth.JWTPublicKeyFile != "",
+			JWTPublicKeyFile: c.Auth.JWTPublicKeyFile,
+			JWTIssuer:        c.Auth.JWTIssuer,
+			JWTAudience:      c.Auth.JWTAudience,
+		},
+		ConfigFile: c.File,
+		Sources:    sources,
+	}
+}
+
+// splitList 按逗号拆分并去除空项
+func splitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
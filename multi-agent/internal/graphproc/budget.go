@@ -0,0 +1,127 @@
+package graphproc
+
+// 本文件实现单次 run 的 token 预算：
+// - RunOptions.TokenBudget：一次 run 可消耗的 token 上限（路由与子代理阶段合计，含重试）；<=0 表示不限
+// - RunOptions.Quota：run 之外的额度（如用户日/月配额，见 internal/quota），与预算同时检查、同时记账
+// - 每次模型调用前 reserve：在同一把锁内按“已用量 + 进行中调用的预留 + 本次输入的估算值 + 输出上限”检查并预留，
+//   超出时不发起调用，节点记为 budget_exceeded；并发节点因此不会同时通过检查而共同超出预算；
+//   输出上限为模型的 max_completion_tokens（见 AgentRegistry.MaxCompletionTokens），模型按同一上限截断输出，
+//   因此一次调用的实际消耗不会超出其预留；
+// - 输入按调用所用模型的分词器计数（分词器不认识的模型按经验规则估算，见 estimate.go）；
+// - 每次调用结束后 settle：释放预留，按模型上报的用量记账，未上报时使用本地估算（见 estimate.go）；
+// - 预算或额度用尽后中止 run：运行中的节点被中断，未完成的节点均记为 budget_exceeded。
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// NodeStatusBudgetExceeded 因 token 预算或额度用尽而未执行（或被中断）的节点状态
+const NodeStatusBudgetExceeded = "budget_exceeded"
+
+// DefaultCompletionAllowance 模型未限制输出长度时每次调用为输出预留的 token
+const DefaultCompletionAllowance = 4096
+
+// ErrBudgetExceeded token 预算或额度用尽
+var ErrBudgetExceeded = errors.New("token budget exceeded")
+
+// TokenQuota run 之外的 token 额度；实现须并发安全，且 Reserve 的检查与预留须是原子的
+type TokenQuota interface {
+	// Remaining 返回已记账之外的剩余额度（不扣除预留）；ok 为 false 表示不限
+	Remaining() (n int, ok bool)
+	// Reserve 检查并预留 n 个 token；剩余额度扣除其他预留后不足 n 时返回错误且不预留
+	Reserve(n int) error
+	// Settle 释放此前预留的 reserved 个 token，并记入实际消耗的 actual 个
+	Settle(reserved, actual int)
+}
+
+// tokenBudget 记录一次 run 已消耗与预留的 token，并按预算与额度检查
+type tokenBudget struct {
+	limit int
+	quota TokenQuota
+	// completion 每次调用在输入估算之外为输出预留的 token
+	completion int
+
+	mu   sync.Mutex
+	used int
+	// reserved 进行中的模型调用预留的 token
+	reserved int
+}
+
+func newTokenBudget(limit int, quota TokenQuota, completion int) *tokenBudget {
+	if limit < 0 {
+		limit = 0
+	}
+	return &tokenBudget{limit: limit, quota: quota, completion: max(completion, 0)}
+}
+
+// reserve 在模型调用前检查并预留本次调用的估算输入与输出上限：已用量、其他调用的预留与本次预留之和超出预算，
+// 或额度不足时返回包装了 ErrBudgetExceeded 的错误且不预留。返回值为实际预留的数量，须交给 settle 结清。
+func (b *tokenBudget) reserve(input int) (int, error) {
+	estimate := max(input, 0) + b.completion
+	if estimate < 1 {
+		estimate = 1
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limit > 0 && b.used+b.reserved+estimate > b.limit {
+		return 0, fmt.Errorf("%w: %d of %d tokens used, %d reserved by calls in flight, next call needs about %d", ErrBudgetExceeded, b.used, b.limit, b.reserved, estimate)
+	}
+	if b.quota != nil {
+		if err := b.quota.Reserve(estimate); err != nil {
+			return 0, fmt.Errorf("%w: user %v", ErrBudgetExceeded, err)
+		}
+	}
+	b.reserved += estimate
+	return estimate, nil
+}
+
+// exhausted 预算或额度已用尽时返回包装了 ErrBudgetExceeded 的错误
+func (b *tokenBudget) exhausted() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limit > 0 && b.used >= b.limit {
+		return fmt.Errorf("%w: %d of %d tokens used", ErrBudgetExceeded, b.used, b.limit)
+	}
+	if b.quota != nil {
+		if left, ok := b.quota.Remaining(); ok && left <= 0 {
+			return fmt.Errorf("%w: user quota used up", ErrBudgetExceeded)
+		}
+	}
+	return nil
+}
+
+// settle 结清一次 reserve：释放预留的 reserved 个 token，记入实际消耗的 actual 个（同时结清额度）
+func (b *tokenBudget) settle(reserved, actual int) {
+	actual = max(actual, 0)
+	b.mu.Lock()
+	b.reserved -= reserved
+	b.used += actual
+	b.mu.Unlock()
+	if b.quota != nil {
+		b.quota.Settle(reserved, actual)
+	}
+}
+
+// usageTokens 返回一次调用的记账 token：优先使用上报（或已估算）的用量，没有用量时（如调用失败）按 model 的分词器计数输入与输出文本
+func usageTokens(u *TokenUsage, model, input, output string) int {
+	if u != nil {
+		if u.TotalTokens > 0 {
+			return u.TotalTokens
+		}
+		if n := u.PromptTokens + u.CompletionTokens; n > 0 {
+			return n
+		}
+	}
+	return CountTokens(model, input) + CountTokens(model, output)
+}
+
+// ChargedTokens 汇总各节点计入预算的 token（rerun 复用或覆盖的节点未执行，不计入）
+func ChargedTokens(results map[string]NodeResult) int {
+	total := 0
+	for _, r := range results {
+		total += r.ChargedTokens
+	}
+	return total
+}
@@ -0,0 +1,190 @@
+package graphproc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"multi-agent/model"
+)
+
+// fakeQuota 内存额度：Reserve 在锁内检查并预留，记录 Settle 的调用
+type fakeQuota struct {
+	mu       sync.Mutex
+	left     int
+	reserved int
+	settled  int
+}
+
+func (q *fakeQuota) Remaining() (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.left, true
+}
+
+func (q *fakeQuota) Reserve(n int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if n > q.left-q.reserved {
+		return fmt.Errorf("%d tokens left", q.left-q.reserved)
+	}
+	q.reserved += n
+	return nil
+}
+
+func (q *fakeQuota) Settle(reserved, actual int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reserved -= reserved
+	q.left -= actual
+	q.settled++
+}
+
+// reserveConcurrently 让 n 个 goroutine 同时各预留 estimate，返回成功的次数与预留值
+func reserveConcurrently(b *tokenBudget, n, estimate int) (int, []int) {
+	var ok atomic.Int32
+	var mu sync.Mutex
+	var got []int
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			r, err := b.reserve(estimate)
+			if err != nil {
+				if !errors.Is(err, ErrBudgetExceeded) {
+					panic(err)
+				}
+				return
+			}
+			ok.Add(1)
+			mu.Lock()
+			got = append(got, r)
+			mu.Unlock()
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return int(ok.Load()), got
+}
+
+func TestTokenBudgetReserveIsAtomic(t *testing.T) {
+	// 预算 100，50 个并发调用各需约 10：只有 10 个能通过，不会因同时检查而共同超出
+	b := newTokenBudget(100, nil, 0)
+	ok, reserved := reserveConcurrently(b, 50, 10)
+	if ok != 10 {
+		t.Fatalf("%d reservations succeeded, want 10", ok)
+	}
+	if _, err := b.reserve(1); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("reserve with budget fully reserved: err = %v", err)
+	}
+	// 实际用量低于估算时，结清后释放的余量可再次预留
+	for _, r := range reserved {
+		b.settle(r, 4)
+	}
+	if b.used != 40 || b.reserved != 0 {
+		t.Fatalf("used=%d reserved=%d, want 40 / 0", b.used, b.reserved)
+	}
+	if _, err := b.reserve(60); err != nil {
+		t.Fatalf("reserve 60 of remaining 60: %v", err)
+	}
+	if _, err := b.reserve(1); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("reserve past the limit: err = %v", err)
+	}
+}
+
+func TestTokenBudgetQuotaReservation(t *testing.T) {
+	q := &fakeQuota{left: 30}
+	b := newTokenBudget(0, q, 0)
+	ok, reserved := reserveConcurrently(b, 20, 10)
+	if ok != 3 {
+		t.Fatalf("%d reservations succeeded against a quota of 30, want 3", ok)
+	}
+	_, err := b.reserve(5)
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("reserve with quota fully reserved: err = %v", err)
+	}
+	if b.reserved != 30 {
+		t.Errorf("budget reserved %d after a rejected quota reservation, want 30", b.reserved)
+	}
+	for _, r := range reserved {
+		b.settle(r, 12)
+	}
+	if q.reserved != 0 || q.left != -6 || q.settled != 3 {
+		t.Errorf("quota reserved=%d left=%d settled=%d, want 0 / -6 / 3", q.reserved, q.left, q.settled)
+	}
+	if err := b.exhausted(); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("exhausted() = %v after quota overrun", err)
+	}
+}
+
+func TestTokenBudgetUnlimited(t *testing.T) {
+	b := newTokenBudget(-5, nil, 0)
+	for range 3 {
+		r, err := b.reserve(0)
+		if err != nil || r != 1 {
+			t.Fatalf("reserve(0) = %d, %v; want 1, nil", r, err)
+		}
+		b.settle(r, 1000)
+	}
+	if err := b.exhausted(); err != nil {
+		t.Errorf("unlimited budget exhausted: %v", err)
+	}
+}
+
+func TestTokenBudgetReservesCompletion(t *testing.T) {
+	// 每次调用预留输入估算 + 输出上限 40
+	q := &fakeQuota{left: 200}
+	b := newTokenBudget(100, q, 40)
+	r, err := b.reserve(30)
+	if err != nil || r != 70 {
+		t.Fatalf("reserve(30) = %d, %v; want 70, nil", r, err)
+	}
+	if q.reserved != 70 {
+		t.Errorf("quota reserved %d, want 70", q.reserved)
+	}
+	// 剩余 30 不足以容纳下一次调用的输出上限
+	if _, err := b.reserve(1); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("reserve(1) with 30 left: err = %v", err)
+	}
+	b.settle(r, 45)
+	if r, err = b.reserve(10); err != nil || r != 50 {
+		t.Fatalf("reserve(10) after settle = %d, %v; want 50, nil", r, err)
+	}
+}
+
+func TestProcessGraphReservesCompletionAllowance(t *testing.T) {
+	tests := []struct {
+		name       string
+		completion int
+		want       string
+		calls      int
+	}{
+		// 输入估算远小于 1000，但输出上限 2000 超出预算：不调用模型
+		{name: "allowance exceeds budget", completion: 2000, want: NodeStatusBudgetExceeded},
+		{name: "allowance fits", completion: 100, want: NodeStatusSucceeded, calls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := newFakeScript(t)
+			agents := NewAgentRegistry(model.Config{Type: "fake", MaxCompletionTokens: tt.completion})
+			if got := agents.MaxCompletionTokens(); got != tt.completion {
+				t.Fatalf("MaxCompletionTokens = %d", got)
+			}
+			results, _, _ := runTestGraph(t, testGraph([]string{"a"}), script, RunOptions{TokenBudget: 1000, Agents: agents})
+			if got := results["a"].Status; got != tt.want {
+				t.Errorf("status = %q, want %q", got, tt.want)
+			}
+			if got := len(script.Calls()); got != tt.calls {
+				t.Errorf("%d model calls, want %d", got, tt.calls)
+			}
+		})
+	}
+	if got := NewAgentRegistry(model.Config{Type: "fake"}).MaxCompletionTokens(); got != DefaultCompletionAllowance {
+		t.Errorf("uncapped model allowance = %d, want %d", got, DefaultCompletionAllowance)
+	}
+}
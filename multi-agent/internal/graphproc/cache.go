@@ -0,0 +1,204 @@
+package graphproc
+
+// 本文件实现节点输出缓存（内容寻址）：键为 (模板版本与内容摘要, 代理类型, 模型, 完整提示词) 的 SHA-256，
+// 完整提示词已包含节点负载、前驱输出（含边意图）与输出要求，因此任一上游输出变化都会使下游自然失效。
+// 重新执行同一张图时，未变化的节点直接复用缓存输出（NodeResult.Cached=true，不产生子代理 token），
+// 只有被修改的节点及其下游会真正调用模型。缓存为有界 LRU，仅保存成功的节点结果。
+// 监督者的路由决策同样写入缓存（Agent 为 router，键由监督者看到的路由输入计算，不依赖路由结果），
+// 因此规则无法判定的节点在重新执行时也无需再次询问监督者。
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CacheSizeEnv 指定缓存最大条目数的环境变量；未设置时使用 DefaultCacheEntries
+const CacheSizeEnv = "NODE_CACHE_SIZE"
+
+// DefaultCacheEntries 默认缓存条目上限
+const DefaultCacheEntries = 1000
+
+// CacheAgentRouter 路由决策缓存条目的 Agent 字段
+const CacheAgentRouter = "router"
+
+// CacheEntry 一条缓存的节点输出
+type CacheEntry struct {
+	Key    string `json:"key"`
+	NodeID string `json:"node_id"`
+	Kind   string `json:"kind"`
+	Agent  string `json:"agent"`
+	Model  string `json:"model,omitempty"`
+	Output string `json:"output"`
+	// 路由决策条目（Agent 为 router）：Kind 为选中的子代理，Router / RouteReason 为原始决策
+	Router      string `json:"router,omitempty"`
+	RouteReason string `json:"route_reason,omitempty"`
+	// 生成该输出时消耗的子代理 token（命中即节省）
+	PromptTokens     int       `json:"prompt_tokens,omitempty"`
+	CompletionTokens int       `json:"completion_tokens,omitempty"`
+	TotalTokens      int       `json:"total_tokens,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	LastHitAt        time.Time `json:"last_hit_at,omitempty"`
+	Hits             int       `json:"hits"`
+}
+
+// CacheStats 缓存统计
+type CacheStats struct {
+	Entries    int   `json:"entries"`
+	MaxEntries int   `json:"max_entries"`
+	Hits       int64 `json:"hits"`
+	Misses     int64 `json:"misses"`
+	Evictions  int64 `json:"evictions"`
+	// 命中所节省的子代理 token 总数
+	SavedTokens int64 `json:"saved_tokens"`
+}
+
+// OutputCache 有界 LRU 节点输出缓存，可被多个 run 并发使用
+type OutputCache struct {
+	mu    sync.Mutex
+	max   int
+	ll    *list.List // 元素为 *CacheEntry，表头为最近使用
+	items map[string]*list.Element
+	stats CacheStats
+}
+
+// NewOutputCache 创建缓存；maxEntries<=0 时使用 DefaultCacheEntries
+func NewOutputCache(maxEntries int) *OutputCache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheEntries
+	}
+	return &OutputCache{max: maxEntries, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+// LoadOutputCache 按 NODE_CACHE_SIZE 创建缓存
+func LoadOutputCache() *OutputCache {
+	n, _ := strconv.Atoi(os.Getenv(CacheSizeEnv))
+	return NewOutputCache(n)
+}
+
+// NodeCacheKey 计算节点输出的缓存键；promptID 为 PromptSet.ID()，模板（含代理指令）变化时旧缓存自然失效
+func NodeCacheKey(promptID, agentKind, model, prompt string) string {
+	return cacheKey(promptID, agentKind, model, prompt)
+}
+
+// RouteCacheKey 计算监督者路由决策的缓存键：由路由模式、监督者模型与监督者看到的输入（节点 ID、负载、前驱输出与边意图）决定
+func RouteCacheKey(promptID, router, model string, in RouteInput) string {
+	prevJSON, _ := json.Marshal(in.Prevs)
+	return cacheKey(promptID, CacheAgentRouter+":"+router, model, in.Node.ID, string(in.Node.Payload), string(prevJSON))
+}
+
+func cacheKey(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		// 以长度前缀分隔各部分，避免拼接歧义
+		h.Write([]byte(strconv.Itoa(len(part))))
+		h.Write([]byte{':'})
+		h.Write([]byte(part))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get 查找缓存；命中时更新 LRU 顺序与命中统计。c 为空时始终未命中
+func (c *OutputCache) Get(key string) (CacheEntry, bool) {
+	if c == nil {
+		return CacheEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return CacheEntry{}, false
+	}
+	c.ll.MoveToFront(el)
+	e := el.Value.(*CacheEntry)
+	e.Hits++
+	e.LastHitAt = time.Now()
+	c.stats.Hits++
+	c.stats.SavedTokens += int64(e.TotalTokens)
+	return *e, true
+}
+
+// Put 写入（或覆盖）一条缓存，超出上限时淘汰最久未使用的条目
+func (c *OutputCache) Put(e CacheEntry) {
+	if c == nil || e.Key == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	if el, ok := c.items[e.Key]; ok {
+		el.Value = &e
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[e.Key] = c.ll.PushFront(&e)
+	for c.ll.Len() > c.max {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*CacheEntry).Key)
+		c.stats.Evictions++
+	}
+}
+
+// Delete 删除一条缓存；不存在时返回 false
+func (c *OutputCache) Delete(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	c.ll.Remove(el)
+	delete(c.items, key)
+	return true
+}
+
+// Clear 清空缓存并返回清除的条目数（统计计数保留）
+func (c *OutputCache) Clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.ll.Len()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	return n
+}
+
+// Entries 返回全部缓存条目（最近使用在前）
+func (c *OutputCache) Entries() []CacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]CacheEntry, 0, c.ll.Len())
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		out = append(out, *el.Value.(*CacheEntry))
+	}
+	return out
+}
+
+// Stats 返回缓存统计
+func (c *OutputCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = c.ll.Len()
+	s.MaxEntries = c.max
+	return s
+}
+
+// replayOutput 将未经子代理生成的输出（缓存命中、rerun 复用或手动覆盖）按正常节点输出的方式打印（含节点边界与增量事件），保持前端展示一致
+func replayOutput(printer *StreamPrinter, nodeID, output string) {
+	if printer == nil {
+		return
+	}
+	printer.Begin(nodeID)
+	printer.PrintAnswerChunk(output)
+	printer.emitDelta(output)
+	printer.End()
+}
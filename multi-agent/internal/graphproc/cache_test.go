@@ -0,0 +1,185 @@
+package graphproc
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"multi-agent/internal/orchestrator"
+)
+
+func entryKeys(c *OutputCache) []string {
+	var keys []string
+	for _, e := range c.Entries() {
+		keys = append(keys, e.Key)
+	}
+	return keys
+}
+
+func TestOutputCacheLRU(t *testing.T) {
+	c := NewOutputCache(2)
+	c.Put(CacheEntry{Key: "a", Output: "A", TotalTokens: 10})
+	c.Put(CacheEntry{Key: "b", Output: "B", TotalTokens: 20})
+	// 命中 a 后 b 成为最久未使用，写入 c 时淘汰 b
+	if e, ok := c.Get("a"); !ok || e.Output != "A" || e.Hits != 1 {
+		t.Fatalf("Get(a) = %+v, %v", e, ok)
+	}
+	c.Put(CacheEntry{Key: "c", Output: "C"})
+	if got := entryKeys(c); !slices.Equal(got, []string{"c", "a"}) {
+		t.Errorf("entries = %v, want [c a]", got)
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	// 覆盖已有条目不淘汰其它条目，并移到表头
+	c.Put(CacheEntry{Key: "a", Output: "A2"})
+	if got := entryKeys(c); !slices.Equal(got, []string{"a", "c"}) {
+		t.Errorf("entries after overwrite = %v, want [a c]", got)
+	}
+	if e, _ := c.Get("a"); e.Output != "A2" {
+		t.Errorf("overwritten output = %q", e.Output)
+	}
+	// 空键不写入
+	c.Put(CacheEntry{Output: "x"})
+	if n := len(c.Entries()); n != 2 {
+		t.Errorf("entries = %d, want 2", n)
+	}
+}
+
+func TestOutputCacheStatsAndClear(t *testing.T) {
+	c := NewOutputCache(1)
+	c.Put(CacheEntry{Key: "a", TotalTokens: 7})
+	c.Get("a")
+	c.Get("a")
+	c.Get("missing")
+	c.Put(CacheEntry{Key: "b"})
+	want := CacheStats{Entries: 1, MaxEntries: 1, Hits: 2, Misses: 1, Evictions: 1, SavedTokens: 14}
+	if got := c.Stats(); got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+	if !c.Delete("b") || c.Delete("b") {
+		t.Error("Delete should remove b exactly once")
+	}
+	c.Put(CacheEntry{Key: "c"})
+	if n := c.Clear(); n != 1 {
+		t.Errorf("Clear removed %d, want 1", n)
+	}
+	// 清空不重置统计计数
+	want = CacheStats{Entries: 0, MaxEntries: 1, Hits: 2, Misses: 1, Evictions: 1, SavedTokens: 14}
+	if got := c.Stats(); got != want {
+		t.Errorf("stats after clear = %+v, want %+v", got, want)
+	}
+	if _, ok := c.Get("c"); ok {
+		t.Error("c still cached after Clear")
+	}
+	if got := NewOutputCache(0).Stats().MaxEntries; got != DefaultCacheEntries {
+		t.Errorf("default max entries = %d", got)
+	}
+}
+
+func TestNilOutputCache(t *testing.T) {
+	var c *OutputCache
+	c.Put(CacheEntry{Key: "a"})
+	if _, ok := c.Get("a"); ok {
+		t.Error("nil cache hit")
+	}
+}
+
+func TestNodeCacheKey(t *testing.T) {
+	base := NodeCacheKey("v1/zh@abc", "text", "m", "prompt")
+	if again := NodeCacheKey("v1/zh@abc", "text", "m", "prompt"); again != base {
+		t.Fatalf("key not stable: %s != %s", again, base)
+	}
+	// 固定值：键格式变化会让已有缓存全部失效，需有意为之
+	if want := "2a326f55"; base[:8] != want {
+		t.Errorf("key prefix = %s, want %s", base[:8], want)
+	}
+	for name, k := range map[string]string{
+		"prompt set": NodeCacheKey("v2/zh@abc", "text", "m", "prompt"),
+		"agent":      NodeCacheKey("v1/zh@abc", "vision", "m", "prompt"),
+		"model":      NodeCacheKey("v1/zh@abc", "text", "m2", "prompt"),
+		"prompt":     NodeCacheKey("v1/zh@abc", "text", "m", "prompt "),
+		"boundary":   NodeCacheKey("v1/zh@abc", "textm", "", "prompt"),
+	} {
+		if k == base {
+			t.Errorf("changing %s did not change the key", name)
+		}
+	}
+}
+
+func TestRouteCacheKey(t *testing.T) {
+	node := &orchestrator.SimpleNode{ID: "n", Type: "panel", Payload: json.RawMessage(`{"text":"x"}`)}
+	prevs := []PrevOutput{{ID: "p", Kind: RouteVision, Output: "chart"}}
+	base := RouteCacheKey("v1/zh@abc", RouterHybrid, "m", RouteInput{Node: node, Prevs: prevs})
+	// 路由结果相关的上下文（专用代理、是否为最后节点）不影响键
+	if k := RouteCacheKey("v1/zh@abc", RouterHybrid, "m", RouteInput{Node: node, Prevs: prevs, IsLast: true, Agent: &AgentDef{Kind: "ocr"}}); k != base {
+		t.Error("key depends on routing context the supervisor does not see")
+	}
+	other := *node
+	other.Payload = json.RawMessage(`{"text":"y"}`)
+	for name, k := range map[string]string{
+		"router":  RouteCacheKey("v1/zh@abc", RouterLLM, "m", RouteInput{Node: node, Prevs: prevs}),
+		"model":   RouteCacheKey("v1/zh@abc", RouterHybrid, "m2", RouteInput{Node: node, Prevs: prevs}),
+		"payload": RouteCacheKey("v1/zh@abc", RouterHybrid, "m", RouteInput{Node: &other, Prevs: prevs}),
+		"prevs":   RouteCacheKey("v1/zh@abc", RouterHybrid, "m", RouteInput{Node: node}),
+		"output":  NodeCacheKey("v1/zh@abc", RouterHybrid, "m", string(node.Payload)),
+	} {
+		if k == base {
+			t.Errorf("changing %s did not change the key", name)
+		}
+	}
+}
+
+func TestProcessGraphCachesSupervisorDecision(t *testing.T) {
+	script := newFakeScript(t)
+	// a 的负载为空：规则无法判定，混合路由询问监督者；b 为最后节点，由规则判定
+	sg := testGraph([]string{"a", "b"}, "a->b")
+	sg.Nodes[0].Payload = json.RawMessage(`{}`)
+	cache := NewOutputCache(10)
+	supervisorCalls := func() int {
+		n := 0
+		for _, c := range script.Calls() {
+			if c.Agent == "graph_supervisor" {
+				n++
+			}
+		}
+		return n
+	}
+
+	first, _, err := runTestGraph(t, sg, script, RunOptions{Router: RouterHybrid, Cache: cache})
+	if err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	if n := supervisorCalls(); n != 1 {
+		t.Fatalf("first run: supervisor called %d times, want 1", n)
+	}
+	if r := first["a"]; r.Router != RouterLLM || r.RouteCached || r.Cached {
+		t.Errorf("first run a: %+v", r)
+	}
+
+	second, _, err := runTestGraph(t, sg, script, RunOptions{Router: RouterHybrid, Cache: cache})
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if n := supervisorCalls(); n != 1 {
+		t.Errorf("second run called the supervisor again (%d calls)", n)
+	}
+	a := second["a"]
+	if !a.RouteCached || !a.Cached || a.Router != RouterLLM || a.RouterTotalTokens != 0 || a.Output != first["a"].Output {
+		t.Errorf("second run a: %+v", a)
+	}
+	if a.RouteReason != first["a"].RouteReason+" (cached)" {
+		t.Errorf("route reason = %q", a.RouteReason)
+	}
+	if b := second["b"]; b.RouteCached || !b.Cached {
+		t.Errorf("second run b: route_cached=%v cached=%v, want false/true", b.RouteCached, b.Cached)
+	}
+
+	// ForceNodes 中的节点重新询问监督者
+	if _, _, err := runTestGraph(t, sg, script, RunOptions{Router: RouterHybrid, Cache: cache, ForceNodes: []string{"a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := supervisorCalls(); n != 2 {
+		t.Errorf("forced run: supervisor calls = %d, want 2", n)
+	}
+}
@@ -0,0 +1,201 @@
+package graphproc
+
+// 本文件提供 token 计数，用于预算预留，以及模型服务未上报用量（或流式响应未携带用量）时的兜底：
+// - CountTokens 使用模型的 BPE 分词器计数（github.com/tiktoken-go/tokenizer，词表内嵌，无需下载）：
+//   gpt-4 / gpt-3.5 系列为 cl100k_base，gpt-4o / o1 / o3 系列为 o200k_base；分词器不认识的模型
+//   （如 ark / 自托管模型，或自定义的部署名）退回 EstimateTokens；
+// - EstimateTokens 为经验规则（不是分词器）：借用 cl100k 预切分的片段划分，把文本分成词、数字、空白、标点与 CJK 字符，
+//   再按各类片段的经验长度折算 token：英文单词（含前导空格）约 7 个字母以内为 1 个 token，
+//   数字每 3 位 1 个，空白段与标点每 2 个 1 个，中日韩字符每字 1 个，其它文字每 2 个字符 1 个；
+//   结果与模型实际计费的 token 数不一致，偏差随文本语种与模型而变；
+// - EstimateUsage 按对话格式估算一次调用：系统指令与用户消息各计入消息格式开销，附带的图片按固定 token 计；
+// - 估算结果的 TokenUsage.Estimated 为 true，节点结果与用量汇总中相应标记为 estimated。
+// 估算不计入工具定义与多轮工具调用的中间消息，即使使用分词器也只是近似值；需要准确数字时应选用上报用量的模型服务。
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/tiktoken-go/tokenizer"
+)
+
+// 用量来源
+const (
+	// UsageReported 模型服务上报的用量
+	UsageReported = "reported"
+	// UsageEstimated 模型未上报、由 EstimateUsage 本地估算的用量（近似值）
+	UsageEstimated = "estimated"
+	// UsageMixed 汇总中既有上报也有估算的用量
+	UsageMixed = "mixed"
+)
+
+const (
+	// messageOverheadTokens 每条对话消息的格式开销（角色与分隔符）
+	messageOverheadTokens = 4
+	// replyPrimingTokens 回复起始标记的开销
+	replyPrimingTokens = 3
+	// imageTokens 一张附带图片的 token（约为 1024×1024 图片在高细节模式下的计费）
+	imageTokens = 765
+	// transferCallTokens 监督者一次 transfer_to_agent 工具调用（名称与参数）的输出
+	transferCallTokens = 12
+)
+
+// 预切分片段类型
+const (
+	spanNone = iota
+	spanLatin
+	spanLetter
+	spanDigit
+	spanSpace
+	spanNewline
+	spanPunct
+)
+
+// codecs 模型名 → 分词器；分词器不认识的模型为 nil
+var codecs sync.Map
+
+// codecFor 返回模型的 BPE 分词器；模型未知时返回 nil
+func codecFor(model string) tokenizer.Codec {
+	model = strings.ToLower(strings.TrimSpace(model))
+	if model == "" {
+		return nil
+	}
+	if c, ok := codecs.Load(model); ok {
+		codec, _ := c.(tokenizer.Codec)
+		return codec
+	}
+	codec, err := tokenizer.ForModel(tokenizer.Model(model))
+	if err != nil {
+		codec = nil
+	}
+	codecs.Store(model, codec)
+	return codec
+}
+
+// CountTokens 返回 model 的分词器对文本的 token 数；分词器不认识的模型按 EstimateTokens 估算
+func CountTokens(model, s string) int {
+	if s == "" {
+		return 0
+	}
+	if codec := codecFor(model); codec != nil {
+		if n, err := codec.Count(s); err == nil {
+			return n
+		}
+	}
+	return EstimateTokens(s)
+}
+
+// EstimateTokens 按预切分片段的经验长度估算文本的 token 数；只是近似值，仅用于分词器不认识的模型（见 CountTokens）
+func EstimateTokens(s string) int {
+	n := 0
+	kind, length := spanNone, 0
+	flush := func() {
+		n += spanTokens(kind, length)
+		kind, length = spanNone, 0
+	}
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		k := runeSpan(r)
+		switch {
+		case k == spanNone:
+			// 中日韩字符与符号：每字单独计数
+			flush()
+			n++
+			if unicode.Is(unicode.So, r) {
+				// emoji 等符号通常被切成多个字节级 token
+				n++
+			}
+			continue
+		case k == kind:
+			length++
+			continue
+		case kind == spanSpace && length == 1 && (k == spanLatin || k == spanLetter):
+			// 单个空格并入其后的单词（" the" 为 1 个 token）
+			kind, length = k, 1
+			continue
+		}
+		flush()
+		kind, length = k, 1
+	}
+	flush()
+	return n
+}
+
+// runeSpan 返回字符所属的片段类型；中日韩字符与其它符号返回 spanNone
+func runeSpan(r rune) int {
+	switch {
+	case r == '\n' || r == '\r':
+		return spanNewline
+	case unicode.IsSpace(r):
+		return spanSpace
+	case r < 0x250 && unicode.IsLetter(r):
+		return spanLatin
+	case unicode.IsDigit(r):
+		return spanDigit
+	case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+		return spanNone
+	case unicode.IsLetter(r) || unicode.IsMark(r):
+		return spanLetter
+	case r < utf8.RuneSelf:
+		return spanPunct
+	default:
+		return spanNone
+	}
+}
+
+// spanTokens 折算一个片段的 token 数
+func spanTokens(kind, length int) int {
+	if length <= 0 {
+		return 0
+	}
+	switch kind {
+	case spanLatin:
+		return (length + 6) / 7
+	case spanDigit:
+		return (length + 2) / 3
+	case spanNewline:
+		return 1
+	case spanSpace, spanPunct, spanLetter:
+		return (length + 1) / 2
+	default:
+		return length
+	}
+}
+
+// EstimateUsage 估算一次对话式模型调用的用量：model 为模型名称（选择分词器，见 CountTokens），system 为系统指令（可为空），
+// user 为用户消息文本，images 为用户消息附带的图片数，output 为模型输出
+func EstimateUsage(model, system, user string, images int, output string) *TokenUsage {
+	prompt := messageOverheadTokens + CountTokens(model, user) + images*imageTokens + replyPrimingTokens
+	if system != "" {
+		prompt += messageOverheadTokens + CountTokens(model, system)
+	}
+	completion := CountTokens(model, output)
+	return &TokenUsage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion, Estimated: true}
+}
+
+// Source 返回用量的来源：reported / estimated；u 为空时返回空字符串
+func (u *TokenUsage) Source() string {
+	switch {
+	case u == nil:
+		return ""
+	case u.Estimated:
+		return UsageEstimated
+	default:
+		return UsageReported
+	}
+}
+
+// mergeUsageSource 合并两部分用量的来源
+func mergeUsageSource(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "" || a == b:
+		return a
+	default:
+		return UsageMixed
+	}
+}
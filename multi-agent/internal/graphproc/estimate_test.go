@@ -0,0 +1,48 @@
+package graphproc
+
+import "testing"
+
+func TestCountTokens(t *testing.T) {
+	// 参考值为 OpenAI tiktoken 的计数
+	tests := []struct {
+		model string
+		text  string
+		want  int
+	}{
+		{model: "gpt-4", text: "hello world", want: 2},
+		{model: "gpt-4", text: "tiktoken is great!", want: 6},
+		{model: "gpt-4", text: "The quick brown fox jumps over the lazy dog.", want: 10},
+		{model: "gpt-4", text: "antidisestablishmentarianism", want: 6},
+		{model: "gpt-4", text: "你好，世界", want: 6},
+		{model: "gpt-3.5-turbo-0125", text: "你好，世界", want: 6},
+		{model: " GPT-4-turbo ", text: "tiktoken is great!", want: 6},
+		// o200k_base
+		{model: "gpt-4o", text: "你好，世界", want: 3},
+		{model: "gpt-4o-mini-2024-07-18", text: "The quick brown fox jumps over the lazy dog.", want: 10},
+		{model: "gpt-4", text: "", want: 0},
+	}
+	for _, tt := range tests {
+		if got := CountTokens(tt.model, tt.text); got != tt.want {
+			t.Errorf("CountTokens(%q, %q) = %d, want %d", tt.model, tt.text, got, tt.want)
+		}
+	}
+
+	// 分词器不认识的模型按经验规则估算
+	for _, m := range []string{"", "fake", "doubao-seed-1-6-250615"} {
+		text := "The quick brown fox jumps over the lazy dog. 你好，世界"
+		if got, want := CountTokens(m, text), EstimateTokens(text); got != want {
+			t.Errorf("CountTokens(%q) = %d, want heuristic %d", m, got, want)
+		}
+	}
+}
+
+func TestEstimateUsage(t *testing.T) {
+	u := EstimateUsage("gpt-4", "tiktoken is great!", "hello world", 1, "The quick brown fox jumps over the lazy dog.")
+	// 用户消息 4+2，附带图片 765，回复起始 3，系统指令 4+6
+	if u.PromptTokens != 4+2+imageTokens+3+4+6 || u.CompletionTokens != 10 || u.TotalTokens != u.PromptTokens+10 || !u.Estimated {
+		t.Errorf("EstimateUsage = %+v", u)
+	}
+	if u := EstimateUsage("gpt-4", "", "hello world", 0, ""); u.PromptTokens != 4+2+3 || u.CompletionTokens != 0 {
+		t.Errorf("EstimateUsage without system = %+v", u)
+	}
+}
@@ -0,0 +1,114 @@
+package graphproc
+
+// 本文件定义图执行的结构化生命周期事件（供 SSE 等消费方使用）：
+// run_start → (node_start → delta* → [node_error] → node_end)* → run_end
+// 每个节点恰好一对 node_start / node_end，不调用模型的节点也不例外：预置结果（rerun 复用/覆盖）
+// 以一条 delta 回放输出；跳过与未启动即取消的节点在 node_start 之后直接 node_end。
+// run 参数无效（见 CheckRunOptions）时不发送 run_start，只发送一条携带错误的 run_end。
+// 事件 ID 在单个 run 内单调递增；事件处理函数串行调用，消费方无需自行加锁。
+
+import (
+	"sync"
+)
+
+// 事件类型
+const (
+	EventRunStart  = "run_start"
+	EventNodeStart = "node_start"
+	EventDelta     = "delta"
+	EventNodeEnd   = "node_end"
+	EventNodeError = "node_error"
+	EventRunEnd    = "run_end"
+)
+
+// run 结束状态
+const (
+	RunStatusOK        = "ok"
+	RunStatusFailed    = "failed"
+	RunStatusCancelled = "cancelled"
+	// RunStatusBudgetExceeded token 预算或用户额度用尽而中止（见 budget.go）
+	RunStatusBudgetExceeded = "budget_exceeded"
+)
+
+// Event 图执行过程中的一条生命周期事件，Data 为对应类型的 *Data 结构
+type Event struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// EventHandler 接收事件的回调；由 ProcessGraph 串行调用
+type EventHandler func(ev Event)
+
+// RunStartData run_start 事件数据
+type RunStartData struct {
+	RunID string        `json:"run_id"`
+	Nodes int           `json:"nodes"`
+	Edges int           `json:"edges"`
+	Plan  ExecutionPlan `json:"plan"`
+}
+
+// NodeStartData node_start 事件数据；每个节点的 node_end 之前必有一条
+type NodeStartData struct {
+	RunID  string `json:"run_id"`
+	NodeID string `json:"node_id"`
+}
+
+// DeltaData delta 事件数据：某节点的一段增量输出文本。
+// Reset 为 true 时消费方应先丢弃该节点此前收到的增量（例如节点重试后重新输出）。
+type DeltaData struct {
+	RunID  string `json:"run_id"`
+	NodeID string `json:"node_id"`
+	Text   string `json:"text"`
+	Reset  bool   `json:"reset,omitempty"`
+}
+
+// NodeEndData node_end 事件数据：节点的完整结果（含 token 与耗时）
+type NodeEndData struct {
+	RunID  string     `json:"run_id"`
+	NodeID string     `json:"node_id"`
+	Result NodeResult `json:"result"`
+}
+
+// NodeErrorData node_error 事件数据：节点最终失败
+type NodeErrorData struct {
+	RunID  string     `json:"run_id"`
+	NodeID string     `json:"node_id"`
+	Error  string     `json:"error"`
+	Result NodeResult `json:"result"`
+}
+
+// RunEndData run_end 事件数据：全部结果与用量汇总
+type RunEndData struct {
+	RunID        string                `json:"run_id"`
+	Status       string                `json:"status"`
+	Error        string                `json:"error,omitempty"`
+	DurationMs   int64                 `json:"duration_ms"`
+	Results      map[string]NodeResult `json:"results"`
+	UsageSummary UsageSummary          `json:"usage_summary"`
+	Skipped      []SkippedNode         `json:"skipped"`
+	// ChargedTokens 本次 run 计入预算的 token；TokenBudget 为生效的预算（0 表示不限）
+	ChargedTokens int `json:"charged_tokens"`
+	TokenBudget   int `json:"token_budget,omitempty"`
+}
+
+// eventEmitter 为事件分配递增 ID 并串行调用处理函数
+type eventEmitter struct {
+	mu      sync.Mutex
+	seq     int64
+	handler EventHandler
+}
+
+func newEventEmitter(h EventHandler) *eventEmitter {
+	return &eventEmitter{handler: h}
+}
+
+func (e *eventEmitter) emit(typ string, data any) {
+	if e == nil || e.handler == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.seq++
+	e.handler(Event{ID: e.seq, Type: typ, Data: data})
+}
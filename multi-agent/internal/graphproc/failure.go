@@ -0,0 +1,85 @@
+package graphproc
+
+// 本文件定义节点失败后的传播方式：
+// - continue：保持原有行为，失败节点的后继照常执行（前驱输出为空）
+// - skip-downstream：失败节点的所有后代不再执行，标记为 skipped 并记录根因节点
+// - fail-fast：首个节点失败即取消整个 run，运行中的节点被中断，其余节点记为 cancelled
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 失败传播模式
+const (
+	FailureContinue       = "continue"
+	FailureSkipDownstream = "skip-downstream"
+	FailureFailFast       = "fail-fast"
+)
+
+// NodeStatusSkipped 因上游失败而未执行的节点状态
+const NodeStatusSkipped = "skipped"
+
+// ErrRunAborted fail-fast 模式下因节点失败而中止整个 run
+var ErrRunAborted = errors.New("run aborted")
+
+// ParseFailureMode 校验并规范化失败传播模式；空字符串视为 continue
+func ParseFailureMode(s string) (string, error) {
+	switch m := strings.ToLower(strings.TrimSpace(s)); m {
+	case "":
+		return FailureContinue, nil
+	case FailureContinue, FailureSkipDownstream, FailureFailFast:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown failure mode %q (want %s, %s or %s)", s, FailureContinue, FailureSkipDownstream, FailureFailFast)
+	}
+}
+
+// SkippedNode 描述一个被跳过的节点及原因
+type SkippedNode struct {
+	NodeID    string `json:"node_id"`
+	CauseNode string `json:"cause_node"`
+	Reason    string `json:"reason"`
+}
+
+// SkippedNodes 从结果中列出所有被跳过的节点（按节点 ID 排序）
+func SkippedNodes(results map[string]NodeResult) []SkippedNode {
+	out := make([]SkippedNode, 0)
+	for id, r := range results {
+		if r.Status == NodeStatusSkipped {
+			out = append(out, SkippedNode{NodeID: id, CauseNode: r.SkipCause, Reason: r.Error})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].NodeID < out[j].NodeID })
+	return out
+}
+
+// skipResult 在 skip-downstream 模式下判断节点是否需要跳过：
+// 任一直接前驱失败、被取消或已被跳过时返回 skipped 结果，根因沿链路继承到最初失败的节点。
+func (gr *graphRun) skipResult(id string) (NodeResult, bool) {
+	gr.resMu.Lock()
+	defer gr.resMu.Unlock()
+	for _, p := range gr.preds[id] {
+		r, ok := gr.results[p]
+		if !ok {
+			continue
+		}
+		switch r.Status {
+		case NodeStatusFailed, NodeStatusCancelled:
+			return NodeResult{
+				Status:    NodeStatusSkipped,
+				SkipCause: p,
+				Error:     fmt.Sprintf("skipped: upstream node %s %s: %s", p, r.Status, r.Error),
+			}, true
+		case NodeStatusSkipped:
+			return NodeResult{
+				Status:    NodeStatusSkipped,
+				SkipCause: r.SkipCause,
+				Error:     fmt.Sprintf("skipped: upstream node %s was skipped (root cause %s)", p, r.SkipCause),
+			}, true
+		}
+	}
+	return NodeResult{}, false
+}
@@ -0,0 +1,443 @@
+package graphproc
+
+// 本文件实现视觉代理的图片读取：
+// - 解析由 /api/images/:id 提供的 imageUrl（任意主机名），从图片存储（imagestore.Default，本地目录或 S3）读取图片，托管模型无需访问 localhost；
+// - 超过字节上限或最长边上限的图片等比缩小并重新编码为 JPEG，仍超限时报错；
+// - get_image 工具：按 imageUrl 或图片 ID 返回图片元数据（内置视觉代理默认挂载）；工具结果只能是文本，
+//   不返回图片内容（数 MB 的 base64 文本既占上下文又无法被模型当作图片理解），图片内容通过下面的 inline 路径附加；
+// - VISION_IMAGE_MODE=inline 时，processNode 将图片作为多模态图片部分附加到用户消息中（见 processor.go），
+//   默认 url 模式保持原行为：仅在提示词中给出图片链接。
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/components/tool/utils"
+	"github.com/cloudwego/eino/schema"
+
+	"multi-agent/internal/auth"
+	"multi-agent/internal/imagestore"
+)
+
+// 图片读取相关的环境变量
+const (
+	// ImageMaxBytesEnv 附加到消息的图片字节上限，默认 DefaultImageMaxBytes
+	ImageMaxBytesEnv = "IMAGE_MAX_BYTES"
+	// ImageMaxSideEnv 图片最长边像素上限，超出时等比缩小；0 表示不按尺寸缩小
+	ImageMaxSideEnv = "IMAGE_MAX_SIDE"
+	// VisionImageModeEnv 视觉节点的图片传递方式：url（默认）/ inline
+	VisionImageModeEnv = "VISION_IMAGE_MODE"
+)
+
+// 默认值
+const (
+	DefaultImageMaxBytes = 4 << 20
+	DefaultImageMaxSide  = 1568
+	// maxImageSourceBytes 源文件大小上限，避免解码超大文件
+	maxImageSourceBytes = 32 << 20
+	// minImageSide 缩小时的最小边长，低于该值仍超限则放弃
+	minImageSide = 64
+)
+
+// 视觉节点的图片传递方式
+const (
+	// ImageModeURL 仅在提示词中给出图片链接（模型需自行访问或调用 get_image）
+	ImageModeURL = "url"
+	// ImageModeInline 读取图片并作为 base64 data URL 附加到用户消息
+	ImageModeInline = "inline"
+)
+
+// ToolGetImage get_image 工具名
+const ToolGetImage = "get_image"
+
+// ErrNotLocalImage imageUrl 不是图片存储中的图片（非 /api/images/:id 链接）
+var ErrNotLocalImage = errors.New("not a local image url")
+
+var imageURLPathExpr = regexp.MustCompile(`/api/images/([A-Za-z0-9_-]+)/?$`)
+
+// ParseImageMode 校验并规范化图片传递方式；空字符串视为 url
+func ParseImageMode(s string) (string, error) {
+	switch m := strings.ToLower(strings.TrimSpace(s)); m {
+	case "":
+		return ImageModeURL, nil
+	case ImageModeURL, ImageModeInline:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown image mode %q (want %s or %s)", s, ImageModeURL, ImageModeInline)
+	}
+}
+
+// ImageLoader 从图片存储读取图片，并按上限缩小
+type ImageLoader struct {
+	Store    imagestore.ImageStore
+	MaxBytes int
+	MaxSide  int
+	// Mode 视觉节点的图片传递方式（url / inline）
+	Mode string
+	// Signer 非空时，提示词与 get_image 中的图片存储链接改用带短期签名的链接（见 imagestore.URLSigner），
+	// 模型服务无需凭据即可下载；服务在启用认证时设置
+	Signer *imagestore.URLSigner
+}
+
+// LoadedImage 读取（并可能缩小）后的图片
+type LoadedImage struct {
+	ID       string `json:"id"`
+	MIMEType string `json:"mime_type"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Bytes    int    `json:"bytes"`
+	// Resized 为 true 时图片已缩小或重新编码
+	Resized bool `json:"resized,omitempty"`
+
+	data []byte
+}
+
+// Base64 返回图片内容的 base64 编码
+func (img *LoadedImage) Base64() string {
+	return base64.StdEncoding.EncodeToString(img.data)
+}
+
+// DataURL 返回 data:<mime>;base64,<data>
+func (img *LoadedImage) DataURL() string {
+	return "data:" + img.MIMEType + ";base64," + img.Base64()
+}
+
+// NewImageLoader 创建图片读取器；maxBytes <= 0 时使用默认上限，maxSide < 0 时使用默认边长
+func NewImageLoader(store imagestore.ImageStore, maxBytes, maxSide int, mode string) (*ImageLoader, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultImageMaxBytes
+	}
+	if maxSide < 0 {
+		maxSide = DefaultImageMaxSide
+	}
+	mode, err := ParseImageMode(mode)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageLoader{Store: store, MaxBytes: maxBytes, MaxSide: maxSide, Mode: mode}, nil
+}
+
+// LoadImageLoader 使用进程级图片存储（见 imagestore.Default），按 IMAGE_MAX_BYTES / IMAGE_MAX_SIDE / VISION_IMAGE_MODE 创建图片读取器
+func LoadImageLoader() (*ImageLoader, error) {
+	store, err := imagestore.Default()
+	if err != nil {
+		return nil, err
+	}
+	maxBytes, _ := strconv.Atoi(os.Getenv(ImageMaxBytesEnv))
+	maxSide := -1
+	if s := strings.TrimSpace(os.Getenv(ImageMaxSideEnv)); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s %q", ImageMaxSideEnv, s)
+		}
+		maxSide = n
+	}
+	return NewImageLoader(store, maxBytes, maxSide, os.Getenv(VisionImageModeEnv))
+}
+
+var (
+	defaultImagesOnce sync.Once
+	defaultImages     *ImageLoader
+	defaultImagesErr  error
+)
+
+// DefaultImageLoader 返回进程级图片读取器，首次调用时按环境变量创建；未设置 RunOptions.Images 的 run 使用它
+func DefaultImageLoader() (*ImageLoader, error) {
+	defaultImagesOnce.Do(func() {
+		defaultImages, defaultImagesErr = LoadImageLoader()
+	})
+	return defaultImages, defaultImagesErr
+}
+
+// LocalImageID 从 imageUrl（/api/images/:id，任意主机名）或图片 ID 中解析图片 ID；其它链接返回 ErrNotLocalImage
+func LocalImageID(ref string) (string, error) {
+	ref = strings.TrimSpace(ref)
+	if imagestore.ValidID(ref) {
+		return ref, nil
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", ErrNotLocalImage
+	}
+	if m := imageURLPathExpr.FindStringSubmatch(u.Path); m != nil {
+		return m[1], nil
+	}
+	return "", ErrNotLocalImage
+}
+
+// Load 读取 imageUrl 或图片 ID 对应的已存储图片，超出上限时缩小
+func (l *ImageLoader) Load(ctx context.Context, ref string) (*LoadedImage, error) {
+	id, err := LocalImageID(ref)
+	if err != nil {
+		return nil, err
+	}
+	rc, meta, err := l.Store.Get(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("image %s: %w", id, err)
+	}
+	defer rc.Close()
+	// HTTP 执行时上下文带有认证身份：只能读取自己的图片（管理员不限）；CLI 执行不检查
+	if u := auth.FromContext(ctx); u != nil && !u.CanAccess(meta.Owner) {
+		return nil, fmt.Errorf("image %s: %w", id, imagestore.ErrNotFound)
+	}
+	if meta.Size > maxImageSourceBytes {
+		return nil, fmt.Errorf("image %s: file too large (%d bytes, max %d)", id, meta.Size, maxImageSourceBytes)
+	}
+	data, err := io.ReadAll(io.LimitReader(rc, maxImageSourceBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("image %s: %w", id, err)
+	}
+	if len(data) > maxImageSourceBytes {
+		return nil, fmt.Errorf("image %s: file too large (max %d bytes)", id, maxImageSourceBytes)
+	}
+	img, err := l.fit(data)
+	if err != nil {
+		return nil, fmt.Errorf("image %s: %w", id, err)
+	}
+	img.ID = id
+	return img, nil
+}
+
+// fit 检查字节数与尺寸：均未超限时原样返回；否则等比缩小并编码为 JPEG，直到不超过字节上限
+func (l *ImageLoader) fit(data []byte) (*LoadedImage, error) {
+	mimeType := http.DetectContentType(data)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return nil, fmt.Errorf("not an image (%s)", mimeType)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		// 无法解码的格式（如 webp）只能原样传递
+		if len(data) > l.MaxBytes {
+			return nil, fmt.Errorf("image too large (%d bytes, max %d) and format %s cannot be downscaled", len(data), l.MaxBytes, mimeType)
+		}
+		return &LoadedImage{MIMEType: mimeType, Bytes: len(data), data: data}, nil
+	}
+	side := max(cfg.Width, cfg.Height)
+	if len(data) <= l.MaxBytes && (l.MaxSide == 0 || side <= l.MaxSide) {
+		return &LoadedImage{MIMEType: mimeType, Width: cfg.Width, Height: cfg.Height, Bytes: len(data), data: data}, nil
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", mimeType, err)
+	}
+	if l.MaxSide > 0 && side > l.MaxSide {
+		side = l.MaxSide
+	}
+	for side >= minImageSide {
+		dst := downscale(src, side)
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, fmt.Errorf("encode jpeg: %w", err)
+		}
+		if buf.Len() <= l.MaxBytes {
+			b := dst.Bounds()
+			return &LoadedImage{MIMEType: "image/jpeg", Width: b.Dx(), Height: b.Dy(), Bytes: buf.Len(), Resized: true, data: buf.Bytes()}, nil
+		}
+		side = side * 3 / 4
+	}
+	return nil, fmt.Errorf("image too large (%d bytes) even after downscaling (max %d bytes)", len(data), l.MaxBytes)
+}
+
+// downscale 按区域平均将图片缩小到最长边不超过 side（不放大），透明区域以白色填充
+func downscale(src image.Image, side int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	flat := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), src, b.Min, draw.Over)
+	if max(w, h) <= side {
+		return flat
+	}
+	dw, dh := side, max(1, h*side/w)
+	if h > w {
+		dw, dh = max(1, w*side/h), side
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*h/dh, max((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*w/dw, max((x+1)*w/dw, x*w/dw+1)
+			var r, g, bl, n int
+			for sy := y0; sy < y1; sy++ {
+				off := sy*flat.Stride + x0*4
+				for sx := x0; sx < x1; sx++ {
+					r += int(flat.Pix[off])
+					g += int(flat.Pix[off+1])
+					bl += int(flat.Pix[off+2])
+					off += 4
+					n++
+				}
+			}
+			d := y*dst.Stride + x*4
+			dst.Pix[d], dst.Pix[d+1], dst.Pix[d+2], dst.Pix[d+3] = uint8(r/n), uint8(g/n), uint8(bl/n), 0xff
+		}
+	}
+	return dst
+}
+
+type imageLoaderKey struct{}
+
+// withImageLoader 记录本次 run 使用的图片读取器（RunOptions.Images），供 get_image 工具读取
+func withImageLoader(ctx context.Context, loader *ImageLoader) context.Context {
+	return context.WithValue(ctx, imageLoaderKey{}, loader)
+}
+
+// imageLoaderFrom 返回 ctx 中的图片读取器，未设置时为进程级读取器（见 DefaultImageLoader）
+func imageLoaderFrom(ctx context.Context) (*ImageLoader, error) {
+	if loader, _ := ctx.Value(imageLoaderKey{}).(*ImageLoader); loader != nil {
+		return loader, nil
+	}
+	return DefaultImageLoader()
+}
+
+type attachedImageKey struct{}
+
+// withAttachedImage 记录已作为多模态部分附加到子代理消息的图片 ID，get_image 据此告知模型图片已在消息中
+func withAttachedImage(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, attachedImageKey{}, id)
+}
+
+// attachedImageID 返回附加到当前子代理消息的图片 ID，未附加时为空
+func attachedImageID(ctx context.Context) string {
+	id, _ := ctx.Value(attachedImageKey{}).(string)
+	return id
+}
+
+// ImageMessage 构建附带图片的用户消息：文本部分为 prompt，图片部分为 imageURL（data URL 或远程链接）
+func ImageMessage(prompt, imageURL, mimeType string) *schema.Message {
+	return &schema.Message{
+		Role: schema.User,
+		UserInputMultiContent: []schema.MessageInputPart{
+			{Type: schema.ChatMessagePartTypeText, Text: prompt},
+			{Type: schema.ChatMessagePartTypeImageURL, Image: &schema.MessageInputImage{
+				MessagePartCommon: schema.MessagePartCommon{URL: &imageURL, MIMEType: mimeType},
+				Detail:            schema.ImageURLDetailAuto,
+			}},
+		},
+	}
+}
+
+// SignedLink 返回图片存储链接 imageURL 的短期签名链接（校验归属同 Load）；未设置 Signer 或不是图片存储中的链接时返回空字符串
+func (l *ImageLoader) SignedLink(ctx context.Context, imageURL string) (string, error) {
+	if l.Signer == nil {
+		return "", nil
+	}
+	id, err := LocalImageID(imageURL)
+	if err != nil {
+		return "", nil
+	}
+	meta, err := l.Store.Stat(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("image %s: %w", id, err)
+	}
+	if u := auth.FromContext(ctx); u != nil && !u.CanAccess(meta.Owner) {
+		return "", fmt.Errorf("image %s: %w", id, imagestore.ErrNotFound)
+	}
+	// 仅有图片 ID 时无法得知对外地址，只签发相对链接
+	if imagestore.ValidID(strings.TrimSpace(imageURL)) {
+		imageURL = "/api/images/" + id
+	}
+	link, _, err := l.Signer.SignURL(imageURL, id)
+	return link, err
+}
+
+// visionAttachment 返回 inline 模式下附加到视觉节点消息的图片链接：
+// 图片存储中的图片读取为 data URL；其它 http(s) 链接（非 localhost）原样附加，由模型服务自行下载
+func (l *ImageLoader) visionAttachment(ctx context.Context, imageURL string) (attachURL, mimeType string, err error) {
+	img, err := l.Load(ctx, imageURL)
+	if err == nil {
+		return img.DataURL(), img.MIMEType, nil
+	}
+	if !errors.Is(err, ErrNotLocalImage) {
+		return "", "", err
+	}
+	u, perr := url.Parse(imageURL)
+	if perr != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", "", fmt.Errorf("unsupported image url %q", imageURL)
+	}
+	if h := u.Hostname(); h == "localhost" || strings.HasPrefix(h, "127.") || h == "::1" {
+		return "", "", fmt.Errorf("image url %q is not reachable by the model and not in the local store", imageURL)
+	}
+	return imageURL, "", nil
+}
+
+// getImageInput get_image 工具参数
+type getImageInput struct {
+	URL string `json:"url"`
+	ID  string `json:"id"`
+}
+
+// getImageOutput get_image 工具结果：图片元数据，不含图片内容；读取失败时仅返回 error，交由模型处理
+type getImageOutput struct {
+	*LoadedImage
+	// Attached 为 true 表示图片已作为多模态部分附加在本次用户消息中（inline 模式）
+	Attached bool `json:"attached"`
+	// URL 未附加时模型可下载的签名链接（见 ImageLoader.Signer）
+	URL   string `json:"url,omitempty"`
+	Note  string `json:"note,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// newGetImageTool 构建 get_image 工具：按 imageUrl（/api/images/:id）或图片 ID 返回图片元数据，并说明图片内容是否已附加在消息中
+func newGetImageTool() tool.InvokableTool {
+	info := &schema.ToolInfo{
+		Name: ToolGetImage,
+		Desc: "Look up an uploaded image by its imageUrl (/api/images/<id>) or image id. Returns its MIME type, size and dimensions, and whether the image itself is attached to the user message.",
+		ParamsOneOf: schema.NewParamsOneOfByParams(map[string]*schema.ParameterInfo{
+			"url": {Type: schema.String, Desc: "imageUrl from the node payload"},
+			"id":  {Type: schema.String, Desc: "image id (alternative to url)"},
+		}),
+	}
+	return utils.NewTool(info, func(ctx context.Context, in getImageInput) (getImageOutput, error) {
+		ref := strings.TrimSpace(in.URL)
+		if ref == "" {
+			ref = strings.TrimSpace(in.ID)
+		}
+		if ref == "" {
+			return getImageOutput{Error: "url or id is required"}, nil
+		}
+		loader, err := imageLoaderFrom(ctx)
+		if err != nil {
+			return getImageOutput{}, err
+		}
+		return getImageInfo(ctx, loader, ref), nil
+	})
+}
+
+// getImageInfo 读取图片（校验归属并按上限缩小）并返回附加到消息时的元数据
+func getImageInfo(ctx context.Context, loader *ImageLoader, ref string) getImageOutput {
+	img, err := loader.Load(ctx, ref)
+	if err != nil {
+		return getImageOutput{Error: err.Error()}
+	}
+	if attachedImageID(ctx) == img.ID {
+		return getImageOutput{LoadedImage: img, Attached: true, Note: "the image is attached to the user message"}
+	}
+	out := getImageOutput{LoadedImage: img, Note: "the image content is not returned by this tool; use the image link in the prompt"}
+	if link, err := loader.SignedLink(ctx, ref); err == nil && link != "" {
+		out.URL = link
+		out.Note = "the image content is not returned by this tool; download it from url"
+	}
+	return out
+}
+
+func init() {
+	RegisterTool(ToolGetImage, newGetImageTool())
+}
@@ -0,0 +1,326 @@
+package graphproc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"net/url"
+	"strings"
+	"testing"
+
+	"multi-agent/internal/auth"
+	"multi-agent/internal/imagestore"
+)
+
+// pngBytes 生成 w×h 的随机噪点 PNG（噪点使 JPEG 体积随尺寸增长，便于测试字节上限）
+func pngBytes(t *testing.T, w, h int) []byte {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	rng.Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLocalImageID(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{ref: "abc-123_X", want: "abc-123_X"},
+		{ref: "  abc  ", want: "abc"},
+		{ref: "http://localhost:8080/api/images/abc", want: "abc"},
+		{ref: "https://example.com/prefix/api/images/a-b_c/", want: "a-b_c"},
+		{ref: "/api/images/abc?x=1", want: "abc"},
+		{ref: "https://example.com/images/abc"},
+		{ref: "https://example.com/api/images/abc/raw"},
+		{ref: "https://example.com/api/images/../etc"},
+		{ref: "a.png"},
+		{ref: ""},
+	}
+	for _, tt := range tests {
+		id, err := LocalImageID(tt.ref)
+		if tt.want == "" {
+			if !errors.Is(err, ErrNotLocalImage) {
+				t.Errorf("LocalImageID(%q) = %q, %v, want ErrNotLocalImage", tt.ref, id, err)
+			}
+			continue
+		}
+		if err != nil || id != tt.want {
+			t.Errorf("LocalImageID(%q) = %q, %v, want %q", tt.ref, id, err, tt.want)
+		}
+	}
+}
+
+func TestImageLoaderFit(t *testing.T) {
+	small := pngBytes(t, 40, 20)
+	big := pngBytes(t, 400, 200)
+	webp := append([]byte("RIFF\x00\x00\x00\x00WEBPVP8 "), make([]byte, 2000)...)
+	tests := []struct {
+		name     string
+		data     []byte
+		maxBytes int
+		maxSide  int
+		mime     string
+		w, h     int
+		resized  bool
+		errSub   string
+	}{
+		{name: "within limits", data: small, maxBytes: 1 << 20, maxSide: 100, mime: "image/png", w: 40, h: 20},
+		{name: "side limit disabled", data: big, maxBytes: 1 << 20, maxSide: 0, mime: "image/png", w: 400, h: 200},
+		{name: "side over limit", data: big, maxBytes: 1 << 20, maxSide: 100, mime: "image/jpeg", w: 100, h: 50, resized: true},
+		// 噪点 JPEG 约每像素 1 字节以上：400×200 超过 60KB，缩小到最长边 300 左右才满足
+		{name: "bytes over limit", data: big, maxBytes: 60 << 10, maxSide: 0, mime: "image/jpeg", resized: true},
+		{name: "cannot fit", data: big, maxBytes: 100, maxSide: 0, errSub: "even after downscaling"},
+		{name: "undecodable within limit", data: webp, maxBytes: 1 << 20, maxSide: 10, mime: "image/webp"},
+		{name: "undecodable over limit", data: webp, maxBytes: 100, errSub: "cannot be downscaled"},
+		{name: "not an image", data: []byte("hello world"), maxBytes: 1 << 20, errSub: "not an image"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &ImageLoader{MaxBytes: tt.maxBytes, MaxSide: tt.maxSide}
+			img, err := l.fit(tt.data)
+			if tt.errSub != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errSub) {
+					t.Fatalf("err = %v, want %q", err, tt.errSub)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fit: %v", err)
+			}
+			if img.MIMEType != tt.mime || img.Resized != tt.resized || img.Bytes != len(img.data) || img.Bytes > tt.maxBytes {
+				t.Errorf("got mime=%s resized=%v bytes=%d (data %d), want mime=%s resized=%v", img.MIMEType, img.Resized, img.Bytes, len(img.data), tt.mime, tt.resized)
+			}
+			if tt.w > 0 && (img.Width != tt.w || img.Height != tt.h) {
+				t.Errorf("size = %dx%d, want %dx%d", img.Width, img.Height, tt.w, tt.h)
+			}
+			if tt.resized {
+				// 等比缩小：宽高比保持 2:1
+				if img.Width != 2*img.Height || img.Width >= 400 {
+					t.Errorf("resized to %dx%d", img.Width, img.Height)
+				}
+				cfg, format, err := image.DecodeConfig(bytes.NewReader(img.data))
+				if err != nil || format != "jpeg" || cfg.Width != img.Width {
+					t.Errorf("re-encoded data: %v %s %+v", err, format, cfg)
+				}
+			} else if !bytes.Equal(img.data, tt.data) {
+				t.Error("image within limits was re-encoded")
+			}
+		})
+	}
+}
+
+func TestDownscale(t *testing.T) {
+	tests := []struct {
+		w, h, side int
+		dw, dh     int
+	}{
+		{w: 400, h: 200, side: 100, dw: 100, dh: 50},
+		{w: 200, h: 400, side: 100, dw: 50, dh: 100},
+		{w: 1000, h: 1, side: 10, dw: 10, dh: 1},
+		// 不放大
+		{w: 30, h: 20, side: 100, dw: 30, dh: 20},
+	}
+	for _, tt := range tests {
+		dst := downscale(image.NewRGBA(image.Rect(0, 0, tt.w, tt.h)), tt.side)
+		if b := dst.Bounds(); b.Dx() != tt.dw || b.Dy() != tt.dh {
+			t.Errorf("downscale(%dx%d, %d) = %dx%d, want %dx%d", tt.w, tt.h, tt.side, b.Dx(), b.Dy(), tt.dw, tt.dh)
+		}
+	}
+
+	// 区域平均：左黑右白的 2×1 图缩小为 1×1 灰色；透明像素以白色填充
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.Black)
+	src.Set(1, 0, color.White)
+	if got := downscale(src, 1).RGBAAt(0, 0); got != (color.RGBA{127, 127, 127, 255}) {
+		t.Errorf("average = %v, want gray", got)
+	}
+	transparent := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	if got := downscale(transparent, 1).RGBAAt(0, 0); got != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("transparent = %v, want white", got)
+	}
+}
+
+// newTestImageLoader 使用临时目录中的本地存储，写入 alice 的一张图片
+func newTestImageLoader(t *testing.T) (*ImageLoader, string) {
+	t.Helper()
+	store, err := imagestore.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := store.Put(context.Background(), imagestore.PutInput{ContentType: "image/png", Owner: "alice"}, bytes.NewReader(pngBytes(t, 40, 20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewImageLoader(store, 0, -1, ImageModeInline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, meta.ID
+}
+
+func TestImageLoaderLoadChecksOwner(t *testing.T) {
+	l, id := newTestImageLoader(t)
+	tests := []struct {
+		name string
+		user *auth.User
+		ref  string
+		err  error
+	}{
+		{name: "cli run without identity", ref: id},
+		{name: "owner", user: &auth.User{ID: "alice", Role: auth.RoleUser}, ref: "http://any-host/api/images/" + id},
+		{name: "admin", user: &auth.User{ID: "root", Role: auth.RoleAdmin}, ref: id},
+		{name: "other user", user: &auth.User{ID: "bob", Role: auth.RoleUser}, ref: id, err: imagestore.ErrNotFound},
+		{name: "missing", ref: "nope", err: imagestore.ErrNotFound},
+		{name: "remote url", ref: "https://example.com/a.png", err: ErrNotLocalImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.user != nil {
+				ctx = auth.WithUser(ctx, tt.user)
+			}
+			img, err := l.Load(ctx, tt.ref)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if img.ID != id || img.MIMEType != "image/png" || img.Width != 40 {
+				t.Errorf("loaded %+v", img)
+			}
+		})
+	}
+}
+
+func TestGetImageInfoReturnsMetadataOnly(t *testing.T) {
+	l, id := newTestImageLoader(t)
+	ctx := context.Background()
+
+	out := getImageInfo(ctx, l, id)
+	if out.Error != "" || out.Attached || out.Width != 40 || out.Height != 20 || out.MIMEType != "image/png" {
+		t.Fatalf("out = %+v", out)
+	}
+	data, _ := json.Marshal(out)
+	if strings.Contains(string(data), "base64") || len(data) > 512 {
+		t.Errorf("tool result carries image content: %s", data)
+	}
+
+	// inline 模式已附加该图片时告知模型
+	if out := getImageInfo(withAttachedImage(ctx, id), l, "/api/images/"+id); !out.Attached {
+		t.Errorf("attached image not reported: %+v", out)
+	}
+	if out := getImageInfo(withAttachedImage(ctx, "other"), l, id); out.Attached {
+		t.Errorf("different attached image reported as attached: %+v", out)
+	}
+	if out := getImageInfo(auth.WithUser(ctx, &auth.User{ID: "bob", Role: auth.RoleUser}), l, id); !strings.Contains(out.Error, "not found") || out.LoadedImage != nil {
+		t.Errorf("other user's image: %+v", out)
+	}
+}
+
+func TestImageLoaderSignedLink(t *testing.T) {
+	l, id := newTestImageLoader(t)
+	ctx := context.Background()
+	if link, err := l.SignedLink(ctx, id); link != "" || err != nil {
+		t.Fatalf("without signer: %q, %v", link, err)
+	}
+
+	l.Signer = imagestore.NewURLSigner([]byte("k"), 0)
+	tests := []struct {
+		name   string
+		user   *auth.User
+		ref    string
+		prefix string
+		err    error
+	}{
+		{name: "absolute link", ref: "https://agents.example.com/api/images/" + id, prefix: "https://agents.example.com/api/images/" + id + "?"},
+		{name: "image id", user: &auth.User{ID: "alice", Role: auth.RoleUser}, ref: id, prefix: "/api/images/" + id + "?"},
+		{name: "remote url unchanged", ref: "https://example.com/a.png"},
+		{name: "other user", user: &auth.User{ID: "bob", Role: auth.RoleUser}, ref: id, err: imagestore.ErrNotFound},
+		{name: "missing", ref: "/api/images/nope", err: imagestore.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := ctx
+			if tt.user != nil {
+				ctx = auth.WithUser(ctx, tt.user)
+			}
+			link, err := l.SignedLink(ctx, tt.ref)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if tt.prefix == "" {
+				if link != "" {
+					t.Errorf("link = %q, want none", link)
+				}
+				return
+			}
+			if !strings.HasPrefix(link, tt.prefix) {
+				t.Fatalf("link = %q, want prefix %q", link, tt.prefix)
+			}
+			u, _ := url.Parse(link)
+			if err := l.Signer.Verify(id, u.Query().Get(imagestore.ExpiresParam), u.Query().Get(imagestore.SignatureParam)); err != nil {
+				t.Errorf("Verify(%q) = %v", link, err)
+			}
+		})
+	}
+
+	// get_image 未附加图片时返回签名链接
+	if out := getImageInfo(ctx, l, id); !strings.Contains(out.URL, imagestore.SignatureParam+"=") {
+		t.Errorf("get_image url = %q", out.URL)
+	}
+}
+
+func TestProcessGraphSignsVisionImageLinks(t *testing.T) {
+	l, id := newTestImageLoader(t)
+	l.Mode = ImageModeURL
+	l.Signer = imagestore.NewURLSigner([]byte("k"), 0)
+	imageURL := "https://agents.example.com/api/images/" + id
+	sg := testGraph([]string{"img", "end"}, "img->end")
+	sg.Nodes[0].Payload = []byte(`{"imageUrl": "` + imageURL + `"}`)
+	cache := NewOutputCache(10)
+
+	script := newFakeScript(t)
+	results, _, err := runTestGraph(t, sg, script, RunOptions{Images: l, Cache: cache})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := results["img"]; r.Kind != RouteVision || r.Status != NodeStatusSucceeded {
+		t.Fatalf("img = %+v", r)
+	}
+	var input string
+	for _, c := range script.Calls() {
+		if c.Agent == "vision_agent" {
+			input = c.Input
+		}
+	}
+	if !strings.Contains(input, imageURL+"?"+imagestore.ExpiresParam+"=") || !strings.Contains(input, imagestore.SignatureParam+"=") {
+		t.Fatalf("vision prompt has no signed link:\n%s", input)
+	}
+
+	// 签名随时间变化，缓存键按原链接计算：再次执行命中缓存
+	l.Signer = imagestore.NewURLSigner([]byte("rotated"), 0)
+	results, _, err = runTestGraph(t, sg, newFakeScript(t), RunOptions{Images: l, Cache: cache})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results["img"].Cached {
+		t.Errorf("second run missed the cache: %+v", results["img"])
+	}
+}
@@ -0,0 +1,70 @@
+package graphproc
+
+// 本文件定义边意图（edge intent）：决定前驱输出在下游节点提示词“# 前驱节点输出”中的呈现方式。
+// 无意图（或未知意图）的边保持原样：“- id (kind): 输出”；已知意图在输出前附上对下游代理的使用说明。
+// 使用说明与行格式由当前语言包的模板 intent_<意图> / prev_line 渲染（见 prompts.go）。
+// 意图参数来自 SimpleEdge.Params：所有意图支持 focus（关注点），translate 额外支持 target_lang。
+
+import (
+	"strings"
+)
+
+// 边意图
+const (
+	IntentCritique  = "critique"
+	IntentExpand    = "expand"
+	IntentTranslate = "translate"
+	IntentContrast  = "contrast"
+)
+
+// edgeIntents 受支持的意图
+var edgeIntents = map[string]bool{
+	IntentCritique:  true,
+	IntentExpand:    true,
+	IntentTranslate: true,
+	IntentContrast:  true,
+}
+
+// KnownEdgeIntent 判断意图是否受支持
+func KnownEdgeIntent(intent string) bool {
+	return edgeIntents[intent]
+}
+
+// IntentData 意图说明模板 intent_<意图> 的数据
+type IntentData struct {
+	Params map[string]any
+	// Focus 关注点（params.focus）
+	Focus string
+	// TargetLang 翻译目标语言（params.target_lang / lang / language），为空时由模板决定默认值
+	TargetLang string
+}
+
+// PrevLineData 模板 prev_line 的数据：一条前驱输出
+type PrevLineData struct {
+	ID     string
+	Kind   string
+	Intent string
+	// Frame 渲染后的意图说明；无意图或未知意图时为空
+	Frame string
+	// Output 去除首尾空白的输出，Indented 为每行缩进两格的版本（用于意图说明之后另起一行）
+	Output   string
+	Indented string
+}
+
+func newIntentData(params map[string]any) IntentData {
+	return IntentData{
+		Params:     params,
+		Focus:      paramString(params, "focus"),
+		TargetLang: paramString(params, "target_lang", "lang", "language"),
+	}
+}
+
+// paramString 依次读取第一个非空的字符串参数
+func paramString(params map[string]any, keys ...string) string {
+	for _, k := range keys {
+		if s, ok := params[k].(string); ok && strings.TrimSpace(s) != "" {
+			return strings.TrimSpace(s)
+		}
+	}
+	return ""
+}
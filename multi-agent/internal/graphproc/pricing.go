@@ -0,0 +1,110 @@
+package graphproc
+
+// 本文件提供按模型计费的价格表：
+// - 价格为每百万 token 的单价，输入（prompt）与输出（completion）分别计价；
+// - 价格表来自 PRICING_FILE（JSON），模型名精确匹配优先，其次为以 "*" 结尾的前缀规则（最长者优先），最后为 default；
+// - 未配置价格的模型费用为 0。节点费用在执行时按当时的价格计算并写入 NodeResult，历史记录不受之后调价影响。
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// PricingFileEnv 价格表文件路径的环境变量
+const PricingFileEnv = "PRICING_FILE"
+
+// DefaultCurrency 价格表未指定币种时使用的币种
+const DefaultCurrency = "USD"
+
+// ModelPrice 一个模型每百万 token 的单价
+type ModelPrice struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+// Pricing 模型价格表；nil 表示不计费
+type Pricing struct {
+	Currency string `json:"currency"`
+	// Models 模型名 → 单价；键以 "*" 结尾时按前缀匹配（如 "gpt-4o*"）
+	Models map[string]ModelPrice `json:"models"`
+	// Default 未匹配任何规则的模型单价；为空时这些模型不计费
+	Default *ModelPrice `json:"default,omitempty"`
+}
+
+// LoadPricing 读取 PRICING_FILE 指定的价格表；未设置时返回空价格表（所有模型费用为 0）
+func LoadPricing() (*Pricing, error) {
+	return LoadPricingFile(os.Getenv(PricingFileEnv))
+}
+
+// LoadPricingFile 读取 path 指定的价格表；path 为空时返回空价格表（所有模型费用为 0）
+func LoadPricingFile(path string) (*Pricing, error) {
+	p := &Pricing{Currency: DefaultCurrency, Models: map[string]ModelPrice{}}
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return p, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read pricing file: %w", err)
+	}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("parse pricing file %s: %w", path, err)
+	}
+	if strings.TrimSpace(p.Currency) == "" {
+		p.Currency = DefaultCurrency
+	}
+	if p.Models == nil {
+		p.Models = map[string]ModelPrice{}
+	}
+	for name, mp := range p.Models {
+		if mp.Prompt < 0 || mp.Completion < 0 {
+			return nil, fmt.Errorf("pricing file %s: negative price for model %q", path, name)
+		}
+	}
+	if p.Default != nil && (p.Default.Prompt < 0 || p.Default.Completion < 0) {
+		return nil, fmt.Errorf("pricing file %s: negative default price", path)
+	}
+	return p, nil
+}
+
+// Price 返回模型的单价；未配置时 ok 为 false
+func (p *Pricing) Price(model string) (ModelPrice, bool) {
+	if p == nil || model == "" {
+		return ModelPrice{}, false
+	}
+	if mp, ok := p.Models[model]; ok {
+		return mp, true
+	}
+	best, found := "", false
+	var price ModelPrice
+	for name, mp := range p.Models {
+		prefix, ok := strings.CutSuffix(name, "*")
+		if ok && strings.HasPrefix(model, prefix) && (!found || len(prefix) > len(best)) {
+			best, price, found = prefix, mp, true
+		}
+	}
+	if found {
+		return price, true
+	}
+	if p.Default != nil {
+		return *p.Default, true
+	}
+	return ModelPrice{}, false
+}
+
+// Cost 按模型单价计算一次调用的费用
+func (p *Pricing) Cost(model string, promptTokens, completionTokens int) float64 {
+	mp, ok := p.Price(model)
+	if !ok {
+		return 0
+	}
+	return RoundCost((float64(promptTokens)*mp.Prompt + float64(completionTokens)*mp.Completion) / 1e6)
+}
+
+// RoundCost 将费用舍入到 1e-8，避免累加时的浮点噪声
+func RoundCost(v float64) float64 {
+	return math.Round(v*1e8) / 1e8
+}
@@ -0,0 +1,113 @@
+package graphproc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPricingPrice(t *testing.T) {
+	p := &Pricing{
+		Currency: DefaultCurrency,
+		Models: map[string]ModelPrice{
+			"gpt-4o":       {Prompt: 2.5, Completion: 10},
+			"gpt-4o*":      {Prompt: 3, Completion: 12},
+			"gpt-4o-mini*": {Prompt: 0.15, Completion: 0.6},
+			"gpt-*":        {Prompt: 1, Completion: 1},
+		},
+		Default: &ModelPrice{Prompt: 0.5, Completion: 1.5},
+	}
+	tests := []struct {
+		name  string
+		model string
+		want  ModelPrice
+		ok    bool
+	}{
+		// 精确匹配优先于同名前缀规则
+		{name: "exact", model: "gpt-4o", want: ModelPrice{Prompt: 2.5, Completion: 10}, ok: true},
+		{name: "prefix", model: "gpt-4o-2024-08-06", want: ModelPrice{Prompt: 3, Completion: 12}, ok: true},
+		{name: "longest prefix", model: "gpt-4o-mini-2024-07-18", want: ModelPrice{Prompt: 0.15, Completion: 0.6}, ok: true},
+		{name: "short prefix", model: "gpt-3.5-turbo", want: ModelPrice{Prompt: 1, Completion: 1}, ok: true},
+		{name: "default", model: "doubao-pro", want: ModelPrice{Prompt: 0.5, Completion: 1.5}, ok: true},
+		{name: "empty model", model: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := p.Price(tt.model)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("Price(%q) = %+v, %v; want %+v, %v", tt.model, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+
+	// 没有 default 时未匹配的模型不计费
+	p.Default = nil
+	if _, ok := p.Price("doubao-pro"); ok {
+		t.Error("unmatched model priced without a default")
+	}
+	if c := p.Cost("doubao-pro", 1000, 1000); c != 0 {
+		t.Errorf("Cost of unpriced model = %v", c)
+	}
+	var none *Pricing
+	if _, ok := none.Price("gpt-4o"); ok || none.Cost("gpt-4o", 1, 1) != 0 {
+		t.Error("nil pricing charges")
+	}
+}
+
+func TestPricingCost(t *testing.T) {
+	p := &Pricing{Models: map[string]ModelPrice{"gpt-4o*": {Prompt: 2.5, Completion: 10}}}
+	// 每百万 token 单价：1200 × 2.5 / 1e6 + 300 × 10 / 1e6
+	if got := p.Cost("gpt-4o-mini", 1200, 300); got != 0.006 {
+		t.Errorf("Cost = %v, want 0.006", got)
+	}
+	// 舍入到 1e-8，累加时不出现浮点噪声
+	sum := 0.0
+	for range 10 {
+		sum = RoundCost(sum + p.Cost("gpt-4o", 1, 0))
+	}
+	if sum != 0.000025 {
+		t.Errorf("sum = %v, want 0.000025", sum)
+	}
+}
+
+func TestLoadPricingFile(t *testing.T) {
+	write := func(t *testing.T, data string) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "pricing.json")
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	p, err := LoadPricingFile("")
+	if err != nil || p.Currency != DefaultCurrency || len(p.Models) != 0 || p.Default != nil {
+		t.Fatalf("LoadPricingFile(\"\") = %+v, %v", p, err)
+	}
+	p, err = LoadPricingFile(write(t, `{"currency": "CNY", "models": {"doubao*": {"prompt": 0.8, "completion": 2}}, "default": {"prompt": 1, "completion": 3}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mp, ok := p.Price("doubao-pro-32k"); p.Currency != "CNY" || !ok || mp.Prompt != 0.8 || p.Default == nil {
+		t.Errorf("loaded pricing = %+v", p)
+	}
+	p, err = LoadPricingFile(write(t, `{}`))
+	if err != nil || p.Currency != DefaultCurrency || p.Models == nil {
+		t.Errorf("empty pricing file = %+v, %v", p, err)
+	}
+
+	for name, tt := range map[string]struct{ data, want string }{
+		"negative price":   {`{"models": {"m": {"prompt": -1}}}`, `negative price for model "m"`},
+		"negative default": {`{"default": {"completion": -1}}`, "negative default price"},
+		"malformed":        {`{"models": [`, "parse pricing file"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadPricingFile(write(t, tt.data)); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+	if _, err := LoadPricingFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing pricing file loaded")
+	}
+}
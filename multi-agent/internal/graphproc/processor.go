@@ -2,20 +2,24 @@ package graphproc
 
 // 本文件负责“按图执行”的核心逻辑：
 // - 根据边关系构建各节点的入度与邻接表
-// - 分层（Kahn 算法）推进可执行节点集合 layer
+// - 以就绪队列驱动执行：节点的全部直接前驱完成后立即进入就绪队列，无需等待整层结束
 // - 对每个节点：由监督者（graph_supervisor）决定路由到 text 或 vision 子代理
 // - 显式调用子代理，融合前驱输出与本节点负载，得到结果并记录
-// - 逐层减少后继入度，生成下一层，直至全部可执行节点处理完毕
-// 重要约束：图需为有向无环图（DAG）。若存在环，相关节点的入度不会降为 0，将永远无法进入执行层。
+// - 节点完成后减少其后继入度，入度归零的后继进入就绪队列，直至全部可执行节点处理完毕
+// 重要约束：图需为有向无环图（DAG）。若存在环，相关节点的入度不会降为 0，将永远无法进入就绪队列。
+// 调用方应先使用 ValidateGraph（见 validate.go）拒绝含环、悬空边或重复 ID 的图。
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"multi-agent/internal/logs"
 	"strings"
 	"sync"
+	"time"
 
+	"multi-agent/internal/metrics"
 	"multi-agent/internal/orchestrator"
 
 	"github.com/cloudwego/eino/adk"
@@ -23,249 +27,704 @@ import (
 
 // ProcessGraph 执行最简代理图（SimpleGraph）。
 // 参数：
-// - ctx：上下文，用于模型调用的取消与超时控制。
+// - ctx：上下文，用于模型调用的取消与超时控制；取消后不再调度新节点，未完成节点记为 cancelled。
 // - sg：最简图（节点 id、原始 payload、边）。
-// - supervisorAgent：监督者，仅负责在 text/vision 两个子代理间进行路由决策。
+// - supervisorAgent：监督者，仅负责在 text/vision 两个子代理间进行路由决策（opts.Router 为 llm/hybrid 时使用，见 router.go）。
 // - textAgent：文本子代理，处理纯文本分析与总结。
-// - visionAgent：视觉子代理，处理图像相关内容（可调用 get_image 工具获取 data URL）。
+// - visionAgent：视觉子代理，处理图像相关内容（可调用 get_image 工具查询图片元数据，图片内容按 VISION_IMAGE_MODE 附加到消息）。
 // - results：输出映射，key 为节点 id，value 为节点执行结果（类型、输出文本、错误）。
+// - opts：运行参数（run ID、全局并发上限、关键路径优先、重试策略、失败传播模式），见 scheduler.go。
 // 行为：
-// - 构建入度 indeg 与邻接表 adj；初始化第一层 layer（入度为 0 的节点）。
-// - 逐层遍历 layer：为每个节点汇总前驱输出，询问监督者进行路由，随后显式调用对应子代理执行。
-// - 写入 results；将本层已处理节点的所有后继入度减 1，入度变为 0 的加入下一层 next。
-// - 直到没有新节点进入 layer，处理结束。
-func ProcessGraph(ctx context.Context, sg orchestrator.SimpleGraph, supervisorAgent adk.Agent, textAgent adk.Agent, visionAgent adk.Agent, results map[string]NodeResult, printer *StreamPrinter) error {
-	// 1) 构建入度（indeg）与邻接表（adj）：供分层推进使用
+// - 构建入度 indeg 与邻接表 adj；入度为 0 的节点进入就绪队列。
+// - 在并发上限内不断从就绪队列取出节点执行：汇总前驱输出，询问监督者进行路由，随后显式调用对应子代理执行。
+// - 任一节点完成即写入 results，并将其后继入度减 1；入度变为 0 的后继立即入队，不受其它分支进度影响。
+// - 直到就绪队列为空且没有运行中的节点，处理结束。
+// - 若 ctx 被取消（客户端断开或显式取消），等待运行中的节点退出，将所有未完成节点标记为 cancelled 并返回 ctx.Err()。
+// - 节点失败后按 opts.FailureMode 传播（见 failure.go）：continue 照常推进；skip-downstream 将后代标记为 skipped；fail-fast 取消整个 run 并返回包装了 ErrRunAborted 的错误。
+// - opts.TokenBudget / opts.Quota 限制 token 消耗（见 budget.go）：每次模型调用前检查，用尽后中止 run，未完成节点记为 budget_exceeded，返回包装了 ErrBudgetExceeded 的错误。
+// - 设置了 opts.OnEvent 时按 run_start → node_start/delta/node_error/node_end → run_end 依次回调结构化事件（见 events.go）。
+// - 失败模式、路由或提示词版本/语言无效时不执行任何节点，返回包装了 ErrInvalidRunOptions 的错误；设置了 opts.OnEvent 时仍回调一条 status=failed 的 run_end。
+func ProcessGraph(ctx context.Context, sg orchestrator.SimpleGraph, supervisorAgent adk.Agent, textAgent adk.Agent, visionAgent adk.Agent, results map[string]NodeResult, printer *StreamPrinter, opts RunOptions) error {
+	if opts.RunID == "" {
+		opts.RunID = NewRunID()
+	}
+	ctx = WithRunID(ctx, opts.RunID)
+	mode, router, prompts, err := resolveRunSetup(sg, supervisorAgent, opts)
+	if err != nil {
+		// run 未开始即结束：事件消费方（如 SSE）仍会收到携带错误的 run_end，而不是一条空的事件流
+		err = fmt.Errorf("%w: %w", ErrInvalidRunOptions, err)
+		metrics.RunsTotal.Inc(RunStatus(err))
+		newEventEmitter(opts.OnEvent).emit(EventRunEnd, RunEndData{
+			RunID:   opts.RunID,
+			Status:  RunStatus(err),
+			Error:   err.Error(),
+			Results: map[string]NodeResult{},
+			Skipped: SkippedNodes(nil),
+		})
+		return err
+	}
+	if opts.Images != nil {
+		ctx = withImageLoader(ctx, opts.Images)
+	}
+	// fail-fast 通过带原因的取消中止 run；父 ctx 取消时原因为 context.Canceled
+	ctx, cancelRun := context.WithCancelCause(ctx)
+	defer cancelRun(nil)
+	started := time.Now()
+	metrics.RunsActive.Inc()
+	defer metrics.RunsActive.Dec()
+
+	// 1) 构建入度（indeg）与邻接表（adj）：供依赖推进使用
 	indeg := make(map[string]int, len(sg.Nodes))
 	adj := make(map[string][]string, len(sg.Nodes))
+	preds := make(map[string][]string, len(sg.Nodes))
+	nodes := make(map[string]*orchestrator.SimpleNode, len(sg.Nodes))
 	// 初始化所有节点的入度为0
-	for _, n := range sg.Nodes {
+	for i, n := range sg.Nodes {
 		indeg[n.ID] = 0
+		if _, ok := nodes[n.ID]; !ok {
+			nodes[n.ID] = &sg.Nodes[i]
+		}
 	}
 	for _, e := range sg.Edges {
 		// 遍历边，边的两端是节点，依据此更新节点的邻接表，表示当前节点的后继
 		adj[e.From] = append(adj[e.From], e.To)
 		// to是下一个节点，有边就表示有注入，入度加1
 		indeg[e.To]++
+		preds[e.To] = append(preds[e.To], e.From)
 	}
 
-	// 2) 初始化第一层（layer）：所有入度为 0 的节点可立即执行
-	layer := make([]string, 0)
-	for id, d := range indeg {
-		// 找到入度为0，表示没有依赖的节点，可作为首节点立即执行
-		if d == 0 {
-			layer = append(layer, id)
+	gr := &graphRun{
+		sg:          sg,
+		nodes:       nodes,
+		adj:         adj,
+		preds:       preds,
+		router:      router,
+		agents:      opts.Agents,
+		cache:       opts.Cache,
+		pricing:     opts.Pricing,
+		prompts:     prompts,
+		force:       make(map[string]bool, len(opts.ForceNodes)),
+		textAgent:   textAgent,
+		visionAgent: visionAgent,
+		printer:     printer,
+		retry:       opts.Retry,
+		budget:      newTokenBudget(opts.TokenBudget, opts.Quota, opts.Agents.MaxCompletionTokens()),
+		events:      newEventEmitter(opts.OnEvent),
+		runID:       opts.RunID,
+		results:     results,
+	}
+	for _, id := range opts.ForceNodes {
+		gr.force[id] = true
+	}
+	if opts.OnEvent != nil {
+		if printer != nil {
+			printer.SetDeltaHandler(func(nodeID, text string, reset bool) {
+				gr.events.emit(EventDelta, DeltaData{RunID: opts.RunID, NodeID: nodeID, Text: text, Reset: reset})
+			})
 		}
+		gr.events.emit(EventRunStart, RunStartData{
+			RunID: opts.RunID,
+			Nodes: len(sg.Nodes),
+			Edges: len(sg.Edges),
+			Plan:  BuildExecutionPlan(sg, opts),
+		})
 	}
 
-	// 3) 逐层处理：由监督者判断并调用子代理，推进拓扑执行
-	// processed 记录已处理节点，用于生成下一层时判断依赖是否完成
-	processed := make(map[string]struct{}, len(sg.Nodes))
-	// 读写共享结构的互斥锁（results 与 processed）
-	var resMu sync.Mutex
-	var procMu sync.Mutex
-
-	for len(layer) > 0 {
-		// 并发上限（可根据需要调整）；同层通过信号量控制并发度
-		maxConcurrent := 4
-		if maxConcurrent > len(layer) {
-			maxConcurrent = len(layer)
+	// 2) 初始化就绪队列：所有入度为 0 的节点可立即执行（按图中声明顺序入队，保证结果稳定）
+	var priority map[string]int
+	if opts.CriticalPathPriority {
+		priority = criticalPathLengths(sg, adj)
+	}
+	ready := &readyQueue{}
+	queued := make(map[string]struct{}, len(sg.Nodes))
+	for _, n := range sg.Nodes {
+		if _, ok := queued[n.ID]; ok || indeg[n.ID] != 0 {
+			continue
 		}
-		sem := make(chan struct{}, maxConcurrent)
-		var wg sync.WaitGroup
+		queued[n.ID] = struct{}{}
+		ready.push(n.ID, priority[n.ID])
+	}
 
-		for _, id := range layer {
-			wg.Add(1)
-			sem <- struct{}{}
-			go func(id string) {
-				defer func() { <-sem; wg.Done() }()
-				// 3.1) 定位当前层的具体节点实体
-				var node *orchestrator.SimpleNode
-				for i := range sg.Nodes {
-					if sg.Nodes[i].ID == id {
-						node = &sg.Nodes[i]
-						break
-					}
-				}
-				if node == nil {
-					return
+	// 3) 依赖驱动调度：调度状态（indeg/ready/running）只在当前 goroutine 中读写，
+	//    工作 goroutine 完成后通过 done 通知，由这里统一推进后继
+	limit := opts.concurrency()
+	done := make(chan string)
+	running := 0
+	// advance 推进后继：入度变为 0 的后继立即进入就绪队列，不等待其它分支
+	advance := func(id string) {
+		for _, nb := range adj[id] {
+			indeg[nb]--
+			if indeg[nb] == 0 {
+				ready.push(nb, priority[nb])
+			}
+		}
+	}
+	for ready.Len() > 0 || running > 0 {
+		// ctx 取消后不再启动新节点，只等待运行中的节点退出
+		for running < limit && ready.Len() > 0 && ctx.Err() == nil {
+			id := ready.pop()
+			// 预置结果（rerun 复用或手动覆盖）：不执行，直接视为完成
+			if nr, ok := opts.Preset[id]; ok {
+				logs.Infof("[graph] run=%s node=%s preset reused=%v overridden=%v", opts.RunID, id, nr.Reused, nr.Overridden)
+				gr.events.emit(EventNodeStart, NodeStartData{RunID: opts.RunID, NodeID: id})
+				replayOutput(printer, id, nr.Output)
+				gr.setResult(id, nr)
+				gr.emitNodeEnd(id, nr)
+				advance(id)
+				continue
+			}
+			// skip-downstream：上游失败的节点不执行，直接记为 skipped 并继续推进其后继
+			if mode == FailureSkipDownstream {
+				if nr, skip := gr.skipResult(id); skip {
+					logs.Infof("[graph] run=%s node=%s skipped cause=%s", opts.RunID, id, nr.SkipCause)
+					gr.events.emit(EventNodeStart, NodeStartData{RunID: opts.RunID, NodeID: id})
+					gr.setResult(id, nr)
+					gr.emitNodeEnd(id, nr)
+					advance(id)
+					continue
 				}
+			}
+			running++
+			go func(id string) {
+				gr.processNode(ctx, id)
+				done <- id
+			}(id)
+		}
+		if running == 0 {
+			break
+		}
 
-				// 判断是否为最后一个节点（无任何后继）
-				isLast := len(adj[node.ID]) == 0
+		id := <-done
+		running--
+		nr := gr.result(id)
+		// fail-fast：首个失败节点即取消整个 run（运行中的节点随之中断）
+		if mode == FailureFailFast && nr.Status == NodeStatusFailed {
+			cancelRun(fmt.Errorf("%w: node %s failed: %s", ErrRunAborted, id, nr.Error))
+		}
+		// token 预算：节点因预算不足未调用模型，或累计用量已达上限时中止 run
+		if nr.Status == NodeStatusBudgetExceeded {
+			cancelRun(fmt.Errorf("%w: node %s stopped before calling the model", ErrBudgetExceeded, id))
+		} else if berr := gr.budget.exhausted(); berr != nil {
+			cancelRun(berr)
+		}
+		// 4) 推进后继
+		advance(id)
+	}
 
-				// 3.2) 收集前驱节点输出（prevs）：供监督者路由与子代理参考
-				type prevInfo struct {
-					ID     string `json:"id"`
-					Kind   string `json:"kind"`
-					Output string `json:"output"`
-				}
-				var prevs []prevInfo
-				// 读 results 也需加锁，避免与其他 goroutine 写入冲突
-				resMu.Lock()
-				for _, e := range sg.Edges {
-					if e.To == node.ID {
-						if r, ok := results[e.From]; ok {
-							prevs = append(prevs, prevInfo{ID: e.From, Kind: r.Kind, Output: r.Output})
-						}
-					}
-				}
-				resMu.Unlock()
-				// Debug: 打印当前节点的直接前驱ID，便于核验
-				var prevIDs []string
-				for _, p := range prevs {
-					prevIDs = append(prevIDs, p.ID)
-				}
-				if len(prevIDs) > 0 {
-					logs.Infof("[graph] node=%s direct_predecessors=%v", node.ID, prevIDs)
-				} else {
-					logs.Infof("[graph] node=%s direct_predecessors=[]", node.ID)
+	// 5) 取消处理：未写入结果（未启动）的节点统一标记为 cancelled（预算用尽时为 budget_exceeded），
+	//    同样补发 node_start，保证每个节点的事件都成对出现
+	var runErr error
+	if ctx.Err() != nil {
+		runErr = context.Cause(ctx)
+		logs.Infof("[graph] run=%s cancelled: %v", opts.RunID, runErr)
+		status := NodeStatusCancelled
+		if errors.Is(runErr, ErrBudgetExceeded) {
+			status = NodeStatusBudgetExceeded
+		}
+		for _, n := range sg.Nodes {
+			if _, ok := gr.lookup(n.ID); ok {
+				continue
+			}
+			nr := NodeResult{Status: status, Error: fmt.Sprintf("node not started: %v", runErr)}
+			gr.events.emit(EventNodeStart, NodeStartData{RunID: opts.RunID, NodeID: n.ID})
+			gr.setResult(n.ID, nr)
+			gr.emitNodeEnd(n.ID, nr)
+		}
+	}
+
+	metrics.RunsTotal.Inc(RunStatus(runErr))
+	if opts.OnEvent != nil {
+		end := RunEndData{
+			RunID:      opts.RunID,
+			Status:     RunStatus(runErr),
+			DurationMs: time.Since(started).Milliseconds(),
+		}
+		if runErr != nil {
+			end.Error = runErr.Error()
+		}
+		gr.resMu.Lock()
+		end.Results = make(map[string]NodeResult, len(results))
+		for id, r := range results {
+			end.Results[id] = r
+		}
+		gr.resMu.Unlock()
+		end.UsageSummary = SummarizeUsage(end.Results)
+		end.Skipped = SkippedNodes(end.Results)
+		end.ChargedTokens = ChargedTokens(end.Results)
+		end.TokenBudget = gr.budget.limit
+		gr.events.emit(EventRunEnd, end)
+	}
+	return runErr
+}
+
+// ErrInvalidRunOptions 失败模式、路由或提示词版本/语言无效，run 未开始即结束
+var ErrInvalidRunOptions = errors.New("invalid run options")
+
+// CheckRunOptions 校验 run 参数（失败模式、路由、提示词版本与语言）。ProcessGraph 开始前会做同样的校验，
+// 调用方可在写出响应（如 SSE 头）之前调用，以便直接返回 400
+func CheckRunOptions(sg orchestrator.SimpleGraph, opts RunOptions) error {
+	_, _, _, err := resolveRunSetup(sg, nil, opts)
+	return err
+}
+
+// resolveRunSetup 解析失败传播模式、路由器与语言包（见 prompts.go）；调用方须用同一语言包构建 textAgent / visionAgent
+func resolveRunSetup(sg orchestrator.SimpleGraph, supervisorAgent adk.Agent, opts RunOptions) (string, Router, *PromptSet, error) {
+	mode, err := ParseFailureMode(opts.FailureMode)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	router := opts.RouterImpl
+	if router == nil {
+		if router, err = NewRouter(opts.Router, supervisorAgent); err != nil {
+			return "", nil, nil, err
+		}
+	}
+	prompts, err := ResolvePromptSet(opts.PromptVersion, opts.Language, sg.Options)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return mode, router, prompts, nil
+}
+
+// RunStatus 将 ProcessGraph 的返回值映射为 run 结束状态：ok / failed（fail-fast 中止或参数无效）/ budget_exceeded / cancelled
+func RunStatus(err error) string {
+	switch {
+	case err == nil:
+		return RunStatusOK
+	case errors.Is(err, ErrRunAborted), errors.Is(err, ErrInvalidRunOptions):
+		return RunStatusFailed
+	case errors.Is(err, ErrBudgetExceeded):
+		return RunStatusBudgetExceeded
+	default:
+		return RunStatusCancelled
+	}
+}
+
+// graphRun 保存一次图执行过程中各节点共享的状态
+type graphRun struct {
+	sg          orchestrator.SimpleGraph
+	nodes       map[string]*orchestrator.SimpleNode
+	adj         map[string][]string
+	preds       map[string][]string
+	router      Router
+	agents      *AgentRegistry
+	cache       *OutputCache
+	pricing     *Pricing
+	prompts     *PromptSet
+	force       map[string]bool
+	textAgent   adk.Agent
+	visionAgent adk.Agent
+	printer     *StreamPrinter
+	// 运行级重试策略（可被图级与节点级覆盖）
+	retry *orchestrator.RetryPolicy
+	// token 预算与用户额度（见 budget.go）
+	budget *tokenBudget
+	// 结构化事件发送器（未设置 OnEvent 时为空操作）
+	events *eventEmitter
+	runID  string
+
+	// 读写共享结构 results 的互斥锁
+	resMu   sync.Mutex
+	results map[string]NodeResult
+}
+
+// processNode 执行单个节点：收集前驱输出、路由、调用子代理并写入结果
+func (gr *graphRun) processNode(ctx context.Context, id string) {
+	sg, adj, results, printer := gr.sg, gr.adj, gr.results, gr.printer
+	textAgent, visionAgent := gr.textAgent, gr.visionAgent
+
+	// 3.1) 定位当前节点实体
+	node, ok := gr.nodes[id]
+	if !ok {
+		return
+	}
+	started := time.Now()
+	gr.events.emit(EventNodeStart, NodeStartData{RunID: gr.runID, NodeID: id})
+
+	// 判断是否为最后一个节点（无任何后继）
+	isLast := len(adj[node.ID]) == 0
+
+	// 3.2) 收集前驱节点输出（prevs）：供路由与子代理参考
+	var prevs []PrevOutput
+	// 读 results 也需加锁，避免与其他 goroutine 写入冲突
+	gr.resMu.Lock()
+	for _, e := range sg.Edges {
+		if e.To == node.ID {
+			if r, ok := results[e.From]; ok {
+				prevs = append(prevs, PrevOutput{ID: e.From, Kind: r.Kind, Output: r.Output, Intent: e.Intent, Params: e.Params})
+			}
+		}
+	}
+	gr.resMu.Unlock()
+	// Debug: 打印当前节点的直接前驱ID，便于核验
+	var prevIDs []string
+	for _, p := range prevs {
+		prevIDs = append(prevIDs, p.ID)
+	}
+	if len(prevIDs) > 0 {
+		logs.Infof("[graph] node=%s direct_predecessors=%v", node.ID, prevIDs)
+	} else {
+		logs.Infof("[graph] node=%s direct_predecessors=[]", node.ID)
+	}
+
+	// 节点类型对应的专用代理（见 registry.go）；最后节点固定由文本代理输出最终总结
+	var typeDef *AgentDef
+	if def, ok := gr.agents.Lookup(node.Type); ok && !isLast {
+		typeDef = &def
+	}
+
+	var payload map[string]any
+	_ = json.Unmarshal(node.Payload, &payload)
+	// 节点语言包：payload.language 覆盖本次执行的语言（见 prompts.go）；语言不存在时节点失败
+	nodeSet, serr := nodePromptSet(gr.prompts, payload)
+
+	// 3.3) 路由：由配置的 Router 在 text/vision 子代理间选择（规则 / 监督者 / 混合，见 router.go）
+	// 路由与子代理调用均按节点生效的重试策略执行（见 retry.go）
+	policy := resolveRetryPolicy(gr.retry, sg.Options, node)
+	var decision RouteDecision
+	var routerAttempts int
+	var routerAttemptErrs []AttemptError
+	var err error
+	// 本节点计入预算的 token（路由与子代理的每次尝试）；每次尝试先预留估算值，结束后按实际用量结清
+	var charged int
+	settle := func(reserved, n int) {
+		charged += n
+		gr.budget.settle(reserved, n)
+	}
+	// 路由输入的估算：监督者提示词包含节点负载与前驱输出；本节点后续的子代理调用同样需要这些输入
+	routeInput := string(node.Payload)
+	for _, p := range prevs {
+		routeInput += p.Output
+	}
+	// 路由决策缓存命中（监督者的决策复用自节点输出缓存，未调用模型，见 cache.go）
+	var routeCached bool
+	if serr == nil {
+		in := RouteInput{Node: node, Prevs: prevs, IsLast: isLast, Agent: typeDef, Prompts: nodeSet, Model: gr.agents.RouterModelName()}
+		var ruled bool
+		// 规则可判定时不调用模型，也不查缓存（规则路由，以及混合路由的确定性分支）
+		if decision, ruled = decideWithoutModel(gr.router, in); !ruled {
+			// 监督者的路由输入（节点负载与前驱输出）未变化时复用上次的决策；ForceNodes 中的节点同样重新决策
+			var routeKey string
+			var entry CacheEntry
+			if gr.cache != nil {
+				routeKey = RouteCacheKey(nodeSet.ID(), gr.router.Name(), gr.agents.RouterModelName(), in)
+				if !gr.force[node.ID] {
+					entry, routeCached = gr.cache.Get(routeKey)
 				}
-				prevJSON, _ := json.Marshal(prevs)
-
-				// 3.3) 询问监督者（graph_supervisor）进行路由：只需返回 {"used":"text|vision"}
-				// 注意：监督者不负责执行任务，只做选择；真正的执行在 3.5) 子代理调用。
-				prompt := fmt.Sprintf(`请仅进行路由选择，不要自己完成任务。
-节点ID: %s
-节点负载(JSON): %s
-前驱节点输出(JSON): %s
-规则：
-- 若节点负载包含非空 imageUrl，则选择 vision_agent；
-- 否则根据负载文本与前驱输出在 text_agent/vision_agent 中选择其一。
-只返回一个严格的 JSON：{"used":"text"} 或 {"used":"vision"}。`,
-					node.ID, string(node.Payload), string(prevJSON))
-				used, _, routerUsage, err := runRouterWithUsage(ctx, supervisorAgent, prompt)
-
-				var kind, output, errStr string
-				// 用于记录子代理执行阶段的token用量（若可获取）
-				var usage *TokenUsage
-				if err != nil {
-					// 3.4) 路由失败兜底：记录错误并继续推进（避免单点失败导致整体中断）
-					kind = "llm_routed"
-					output = ""
-					errStr = err.Error()
-				} else {
-					// 3.5) 根据路由结果显式调用子代理：
-					// - 输入以文本形式融合前驱输出与当前节点负载；若为最后节点，额外注入完整图负载并改为最终总结输出
-					// - 子代理返回结论文本（由 RunAgentOnce 抽取最后消息内容）
-					var sb strings.Builder
-					fmt.Fprintf(&sb, "处理节点: %s\n", node.ID)
-					// 角色与目的：根据是否存在前驱输出与是否为最后节点进行区分
-					if isLast {
-						fmt.Fprintf(&sb, "## 角色与目的\n你是最后节点总结代理：结合直接前驱输出与完整负载，生成最终的中文总结、建议与最终结果（满足用户的具体交付）。\n")
-					} else if len(prevs) == 0 {
-						fmt.Fprintf(&sb, "## 角色与目的\n你是首节点分析代理：仅基于当前节点负载进行理解与联想。\n")
-					} else {
-						fmt.Fprintf(&sb, "## 角色与目的\n你是中间节点分析代理：结合上述直接前驱的输出与当前负载进行整合与延伸；不要引用未列出的其它节点。\n")
-					}
-					// 前驱输出
-					if len(prevs) > 0 {
-						fmt.Fprintf(&sb, "\n# 前驱节点输出\n")
-						for _, p := range prevs {
-							fmt.Fprintf(&sb, "- %s (%s): %s\n", p.ID, p.Kind, strings.TrimSpace(p.Output))
-						}
-					} else {
-						fmt.Fprintf(&sb, "\n# 前驱节点输出\n无\n")
+			}
+			if routeCached {
+				decision = RouteDecision{Used: entry.Kind, Router: entry.Router, Reason: entry.RouteReason + " (cached)"}
+				logs.Infof("[cache] run=%s node=%s route hit key=%s saved_tokens=%d", gr.runID, node.ID, routeKey[:12], entry.TotalTokens)
+			} else {
+				routerAttempts, routerAttemptErrs, err = runWithRetry(ctx, policy, StageRouter, node.ID, func(actx context.Context) error {
+					reserved, berr := gr.budget.reserve(CountTokens(in.Model, routeInput))
+					if berr != nil {
+						return berr
 					}
-					// 当前负载
-					fmt.Fprintf(&sb, "\n# 当前节点负载(JSON)\n%s\n", string(node.Payload))
-					// 若为最后节点，注入完整图负载（nodes 与 edges）
-					if isLast {
-						fullJSON, _ := json.Marshal(sg)
-						fmt.Fprintf(&sb, "\n# 完整负载(JSON)\n%s\n", string(fullJSON))
+					var rerr error
+					decision, rerr = gr.router.Route(actx, in)
+					// 监督者使用默认模型（见 agents.go）
+					recordTokenMetrics(metrics.StageRouter, gr.agents.RouterModelName(), decision.Usage)
+					// 规则路由不调用模型，只释放预留、不记账
+					spent := 0
+					if decision.Usage != nil || decision.Router == RouterLLM {
+						spent = usageTokens(decision.Usage, in.Model, routeInput, "")
 					}
-					// 输出规范（最后节点改为最终总结样式，其它节点保持精炼要点）
-					if isLast {
-						fmt.Fprintf(&sb, "\n## 输出要求\n- 先给出总体总结（不超过 10 句）\n- 再给出 3 条可执行建议（编号 1-3）\n- 最后输出\"最终结果\"：直接给出满足用户需求的交付内容；严格遵守用户约束（例如字数与风格）\n- 为增强可读性，可以适度使用表情符号（每条建议不超过 2 个）\n- 不输出代码块、不加额外引号\n")
-					} else {
-						fmt.Fprintf(&sb, "\n## 输出要求\n- 仅参考上面列出的直接前驱输出，不要引用未列出的节点\n- 结合前驱输出与当前负载进行分析/整合（首节点仅基于当前负载）\n- 直接返回结论与要点，中文，精炼（不超过 6 句）\n- 中间结果不使用表情符号\n")
+					settle(reserved, spent)
+					return rerr
+				})
+				// 仅缓存监督者成功做出的决策
+				if gr.cache != nil && err == nil && ctx.Err() == nil && decision.Router == RouterLLM {
+					entry := CacheEntry{Key: routeKey, NodeID: node.ID, Kind: decision.Used, Agent: CacheAgentRouter, Model: gr.agents.RouterModelName(), Router: decision.Router, RouteReason: decision.Reason}
+					if u := decision.Usage; u != nil {
+						entry.PromptTokens, entry.CompletionTokens, entry.TotalTokens = u.PromptTokens, u.CompletionTokens, u.TotalTokens
 					}
+					gr.cache.Put(entry)
+				}
+			}
+		}
+	}
+	used, routerUsage := decision.Used, decision.Usage
+	// 调用前检查发现预算或额度不足（未调用模型）
+	overBudget := errors.Is(err, ErrBudgetExceeded)
+	attemptErrs := routerAttemptErrs
+	attempts := 0
 
-					// 3.6) 负载字段检查：若存在 imageUrl，则强制使用 vision（避免文本代理误判）
-					var payload map[string]any
-					_ = json.Unmarshal(node.Payload, &payload)
-					var imageURL string
-					if v, ok := payload["imageUrl"].(string); ok && v != "" {
-						imageURL = v
-					}
+	var kind, agentKind, output, errStr string
+	// 输出缓存命中时不调用子代理（见 cache.go）
+	var cached bool
+	var cacheKey string
+	// 渲染节点提示词使用的入口模板（见 prompts.go）
+	var promptTemplate string
+	// 用于记录子代理执行阶段的token用量（模型上报，未上报时为本地估算）
+	var usage *TokenUsage
+	if serr != nil {
+		errStr = serr.Error()
+		logs.Errorf("[graph] run=%s node=%s: %v", gr.runID, node.ID, serr)
+	} else if err != nil {
+		// 3.4) 路由失败兜底：记录错误并继续推进（避免单点失败导致整体中断）
+		kind = "llm_routed"
+		output = ""
+		errStr = err.Error()
+	} else {
+		// 3.5) 根据路由结果显式调用子代理：
+		// - 输入由模板渲染（见 prompts.go）：融合前驱输出与当前节点负载；若为最后节点，额外注入完整图负载并改为最终总结输出
+		// - 角色与目的按是否存在前驱输出、是否为最后节点选择入口模板 node_first / node_middle / node_last
+		// - 子代理返回结论文本（由 RunAgentOnce 抽取最后消息内容）
+		promptTemplate = nodePromptTemplate(isLast, len(prevs))
+		data := NodePromptData{NodeID: node.ID, Type: node.Type, Payload: string(node.Payload), Prevs: prevs}
+		// 若为最后节点，注入完整图负载（nodes 与 edges）
+		if isLast {
+			fullJSON, _ := json.Marshal(sg)
+			data.Graph = string(fullJSON)
+		}
+		// 3.6) 负载字段检查：若存在 imageUrl，则强制使用 vision（避免文本代理误判）
+		var imageURL string
+		if v, ok := payload["imageUrl"].(string); ok && v != "" {
+			imageURL = v
+		}
 
-					// 最后节点强制使用文本代理用于最终总结；否则若存在图片链接则使用视觉代理
-					if isLast {
-						used = "text"
-					} else if imageURL != "" {
-						used = "vision"
-					}
+		// 最后节点强制使用文本代理用于最终总结；否则若存在图片链接则使用视觉代理（规则路由已满足，仅监督者可能被覆盖）
+		if isLast && used != RouteText {
+			decision.Reason = fmt.Sprintf("overridden %s -> text: last node (%s)", used, decision.Reason)
+			used = RouteText
+		} else if !isLast && imageURL != "" && used != RouteVision {
+			decision.Reason = fmt.Sprintf("overridden %s -> vision: payload has imageUrl (%s)", used, decision.Reason)
+			used = RouteVision
+		}
+		logs.Infof("[route] run=%s node=%s used=%s router=%s reason=%s", gr.runID, node.ID, used, decision.Router, decision.Reason)
 
-					var subOut string
-					var subErr error
-					if used == "vision" {
-						kind = "vision"
-						// 3.7) 图像场景：为降低 tokens，仅传递图片链接与提示，不注入 base64 数据
-						if imageURL != "" {
-							fmt.Fprintf(&sb, "\n# 图片链接\nURL: %s\n", imageURL)
-						}
-						subOut, usage, subErr = RunAgentOnceWithUsageStreaming(ctx, visionAgent, sb.String(), printer, node.ID)
+		var agent adk.Agent
+		if used == "vision" {
+			kind, agentKind = "vision", AgentVision
+			agent = visionAgent
+		} else {
+			// 默认文本代理
+			kind, agentKind = "text", AgentText
+			agent = textAgent
+		}
+		// 节点语言与本次执行不同：改用该语言包构建的同类代理（指令随语言切换）
+		if nodeSet != gr.prompts && gr.agents != nil {
+			if a, aerr := gr.agents.AgentWith(agentKind, nodeSet); aerr != nil {
+				logs.Errorf("[graph] run=%s node=%s language=%s: %v, keeping run language agent", gr.runID, node.ID, nodeSet.Language, aerr)
+			} else {
+				agent = a
+			}
+		}
+		// 节点类型注册了同模态的专用代理时改用该代理（如 agenda-panel -> summarizer）
+		var outputRules []string
+		if typeDef != nil && typeDef.Vision == (kind == "vision") && typeDef.Kind != agentKind {
+			a, aerr := gr.agents.AgentWith(typeDef.Kind, nodeSet)
+			var rules []string
+			if aerr == nil {
+				rules, aerr = typeDef.RenderOutputRules(nodeSet)
+			}
+			if aerr != nil {
+				logs.Errorf("[graph] run=%s node=%s type=%s: %v, falling back to %s agent", gr.runID, node.ID, node.Type, aerr, agentKind)
+			} else {
+				agent, agentKind = a, typeDef.Kind
+				outputRules = rules
+			}
+		}
+		// 输出规范由模板决定（最后节点为最终总结样式；专用代理使用其定义的输出要求；其它节点保持精炼要点）
+		data.Agent, data.OutputRules = agentKind, outputRules
+		// 3.7) 图像场景：默认仅传递图片链接与提示，不注入 base64 数据；
+		// 图片读取器的传递方式为 inline（images.vision_mode / VISION_IMAGE_MODE）时读取图片并作为多模态部分附加到用户消息（见 image.go），读取失败时退回链接
+		var attachURL, attachMIME, signedURL string
+		if kind == "vision" {
+			data.ImageURL = imageURL
+			if images, ierr := imageLoaderFrom(ctx); ierr != nil {
+				logs.Errorf("[image] run=%s node=%s: %v", gr.runID, node.ID, ierr)
+			} else if imageURL != "" {
+				if images.Mode == ImageModeInline {
+					if attachURL, attachMIME, ierr = images.visionAttachment(ctx, imageURL); ierr != nil {
+						logs.Errorf("[image] run=%s node=%s: %v, falling back to image link", gr.runID, node.ID, ierr)
 					} else {
-						// 默认文本代理
-						kind = "text"
-						subOut, usage, subErr = RunAgentOnceWithUsageStreaming(ctx, textAgent, sb.String(), printer, node.ID)
-					}
-					if subErr != nil {
-						errStr = subErr.Error()
+						data.ImageAttached = true
+						// get_image 据此告知模型图片已在消息中
+						if id, lerr := LocalImageID(imageURL); lerr == nil {
+							ctx = withAttachedImage(ctx, id)
+						}
 					}
-					output = strings.TrimSpace(subOut)
-					if usage != nil {
-						logs.Infof("[tokens] node=%s kind=%s prompt=%d completion=%d total=%d", node.ID, kind, usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens)
+				}
+				// 模型按链接下载图片时，图片存储中的图片改用短期签名链接（启用认证时图片接口需要凭据或签名）
+				if !data.ImageAttached {
+					if signedURL, ierr = images.SignedLink(ctx, imageURL); ierr != nil {
+						logs.Errorf("[image] run=%s node=%s: sign image link: %v", gr.runID, node.ID, ierr)
 					}
 				}
-				// 3.8) 记录节点结果：包含执行类型（text/vision/llm_routed）、输出、错误，以及token用量
-				var nr NodeResult
-				nr.Kind = kind
-				nr.Output = output
-				nr.Error = errStr
-				// 监督者路由阶段tokens
-				if routerUsage != nil {
-					logs.Infof("[tokens] node=%s router prompt=%d completion=%d total=%d", node.ID, routerUsage.PromptTokens, routerUsage.CompletionTokens, routerUsage.TotalTokens)
-					nr.RouterPromptTokens = routerUsage.PromptTokens
-					nr.RouterCompletionTokens = routerUsage.CompletionTokens
-					nr.RouterTotalTokens = routerUsage.TotalTokens
+			}
+		}
+		prompt, perr := nodeSet.Render(promptTemplate, data)
+		// 输出缓存：提示词（负载 + 前驱输出 + 输出要求）、代理与模型均未变化时直接复用上次的输出
+		if gr.cache != nil && perr == nil {
+			cacheKey = NodeCacheKey(nodeSet.ID(), agentKind, gr.agents.ModelName(agentKind), prompt)
+		}
+		// 发给模型的提示词使用签名链接；缓存键按原链接计算，签名随时间变化不影响命中
+		if signedURL != "" && perr == nil {
+			data.ImageURL = signedURL
+			prompt, perr = nodeSet.Render(promptTemplate, data)
+		}
+		// ForceNodes 中的节点（如 rerun 的起始节点）跳过读取，强制重新生成
+		var entry CacheEntry
+		var hit bool
+		if !gr.force[node.ID] && perr == nil {
+			entry, hit = gr.cache.Get(cacheKey)
+		}
+		if perr != nil {
+			// 模板渲染失败（如覆盖目录中的模板引用了不存在的字段）：节点失败，不调用子代理
+			errStr = perr.Error()
+			logs.Errorf("[graph] run=%s node=%s: %v", gr.runID, node.ID, perr)
+		} else if hit {
+			cached = true
+			output = entry.Output
+			logs.Infof("[cache] run=%s node=%s hit key=%s saved_tokens=%d", gr.runID, node.ID, cacheKey[:12], entry.TotalTokens)
+			replayOutput(printer, node.ID, output)
+		} else {
+			// 每次尝试重新流式输出；节点边界行会让前端清空该节点上一轮的残留内容
+			var subOut string
+			var subAttemptErrs []AttemptError
+			var subErr error
+			// 模型未上报用量时按子代理指令、提示词与附带的图片估算（见 estimate.go）
+			var instruction string
+			if def, ok := gr.agents.Def(agentKind); ok {
+				instruction, _ = def.RenderInstruction(nodeSet)
+			}
+			images := 0
+			if data.ImageAttached {
+				images = 1
+			}
+			subModel := gr.agents.ModelName(agentKind)
+			attempts, subAttemptErrs, subErr = runWithRetry(ctx, policy, StageSubAgent, node.ID, func(actx context.Context) error {
+				reserved, berr := gr.budget.reserve(CountTokens(subModel, prompt))
+				if berr != nil {
+					return berr
 				}
-				// 若有usage则写入
-				if usage != nil {
-					nr.PromptTokens = usage.PromptTokens
-					nr.CompletionTokens = usage.CompletionTokens
-					nr.TotalTokens = usage.TotalTokens
+				var e error
+				if data.ImageAttached {
+					subOut, usage, e = RunAgentMessageWithUsageStreaming(actx, agent, ImageMessage(prompt, attachURL, attachMIME), printer, node.ID)
+				} else {
+					subOut, usage, e = RunAgentOnceWithUsageStreaming(actx, agent, prompt, printer, node.ID)
 				}
-				// 写 results 需加锁
-				resMu.Lock()
-				results[node.ID] = nr
-				resMu.Unlock()
-				// 标记 processed 需加锁
-				procMu.Lock()
-				processed[node.ID] = struct{}{}
-				procMu.Unlock()
-			}(id)
-		}
-		// 等待本层所有并发节点完成，形成层栅栏
-		wg.Wait()
-
-		// 4) 生成下一层：对于当前层每个节点的所有后继 nb，将其入度减 1。
-		//    若后继入度变为 0，则加入 next，成为下一轮可执行节点。
-		next := make([]string, 0)
-		for _, id := range layer {
-			for _, nb := range adj[id] {
-				if _, ok := processed[id]; !ok {
-					continue
+				if usage == nil && (e == nil || subOut != "") {
+					usage = EstimateUsage(subModel, instruction, prompt, images, subOut)
 				}
-				indeg[nb]--
-				if indeg[nb] == 0 {
-					next = append(next, nb)
+				recordTokenMetrics(metrics.StageSubAgent, subModel, usage)
+				settle(reserved, usageTokens(usage, subModel, prompt, subOut))
+				return e
+			})
+			attemptErrs = append(attemptErrs, subAttemptErrs...)
+			if subErr != nil {
+				errStr = subErr.Error()
+				overBudget = errors.Is(subErr, ErrBudgetExceeded)
+			}
+			output = strings.TrimSpace(subOut)
+			if usage != nil {
+				logs.Infof("[tokens] node=%s kind=%s prompt=%d completion=%d total=%d source=%s", node.ID, kind, usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens, usage.Source())
+			}
+			// 仅缓存完整成功的输出
+			if gr.cache != nil && subErr == nil && ctx.Err() == nil && output != "" {
+				entry := CacheEntry{Key: cacheKey, NodeID: node.ID, Kind: kind, Agent: agentKind, Model: gr.agents.ModelName(agentKind), Output: output}
+				if usage != nil {
+					entry.PromptTokens, entry.CompletionTokens, entry.TotalTokens = usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens
 				}
+				gr.cache.Put(entry)
 			}
 		}
-		// 更新当前层为下一层
-		layer = next
 	}
+	// 3.8) 记录节点结果：包含执行类型（text/vision/llm_routed）、输出、错误，以及token用量
+	var nr NodeResult
+	nr.Kind = kind
+	nr.Agent = agentKind
+	nr.Model = gr.agents.ModelName(agentKind)
+	nr.Output = output
+	nr.Error = errStr
+	nr.Attempts = attempts
+	nr.RouterAttempts = routerAttempts
+	nr.AttemptErrors = attemptErrs
+	nr.Router = decision.Router
+	nr.RouteReason = decision.Reason
+	nr.RouteCached = routeCached
+	nr.Cached = cached
+	nr.CacheKey = cacheKey
+	if promptTemplate != "" {
+		nr.PromptTemplate = promptTemplate
+		nr.PromptVersion = nodeSet.Version
+		nr.Language = nodeSet.Language
+	}
+	nr.ChargedTokens = charged
+	switch {
+	case ctx.Err() != nil:
+		// 执行过程中被取消（客户端断开、显式取消、fail-fast 或预算用尽）：模型调用被中断，输出不完整
+		nr.Status = NodeStatusCancelled
+		nr.Error = context.Cause(ctx).Error()
+		if errors.Is(context.Cause(ctx), ErrBudgetExceeded) {
+			nr.Status = NodeStatusBudgetExceeded
+		}
+	case overBudget:
+		nr.Status = NodeStatusBudgetExceeded
+	case errStr != "":
+		nr.Status = NodeStatusFailed
+	default:
+		nr.Status = NodeStatusSucceeded
+	}
+	// 监督者路由阶段tokens
+	if routerUsage != nil {
+		logs.Infof("[tokens] node=%s router prompt=%d completion=%d total=%d source=%s", node.ID, routerUsage.PromptTokens, routerUsage.CompletionTokens, routerUsage.TotalTokens, routerUsage.Source())
+		nr.RouterPromptTokens = routerUsage.PromptTokens
+		nr.RouterCompletionTokens = routerUsage.CompletionTokens
+		nr.RouterTotalTokens = routerUsage.TotalTokens
+		nr.RouterUsageSource = routerUsage.Source()
+	}
+	// 监督者使用默认模型（见 agents.go）
+	if decision.Router == RouterLLM {
+		nr.RouterModel = gr.agents.RouterModelName()
+		nr.RouterCost = gr.pricing.Cost(nr.RouterModel, nr.RouterPromptTokens, nr.RouterCompletionTokens)
+	}
+	// 若有usage则写入（模型上报或本地估算）
+	if usage != nil {
+		nr.PromptTokens = usage.PromptTokens
+		nr.CompletionTokens = usage.CompletionTokens
+		nr.TotalTokens = usage.TotalTokens
+		nr.UsageSource = usage.Source()
+		nr.Cost = gr.pricing.Cost(nr.Model, nr.PromptTokens, nr.CompletionTokens)
+	}
+	nr.DurationMs = time.Since(started).Milliseconds()
+	// 未调用代理的节点（路由失败、语言包或模板错误）按 none 统计
+	agentLabel := agentKind
+	if agentLabel == "" {
+		agentLabel = "none"
+	}
+	metrics.NodeDuration.Observe(time.Since(started).Seconds(), agentLabel)
+	gr.setResult(node.ID, nr)
+	if nr.Status == NodeStatusFailed {
+		gr.events.emit(EventNodeError, NodeErrorData{RunID: gr.runID, NodeID: node.ID, Error: nr.Error, Result: nr})
+	}
+	gr.emitNodeEnd(node.ID, nr)
+}
+
+// emitNodeEnd 发送节点结束事件（成功、失败、取消或跳过）
+func (gr *graphRun) emitNodeEnd(id string, nr NodeResult) {
+	gr.events.emit(EventNodeEnd, NodeEndData{RunID: gr.runID, NodeID: id, Result: nr})
+}
+
+// setResult 写入节点结果（写 results 需加锁）
+func (gr *graphRun) setResult(id string, nr NodeResult) {
+	gr.resMu.Lock()
+	gr.results[id] = nr
+	gr.resMu.Unlock()
+}
+
+// result 读取节点结果
+func (gr *graphRun) result(id string) NodeResult {
+	nr, _ := gr.lookup(id)
+	return nr
+}
 
-	return nil
+// lookup 读取节点结果，并返回是否已写入
+func (gr *graphRun) lookup(id string) (NodeResult, bool) {
+	gr.resMu.Lock()
+	defer gr.resMu.Unlock()
+	nr, ok := gr.results[id]
+	return nr, ok
 }
@@ -0,0 +1,332 @@
+package graphproc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"multi-agent/internal/orchestrator"
+	"multi-agent/model"
+
+	"github.com/cloudwego/eino/adk"
+)
+
+// newFakeScript 编译测试脚本；rules 按顺序匹配，未命中时回显输入（监督者回答 {"used":"text"}）
+func newFakeScript(t *testing.T, rules ...*model.FakeRule) *model.FakeScript {
+	t.Helper()
+	s := &model.FakeScript{Rules: rules}
+	if err := s.Compile(); err != nil {
+		t.Fatalf("compile fake script: %v", err)
+	}
+	return s
+}
+
+// newFakeAgent 构建由脚本驱动的代理；name 与生产代码中的代理名一致，脚本规则按名称匹配
+func newFakeAgent(t *testing.T, name string, script *model.FakeScript) adk.Agent {
+	t.Helper()
+	a, err := adk.NewChatModelAgent(context.Background(), &adk.ChatModelAgentConfig{
+		Name:        name,
+		Description: "test " + name,
+		Instruction: "test instruction for " + name,
+		Model:       model.NewFakeChatModel(name, "fake-model", script),
+	})
+	if err != nil {
+		t.Fatalf("build %s: %v", name, err)
+	}
+	return a
+}
+
+// nodeRule 让节点 id 的子代理调用按 resp 作答（提示词中包含“处理节点: <id>”）
+func nodeRule(id string, resp model.FakeResponse) *model.FakeRule {
+	return &model.FakeRule{Agent: "text_agent", Match: "处理节点: " + id + "\n", FakeResponse: resp}
+}
+
+// recorder 收集 ProcessGraph 的结构化事件
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) handle(ev Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+}
+
+// sequence 返回 "<type>:<node>" 形式的节点事件序列（忽略 delta）
+func (r *recorder) sequence() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var seq []string
+	for _, ev := range r.events {
+		switch d := ev.Data.(type) {
+		case NodeStartData:
+			seq = append(seq, ev.Type+":"+d.NodeID)
+		case NodeEndData:
+			seq = append(seq, ev.Type+":"+d.NodeID)
+		case NodeErrorData:
+			seq = append(seq, ev.Type+":"+d.NodeID)
+		}
+	}
+	return seq
+}
+
+func (r *recorder) last() Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.events[len(r.events)-1]
+}
+
+// runTestGraph 以规则路由执行图；opts.OnEvent 为空时使用返回的 recorder
+func runTestGraph(t *testing.T, sg orchestrator.SimpleGraph, script *model.FakeScript, opts RunOptions) (map[string]NodeResult, *recorder, error) {
+	t.Helper()
+	rec := &recorder{}
+	if opts.OnEvent == nil {
+		opts.OnEvent = rec.handle
+	}
+	if opts.Router == "" && opts.RouterImpl == nil {
+		opts.Router = RouterRule
+	}
+	if opts.Retry == nil {
+		opts.Retry = &orchestrator.RetryPolicy{MaxAttempts: 1}
+	}
+	printer := NewStreamPrinter()
+	printer.SetWriter(io.Discard)
+	results := map[string]NodeResult{}
+	err := ProcessGraph(context.Background(), sg,
+		newFakeAgent(t, "graph_supervisor", script),
+		newFakeAgent(t, "text_agent", script),
+		newFakeAgent(t, "vision_agent", script),
+		results, printer, opts)
+	return results, rec, err
+}
+
+func indexOf(seq []string, s string) int {
+	i := slices.Index(seq, s)
+	if i < 0 {
+		panic(fmt.Sprintf("%q not in %v", s, seq))
+	}
+	return i
+}
+
+func TestProcessGraphDispatchesInDeclarationOrder(t *testing.T) {
+	script := newFakeScript(t)
+	sg := testGraph([]string{"a", "b", "c", "d"}, "a->c", "b->d")
+	results, rec, err := runTestGraph(t, sg, script, RunOptions{MaxConcurrency: 1})
+	if err != nil {
+		t.Fatalf("ProcessGraph: %v", err)
+	}
+	want := []string{
+		"node_start:a", "node_end:a",
+		"node_start:b", "node_end:b",
+		"node_start:c", "node_end:c",
+		"node_start:d", "node_end:d",
+	}
+	if got := rec.sequence(); !slices.Equal(got, want) {
+		t.Fatalf("event order:\n got %v\nwant %v", got, want)
+	}
+	for _, id := range []string{"a", "b", "c", "d"} {
+		r := results[id]
+		if r.Status != NodeStatusSucceeded || r.Kind != RouteText {
+			t.Errorf("%s: status=%q kind=%q, want succeeded text", id, r.Status, r.Kind)
+		}
+		if want := "[fake text_agent] 处理节点: " + id; r.Output != want {
+			t.Errorf("%s: output %q, want %q", id, r.Output, want)
+		}
+	}
+}
+
+func TestProcessGraphSuccessorReadyBeforeLevelEnds(t *testing.T) {
+	// a 很快完成，s 较慢；a 的后继 b 应在 s 结束前完成，无需等待同层的 s
+	slow := 300
+	script := newFakeScript(t, nodeRule("s", model.FakeResponse{Content: "slow", LatencyMs: &slow}))
+	sg := testGraph([]string{"a", "s", "b", "j"}, "a->b", "b->j", "s->j")
+	_, rec, err := runTestGraph(t, sg, script, RunOptions{MaxConcurrency: 2})
+	if err != nil {
+		t.Fatalf("ProcessGraph: %v", err)
+	}
+	seq := rec.sequence()
+	if indexOf(seq, "node_end:b") > indexOf(seq, "node_end:s") {
+		t.Errorf("b finished after slow sibling s: %v", seq)
+	}
+	// 汇合节点 j 须等全部直接前驱完成
+	for _, pred := range []string{"b", "s"} {
+		if indexOf(seq, "node_start:j") < indexOf(seq, "node_end:"+pred) {
+			t.Errorf("j started before predecessor %s finished: %v", pred, seq)
+		}
+	}
+}
+
+func TestProcessGraphFailFast(t *testing.T) {
+	script := newFakeScript(t, nodeRule("b", model.FakeResponse{Error: "boom"}))
+	sg := testGraph([]string{"a", "b", "c", "d"}, "a->b", "b->c", "a->d")
+	results, rec, err := runTestGraph(t, sg, script, RunOptions{MaxConcurrency: 1, FailureMode: FailureFailFast})
+	if !errors.Is(err, ErrRunAborted) {
+		t.Fatalf("err = %v, want ErrRunAborted", err)
+	}
+	if !strings.Contains(err.Error(), "node b failed") {
+		t.Errorf("err = %q, want the failed node", err)
+	}
+	if r := results["a"]; r.Status != NodeStatusSucceeded {
+		t.Errorf("a: status %q, want succeeded", r.Status)
+	}
+	if r := results["b"]; r.Status != NodeStatusFailed || !strings.Contains(r.Error, "boom") {
+		t.Errorf("b: status=%q error=%q, want failed with boom", r.Status, r.Error)
+	}
+	// b 与 d 同时就绪，并发为 1 时 b 先执行；失败后 c、d 均不再启动
+	for _, id := range []string{"c", "d"} {
+		r := results[id]
+		if r.Status != NodeStatusCancelled || !strings.HasPrefix(r.Error, "node not started") {
+			t.Errorf("%s: status=%q error=%q, want cancelled before start", id, r.Status, r.Error)
+		}
+	}
+	for _, c := range script.Calls() {
+		if strings.Contains(c.Input, "处理节点: c\n") || strings.Contains(c.Input, "处理节点: d\n") {
+			t.Errorf("model called for a node after fail-fast: %s", c.Source)
+		}
+	}
+	end := rec.last()
+	if data, ok := end.Data.(RunEndData); end.Type != EventRunEnd || !ok || data.Status != RunStatusFailed {
+		t.Errorf("last event = %s %+v, want run_end with status failed", end.Type, end.Data)
+	}
+}
+
+func TestProcessGraphContinueAfterFailure(t *testing.T) {
+	script := newFakeScript(t, nodeRule("b", model.FakeResponse{Error: "boom"}))
+	sg := testGraph([]string{"a", "b", "c"}, "a->b", "b->c")
+	results, _, err := runTestGraph(t, sg, script, RunOptions{MaxConcurrency: 1})
+	if err != nil {
+		t.Fatalf("ProcessGraph: %v", err)
+	}
+	if r := results["b"]; r.Status != NodeStatusFailed {
+		t.Errorf("b: status %q, want failed", r.Status)
+	}
+	// continue 模式下后继照常执行
+	if r := results["c"]; r.Status != NodeStatusSucceeded {
+		t.Errorf("c: status %q, want succeeded", r.Status)
+	}
+}
+
+func TestProcessGraphNodeEventsPaired(t *testing.T) {
+	// 每个节点恰好一对 node_start / node_end，且 start 在前；不调用模型的节点也不例外
+	sg := testGraph([]string{"a", "b", "c", "d"}, "a->b", "b->c", "a->d")
+	tests := []struct {
+		name   string
+		opts   RunOptions
+		status map[string]string
+	}{
+		{
+			name:   "skip-downstream",
+			opts:   RunOptions{MaxConcurrency: 1, FailureMode: FailureSkipDownstream},
+			status: map[string]string{"a": NodeStatusSucceeded, "b": NodeStatusFailed, "c": NodeStatusSkipped, "d": NodeStatusSucceeded},
+		},
+		{
+			name:   "fail-fast",
+			opts:   RunOptions{MaxConcurrency: 1, FailureMode: FailureFailFast},
+			status: map[string]string{"a": NodeStatusSucceeded, "b": NodeStatusFailed, "c": NodeStatusCancelled, "d": NodeStatusCancelled},
+		},
+		{
+			name:   "preset",
+			opts:   RunOptions{MaxConcurrency: 1, Preset: map[string]NodeResult{"b": {Status: NodeStatusSucceeded, Output: "kept", Reused: true}}},
+			status: map[string]string{"a": NodeStatusSucceeded, "b": NodeStatusSucceeded, "c": NodeStatusSucceeded, "d": NodeStatusSucceeded},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := newFakeScript(t, nodeRule("b", model.FakeResponse{Error: "boom"}))
+			results, rec, _ := runTestGraph(t, sg, script, tt.opts)
+			for id, want := range tt.status {
+				if got := results[id].Status; got != want {
+					t.Errorf("%s: status %q, want %q", id, got, want)
+				}
+			}
+			seq := rec.sequence()
+			for _, id := range []string{"a", "b", "c", "d"} {
+				var starts, ends int
+				for _, s := range seq {
+					switch s {
+					case EventNodeStart + ":" + id:
+						starts++
+					case EventNodeEnd + ":" + id:
+						ends++
+					}
+				}
+				if starts != 1 || ends != 1 {
+					t.Errorf("%s: %d node_start / %d node_end, want 1 / 1 in %v", id, starts, ends, seq)
+					continue
+				}
+				if indexOf(seq, EventNodeStart+":"+id) > indexOf(seq, EventNodeEnd+":"+id) {
+					t.Errorf("%s: node_end before node_start in %v", id, seq)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessGraphInvalidOptionsEndRun(t *testing.T) {
+	// 参数无效时不执行任何节点，事件消费方只收到一条携带错误的 run_end
+	tests := []struct {
+		name   string
+		opts   RunOptions
+		graph  *orchestrator.GraphOptions
+		errSub string
+	}{
+		{name: "failure mode", opts: RunOptions{FailureMode: "retry-forever"}, errSub: "retry-forever"},
+		{name: "router", opts: RunOptions{Router: "coin"}, errSub: "coin"},
+		{name: "graph prompt version", graph: &orchestrator.GraphOptions{PromptVersion: "v404"}, errSub: "v404"},
+		{name: "language", opts: RunOptions{Language: "xx"}, errSub: "xx"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sg := testGraph([]string{"a", "b"}, "a->b")
+			sg.Options = tt.graph
+			if err := CheckRunOptions(sg, tt.opts); err == nil || !strings.Contains(err.Error(), tt.errSub) {
+				t.Errorf("CheckRunOptions = %v, want error mentioning %q", err, tt.errSub)
+			}
+			script := newFakeScript(t)
+			results, rec, err := runTestGraph(t, sg, script, tt.opts)
+			if !errors.Is(err, ErrInvalidRunOptions) || !strings.Contains(err.Error(), tt.errSub) {
+				t.Fatalf("ProcessGraph = %v, want ErrInvalidRunOptions mentioning %q", err, tt.errSub)
+			}
+			if len(results) != 0 || len(script.Calls()) != 0 {
+				t.Errorf("results = %v, %d model calls; want nothing executed", results, len(script.Calls()))
+			}
+			if len(rec.events) != 1 {
+				t.Fatalf("events = %+v, want a single run_end", rec.events)
+			}
+			end, ok := rec.last().Data.(RunEndData)
+			if rec.last().Type != EventRunEnd || !ok || end.Status != RunStatusFailed || end.Error != err.Error() {
+				t.Errorf("run_end = %+v", rec.last())
+			}
+		})
+	}
+}
+
+func TestProcessGraphRecordsRouterAttempts(t *testing.T) {
+	// 监督者首次调用失败（可重试）后成功；子代理一次成功
+	script := newFakeScript(t,
+		&model.FakeRule{Agent: "graph_supervisor", Times: 1, FakeResponse: model.FakeResponse{Error: "503 service unavailable"}},
+		&model.FakeRule{Agent: "graph_supervisor", FakeResponse: model.FakeResponse{Content: `{"used":"text"}`}},
+	)
+	sg := testGraph([]string{"a"})
+	retry := &orchestrator.RetryPolicy{MaxAttempts: 3, InitialBackoffMs: 1, MaxBackoffMs: 1}
+	results, _, err := runTestGraph(t, sg, script, RunOptions{Router: RouterLLM, Retry: retry})
+	if err != nil {
+		t.Fatalf("ProcessGraph: %v", err)
+	}
+	r := results["a"]
+	if r.Status != NodeStatusSucceeded {
+		t.Fatalf("a: status=%q error=%q, want succeeded", r.Status, r.Error)
+	}
+	if r.RouterAttempts != 2 || r.Attempts != 1 {
+		t.Errorf("router_attempts=%d attempts=%d, want 2/1", r.RouterAttempts, r.Attempts)
+	}
+	if len(r.AttemptErrors) != 1 || r.AttemptErrors[0].Stage != StageRouter {
+		t.Errorf("attempt_errors = %+v, want one router failure", r.AttemptErrors)
+	}
+}
@@ -0,0 +1,415 @@
+package graphproc
+
+// 本文件管理节点提示词与代理指令模板（text/template），按 版本 × 语言 组织为语言包：
+// - 默认模板随程序内嵌（prompts/<版本>/<语言>/*.tmpl，内置 zh 与 en），PROMPT_TEMPLATE_DIR 目录下同结构的文件可覆盖或新增版本与语言
+// - 覆盖按文件名生效：目录中的 <版本>/<语言>/<文件> 替换内嵌同版本同语言的同名文件；
+//   内嵌中不存在的 版本/语言 以默认版本的同语言包为基础（该语言也不存在时以默认版本的 zh 为基础）
+// - 版本选择（后者覆盖前者）：DefaultPromptVersion < PROMPT_VERSION < 图级 options.prompt_version < RunOptions.PromptVersion
+// - 语言选择（后者覆盖前者）：DefaultPromptLanguage < PROMPT_LANGUAGE < 图级 options.language < RunOptions.Language < 节点 payload.language
+// 每个节点实际使用的模板名、版本与语言记录在 NodeResult.PromptTemplate / PromptVersion / Language 中；
+// 模板内容摘要参与缓存键计算，修改模板后旧缓存自然失效（见 cache.go）。
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"multi-agent/internal/orchestrator"
+)
+
+//go:embed prompts
+var embeddedPrompts embed.FS
+
+// PromptDirEnv 指定模板覆盖目录的环境变量
+const PromptDirEnv = "PROMPT_TEMPLATE_DIR"
+
+// PromptVersionEnv 指定默认模板版本的环境变量
+const PromptVersionEnv = "PROMPT_VERSION"
+
+// PromptLanguageEnv 指定默认语言的环境变量
+const PromptLanguageEnv = "PROMPT_LANGUAGE"
+
+// DefaultPromptVersion 内置默认模板版本
+const DefaultPromptVersion = "v1"
+
+// DefaultPromptLanguage 内置默认语言
+const DefaultPromptLanguage = "zh"
+
+// 节点提示词入口模板
+const (
+	PromptNodeFirst  = "node_first"
+	PromptNodeMiddle = "node_middle"
+	PromptNodeLast   = "node_last"
+	// PromptSupervisor 监督者指令模板
+	PromptSupervisor = "supervisor"
+	// PromptRoute 监督者路由输入模板（llm / hybrid 路由）
+	PromptRoute = "route"
+	// PromptPrevLine 一条前驱输出的格式
+	PromptPrevLine = "prev_line"
+)
+
+// NodePromptData 节点提示词模板的数据
+type NodePromptData struct {
+	NodeID string
+	// Type 节点类型（ExportNode.Type），可能为空
+	Type string
+	// Payload 当前节点负载（原始 JSON）
+	Payload string
+	// Prevs 直接前驱输出；模板中用 {{prev .}} 按边意图渲染
+	Prevs []PrevOutput
+	// Agent 执行该节点的代理类型，OutputRules 为其定义的输出要求（为空时使用通用要求）
+	Agent       string
+	OutputRules []string
+	// Graph 完整图负载（JSON），仅最后节点
+	Graph string
+	// ImageURL 图片链接，仅视觉代理；ImageAttached 为 true 时图片已作为多模态部分附加到消息（VISION_IMAGE_MODE=inline，见 image.go）
+	ImageURL      string
+	ImageAttached bool
+}
+
+// RoutePromptData 路由输入模板的数据
+type RoutePromptData struct {
+	NodeID string
+	// Payload 节点负载（原始 JSON），Prevs 为前驱输出数组的 JSON
+	Payload string
+	Prevs   string
+}
+
+// PromptSet 一个 版本 × 语言 的模板集合（语言包）
+type PromptSet struct {
+	Version  string `json:"version"`
+	Language string `json:"language"`
+	// Digest 模板源文件内容的 SHA-256
+	Digest string `json:"digest"`
+	// Files 模板文件名与来源（embedded 或覆盖目录中的路径）
+	Files map[string]string `json:"files"`
+	// Templates 已定义的模板名
+	Templates []string `json:"templates"`
+
+	tmpl *template.Template
+}
+
+// ID 返回版本、语言与内容摘要，用于区分同名版本的不同内容
+func (s *PromptSet) ID() string {
+	return s.Version + "/" + s.Language + "@" + s.Digest[:12]
+}
+
+// Has 判断模板是否存在
+func (s *PromptSet) Has(name string) bool {
+	return s.tmpl.Lookup(name) != nil
+}
+
+// Render 执行指定模板
+func (s *PromptSet) Render(name string, data any) (string, error) {
+	if !s.Has(name) {
+		return "", fmt.Errorf("prompt template %q not found in %s/%s", name, s.Version, s.Language)
+	}
+	var sb strings.Builder
+	if err := s.tmpl.ExecuteTemplate(&sb, name, data); err != nil {
+		return "", fmt.Errorf("render prompt template %s@%s/%s: %w", name, s.Version, s.Language, err)
+	}
+	return sb.String(), nil
+}
+
+// renderPrev 模板函数 prev：按边意图渲染一条前驱输出（以换行结尾）
+func (s *PromptSet) renderPrev(p PrevOutput) (string, error) {
+	out := strings.TrimSpace(p.Output)
+	line := PrevLineData{ID: p.ID, Kind: p.Kind, Intent: p.Intent, Output: out, Indented: strings.ReplaceAll(out, "\n", "\n  ")}
+	if KnownEdgeIntent(p.Intent) && s.Has("intent_"+p.Intent) {
+		frame, err := s.Render("intent_"+p.Intent, newIntentData(p.Params))
+		if err != nil {
+			return "", err
+		}
+		line.Frame = strings.TrimSpace(frame)
+	}
+	return s.Render(PromptPrevLine, line)
+}
+
+// PromptLibrary 全部可用的模板版本与语言包
+type PromptLibrary struct {
+	// 版本 → 语言 → 语言包
+	sets        map[string]map[string]*PromptSet
+	defVersion  string
+	defLanguage string
+}
+
+// promptFile 一个模板源文件
+type promptFile struct {
+	source string
+	text   string
+}
+
+// LoadPromptLibrary 加载内嵌模板，并在 dir 非空时应用覆盖目录
+func LoadPromptLibrary(dir string) (*PromptLibrary, error) {
+	// 键为 <版本>/<语言>
+	files := make(map[string]map[string]promptFile)
+	err := fs.WalkDir(embeddedPrompts, "prompts", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".tmpl" {
+			return err
+		}
+		parts := strings.Split(path, "/")
+		if len(parts) != 4 {
+			return nil
+		}
+		data, err := embeddedPrompts.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		key := parts[1] + "/" + parts[2]
+		if files[key] == nil {
+			files[key] = make(map[string]promptFile)
+		}
+		files[key][parts[3]] = promptFile{source: "embedded", text: string(data)}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read embedded prompts: %w", err)
+	}
+
+	if strings.TrimSpace(dir) != "" {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("read prompt template dir: %w", err)
+		}
+		matches, err := filepath.Glob(filepath.Join(dir, "*", "*", "*.tmpl"))
+		if err != nil {
+			return nil, fmt.Errorf("read prompt template dir: %w", err)
+		}
+		for _, path := range matches {
+			rel, _ := filepath.Rel(dir, path)
+			parts := strings.Split(filepath.ToSlash(rel), "/")
+			if strings.HasPrefix(parts[0], ".") || strings.HasPrefix(parts[1], ".") {
+				continue
+			}
+			key := parts[0] + "/" + NormalizeLanguage(parts[1])
+			set, ok := files[key]
+			if !ok {
+				// 新的版本或语言以默认版本的同语言包（或 zh）为基础，只需提供需要修改的文件
+				base, ok := files[DefaultPromptVersion+"/"+NormalizeLanguage(parts[1])]
+				if !ok {
+					base = files[DefaultPromptVersion+"/"+DefaultPromptLanguage]
+				}
+				set = make(map[string]promptFile, len(base))
+				for name, f := range base {
+					set[name] = f
+				}
+				files[key] = set
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("read prompt template: %w", err)
+			}
+			set[parts[2]] = promptFile{source: path, text: string(data)}
+		}
+	}
+
+	lib := &PromptLibrary{sets: make(map[string]map[string]*PromptSet), defVersion: DefaultPromptVersion, defLanguage: DefaultPromptLanguage}
+	for key, set := range files {
+		version, language, _ := strings.Cut(key, "/")
+		ps, err := parsePromptSet(version, language, set)
+		if err != nil {
+			return nil, err
+		}
+		if lib.sets[version] == nil {
+			lib.sets[version] = make(map[string]*PromptSet)
+		}
+		lib.sets[version][language] = ps
+	}
+	if v := strings.TrimSpace(os.Getenv(PromptVersionEnv)); v != "" {
+		lib.defVersion = v
+	}
+	if l := strings.TrimSpace(os.Getenv(PromptLanguageEnv)); l != "" {
+		lib.defLanguage = NormalizeLanguage(l)
+	}
+	if _, err := lib.Get("", ""); err != nil {
+		return nil, fmt.Errorf("default prompt set: %w", err)
+	}
+	return lib, nil
+}
+
+// parsePromptSet 按文件名顺序解析一个语言包，并校验必需的模板齐全
+func parsePromptSet(version, language string, files map[string]promptFile) (*PromptSet, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ps := &PromptSet{Version: version, Language: language, Files: make(map[string]string, len(names))}
+	tmpl := template.New(version + "/" + language).Funcs(template.FuncMap{"prev": ps.renderPrev}).Option("missingkey=error")
+	h := sha256.New()
+	for _, name := range names {
+		f := files[name]
+		if _, err := tmpl.New(name).Parse(f.text); err != nil {
+			return nil, fmt.Errorf("parse prompt template %s (%s): %w", name, f.source, err)
+		}
+		ps.Files[name] = f.source
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write([]byte(f.text))
+		h.Write([]byte{0})
+	}
+	ps.Digest = hex.EncodeToString(h.Sum(nil))
+	ps.tmpl = tmpl
+	for _, name := range []string{PromptNodeFirst, PromptNodeMiddle, PromptNodeLast, PromptSupervisor, PromptRoute, PromptPrevLine} {
+		if !ps.Has(name) {
+			return nil, fmt.Errorf("prompt set %s/%s: template %q is required", version, language, name)
+		}
+	}
+	for _, t := range tmpl.Templates() {
+		// 文件名本身也是模板（仅含 define 时为空），不列出
+		if _, isFile := files[t.Name()]; !isFile && t.Name() != tmpl.Name() {
+			ps.Templates = append(ps.Templates, t.Name())
+		}
+	}
+	sort.Strings(ps.Templates)
+	return ps, nil
+}
+
+// NormalizeLanguage 规范化语言标识：小写，下划线改为短横线（zh_CN → zh-cn）
+func NormalizeLanguage(lang string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(lang)), "_", "-")
+}
+
+// Get 返回指定版本与语言的语言包；为空时使用默认值。
+// 语言先按完整标识匹配，再按主语言匹配（en-US → en）
+func (l *PromptLibrary) Get(version, language string) (*PromptSet, error) {
+	if strings.TrimSpace(version) == "" {
+		version = l.defVersion
+	}
+	language = NormalizeLanguage(language)
+	if language == "" {
+		language = l.defLanguage
+	}
+	langs, ok := l.sets[version]
+	if !ok {
+		return nil, fmt.Errorf("unknown prompt version %q (available: %s)", version, strings.Join(l.Versions(), ", "))
+	}
+	if ps, ok := langs[language]; ok {
+		return ps, nil
+	}
+	if base, _, found := strings.Cut(language, "-"); found {
+		if ps, ok := langs[base]; ok {
+			return ps, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown language %q for prompt version %s (available: %s)", language, version, strings.Join(l.Languages(version), ", "))
+}
+
+// Default 返回默认版本的默认语言包
+func (l *PromptLibrary) Default() *PromptSet {
+	ps, _ := l.Get("", "")
+	return ps
+}
+
+// DefaultVersion 返回默认版本名
+func (l *PromptLibrary) DefaultVersion() string { return l.defVersion }
+
+// DefaultLanguage 返回默认语言
+func (l *PromptLibrary) DefaultLanguage() string { return l.defLanguage }
+
+// Versions 返回全部版本名（排序）
+func (l *PromptLibrary) Versions() []string {
+	out := make([]string, 0, len(l.sets))
+	for v := range l.sets {
+		out = append(out, v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Languages 返回版本下的全部语言（排序）
+func (l *PromptLibrary) Languages(version string) []string {
+	out := make([]string, 0, len(l.sets[version]))
+	for lang := range l.sets[version] {
+		out = append(out, lang)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Sets 返回全部语言包（按版本、语言排序）
+func (l *PromptLibrary) Sets() []*PromptSet {
+	var out []*PromptSet
+	for _, v := range l.Versions() {
+		for _, lang := range l.Languages(v) {
+			out = append(out, l.sets[v][lang])
+		}
+	}
+	return out
+}
+
+var (
+	defaultPromptsOnce sync.Once
+	defaultPrompts     *PromptLibrary
+	defaultPromptsErr  error
+)
+
+// DefaultPromptLibrary 返回进程级模板库（内嵌模板 + PROMPT_TEMPLATE_DIR），首次调用时加载
+func DefaultPromptLibrary() (*PromptLibrary, error) {
+	defaultPromptsOnce.Do(func() {
+		defaultPrompts, defaultPromptsErr = LoadPromptLibrary(os.Getenv(PromptDirEnv))
+	})
+	return defaultPrompts, defaultPromptsErr
+}
+
+// ResolvePromptSet 按 运行参数 > 图级 options > 默认值 选择本次执行使用的模板版本与语言
+func ResolvePromptSet(runVersion, runLanguage string, graph *orchestrator.GraphOptions) (*PromptSet, error) {
+	lib, err := DefaultPromptLibrary()
+	if err != nil {
+		return nil, err
+	}
+	version, language := strings.TrimSpace(runVersion), strings.TrimSpace(runLanguage)
+	if graph != nil {
+		if version == "" {
+			version = strings.TrimSpace(graph.PromptVersion)
+		}
+		if language == "" {
+			language = strings.TrimSpace(graph.Language)
+		}
+	}
+	return lib.Get(version, language)
+}
+
+// defaultPromptSet 返回 prompts，为空时返回默认语言包
+func defaultPromptSet(prompts *PromptSet) (*PromptSet, error) {
+	if prompts != nil {
+		return prompts, nil
+	}
+	lib, err := DefaultPromptLibrary()
+	if err != nil {
+		return nil, err
+	}
+	return lib.Default(), nil
+}
+
+// nodePromptSet 返回节点使用的语言包：payload.language 覆盖本次执行的语言（版本不变）
+func nodePromptSet(run *PromptSet, payload map[string]any) (*PromptSet, error) {
+	lang := NormalizeLanguage(payloadString(payload, "language"))
+	if lang == "" || lang == run.Language {
+		return run, nil
+	}
+	lib, err := DefaultPromptLibrary()
+	if err != nil {
+		return nil, err
+	}
+	return lib.Get(run.Version, lang)
+}
+
+// nodePromptTemplate 返回节点使用的入口模板名
+func nodePromptTemplate(isLast bool, prevs int) string {
+	switch {
+	case isLast:
+		return PromptNodeLast
+	case prevs == 0:
+		return PromptNodeFirst
+	default:
+		return PromptNodeMiddle
+	}
+}
@@ -0,0 +1,329 @@
+package graphproc
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"multi-agent/internal/orchestrator"
+)
+
+// writePromptDir 按 "<版本>/<语言>/<文件>" → 内容 创建覆盖目录
+func writePromptDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for rel, text := range files {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func loadPrompts(t *testing.T, dir string) *PromptLibrary {
+	t.Helper()
+	lib, err := LoadPromptLibrary(dir)
+	if err != nil {
+		t.Fatalf("LoadPromptLibrary: %v", err)
+	}
+	return lib
+}
+
+func TestLoadPromptLibraryEmbedded(t *testing.T) {
+	lib := loadPrompts(t, "")
+	if got := lib.Versions(); !slices.Equal(got, []string{"v1"}) {
+		t.Errorf("versions = %v", got)
+	}
+	ps := lib.Default()
+	if ps.Version != DefaultPromptVersion || ps.Language != DefaultPromptLanguage || ps.Files["router.tmpl"] != "embedded" {
+		t.Errorf("default set = %s/%s files=%v", ps.Version, ps.Language, ps.Files)
+	}
+	// 内容摘要稳定：同样的模板得到同样的 ID（缓存键依赖它）
+	if again := loadPrompts(t, "").Default(); again.ID() != ps.ID() {
+		t.Errorf("ID not stable: %s != %s", again.ID(), ps.ID())
+	}
+	if !strings.HasPrefix(ps.ID(), "v1/zh@") || len(ps.ID()) != len("v1/zh@")+12 {
+		t.Errorf("ID = %q", ps.ID())
+	}
+}
+
+func TestLoadPromptLibraryOverrideDir(t *testing.T) {
+	const route = `{{define "route"}}custom route for {{.NodeID}}{{end}}`
+	dir := writePromptDir(t, map[string]string{
+		// 覆盖内置版本中的单个文件
+		"v1/zh/router.tmpl": route,
+		// 新版本只提供需要修改的文件，其余继承 v1 的同语言包
+		"v2/en/extra.tmpl": `{{define "extra"}}extra{{end}}`,
+		// 新版本的新语言继承 v1/zh；目录名按语言标识规范化
+		"v2/FR_ca/router.tmpl": route,
+		// 隐藏目录与非模板文件被忽略
+		".git/zh/x.tmpl":  "{{",
+		"v1/.tmp/x.tmpl":  "{{",
+		"v1/zh/README.md": "{{",
+	})
+	lib := loadPrompts(t, dir)
+	if got := lib.Versions(); !slices.Equal(got, []string{"v1", "v2"}) {
+		t.Fatalf("versions = %v", got)
+	}
+	if got := lib.Languages("v2"); !slices.Equal(got, []string{"en", "fr-ca"}) {
+		t.Errorf("v2 languages = %v", got)
+	}
+
+	embedded := loadPrompts(t, "")
+	zh, _ := lib.Get("v1", "zh")
+	if zh.Files["router.tmpl"] != filepath.Join(dir, "v1", "zh", "router.tmpl") || zh.Files["node.tmpl"] != "embedded" {
+		t.Errorf("v1/zh sources = %v", zh.Files)
+	}
+	if zh.ID() == embedded.Default().ID() {
+		t.Error("override did not change the prompt set ID")
+	}
+	if out, err := zh.Render(PromptRoute, RoutePromptData{NodeID: "n1"}); err != nil || out != "custom route for n1" {
+		t.Errorf("route = %q, %v", out, err)
+	}
+	// 未覆盖的语言包不受影响
+	if en, _ := lib.Get("v1", "en"); en.ID() != mustGet(t, embedded, "v1", "en").ID() {
+		t.Error("v1/en changed without overrides")
+	}
+
+	v2en, _ := lib.Get("v2", "en")
+	if !v2en.Has("extra") || v2en.Files["agents.tmpl"] != "embedded" {
+		t.Errorf("v2/en templates=%v files=%v", v2en.Templates, v2en.Files)
+	}
+	base, _ := mustGet(t, embedded, "v1", "en").Render(PromptSupervisor, nil)
+	if got, _ := v2en.Render(PromptSupervisor, nil); got != base {
+		t.Error("v2/en should inherit v1/en agent templates")
+	}
+	v2fr := mustGet(t, lib, "v2", "fr-ca")
+	zhSupervisor, _ := mustGet(t, embedded, "v1", "zh").Render(PromptSupervisor, nil)
+	if got, _ := v2fr.Render(PromptSupervisor, nil); got != zhSupervisor {
+		t.Error("v2/fr-ca should inherit v1/zh")
+	}
+}
+
+func mustGet(t *testing.T, lib *PromptLibrary, version, language string) *PromptSet {
+	t.Helper()
+	ps, err := lib.Get(version, language)
+	if err != nil {
+		t.Fatalf("Get(%q, %q): %v", version, language, err)
+	}
+	return ps
+}
+
+func TestLoadPromptLibraryErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		env    string
+		errSub string
+	}{
+		{name: "parse error", files: map[string]string{"v1/zh/node.tmpl": "{{define \"node_first\"}}{{.NodeID"}, errSub: "parse prompt template node.tmpl"},
+		{name: "required template missing", files: map[string]string{"v1/zh/router.tmpl": "no route here"}, errSub: `template "route" is required`},
+		{name: "unknown default version", env: "v9", errSub: `default prompt set: unknown prompt version "v9"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(PromptVersionEnv, tt.env)
+			_, err := LoadPromptLibrary(writePromptDir(t, tt.files))
+			if err == nil || !strings.Contains(err.Error(), tt.errSub) {
+				t.Fatalf("err = %v, want %q", err, tt.errSub)
+			}
+		})
+	}
+	if _, err := LoadPromptLibrary(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("want error for a missing override dir")
+	}
+}
+
+func TestPromptLibraryVersionResolution(t *testing.T) {
+	dir := writePromptDir(t, map[string]string{"v2/zh/extra.tmpl": `{{define "extra"}}x{{end}}`})
+	tests := []struct {
+		name    string
+		env     string
+		version string
+		want    string
+		errSub  string
+	}{
+		{name: "builtin default", want: "v1"},
+		{name: "explicit", version: "v2", want: "v2"},
+		{name: "explicit trimmed", version: " v2 ", errSub: `unknown prompt version " v2 "`},
+		{name: "env default", env: "v2", want: "v2"},
+		{name: "explicit beats env", env: "v2", version: "v1", want: "v1"},
+		{name: "unknown", version: "v3", errSub: `unknown prompt version "v3" (available: v1, v2)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(PromptVersionEnv, tt.env)
+			lib := loadPrompts(t, dir)
+			ps, err := lib.Get(tt.version, "")
+			if tt.errSub != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errSub) {
+					t.Fatalf("err = %v, want %q", err, tt.errSub)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ps.Version != tt.want || ps.Language != DefaultPromptLanguage {
+				t.Errorf("got %s/%s, want %s/%s", ps.Version, ps.Language, tt.want, DefaultPromptLanguage)
+			}
+		})
+	}
+}
+
+func TestResolvePromptSetVersion(t *testing.T) {
+	// 进程级模板库只含内嵌的 v1
+	tests := []struct {
+		name   string
+		run    string
+		graph  *orchestrator.GraphOptions
+		errSub string
+	}{
+		{name: "defaults"},
+		{name: "graph option", graph: &orchestrator.GraphOptions{PromptVersion: "v1"}},
+		{name: "run option beats graph", run: "v1", graph: &orchestrator.GraphOptions{PromptVersion: "v9"}},
+		{name: "unknown graph version", graph: &orchestrator.GraphOptions{PromptVersion: "v9"}, errSub: `unknown prompt version "v9"`},
+		{name: "unknown run version", run: "v9", graph: &orchestrator.GraphOptions{PromptVersion: "v1"}, errSub: `unknown prompt version "v9"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, err := ResolvePromptSet(tt.run, "", tt.graph)
+			if tt.errSub != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errSub) {
+					t.Fatalf("err = %v, want %q", err, tt.errSub)
+				}
+				return
+			}
+			if err != nil || ps.Version != "v1" {
+				t.Errorf("got %v, %v", ps, err)
+			}
+		})
+	}
+}
+
+func TestNormalizeLanguage(t *testing.T) {
+	for in, want := range map[string]string{"zh": "zh", " EN_us ": "en-us", "zh_Hant_TW": "zh-hant-tw", "": ""} {
+		if got := NormalizeLanguage(in); got != want {
+			t.Errorf("NormalizeLanguage(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestPromptLibraryLanguageResolution(t *testing.T) {
+	// 覆盖目录新增 v1/en-gb，验证完整标识优先于主语言
+	dir := writePromptDir(t, map[string]string{"v1/en-gb/extra.tmpl": `{{define "extra"}}x{{end}}`})
+	tests := []struct {
+		name   string
+		env    string
+		lang   string
+		want   string
+		errSub string
+	}{
+		{name: "builtin default", want: "zh"},
+		{name: "exact", lang: "en", want: "en"},
+		{name: "case and underscore", lang: "EN_GB", want: "en-gb"},
+		{name: "region falls back to base language", lang: "en-US", want: "en"},
+		{name: "zh region", lang: "zh_CN", want: "zh"},
+		{name: "env default", env: "en_AU", want: "en"},
+		{name: "explicit beats env", env: "en", lang: "zh", want: "zh"},
+		{name: "unknown", lang: "fr", errSub: `unknown language "fr" for prompt version v1 (available: en, en-gb, zh)`},
+		{name: "unknown region of unknown base", lang: "fr-CA", errSub: `unknown language "fr-ca"`},
+		{name: "unknown env default", env: "fr", errSub: `unknown language "fr"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(PromptLanguageEnv, tt.env)
+			lib, err := LoadPromptLibrary(dir)
+			var ps *PromptSet
+			if err == nil {
+				ps, err = lib.Get("", tt.lang)
+			}
+			if tt.errSub != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errSub) {
+					t.Fatalf("err = %v, want %q", err, tt.errSub)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ps.Language != tt.want {
+				t.Errorf("language = %q, want %q", ps.Language, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolvePromptSetLanguage(t *testing.T) {
+	tests := []struct {
+		name  string
+		run   string
+		graph *orchestrator.GraphOptions
+		want  string
+	}{
+		{name: "default", want: "zh"},
+		{name: "graph option", graph: &orchestrator.GraphOptions{Language: "en"}, want: "en"},
+		{name: "run option beats graph", run: "zh", graph: &orchestrator.GraphOptions{Language: "en"}, want: "zh"},
+		{name: "blank run option ignored", run: "  ", graph: &orchestrator.GraphOptions{Language: "en-US"}, want: "en"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, err := ResolvePromptSet("", tt.run, tt.graph)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ps.Language != tt.want {
+				t.Errorf("language = %q, want %q", ps.Language, tt.want)
+			}
+		})
+	}
+	if _, err := ResolvePromptSet("", "fr", nil); err == nil {
+		t.Error("want error for unknown run language")
+	}
+}
+
+func TestNodePromptSet(t *testing.T) {
+	run, err := ResolvePromptSet("", "zh", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		payload map[string]any
+		want    string
+		errSub  string
+	}{
+		{name: "no language", payload: map[string]any{"text": "x"}, want: "zh"},
+		{name: "same language", payload: map[string]any{"language": "ZH"}, want: "zh"},
+		{name: "node language", payload: map[string]any{"language": "en_US"}, want: "en"},
+		{name: "non-string ignored", payload: map[string]any{"language": 1}, want: "zh"},
+		{name: "unknown", payload: map[string]any{"language": "fr"}, errSub: `unknown language "fr"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, err := nodePromptSet(run, tt.payload)
+			if tt.errSub != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errSub) {
+					t.Fatalf("err = %v, want %q", err, tt.errSub)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ps.Language != tt.want || ps.Version != run.Version {
+				t.Errorf("got %s/%s, want %s/%s", ps.Version, ps.Language, run.Version, tt.want)
+			}
+			// 语言与本次执行相同时沿用同一个语言包（代理实例按语言包缓存）
+			if tt.want == run.Language && ps != run {
+				t.Error("same language returned a different prompt set")
+			}
+		})
+	}
+}
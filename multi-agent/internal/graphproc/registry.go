@@ -0,0 +1,446 @@
+package graphproc
+
+// 本文件维护“节点类型 → 子代理定义”的注册表：
+// - 内置 text / vision 两种通用子代理，以及面向白板模块类型的 summarizer / action_items / critic
+// - 通过 AGENT_REGISTRY_FILE 指向的 JSON 文件新增或覆盖代理定义（指令、模型、工具、输出要求、适用的节点类型）
+// - 代理实例按需构建并缓存；工具需先通过 RegisterTool 注册后才能在定义中引用
+// 节点类型匹配忽略大小写，前端导出的显示名称（如“议程面板”）可作为别名写入 node_types。
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"multi-agent/model"
+
+	"github.com/cloudwego/eino/adk"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+)
+
+// 内置代理类型
+const (
+	AgentText        = "text"
+	AgentVision      = "vision"
+	AgentSummarizer  = "summarizer"
+	AgentActionItems = "action_items"
+	AgentCritic      = "critic"
+)
+
+// AgentRegistryEnv 指定额外代理定义文件的环境变量
+const AgentRegistryEnv = "AGENT_REGISTRY_FILE"
+
+// AgentDef 一种子代理的定义
+type AgentDef struct {
+	// Kind 代理类型标识（小写字母、数字、下划线或短横线），代理名为 <kind>_agent
+	Kind        string `json:"kind"`
+	Description string `json:"description,omitempty"`
+	// Instruction 系统指令；InstructionTemplate 非空时改用本次执行语言包中的同名模板渲染（见 prompts.go）
+	Instruction         string `json:"instruction,omitempty"`
+	InstructionTemplate string `json:"instruction_template,omitempty"`
+	// Model 覆盖模型名称（为空时使用 ARK_MODEL / OPENAI_MODEL）
+	Model string `json:"model,omitempty"`
+	// Tools 挂载的工具名称（须已通过 RegisterTool 注册）
+	Tools []string `json:"tools,omitempty"`
+	// OutputRules 非最后节点的“输出要求”条目；为空时使用通用要求。
+	// OutputRulesTemplate 非空时改用语言包中的同名模板（每行一条）
+	OutputRules         []string `json:"output_rules,omitempty"`
+	OutputRulesTemplate string   `json:"output_rules_template,omitempty"`
+	// Locales 按语言覆盖指令与输出要求（如 {"en": {"instruction": "...", "output_rules": [...]}}），优先于模板与默认值
+	Locales map[string]AgentLocale `json:"locales,omitempty"`
+	// Vision 为 true 时该代理处理图像内容（路由为 vision）
+	Vision bool `json:"vision,omitempty"`
+	// NodeTypes 由该代理处理的节点类型（ExportNode.Type）
+	NodeTypes []string `json:"node_types,omitempty"`
+}
+
+// AgentLocale 某一语言下的指令与输出要求；空字段沿用默认值
+type AgentLocale struct {
+	Instruction string   `json:"instruction,omitempty"`
+	OutputRules []string `json:"output_rules,omitempty"`
+}
+
+// agentRegistryFile 代理定义文件格式
+type agentRegistryFile struct {
+	Agents []AgentDef `json:"agents"`
+}
+
+var agentKindPattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// builtinAgentDefs 内置代理定义
+func builtinAgentDefs() []AgentDef {
+	return []AgentDef{
+		{
+			Kind:                AgentText,
+			Description:         "负责处理文本内容的代理",
+			InstructionTemplate: "agent_text",
+		},
+		{
+			Kind:                AgentVision,
+			Description:         "负责处理图像内容的代理",
+			InstructionTemplate: "agent_vision",
+			Tools:               []string{ToolGetImage},
+			Vision:              true,
+			NodeTypes:           []string{"photo", "Photo"},
+		},
+		{
+			Kind:                AgentSummarizer,
+			Description:         "负责总结议程与会议纪要的代理",
+			InstructionTemplate: "agent_summarizer",
+			OutputRulesTemplate: "rules_summarizer",
+			NodeTypes:           []string{"agenda-panel", "议程面板"},
+		},
+		{
+			Kind:                AgentActionItems,
+			Description:         "负责抽取行动项的代理",
+			InstructionTemplate: "agent_action_items",
+			OutputRulesTemplate: "rules_action_items",
+			NodeTypes:           []string{"action-card", "行动卡片"},
+		},
+		{
+			Kind:                AgentCritic,
+			Description:         "负责审视与反馈的代理",
+			InstructionTemplate: "agent_critic",
+			OutputRulesTemplate: "rules_critic",
+			NodeTypes:           []string{"feedback", "Feedback"},
+		},
+	}
+}
+
+// AgentRegistry 代理定义注册表与代理实例缓存（并发安全）
+type AgentRegistry struct {
+	mu    sync.Mutex
+	defs  map[string]AgentDef
+	types map[string]string
+	// models 构建代理时使用的模型设置
+	models model.Config
+	// 代理实例按 kind 与模板版本（PromptSet.ID）缓存
+	agents map[string]adk.Agent
+}
+
+// NewAgentRegistry 创建仅包含内置定义的注册表；代理按 models 创建模型（定义中的 model 覆盖模型名称）
+func NewAgentRegistry(models model.Config) *AgentRegistry {
+	r := &AgentRegistry{
+		models: models,
+		defs:   make(map[string]AgentDef),
+		types:  make(map[string]string),
+		agents: make(map[string]adk.Agent),
+	}
+	for _, def := range builtinAgentDefs() {
+		if err := r.Register(def); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+// LoadAgentRegistry 创建注册表，并在设置了 AGENT_REGISTRY_FILE 时加载其中的定义
+func LoadAgentRegistry(models model.Config) (*AgentRegistry, error) {
+	r := NewAgentRegistry(models)
+	if path := strings.TrimSpace(os.Getenv(AgentRegistryEnv)); path != "" {
+		if err := r.LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	// 指令与输出要求模板须存在于默认语言包中（其它版本与语言在使用时校验）
+	prompts, err := defaultPromptSet(nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, def := range r.Defs() {
+		for _, name := range []string{def.InstructionTemplate, def.OutputRulesTemplate} {
+			if name != "" && !prompts.Has(name) {
+				return nil, fmt.Errorf("agent %s: template %q not found in prompt set %s/%s", def.Kind, name, prompts.Version, prompts.Language)
+			}
+		}
+	}
+	return r, nil
+}
+
+// LoadFile 从 JSON 文件（{"agents":[...]}）加载代理定义；同名 kind 覆盖已有定义
+func (r *AgentRegistry) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read agent registry: %w", err)
+	}
+	var f agentRegistryFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("decode agent registry %s: %w", path, err)
+	}
+	for _, def := range f.Agents {
+		if err := r.Register(def); err != nil {
+			return fmt.Errorf("agent registry %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// Register 注册（或覆盖）一种代理定义，并将其 NodeTypes 映射到该代理
+func (r *AgentRegistry) Register(def AgentDef) error {
+	def.Kind = strings.TrimSpace(def.Kind)
+	if !agentKindPattern.MatchString(def.Kind) {
+		return fmt.Errorf("invalid agent kind %q", def.Kind)
+	}
+	if strings.TrimSpace(def.Instruction) == "" && strings.TrimSpace(def.InstructionTemplate) == "" {
+		return fmt.Errorf("agent %s: instruction or instruction_template is required", def.Kind)
+	}
+	if strings.TrimSpace(def.Description) == "" {
+		def.Description = def.Kind + " 代理"
+	}
+	for _, name := range def.Tools {
+		if _, ok := lookupTool(name); !ok {
+			return fmt.Errorf("agent %s: unknown tool %q", def.Kind, name)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// 覆盖时移除旧定义的类型映射与已构建的实例
+	for t, kind := range r.types {
+		if kind == def.Kind {
+			delete(r.types, t)
+		}
+	}
+	for key := range r.agents {
+		if strings.HasPrefix(key, def.Kind+"@") {
+			delete(r.agents, key)
+		}
+	}
+	r.defs[def.Kind] = def
+	for _, t := range def.NodeTypes {
+		if t = normalizeNodeType(t); t != "" {
+			r.types[t] = def.Kind
+		}
+	}
+	return nil
+}
+
+// Def 按 kind 查找代理定义
+func (r *AgentRegistry) Def(kind string) (AgentDef, bool) {
+	if r == nil {
+		return AgentDef{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	def, ok := r.defs[kind]
+	return def, ok
+}
+
+// Lookup 按节点类型查找代理定义；r 为空或类型未注册时返回 false
+func (r *AgentRegistry) Lookup(nodeType string) (AgentDef, bool) {
+	if r == nil {
+		return AgentDef{}, false
+	}
+	r.mu.Lock()
+	kind, ok := r.types[normalizeNodeType(nodeType)]
+	r.mu.Unlock()
+	if !ok {
+		return AgentDef{}, false
+	}
+	return r.Def(kind)
+}
+
+// ModelName 返回 kind 对应代理实际使用的模型名称；r 为空或 kind 未注册时返回空字符串
+func (r *AgentRegistry) ModelName(kind string) string {
+	def, ok := r.Def(kind)
+	if !ok {
+		return ""
+	}
+	return r.models.ResolveModelName(def.Model)
+}
+
+// RouterModelName 返回监督者（LLM 路由）使用的模型名称；r 为空时按环境变量解析
+func (r *AgentRegistry) RouterModelName() string {
+	if r == nil {
+		return model.ResolveModelName("")
+	}
+	return r.models.ResolveModelName("")
+}
+
+// MaxCompletionTokens 返回每次模型调用为输出预留的 token：模型配置的输出上限，未限制时为 DefaultCompletionAllowance；
+// r 为空时按环境变量解析
+func (r *AgentRegistry) MaxCompletionTokens() int {
+	models := model.ConfigFromEnv()
+	if r != nil {
+		models = r.models
+	}
+	if models.MaxCompletionTokens > 0 {
+		return models.MaxCompletionTokens
+	}
+	return DefaultCompletionAllowance
+}
+
+// Defs 返回全部代理定义（按 kind 排序）
+func (r *AgentRegistry) Defs() []AgentDef {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]AgentDef, 0, len(r.defs))
+	for _, def := range r.defs {
+		out = append(out, def)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Kind < out[j].Kind })
+	return out
+}
+
+// Agent 返回 kind 对应的代理实例（使用默认模板版本）
+func (r *AgentRegistry) Agent(kind string) (adk.Agent, error) {
+	return r.AgentWith(kind, nil)
+}
+
+// AgentWith 返回 kind 对应、按模板版本 prompts 渲染指令的代理实例（首次调用时构建并缓存）；prompts 为空时使用默认版本
+func (r *AgentRegistry) AgentWith(kind string, prompts *PromptSet) (adk.Agent, error) {
+	def, ok := r.Def(kind)
+	if !ok {
+		return nil, fmt.Errorf("unknown agent kind %q", kind)
+	}
+	prompts, err := defaultPromptSet(prompts)
+	if err != nil {
+		return nil, err
+	}
+	key := kind + "@" + prompts.ID()
+	r.mu.Lock()
+	a, ok := r.agents[key]
+	r.mu.Unlock()
+	if ok {
+		return a, nil
+	}
+	a, err = newDefinedAgent(def, prompts, r.models)
+	if err != nil {
+		return nil, fmt.Errorf("build %s agent: %w", kind, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cached, ok := r.agents[key]; ok {
+		return cached, nil
+	}
+	r.agents[key] = a
+	return a, nil
+}
+
+// BuildAgents 构建监督者以及注册表中的 text / vision 子代理（使用默认模板版本）
+func (r *AgentRegistry) BuildAgents() (adk.Agent, adk.Agent, adk.Agent, error) {
+	return r.BuildAgentsWith(nil)
+}
+
+// BuildAgentsWith 按模板版本 prompts 构建监督者以及 text / vision 子代理；须与 RunOptions.PromptVersion 选择的版本一致
+func (r *AgentRegistry) BuildAgentsWith(prompts *PromptSet) (adk.Agent, adk.Agent, adk.Agent, error) {
+	prompts, err := defaultPromptSet(prompts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	textAgent, err := r.AgentWith(AgentText, prompts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	visionAgent, err := r.AgentWith(AgentVision, prompts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	supervisorAgent, err := newSupervisorAgent(prompts, r.models)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return supervisorAgent, textAgent, visionAgent, nil
+}
+
+// RenderInstruction 返回定义在语言包 prompts 下的系统指令（Locales > InstructionTemplate > Instruction）；prompts 为空时使用默认语言包
+func (def AgentDef) RenderInstruction(prompts *PromptSet) (string, error) {
+	prompts, err := defaultPromptSet(prompts)
+	if err != nil {
+		return "", err
+	}
+	if loc, ok := def.Locales[prompts.Language]; ok && strings.TrimSpace(loc.Instruction) != "" {
+		return loc.Instruction, nil
+	}
+	if strings.TrimSpace(def.InstructionTemplate) == "" {
+		return def.Instruction, nil
+	}
+	text, err := prompts.Render(def.InstructionTemplate, def)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(text), nil
+}
+
+// RenderOutputRules 返回定义在语言包 prompts 下的输出要求（Locales > OutputRulesTemplate > OutputRules）；prompts 为空时使用默认语言包
+func (def AgentDef) RenderOutputRules(prompts *PromptSet) ([]string, error) {
+	prompts, err := defaultPromptSet(prompts)
+	if err != nil {
+		return nil, err
+	}
+	if loc, ok := def.Locales[prompts.Language]; ok && len(loc.OutputRules) > 0 {
+		return loc.OutputRules, nil
+	}
+	if strings.TrimSpace(def.OutputRulesTemplate) == "" {
+		return def.OutputRules, nil
+	}
+	text, err := prompts.Render(def.OutputRulesTemplate, def)
+	if err != nil {
+		return nil, err
+	}
+	var rules []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			rules = append(rules, line)
+		}
+	}
+	return rules, nil
+}
+
+// newDefinedAgent 按定义构建 ChatModelAgent
+func newDefinedAgent(def AgentDef, prompts *PromptSet, models model.Config) (adk.Agent, error) {
+	instruction, err := def.RenderInstruction(prompts)
+	if err != nil {
+		return nil, err
+	}
+	tools := make([]tool.BaseTool, 0, len(def.Tools))
+	for _, name := range def.Tools {
+		t, ok := lookupTool(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown tool %q", name)
+		}
+		tools = append(tools, t)
+	}
+	return adk.NewChatModelAgent(context.Background(), &adk.ChatModelAgentConfig{
+		Name:        def.Kind + "_agent",
+		Description: def.Description,
+		Instruction: instruction,
+		Model:       models.NewAgentChatModel(def.Kind+"_agent", def.Model),
+		ToolsConfig: adk.ToolsConfig{
+			ToolsNodeConfig: compose.ToolsNodeConfig{
+				Tools: tools,
+				UnknownToolsHandler: func(ctx context.Context, name, input string) (string, error) {
+					return fmt.Sprintf("unknown tool: %s", name), nil
+				},
+			},
+		},
+	})
+}
+
+func normalizeNodeType(t string) string {
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+// 工具注册表：代理定义通过名称引用
+var (
+	toolsMu sync.RWMutex
+	tools   = map[string]tool.BaseTool{}
+)
+
+// RegisterTool 注册可供代理定义引用的工具
+func RegisterTool(name string, t tool.BaseTool) {
+	toolsMu.Lock()
+	defer toolsMu.Unlock()
+	tools[name] = t
+}
+
+func lookupTool(name string) (tool.BaseTool, bool) {
+	toolsMu.RLock()
+	defer toolsMu.RUnlock()
+	t, ok := tools[name]
+	return t, ok
+}
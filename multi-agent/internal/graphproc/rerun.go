@@ -0,0 +1,82 @@
+package graphproc
+
+// 本文件规划基于历史 run 的重新执行（rerun）：
+// - 指定起始节点：该节点及其全部后代重新执行（起始节点忽略缓存读取），其余节点复用上次的输出
+// - 手动覆盖输出：被覆盖的节点视为已完成（输出为给定文本），仅重新计算其后代
+// 两者可同时使用；上次未成功（failed / cancelled / skipped 或缺失）的节点及其后代也会重新执行。
+// 规划结果通过 RunOptions.Preset（预置结果）与 RunOptions.ForceNodes 交给 ProcessGraph。
+
+import (
+	"fmt"
+	"sort"
+
+	"multi-agent/internal/orchestrator"
+)
+
+// PlanRerun 根据上一次的结果计算预置结果与需要重新执行的节点（按图中声明顺序）
+func PlanRerun(sg orchestrator.SimpleGraph, prev map[string]NodeResult, startNode string, overrides map[string]string) (map[string]NodeResult, []string, error) {
+	if startNode == "" && len(overrides) == 0 {
+		return nil, nil, fmt.Errorf("either start_node or overrides must be provided")
+	}
+	exists := make(map[string]bool, len(sg.Nodes))
+	for _, n := range sg.Nodes {
+		exists[n.ID] = true
+	}
+	if startNode != "" && !exists[startNode] {
+		return nil, nil, fmt.Errorf("unknown start node %q", startNode)
+	}
+	overrideIDs := make([]string, 0, len(overrides))
+	for id := range overrides {
+		if !exists[id] {
+			return nil, nil, fmt.Errorf("unknown override node %q", id)
+		}
+		overrideIDs = append(overrideIDs, id)
+	}
+	sort.Strings(overrideIDs)
+
+	adj := make(map[string][]string, len(sg.Nodes))
+	for _, e := range sg.Edges {
+		adj[e.From] = append(adj[e.From], e.To)
+	}
+	dirty := make(map[string]bool, len(sg.Nodes))
+	var mark func(id string)
+	mark = func(id string) {
+		if dirty[id] {
+			return
+		}
+		dirty[id] = true
+		for _, nb := range adj[id] {
+			mark(nb)
+		}
+	}
+	if startNode != "" {
+		mark(startNode)
+	}
+	for _, id := range overrideIDs {
+		for _, nb := range adj[id] {
+			mark(nb)
+		}
+	}
+	for _, n := range sg.Nodes {
+		if r, ok := prev[n.ID]; !ok || r.Status != NodeStatusSucceeded {
+			mark(n.ID)
+		}
+	}
+
+	preset := make(map[string]NodeResult, len(sg.Nodes))
+	recompute := make([]string, 0, len(sg.Nodes))
+	for _, n := range sg.Nodes {
+		if out, ok := overrides[n.ID]; ok {
+			preset[n.ID] = NodeResult{Kind: RouteText, Output: out, Status: NodeStatusSucceeded, Overridden: true}
+			continue
+		}
+		if dirty[n.ID] {
+			recompute = append(recompute, n.ID)
+			continue
+		}
+		r := prev[n.ID]
+		// 复用的节点不计入本次 token 与路由统计
+		preset[n.ID] = NodeResult{Kind: r.Kind, Agent: r.Agent, Model: r.Model, Output: r.Output, Status: NodeStatusSucceeded, Reused: true}
+	}
+	return preset, recompute, nil
+}
@@ -0,0 +1,98 @@
+package graphproc
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPlanRerun(t *testing.T) {
+	// a -> b -> d, a -> c -> d, d -> e；f 独立
+	sg := testGraph([]string{"a", "b", "c", "d", "e", "f"}, "a->b", "a->c", "b->d", "c->d", "d->e")
+	ok := func(out string) NodeResult {
+		return NodeResult{Kind: RouteText, Agent: AgentText, Model: "m", Output: out, Status: NodeStatusSucceeded, TotalTokens: 10}
+	}
+	allOK := map[string]NodeResult{"a": ok("A"), "b": ok("B"), "c": ok("C"), "d": ok("D"), "e": ok("E"), "f": ok("F")}
+	with := func(id string, r NodeResult) map[string]NodeResult {
+		m := maps.Clone(allOK)
+		m[id] = r
+		return m
+	}
+	without := func(id string) map[string]NodeResult {
+		m := maps.Clone(allOK)
+		delete(m, id)
+		return m
+	}
+	tests := []struct {
+		name       string
+		prev       map[string]NodeResult
+		start      string
+		overrides  map[string]string
+		recompute  []string
+		reused     []string
+		overridden []string
+		errSub     string
+	}{
+		{name: "start at root", prev: allOK, start: "a", recompute: []string{"a", "b", "c", "d", "e"}, reused: []string{"f"}},
+		{name: "start mid branch", prev: allOK, start: "c", recompute: []string{"c", "d", "e"}, reused: []string{"a", "b", "f"}},
+		{name: "start at leaf", prev: allOK, start: "e", recompute: []string{"e"}, reused: []string{"a", "b", "c", "d", "f"}},
+		{name: "override recomputes descendants only", prev: allOK, overrides: map[string]string{"b": "B'"}, recompute: []string{"d", "e"}, reused: []string{"a", "c", "f"}, overridden: []string{"b"}},
+		{name: "override leaf", prev: allOK, overrides: map[string]string{"e": "E'"}, reused: []string{"a", "b", "c", "d", "f"}, overridden: []string{"e"}},
+		{name: "override inside start subtree wins", prev: allOK, start: "a", overrides: map[string]string{"d": "D'"}, recompute: []string{"a", "b", "c", "e"}, reused: []string{"f"}, overridden: []string{"d"}},
+		{name: "start and unrelated override", prev: allOK, start: "f", overrides: map[string]string{"c": "C'"}, recompute: []string{"d", "e", "f"}, reused: []string{"a", "b"}, overridden: []string{"c"}},
+		{name: "failed node recomputed", prev: with("b", NodeResult{Status: NodeStatusFailed, Error: "boom"}), start: "f", recompute: []string{"b", "d", "e", "f"}, reused: []string{"a", "c"}},
+		{name: "skipped and missing nodes recomputed", prev: with("c", NodeResult{Status: NodeStatusSkipped}), overrides: map[string]string{"f": "F'"}, recompute: []string{"c", "d", "e"}, reused: []string{"a", "b"}, overridden: []string{"f"}},
+		{name: "missing result", prev: without("d"), start: "f", recompute: []string{"d", "e", "f"}, reused: []string{"a", "b", "c"}},
+		{name: "failed node overridden", prev: with("b", NodeResult{Status: NodeStatusFailed}), overrides: map[string]string{"b": "B'"}, recompute: []string{"d", "e"}, reused: []string{"a", "c", "f"}, overridden: []string{"b"}},
+		{name: "nothing to do", prev: allOK, errSub: "either start_node or overrides"},
+		{name: "unknown start", prev: allOK, start: "x", errSub: `unknown start node "x"`},
+		{name: "unknown override", prev: allOK, overrides: map[string]string{"x": ""}, errSub: `unknown override node "x"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preset, recompute, err := PlanRerun(sg, tt.prev, tt.start, tt.overrides)
+			if tt.errSub != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errSub) {
+					t.Fatalf("err = %v, want %q", err, tt.errSub)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PlanRerun: %v", err)
+			}
+			if !slices.Equal(recompute, tt.recompute) {
+				t.Errorf("recompute = %v, want %v", recompute, tt.recompute)
+			}
+			var reused, overridden []string
+			for _, n := range sg.Nodes {
+				r, ok := preset[n.ID]
+				if !ok {
+					continue
+				}
+				if r.Status != NodeStatusSucceeded {
+					t.Errorf("%s: preset status %q", n.ID, r.Status)
+				}
+				switch {
+				case r.Overridden:
+					overridden = append(overridden, n.ID)
+					if r.Output != tt.overrides[n.ID] {
+						t.Errorf("%s: output %q, want override %q", n.ID, r.Output, tt.overrides[n.ID])
+					}
+				case r.Reused:
+					reused = append(reused, n.ID)
+					// 复用上次的输出与代理，但不计入本次 token
+					if p := tt.prev[n.ID]; r.Output != p.Output || r.Agent != p.Agent || r.Model != p.Model || r.TotalTokens != 0 {
+						t.Errorf("%s: reused %+v from %+v", n.ID, r, p)
+					}
+				}
+			}
+			if !slices.Equal(reused, tt.reused) {
+				t.Errorf("reused = %v, want %v", reused, tt.reused)
+			}
+			if !slices.Equal(overridden, tt.overridden) {
+				t.Errorf("overridden = %v, want %v", overridden, tt.overridden)
+			}
+		})
+	}
+}
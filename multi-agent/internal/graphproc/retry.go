@@ -0,0 +1,273 @@
+package graphproc
+
+// 本文件负责节点模型调用的超时与重试：
+// - 策略来源（后者覆盖前者）：DefaultRetryPolicy < RunOptions.Retry < 图级 options.retry
+//   < 图级 options.node_retry[节点ID] < 节点 payload.retry
+// - 每次尝试可设置独立超时；失败后按指数退避（带抖动）等待再试
+// - 数值字段为 0 表示继承上一层，负数表示显式关闭（如 jitter: -1 关闭抖动）；尝试次数不超过 MaxRetryAttempts
+// - 仅对可重试错误（超时、限流、5xx、连接中断等）重试；调用方取消时立即停止
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"multi-agent/internal/logs"
+	"multi-agent/internal/orchestrator"
+)
+
+// 重试阶段
+const (
+	StageRouter   = "router"
+	StageSubAgent = "subagent"
+)
+
+// AttemptError 记录一次失败的尝试
+type AttemptError struct {
+	Stage      string `json:"stage"`
+	Attempt    int    `json:"attempt"`
+	Error      string `json:"error"`
+	Retryable  bool   `json:"retryable"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// DefaultRetryPolicy 未配置时的默认策略：最多 3 次，500ms 起指数退避，上限 8s，±20% 抖动，不设单次超时
+func DefaultRetryPolicy() orchestrator.RetryPolicy {
+	return orchestrator.RetryPolicy{
+		MaxAttempts:      3,
+		InitialBackoffMs: 500,
+		MaxBackoffMs:     8000,
+		Multiplier:       2,
+		Jitter:           0.2,
+	}
+}
+
+// MaxRetryAttempts 服务端允许的单次模型调用最大尝试次数；请求、图与节点配置的 max_attempts 超出时按此截断
+const MaxRetryAttempts = 10
+
+// mergeRetryPolicy 用 override 覆盖 base：正数覆盖，0 继承，负数显式清零（关闭退避、抖动、退避上限或单次超时）
+func mergeRetryPolicy(base orchestrator.RetryPolicy, override *orchestrator.RetryPolicy) orchestrator.RetryPolicy {
+	if override == nil {
+		return base
+	}
+	if override.MaxAttempts > 0 {
+		base.MaxAttempts = override.MaxAttempts
+	}
+	overrideField(&base.InitialBackoffMs, override.InitialBackoffMs)
+	overrideField(&base.MaxBackoffMs, override.MaxBackoffMs)
+	overrideField(&base.Multiplier, override.Multiplier)
+	overrideField(&base.Jitter, override.Jitter)
+	overrideField(&base.AttemptTimeoutMs, override.AttemptTimeoutMs)
+	if len(override.RetryOn) > 0 {
+		base.RetryOn = append(append([]string{}, base.RetryOn...), override.RetryOn...)
+	}
+	return base
+}
+
+// overrideField 合并单个数值字段：v > 0 覆盖，v < 0 清零，v == 0 保留 base
+func overrideField[T int | float64](base *T, v T) {
+	switch {
+	case v > 0:
+		*base = v
+	case v < 0:
+		*base = 0
+	}
+}
+
+// resolveRetryPolicy 计算某节点最终生效的重试策略
+func resolveRetryPolicy(run *orchestrator.RetryPolicy, graph *orchestrator.GraphOptions, node *orchestrator.SimpleNode) orchestrator.RetryPolicy {
+	p := mergeRetryPolicy(DefaultRetryPolicy(), run)
+	if graph != nil {
+		p = mergeRetryPolicy(p, graph.Retry)
+		if np, ok := graph.NodeRetry[node.ID]; ok {
+			p = mergeRetryPolicy(p, &np)
+		}
+	}
+	if len(node.Payload) > 0 {
+		var payload struct {
+			Retry *orchestrator.RetryPolicy `json:"retry"`
+		}
+		if err := json.Unmarshal(node.Payload, &payload); err == nil {
+			p = mergeRetryPolicy(p, payload.Retry)
+		}
+	}
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 1
+	}
+	if p.MaxAttempts > MaxRetryAttempts {
+		logs.Infof("[retry] node=%s max_attempts=%d clamped to %d", node.ID, p.MaxAttempts, MaxRetryAttempts)
+		p.MaxAttempts = MaxRetryAttempts
+	}
+	return p
+}
+
+// backoffDelay 计算第 attempt 次失败后的等待时间（attempt 从 1 开始）
+func backoffDelay(p orchestrator.RetryPolicy, attempt int) time.Duration {
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 1
+	}
+	d := float64(p.InitialBackoffMs) * math.Pow(mult, float64(attempt-1))
+	if p.MaxBackoffMs > 0 && d > float64(p.MaxBackoffMs) {
+		d = float64(p.MaxBackoffMs)
+	}
+	if j := math.Min(p.Jitter, 1); j > 0 {
+		d += d * j * (2*rand.Float64() - 1)
+	}
+	if d < 0 {
+		d = 0
+	}
+	return time.Duration(d) * time.Millisecond
+}
+
+// retryableStatus 匹配常见的可重试 HTTP 状态码（限流与服务端错误）
+var retryableStatus = regexp.MustCompile(`\b(408|429|500|502|503|504)\b`)
+
+// retryableHints 可重试错误的常见描述片段
+var retryableHints = []string{
+	"rate limit",
+	"too many requests",
+	"timeout",
+	"timed out",
+	"temporarily",
+	"unavailable",
+	"overloaded",
+	"connection reset",
+	"connection refused",
+	"broken pipe",
+	"server error",
+	"bad gateway",
+}
+
+// IsRetryableError 判断错误是否为瞬时错误（值得重试）。
+// extra 为策略中配置的额外可重试片段（大小写不敏感）。
+func IsRetryableError(err error, extra ...string) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, ErrBudgetExceeded) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	if retryableStatus.MatchString(msg) {
+		return true
+	}
+	for _, h := range retryableHints {
+		if strings.Contains(msg, h) {
+			return true
+		}
+	}
+	for _, h := range extra {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" && strings.Contains(msg, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// 模型调用错误类型（见 ModelErrorType）
+const (
+	ModelErrorCanceled  = "canceled"
+	ModelErrorTimeout   = "timeout"
+	ModelErrorRateLimit = "rate_limit"
+	ModelErrorServer    = "server_error"
+	ModelErrorClient    = "client_error"
+	ModelErrorNetwork   = "network"
+	ModelErrorOther     = "other"
+)
+
+// serverStatus / clientStatus 错误信息中的 HTTP 状态码
+var (
+	serverStatus = regexp.MustCompile(`\b5\d\d\b`)
+	clientStatus = regexp.MustCompile(`\b4\d\d\b`)
+)
+
+// ModelErrorType 将模型调用错误归类（用于指标）：canceled / timeout / rate_limit / server_error / client_error / network / other
+func ModelErrorType(err error) string {
+	if errors.Is(err, context.Canceled) {
+		return ModelErrorCanceled
+	}
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+		return ModelErrorTimeout
+	}
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "429") || strings.Contains(msg, "rate limit") || strings.Contains(msg, "too many requests"):
+		return ModelErrorRateLimit
+	case strings.Contains(msg, "timeout") || strings.Contains(msg, "timed out") || strings.Contains(msg, "408"):
+		return ModelErrorTimeout
+	case serverStatus.MatchString(msg) || strings.Contains(msg, "overloaded") || strings.Contains(msg, "unavailable") || strings.Contains(msg, "server error") || strings.Contains(msg, "bad gateway"):
+		return ModelErrorServer
+	case clientStatus.MatchString(msg):
+		return ModelErrorClient
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &ne) ||
+		strings.Contains(msg, "connection reset") || strings.Contains(msg, "connection refused") || strings.Contains(msg, "broken pipe"):
+		return ModelErrorNetwork
+	}
+	return ModelErrorOther
+}
+
+// runWithRetry 按策略执行 fn，返回实际尝试次数与失败记录。
+// fn 的 ctx 在设置了单次超时时为带超时的子上下文；父 ctx 取消时立即返回。
+func runWithRetry(ctx context.Context, p orchestrator.RetryPolicy, stage, nodeID string, fn func(ctx context.Context) error) (int, []AttemptError, error) {
+	var attemptErrs []AttemptError
+	var err error
+	attempt := 0
+	for attempt < p.MaxAttempts {
+		attempt++
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if p.AttemptTimeoutMs > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, time.Duration(p.AttemptTimeoutMs)*time.Millisecond)
+		}
+		start := time.Now()
+		err = fn(attemptCtx)
+		cancel()
+		if err == nil {
+			return attempt, attemptErrs, nil
+		}
+		// 父上下文取消：不再重试
+		if ctx.Err() != nil {
+			return attempt, attemptErrs, ctx.Err()
+		}
+		if p.AttemptTimeoutMs > 0 && errors.Is(attemptCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("attempt timed out after %dms: %w", p.AttemptTimeoutMs, err)
+		}
+		retryable := IsRetryableError(err, p.RetryOn...)
+		attemptErrs = append(attemptErrs, AttemptError{
+			Stage:      stage,
+			Attempt:    attempt,
+			Error:      err.Error(),
+			Retryable:  retryable,
+			DurationMs: time.Since(start).Milliseconds(),
+		})
+		if !retryable || attempt >= p.MaxAttempts {
+			break
+		}
+		delay := backoffDelay(p, attempt)
+		logs.Infof("[retry] run=%s node=%s stage=%s attempt=%d/%d backoff=%s err=%v", RunIDFromContext(ctx), nodeID, stage, attempt, p.MaxAttempts, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, attemptErrs, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return attempt, attemptErrs, err
+}
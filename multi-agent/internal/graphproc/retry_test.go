@@ -0,0 +1,100 @@
+package graphproc
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"multi-agent/internal/orchestrator"
+)
+
+func TestResolveRetryPolicy(t *testing.T) {
+	def := DefaultRetryPolicy()
+	tests := []struct {
+		name    string
+		run     *orchestrator.RetryPolicy
+		graph   *orchestrator.GraphOptions
+		payload string
+		want    orchestrator.RetryPolicy
+	}{
+		{name: "default", want: def},
+		{
+			name: "zero fields inherit",
+			run:  &orchestrator.RetryPolicy{MaxAttempts: 5},
+			want: orchestrator.RetryPolicy{MaxAttempts: 5, InitialBackoffMs: 500, MaxBackoffMs: 8000, Multiplier: 2, Jitter: 0.2},
+		},
+		{
+			name:    "node disables backoff and jitter",
+			payload: `{"retry":{"initial_backoff_ms":-1,"jitter":-1}}`,
+			want:    orchestrator.RetryPolicy{MaxAttempts: 3, MaxBackoffMs: 8000, Multiplier: 2},
+		},
+		{
+			name:    "node drops graph attempt timeout",
+			graph:   &orchestrator.GraphOptions{Retry: &orchestrator.RetryPolicy{AttemptTimeoutMs: 2000, Jitter: 0.5}},
+			payload: `{"retry":{"attempt_timeout_ms":-1}}`,
+			want:    orchestrator.RetryPolicy{MaxAttempts: 3, InitialBackoffMs: 500, MaxBackoffMs: 8000, Multiplier: 2, Jitter: 0.5},
+		},
+		{
+			name:  "node_retry overrides graph retry",
+			graph: &orchestrator.GraphOptions{Retry: &orchestrator.RetryPolicy{MaxAttempts: 2}, NodeRetry: map[string]orchestrator.RetryPolicy{"n": {MaxAttempts: 4, MaxBackoffMs: -1}}},
+			want:  orchestrator.RetryPolicy{MaxAttempts: 4, InitialBackoffMs: 500, Multiplier: 2, Jitter: 0.2},
+		},
+		{
+			name: "max attempts clamped",
+			run:  &orchestrator.RetryPolicy{MaxAttempts: 1000},
+			want: orchestrator.RetryPolicy{MaxAttempts: MaxRetryAttempts, InitialBackoffMs: 500, MaxBackoffMs: 8000, Multiplier: 2, Jitter: 0.2},
+		},
+		{
+			name:    "payload max attempts clamped",
+			payload: `{"retry":{"max_attempts":99}}`,
+			want:    orchestrator.RetryPolicy{MaxAttempts: MaxRetryAttempts, InitialBackoffMs: 500, MaxBackoffMs: 8000, Multiplier: 2, Jitter: 0.2},
+		},
+		{
+			name: "negative max attempts inherits",
+			run:  &orchestrator.RetryPolicy{MaxAttempts: -1},
+			want: def,
+		},
+		{
+			name: "retry_on accumulates",
+			run:  &orchestrator.RetryPolicy{RetryOn: []string{"quota"}},
+			graph: &orchestrator.GraphOptions{
+				Retry: &orchestrator.RetryPolicy{RetryOn: []string{"busy"}},
+			},
+			want: orchestrator.RetryPolicy{MaxAttempts: 3, InitialBackoffMs: 500, MaxBackoffMs: 8000, Multiplier: 2, Jitter: 0.2, RetryOn: []string{"quota", "busy"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &orchestrator.SimpleNode{ID: "n"}
+			if tt.payload != "" {
+				node.Payload = json.RawMessage(tt.payload)
+			}
+			if got := resolveRetryPolicy(tt.run, tt.graph, node); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("policy = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	p := orchestrator.RetryPolicy{InitialBackoffMs: 100, MaxBackoffMs: 300, Multiplier: 2}
+	for attempt, want := range []int{100, 200, 300, 300} {
+		if got := backoffDelay(p, attempt+1).Milliseconds(); got != int64(want) {
+			t.Errorf("attempt %d: delay %dms, want %dms", attempt+1, got, want)
+		}
+	}
+	// 节点以 -1 关闭退避后立即重试
+	off := resolveRetryPolicy(nil, nil, &orchestrator.SimpleNode{ID: "n", Payload: json.RawMessage(`{"retry":{"initial_backoff_ms":-1,"jitter":-1}}`)})
+	for attempt := 1; attempt <= 3; attempt++ {
+		if d := backoffDelay(off, attempt); d != 0 {
+			t.Errorf("attempt %d: delay %v with backoff disabled", attempt, d)
+		}
+	}
+	// 抖动范围 ±Jitter
+	j := orchestrator.RetryPolicy{InitialBackoffMs: 1000, Multiplier: 1, Jitter: 0.2}
+	for range 50 {
+		if d := backoffDelay(j, 1).Milliseconds(); d < 800 || d > 1200 {
+			t.Fatalf("jittered delay %dms outside [800, 1200]", d)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package graphproc
+
+// 本文件定义节点路由（在 text / vision 子代理间选择）的可插拔实现：
+// - RuleRouter：纯规则，依据是否为最后节点、payload 字段（route / imageUrl / imageId）、节点类型与前驱类型判断，不调用模型
+// - LLMRouter：原有的 graph_supervisor 监督者路由，每个节点一次模型往返
+// - HybridRouter（默认）：先走规则，仅在规则无法判定（ambiguous）时询问监督者
+// 每个节点实际做出决策的路由器与理由写入 NodeResult.Router / RouteReason，用量汇总中统计规则与模型各自的决策数。
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"multi-agent/internal/orchestrator"
+
+	"github.com/cloudwego/eino/adk"
+)
+
+// 路由模式
+const (
+	RouterRule   = "rule"
+	RouterLLM    = "llm"
+	RouterHybrid = "hybrid"
+)
+
+// 子代理类型
+const (
+	RouteText   = "text"
+	RouteVision = "vision"
+)
+
+// PrevOutput 直接前驱节点的输出，以及连接边的意图与参数（见 intent.go）
+type PrevOutput struct {
+	ID     string         `json:"id"`
+	Kind   string         `json:"kind"`
+	Output string         `json:"output"`
+	Intent string         `json:"intent,omitempty"`
+	Params map[string]any `json:"params,omitempty"`
+}
+
+// RouteInput 路由所需的节点上下文
+type RouteInput struct {
+	Node   *orchestrator.SimpleNode
+	Prevs  []PrevOutput
+	IsLast bool
+	// Agent 节点类型注册的专用代理（见 registry.go），未注册时为空
+	Agent *AgentDef
+	// Prompts 节点使用的语言包（见 prompts.go），为空时使用默认语言包
+	Prompts *PromptSet
+	// Model 监督者使用的模型名称；监督者未上报用量时据此选择分词器估算（见 estimate.go）
+	Model string
+}
+
+// RouteDecision 路由结果
+type RouteDecision struct {
+	// Used 选中的子代理：text / vision
+	Used string
+	// Router 实际做出决策的路由器：rule / llm
+	Router string
+	// Reason 决策理由（便于排查与统计）
+	Reason string
+	// Usage 路由阶段的 token 用量；规则路由为空，监督者未上报时为本地估算（Estimated=true）
+	Usage *TokenUsage
+}
+
+// Router 为节点选择子代理
+type Router interface {
+	Name() string
+	Route(ctx context.Context, in RouteInput) (RouteDecision, error)
+}
+
+// ParseRouterMode 校验并规范化路由模式；空字符串视为 hybrid
+func ParseRouterMode(s string) (string, error) {
+	switch m := strings.ToLower(strings.TrimSpace(s)); m {
+	case "":
+		return RouterHybrid, nil
+	case RouterRule, RouterLLM, RouterHybrid:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown router %q (want %s, %s or %s)", s, RouterRule, RouterLLM, RouterHybrid)
+	}
+}
+
+// NewRouter 按模式构建路由器；supervisor 为 llm / hybrid 模式使用的监督者代理
+func NewRouter(mode string, supervisor adk.Agent) (Router, error) {
+	mode, err := ParseRouterMode(mode)
+	if err != nil {
+		return nil, err
+	}
+	switch mode {
+	case RouterRule:
+		return RuleRouter{}, nil
+	case RouterLLM:
+		return &LLMRouter{Supervisor: supervisor}, nil
+	default:
+		return &HybridRouter{LLM: &LLMRouter{Supervisor: supervisor}}, nil
+	}
+}
+
+// RuleRouter 纯规则路由：规则无法判定时默认使用 text
+type RuleRouter struct{}
+
+func (RuleRouter) Name() string { return RouterRule }
+
+func (RuleRouter) Route(_ context.Context, in RouteInput) (RouteDecision, error) {
+	return ruleRoute(in), nil
+}
+
+func ruleRoute(in RouteInput) RouteDecision {
+	if d, ok := decideByRules(in); ok {
+		return d
+	}
+	return RouteDecision{Used: RouteText, Router: RouterRule, Reason: "no rule matched, default text"}
+}
+
+// decideWithoutModel 在调用模型前尝试判定：规则路由总能判定，混合路由仅在规则可判定时；
+// ok=false 表示需要调用 Route（LLM 路由、混合路由的模糊节点与自定义路由器）
+func decideWithoutModel(r Router, in RouteInput) (RouteDecision, bool) {
+	switch r.(type) {
+	case RuleRouter, *RuleRouter:
+		return ruleRoute(in), true
+	case *HybridRouter:
+		return decideByRules(in)
+	}
+	return RouteDecision{}, false
+}
+
+// decideByRules 依次应用确定性规则；ok=false 表示规则无法判定
+func decideByRules(in RouteInput) (RouteDecision, bool) {
+	decide := func(used, reason string) (RouteDecision, bool) {
+		return RouteDecision{Used: used, Router: RouterRule, Reason: reason}, true
+	}
+	if in.IsLast {
+		return decide(RouteText, "last node: final summary uses text agent")
+	}
+	var payload map[string]any
+	if in.Node != nil && len(in.Node.Payload) > 0 {
+		_ = json.Unmarshal(in.Node.Payload, &payload)
+	}
+	if v, ok := payload["route"].(string); ok {
+		switch r := strings.ToLower(strings.TrimSpace(v)); r {
+		case RouteText, RouteVision:
+			return decide(r, "payload.route="+r)
+		}
+	}
+	if payloadString(payload, "imageUrl") != "" {
+		return decide(RouteVision, "payload has imageUrl")
+	}
+	if payloadString(payload, "imageId") != "" {
+		return decide(RouteVision, "payload has imageId")
+	}
+	if in.Agent != nil {
+		used := RouteText
+		if in.Agent.Vision {
+			used = RouteVision
+		}
+		return decide(used, fmt.Sprintf("node type %q handled by %s agent", in.Node.Type, in.Agent.Kind))
+	}
+	// 纯文本负载：前驱也没有视觉输出时无需询问监督者
+	for _, p := range in.Prevs {
+		if p.Kind == RouteVision {
+			return RouteDecision{}, false
+		}
+	}
+	for _, v := range payload {
+		if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
+			return decide(RouteText, "text-only payload")
+		}
+	}
+	return RouteDecision{}, false
+}
+
+// payloadString 读取 payload 中的字符串字段（去除首尾空白）
+func payloadString(payload map[string]any, key string) string {
+	if s, ok := payload[key].(string); ok {
+		return strings.TrimSpace(s)
+	}
+	return ""
+}
+
+// LLMRouter 由监督者代理（graph_supervisor）决策：优先依据 transfer 事件，否则解析 JSON 答复 {"used":"text|vision"}；答复无法解析时默认 text
+type LLMRouter struct {
+	Supervisor adk.Agent
+}
+
+func (r *LLMRouter) Name() string { return RouterLLM }
+
+func (r *LLMRouter) Route(ctx context.Context, in RouteInput) (RouteDecision, error) {
+	// 注意：监督者不负责执行任务，只做选择；真正的执行由 processNode 显式调用子代理
+	// 路由输入由语言包中的 route 模板渲染
+	prevJSON, _ := json.Marshal(in.Prevs)
+	prompts, err := defaultPromptSet(in.Prompts)
+	if err != nil {
+		return RouteDecision{Router: RouterLLM}, err
+	}
+	prompt, err := prompts.Render(PromptRoute, RoutePromptData{NodeID: in.Node.ID, Payload: string(in.Node.Payload), Prevs: string(prevJSON)})
+	if err != nil {
+		return RouteDecision{Router: RouterLLM}, err
+	}
+	used, out, usage, err := runRouterWithUsage(ctx, r.Supervisor, prompt)
+	if usage == nil && err == nil {
+		// 监督者未上报用量：按指令、路由提示词与输出估算；transfer 为一次工具调用，另计其输出
+		instruction, _ := prompts.Render(PromptSupervisor, nil)
+		usage = EstimateUsage(in.Model, strings.TrimSpace(instruction), prompt, 0, out)
+		if used != "" {
+			usage.CompletionTokens += transferCallTokens
+			usage.TotalTokens += transferCallTokens
+		}
+	}
+	d := RouteDecision{Used: used, Router: RouterLLM, Usage: usage}
+	if err != nil {
+		return d, err
+	}
+	if used != "" {
+		d.Reason = "supervisor transferred to " + used
+		return d, nil
+	}
+	// 监督者按路由模板的要求以 JSON {"used":"text|vision"} 作答
+	if used, perr := parseRouteAnswer(out); perr != nil {
+		d.Used = RouteText
+		d.Reason = fmt.Sprintf("unparsable supervisor answer (%v), default text", perr)
+	} else {
+		d.Used = used
+		d.Reason = "supervisor chose " + used
+	}
+	return d, nil
+}
+
+// parseRouteAnswer 解析监督者的 JSON 答复（允许包裹在 ``` 代码块或前后附带文字中），used 只接受 text / vision
+func parseRouteAnswer(out string) (string, error) {
+	start, end := strings.Index(out, "{"), strings.LastIndex(out, "}")
+	if start < 0 || end < start {
+		snippet := []rune(strings.TrimSpace(out))
+		if len(snippet) > 80 {
+			snippet = append(snippet[:80], '…')
+		}
+		return "", fmt.Errorf("no JSON object in %q", string(snippet))
+	}
+	var answer struct {
+		Used string `json:"used"`
+	}
+	if err := json.Unmarshal([]byte(out[start:end+1]), &answer); err != nil {
+		return "", fmt.Errorf("invalid JSON: %v", err)
+	}
+	switch used := strings.ToLower(strings.TrimSpace(answer.Used)); used {
+	case RouteText, RouteVision:
+		return used, nil
+	default:
+		return "", fmt.Errorf("used=%q is neither %s nor %s", answer.Used, RouteText, RouteVision)
+	}
+}
+
+// HybridRouter 规则优先，规则无法判定时交给 LLM
+type HybridRouter struct {
+	LLM Router
+}
+
+func (r *HybridRouter) Name() string { return RouterHybrid }
+
+func (r *HybridRouter) Route(ctx context.Context, in RouteInput) (RouteDecision, error) {
+	if d, ok := decideByRules(in); ok {
+		return d, nil
+	}
+	d, err := r.LLM.Route(ctx, in)
+	if err == nil {
+		d.Reason = "ambiguous for rules; " + d.Reason
+	}
+	return d, err
+}
@@ -0,0 +1,201 @@
+package graphproc
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"multi-agent/internal/orchestrator"
+	"multi-agent/model"
+
+	"github.com/cloudwego/eino/adk"
+)
+
+func TestDecideByRules(t *testing.T) {
+	summarizer := &AgentDef{Kind: "summarizer"}
+	ocr := &AgentDef{Kind: "ocr", Vision: true}
+	tests := []struct {
+		name    string
+		payload string
+		typ     string
+		prevs   []PrevOutput
+		isLast  bool
+		agent   *AgentDef
+		want    string
+		reason  string
+		ambig   bool
+	}{
+		{name: "last node", payload: `{"imageUrl":"http://x/a.png"}`, isLast: true, want: RouteText, reason: "last node: final summary uses text agent"},
+		{name: "explicit route", payload: `{"route":" Vision ","text":"hi"}`, want: RouteVision, reason: "payload.route=vision"},
+		{name: "invalid route ignored", payload: `{"route":"audio","text":"hi"}`, want: RouteText, reason: "text-only payload"},
+		{name: "image url", payload: `{"imageUrl":"http://x/a.png"}`, want: RouteVision, reason: "payload has imageUrl"},
+		{name: "image id", payload: `{"imageId":"img1"}`, want: RouteVision, reason: "payload has imageId"},
+		{name: "text agent type", payload: `{}`, typ: "agenda-panel", agent: summarizer, want: RouteText, reason: `node type "agenda-panel" handled by summarizer agent`},
+		{name: "vision agent type", payload: `{}`, typ: "scan", agent: ocr, want: RouteVision, reason: `node type "scan" handled by ocr agent`},
+		{name: "text only", payload: `{"text":"hello"}`, prevs: []PrevOutput{{ID: "p", Kind: RouteText}}, want: RouteText, reason: "text-only payload"},
+		{name: "vision predecessor", payload: `{"text":"hello"}`, prevs: []PrevOutput{{ID: "p", Kind: RouteVision}}, ambig: true},
+		{name: "blank payload", payload: `{"text":"  "}`, ambig: true},
+		{name: "no payload", ambig: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &orchestrator.SimpleNode{ID: "n", Type: tt.typ, Payload: json.RawMessage(tt.payload)}
+			d, ok := decideByRules(RouteInput{Node: node, Prevs: tt.prevs, IsLast: tt.isLast, Agent: tt.agent})
+			if ok == tt.ambig {
+				t.Fatalf("decided=%v (%+v), want ambiguous=%v", ok, d, tt.ambig)
+			}
+			if tt.ambig {
+				return
+			}
+			if d.Used != tt.want || d.Router != RouterRule || d.Reason != tt.reason {
+				t.Errorf("got used=%q router=%q reason=%q, want used=%q router=rule reason=%q", d.Used, d.Router, d.Reason, tt.want, tt.reason)
+			}
+		})
+	}
+}
+
+func TestRuleRouterDefaultsToText(t *testing.T) {
+	d, err := RuleRouter{}.Route(context.Background(), RouteInput{Node: &orchestrator.SimpleNode{ID: "n"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Used != RouteText || d.Reason != "no rule matched, default text" || d.Usage != nil {
+		t.Errorf("got %+v, want text by default without usage", d)
+	}
+}
+
+func TestParseRouteAnswer(t *testing.T) {
+	tests := []struct {
+		out  string
+		want string
+		err  string
+	}{
+		{out: `{"used":"vision"}`, want: RouteVision},
+		{out: "```json\n{\"used\": \"TEXT\"}\n```", want: RouteText},
+		{out: `I pick {"used":"vision"} because of the image`, want: RouteVision},
+		{out: "I think text", err: `no JSON object in "I think text"`},
+		{out: `{"used":}`, err: "invalid JSON"},
+		{out: `{"used":"audio"}`, err: `used="audio" is neither text nor vision`},
+		{out: `{"agent":"text"}`, err: `used="" is neither text nor vision`},
+	}
+	for _, tt := range tests {
+		got, err := parseRouteAnswer(tt.out)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseRouteAnswer(%q) err = %v, want %q", tt.out, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseRouteAnswer(%q) = %q, %v; want %q", tt.out, got, err, tt.want)
+		}
+	}
+}
+
+func TestLLMRouterParsesSupervisorAnswer(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer string
+		want   string
+		reason string
+	}{
+		{name: "json", answer: `{"used":"vision"}`, want: RouteVision, reason: "supervisor chose vision"},
+		{name: "code fence", answer: "```json\n{\"used\":\"text\"}\n```", want: RouteText, reason: "supervisor chose text"},
+		{name: "prose", answer: "I think text", want: RouteText, reason: `unparsable supervisor answer (no JSON object in "I think text"), default text`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := newFakeScript(t, &model.FakeRule{Agent: "graph_supervisor", FakeResponse: model.FakeResponse{Content: tt.answer}})
+			r := &LLMRouter{Supervisor: newFakeAgent(t, "graph_supervisor", script)}
+			node := &orchestrator.SimpleNode{ID: "n1", Payload: json.RawMessage(`{"text":"look at this"}`)}
+			d, err := r.Route(context.Background(), RouteInput{Node: node})
+			if err != nil {
+				t.Fatalf("Route: %v", err)
+			}
+			if d.Used != tt.want || d.Router != RouterLLM || d.Reason != tt.reason {
+				t.Errorf("got used=%q router=%q reason=%q, want used=%q router=llm reason=%q", d.Used, d.Router, d.Reason, tt.want, tt.reason)
+			}
+			if d.Usage == nil || d.Usage.TotalTokens == 0 {
+				t.Errorf("usage = %+v, want the supervisor's usage", d.Usage)
+			}
+			calls := script.Calls()
+			if len(calls) != 1 || !strings.Contains(calls[0].Input, "n1") {
+				t.Errorf("supervisor calls = %+v, want one call mentioning the node", calls)
+			}
+		})
+	}
+}
+
+func TestLLMRouterFollowsTransfer(t *testing.T) {
+	script := newFakeScript(t, &model.FakeRule{
+		Agent: "graph_supervisor",
+		Tool:  adk.TransferToAgentToolName,
+		FakeResponse: model.FakeResponse{ToolCalls: []model.FakeToolCall{{
+			Name:      adk.TransferToAgentToolName,
+			Arguments: json.RawMessage(`{"agent_name":"vision_agent"}`),
+		}}},
+	})
+	ctx := context.Background()
+	supervisor, err := adk.SetSubAgents(ctx, newFakeAgent(t, "graph_supervisor", script), []adk.Agent{
+		newFakeAgent(t, "text_agent", script),
+		newFakeAgent(t, "vision_agent", script),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &LLMRouter{Supervisor: supervisor}
+	d, err := r.Route(ctx, RouteInput{Node: &orchestrator.SimpleNode{ID: "n1", Payload: json.RawMessage(`{}`)}})
+	if err != nil {
+		t.Fatalf("Route: %v", err)
+	}
+	if d.Used != RouteVision || d.Reason != "supervisor transferred to vision" {
+		t.Errorf("got used=%q reason=%q, want transfer to vision", d.Used, d.Reason)
+	}
+	if calls := script.Calls(); len(calls) == 0 || calls[0].Source != "rule[0]" {
+		t.Errorf("supervisor did not use the scripted transfer: %+v", calls)
+	}
+}
+
+func TestHybridRouter(t *testing.T) {
+	script := newFakeScript(t, &model.FakeRule{Agent: "graph_supervisor", FakeResponse: model.FakeResponse{Content: `{"used":"vision"}`}})
+	r, err := NewRouter("", newFakeAgent(t, "graph_supervisor", script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Name() != RouterHybrid {
+		t.Fatalf("default router = %s, want hybrid", r.Name())
+	}
+	ctx := context.Background()
+
+	// 规则可判定：不调用监督者
+	d, err := r.Route(ctx, RouteInput{Node: &orchestrator.SimpleNode{ID: "a", Payload: json.RawMessage(`{"text":"hi"}`)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Used != RouteText || d.Router != RouterRule || d.Reason != "text-only payload" {
+		t.Errorf("rule decision = %+v", d)
+	}
+	if n := len(script.Calls()); n != 0 {
+		t.Errorf("supervisor called %d times for a rule decision", n)
+	}
+
+	// 前驱为视觉输出：规则无法判定，交给监督者
+	prevs := []PrevOutput{{ID: "img", Kind: RouteVision, Output: "a chart"}}
+	d, err = r.Route(ctx, RouteInput{Node: &orchestrator.SimpleNode{ID: "b", Payload: json.RawMessage(`{"text":"describe"}`)}, Prevs: prevs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Used != RouteVision || d.Router != RouterLLM || d.Reason != "ambiguous for rules; supervisor chose vision" {
+		t.Errorf("llm decision = %+v", d)
+	}
+	if n := len(script.Calls()); n != 1 {
+		t.Errorf("supervisor called %d times, want 1", n)
+	}
+}
+
+func TestNewRouterRejectsUnknownMode(t *testing.T) {
+	if _, err := NewRouter("random", nil); err == nil {
+		t.Fatal("want error for unknown router mode")
+	}
+}
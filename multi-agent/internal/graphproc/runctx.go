@@ -0,0 +1,34 @@
+package graphproc
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// runIDKey 用于在 context 中携带本次图执行的 run ID
+type runIDKey struct{}
+
+// NewRunID 生成新的 run ID
+func NewRunID() string {
+	return uuid.New().String()
+}
+
+// WithRunID 将 run ID 写入 context，便于 runner 在日志中标注所属执行
+func WithRunID(ctx context.Context, runID string) context.Context {
+	if runID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, runIDKey{}, runID)
+}
+
+// RunIDFromContext 读取 context 中的 run ID；不存在时返回空字符串
+func RunIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if v, ok := ctx.Value(runIDKey{}).(string); ok {
+		return v
+	}
+	return ""
+}
@@ -3,20 +3,31 @@ package graphproc
 import (
 	"context"
 	"fmt"
+	"io"
 	"reflect"
 	"strings"
 
+	"multi-agent/internal/metrics"
+
 	"github.com/cloudwego/eino/adk"
+	"github.com/cloudwego/eino/schema"
 )
 
-// RunAgentOnceWithUsageStreaming 在消费事件流的同时进行增量打印（使用 StreamPrinter），并提取/估算 token 用量。
+// RunAgentOnceWithUsageStreaming 在消费事件流的同时进行增量打印（使用 StreamPrinter），并提取模型上报的 token 用量。
+// 代理的多轮模型调用（如先调用工具再回答）的用量累加；模型未上报时返回 nil，由调用方估算（见 estimate.go）。
 // 注意：为避免并发输出混流，StreamPrinter 会在一次完整打印期间持锁。
+// ctx 被取消时（客户端断开或显式取消 run），模型调用随之中断，返回已收到的部分输出与 ctx.Err()。
 func RunAgentOnceWithUsageStreaming(ctx context.Context, a adk.Agent, input string, printer *StreamPrinter, nodeID string) (string, *TokenUsage, error) {
+	return RunAgentMessageWithUsageStreaming(ctx, a, schema.UserMessage(input), printer, nodeID)
+}
+
+// RunAgentMessageWithUsageStreaming 同 RunAgentOnceWithUsageStreaming，输入为完整的用户消息（如附带图片的多模态消息，见 image.go）
+func RunAgentMessageWithUsageStreaming(ctx context.Context, a adk.Agent, input adk.Message, printer *StreamPrinter, nodeID string) (string, *TokenUsage, error) {
 	r := adk.NewRunner(ctx, adk.RunnerConfig{
 		Agent:           a,
 		EnableStreaming: true,
 	})
-	iter := r.Query(ctx, input)
+	iter := r.Run(ctx, []adk.Message{input})
 
 	var last string
 	var okMsg bool
@@ -26,6 +37,9 @@ func RunAgentOnceWithUsageStreaming(ctx context.Context, a adk.Agent, input stri
 	var drainedNonEmpty bool
 	var eventIdx int
 	for {
+		if ctx.Err() != nil {
+			break
+		}
 		event, ok := iter.Next()
 		if !ok {
 			break
@@ -34,32 +48,35 @@ func RunAgentOnceWithUsageStreaming(ctx context.Context, a adk.Agent, input stri
 			if firstErr == nil {
 				firstErr = event.Err
 			}
+			metrics.ModelCallErrors.Inc(metrics.StageSubAgent, ModelErrorType(event.Err))
 			if printer != nil && printer.IsVerbose() {
-				fmt.Printf("[event idx=%d node=%s error=%v]\n", eventIdx, nodeID, event.Err)
+				fmt.Printf("[event idx=%d run=%s node=%s error=%v]\n", eventIdx, RunIDFromContext(ctx), nodeID, event.Err)
 			}
 			continue
 		}
 		if printer != nil && printer.IsVerbose() {
 			debugPrintEventMeta(nodeID, eventIdx, event)
 		}
-		if u := extractUsageFromEvent(event); u != nil {
-			usage = u
-		}
+		usage = addUsage(usage, extractUsageFromEvent(event))
 		if event.Output != nil && event.Output.MessageOutput != nil {
 			// 优先读取 MessageStream 以进行流式打印
 			if s := event.Output.MessageOutput.MessageStream; s != nil && printer != nil {
 				if printer.IsVerbose() {
 					fmt.Printf("[event idx=%d node=%s output=message_stream open]\n", eventIdx, nodeID)
 				}
-				out, err := DrainMessageStream(printer, nodeID, s)
+				out, su, err := DrainMessageStream(printer, nodeID, s)
+				usage = addUsage(usage, su)
 				if err == nil {
 					if out != "" {
 						drainedNonEmpty = true
 						last = out
 						okMsg = true
 					}
-				} else if firstErr == nil {
-					firstErr = err
+				} else {
+					metrics.ModelCallErrors.Inc(metrics.StageSubAgent, ModelErrorType(err))
+					if firstErr == nil {
+						firstErr = err
+					}
 				}
 			}
 			// 若没有流或额外的最终消息，则以最终消息为准，并进行一次性打印
@@ -73,6 +90,7 @@ func RunAgentOnceWithUsageStreaming(ctx context.Context, a adk.Agent, input stri
 						printer.PrintAnswerChunk(fmt.Sprintf("[final role=%s] ", role))
 					}
 					printer.PrintAnswerChunk(m.Content)
+					printer.emitDelta(m.Content)
 					printer.End()
 				}
 				last = m.Content
@@ -82,6 +100,9 @@ func RunAgentOnceWithUsageStreaming(ctx context.Context, a adk.Agent, input stri
 		eventIdx++
 	}
 
+	if err := ctx.Err(); err != nil {
+		return last, usage, err
+	}
 	if okMsg {
 		return last, usage, nil
 	}
@@ -90,10 +111,17 @@ func RunAgentOnceWithUsageStreaming(ctx context.Context, a adk.Agent, input stri
 
 // extractUsageFromEvent 通过反射从消息中提取ResponseMeta.Usage中的token使用情况
 func extractUsageFromEvent(event *adk.AgentEvent) *TokenUsage {
-	if event == nil || event.Output == nil || event.Output.MessageOutput == nil || event.Output.MessageOutput.Message == nil {
+	if event == nil || event.Output == nil || event.Output.MessageOutput == nil {
+		return nil
+	}
+	return usageFromMessage(event.Output.MessageOutput.Message)
+}
+
+// usageFromMessage 读取消息（或流式分片）ResponseMeta.Usage 中的 token 用量；未上报时返回 nil
+func usageFromMessage(msg *schema.Message) *TokenUsage {
+	if msg == nil {
 		return nil
 	}
-	msg := event.Output.MessageOutput.Message
 	v := reflect.ValueOf(msg)
 	if v.Kind() == reflect.Ptr {
 		v = v.Elem()
@@ -148,6 +176,41 @@ func extractUsageFromEvent(event *adk.AgentEvent) *TokenUsage {
 	return tu
 }
 
+// addUsage 累加两次模型调用的用量；任一为 nil 时返回另一个
+func addUsage(a, b *TokenUsage) *TokenUsage {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	return &TokenUsage{
+		PromptTokens:     a.PromptTokens + b.PromptTokens,
+		CompletionTokens: a.CompletionTokens + b.CompletionTokens,
+		TotalTokens:      a.TotalTokens + b.TotalTokens,
+		Estimated:        a.Estimated || b.Estimated,
+	}
+}
+
+// collectMessageStream 读取完整的消息流（不打印），返回拼接的内容与分片携带的 token 用量
+func collectMessageStream(s messageStream) (string, *TokenUsage, error) {
+	var builder strings.Builder
+	var usage *TokenUsage
+	for {
+		chunk, err := s.Recv()
+		if err == io.EOF {
+			return builder.String(), usage, nil
+		}
+		if err != nil {
+			return builder.String(), usage, err
+		}
+		if u := usageFromMessage(chunk); u != nil {
+			usage = u
+		}
+		builder.WriteString(chunk.Content)
+	}
+}
+
 // extractRoleFromMessage 通过反射获取消息的角色（Role），若不存在则返回空字符串
 func extractRoleFromMessage(msg any) string {
 	if msg == nil {
@@ -167,7 +230,8 @@ func extractRoleFromMessage(msg any) string {
 	return ""
 }
 
-// runRouterWithUsage 执行监督者路由，同时提取/估算token使用情况
+// runRouterWithUsage 执行监督者路由，同时提取模型上报的token使用情况（未上报时为 nil）
+// ctx 被取消时立即停止消费事件并返回 ctx.Err()。
 func runRouterWithUsage(ctx context.Context, a adk.Agent, input string) (used string, output string, usage *TokenUsage, err error) {
 	r := adk.NewRunner(ctx, adk.RunnerConfig{
 		Agent:           a,
@@ -180,7 +244,11 @@ func runRouterWithUsage(ctx context.Context, a adk.Agent, input string) (used st
 	var firstErr error
 	var u *TokenUsage
 	var eventIdx int
+	runID := RunIDFromContext(ctx)
 	for {
+		if ctx.Err() != nil {
+			break
+		}
 		event, ok := iter.Next()
 		if !ok {
 			break
@@ -189,26 +257,41 @@ func runRouterWithUsage(ctx context.Context, a adk.Agent, input string) (used st
 			if firstErr == nil {
 				firstErr = event.Err
 			}
+			metrics.ModelCallErrors.Inc(metrics.StageRouter, ModelErrorType(event.Err))
 			// 不中断，继续尽力收集usage与最后输出
-			fmt.Printf("[router event idx=%d error=%v]\n", eventIdx, event.Err)
+			fmt.Printf("[router event idx=%d run=%s error=%v]\n", eventIdx, runID, event.Err)
 			continue
 		}
-		if tu := extractUsageFromEvent(event); tu != nil {
-			u = tu
-		}
+		u = addUsage(u, extractUsageFromEvent(event))
 		if event.Action != nil && event.Action.TransferToAgent != nil {
 			dest = event.Action.TransferToAgent.DestAgentName
-			fmt.Printf("[router event idx=%d transfer to=%s]\n", eventIdx, dest)
+			fmt.Printf("[router event idx=%d run=%s transfer to=%s]\n", eventIdx, runID, dest)
 		}
 		if event.Output != nil && event.Output.MessageOutput != nil {
+			// 流式输出时读取完整的消息流，以获得内容与最后分片中的用量
+			if s := event.Output.MessageOutput.MessageStream; s != nil {
+				out, su, serr := collectMessageStream(s)
+				u = addUsage(u, su)
+				if serr != nil {
+					metrics.ModelCallErrors.Inc(metrics.StageRouter, ModelErrorType(serr))
+					if firstErr == nil {
+						firstErr = serr
+					}
+				}
+				last = out
+			}
 			if m := event.Output.MessageOutput.Message; m != nil {
 				last = m.Content
-				fmt.Printf("[router event idx=%d final_message len=%d]\n", eventIdx, len(last))
+				fmt.Printf("[router event idx=%d run=%s final_message len=%d]\n", eventIdx, runID, len(last))
 			}
 		}
 		eventIdx++
 	}
 
+	if err := ctx.Err(); err != nil {
+		return used, last, u, err
+	}
+
 	// 依据 transfer 事件确定使用的子代理
 	if dest != "" {
 		if strings.Contains(strings.ToLower(dest), "vision") {
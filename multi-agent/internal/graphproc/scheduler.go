@@ -0,0 +1,235 @@
+package graphproc
+
+// 本文件提供基于依赖就绪的调度工具：
+// - RunOptions：单次执行的调度参数（全局并发上限、关键路径优先）
+// - readyQueue：就绪队列；默认按就绪先后（FIFO）出队，开启关键路径优先时按剩余最长路径降序出队
+// - criticalPathLengths：计算每个节点到任一汇点的最长路径长度（按节点数计）
+// - BuildExecutionPlan：执行前的计划摘要（拓扑序、依赖层级、关键路径），随 run_start 事件下发
+
+import (
+	"container/heap"
+
+	"multi-agent/internal/orchestrator"
+)
+
+// DefaultMaxConcurrency 未显式配置时的全局并发上限
+const DefaultMaxConcurrency = 4
+
+// RunOptions 控制单次图执行的调度行为
+type RunOptions struct {
+	// RunID 本次执行的标识；为空时由 ProcessGraph 生成
+	RunID string `json:"run_id,omitempty"`
+	// MaxConcurrency 全局同时执行的节点数上限；<=0 时使用 DefaultMaxConcurrency
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+	// CriticalPathPriority 为 true 时，就绪节点按关键路径长度优先调度（长链优先启动）
+	CriticalPathPriority bool `json:"critical_path_priority,omitempty"`
+	// Retry 运行级重试/超时策略；为空时使用 DefaultRetryPolicy，可被图级与节点级覆盖（见 retry.go）
+	Retry *orchestrator.RetryPolicy `json:"retry,omitempty"`
+	// FailureMode 节点失败后的传播方式：continue（默认）/ skip-downstream / fail-fast，见 failure.go
+	FailureMode string `json:"failure_mode,omitempty"`
+	// Router 路由模式：hybrid（默认）/ rule / llm，见 router.go
+	Router string `json:"router,omitempty"`
+	// PromptVersion 节点提示词与代理指令的模板版本；为空时依次使用图级 options.prompt_version 与默认版本（见 prompts.go）
+	PromptVersion string `json:"prompt_version,omitempty"`
+	// TokenBudget 本次 run 可消耗的 token 上限（路由与子代理合计，含重试）；<=0 表示不限，见 budget.go
+	TokenBudget int `json:"token_budget,omitempty"`
+	// Language 提示词语言包（zh / en / 覆盖目录中新增的语言）；为空时依次使用图级 options.language、PROMPT_LANGUAGE 与 zh，节点 payload.language 可再覆盖
+	Language string `json:"language,omitempty"`
+	// RouterImpl 自定义路由器；非空时忽略 Router
+	RouterImpl Router `json:"-"`
+	// Agents 节点类型 → 专用代理注册表（见 registry.go）；为空时所有节点只使用 text/vision 代理
+	Agents *AgentRegistry `json:"-"`
+	// Cache 节点输出缓存（见 cache.go）；为空时不读也不写缓存
+	Cache *OutputCache `json:"-"`
+	// Preset 预置结果：这些节点不执行，直接以给定结果视为完成并推进后继（rerun 复用/覆盖，见 rerun.go）
+	Preset map[string]NodeResult `json:"-"`
+	// ForceNodes 忽略缓存读取、强制调用子代理的节点（结果仍写入缓存）
+	ForceNodes []string `json:"force_nodes,omitempty"`
+	// Images 视觉节点与 get_image 工具使用的图片读取器（见 image.go）；为空时使用 DefaultImageLoader
+	Images *ImageLoader `json:"-"`
+	// Pricing 模型价格表（见 pricing.go）；为空时节点费用为 0
+	Pricing *Pricing `json:"-"`
+	// Quota run 之外的 token 额度（如用户日/月配额）；与 TokenBudget 同时检查与记账，为空时不限
+	Quota TokenQuota `json:"-"`
+	// OnEvent 结构化生命周期事件回调（见 events.go）；为空时不产生事件
+	OnEvent EventHandler `json:"-"`
+}
+
+func (o RunOptions) concurrency() int {
+	if o.MaxConcurrency <= 0 {
+		return DefaultMaxConcurrency
+	}
+	return o.MaxConcurrency
+}
+
+// readyItem 就绪队列中的一个节点
+type readyItem struct {
+	id       string
+	priority int
+	seq      int
+}
+
+// readyQueue 实现 heap.Interface：priority 高者优先，priority 相同按入队顺序（seq）
+type readyQueue struct {
+	items []readyItem
+	seq   int
+}
+
+func (q *readyQueue) Len() int { return len(q.items) }
+
+func (q *readyQueue) Less(i, j int) bool {
+	if q.items[i].priority != q.items[j].priority {
+		return q.items[i].priority > q.items[j].priority
+	}
+	return q.items[i].seq < q.items[j].seq
+}
+
+func (q *readyQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+
+func (q *readyQueue) Push(x any) { q.items = append(q.items, x.(readyItem)) }
+
+func (q *readyQueue) Pop() any {
+	old := q.items
+	n := len(old)
+	it := old[n-1]
+	q.items = old[:n-1]
+	return it
+}
+
+func (q *readyQueue) push(id string, priority int) {
+	heap.Push(q, readyItem{id: id, priority: priority, seq: q.seq})
+	q.seq++
+}
+
+func (q *readyQueue) pop() string {
+	return heap.Pop(q).(readyItem).id
+}
+
+// criticalPathLengths 计算每个节点到汇点的最长路径（含自身，按节点数计）。
+// 要求输入为 DAG；若存在环，回边会被忽略以避免无限递归。
+func criticalPathLengths(sg orchestrator.SimpleGraph, adj map[string][]string) map[string]int {
+	lengths := make(map[string]int, len(sg.Nodes))
+	const visiting = -1
+	var visit func(id string) int
+	visit = func(id string) int {
+		if l, ok := lengths[id]; ok {
+			if l == visiting {
+				return 0
+			}
+			return l
+		}
+		lengths[id] = visiting
+		best := 0
+		for _, nb := range adj[id] {
+			if l := visit(nb); l > best {
+				best = l
+			}
+		}
+		lengths[id] = best + 1
+		return best + 1
+	}
+	for _, n := range sg.Nodes {
+		visit(n.ID)
+	}
+	return lengths
+}
+
+// ExecutionPlan 执行前的计划摘要。实际启动顺序仍由就绪队列决定，Levels 仅表示依赖深度。
+type ExecutionPlan struct {
+	// Order 一个合法的拓扑序（同深度按声明顺序）
+	Order []string `json:"order"`
+	// Levels 按依赖深度分组：第 i 组节点的所有前驱都位于前 i 组
+	Levels [][]string `json:"levels"`
+	// Sources / Sinks 无前驱 / 无后继的节点
+	Sources []string `json:"sources"`
+	Sinks   []string `json:"sinks"`
+	// CriticalPath 最长依赖链（按节点数计）
+	CriticalPath   []string `json:"critical_path"`
+	MaxConcurrency int      `json:"max_concurrency"`
+	FailureMode    string   `json:"failure_mode"`
+	Router         string   `json:"router"`
+}
+
+// BuildExecutionPlan 计算图的执行计划；要求输入为已通过 ValidateGraph 的 DAG
+func BuildExecutionPlan(sg orchestrator.SimpleGraph, opts RunOptions) ExecutionPlan {
+	adj := make(map[string][]string, len(sg.Nodes))
+	preds := make(map[string][]string, len(sg.Nodes))
+	for _, e := range sg.Edges {
+		adj[e.From] = append(adj[e.From], e.To)
+		preds[e.To] = append(preds[e.To], e.From)
+	}
+	mode, _ := ParseFailureMode(opts.FailureMode)
+	router, _ := ParseRouterMode(opts.Router)
+	if opts.RouterImpl != nil {
+		router = opts.RouterImpl.Name()
+	}
+	plan := ExecutionPlan{
+		Order:          []string{},
+		Levels:         [][]string{},
+		Sources:        []string{},
+		Sinks:          []string{},
+		CriticalPath:   []string{},
+		MaxConcurrency: opts.concurrency(),
+		FailureMode:    mode,
+		Router:         router,
+	}
+
+	// 依赖深度：无前驱为 0，否则为前驱最大深度 + 1
+	depth := make(map[string]int, len(sg.Nodes))
+	var visit func(id string) int
+	visit = func(id string) int {
+		if d, ok := depth[id]; ok {
+			return d
+		}
+		depth[id] = 0 // 防御：若存在环，回边按深度 0 处理
+		d := 0
+		for _, p := range preds[id] {
+			if pd := visit(p) + 1; pd > d {
+				d = pd
+			}
+		}
+		depth[id] = d
+		return d
+	}
+	seen := make(map[string]struct{}, len(sg.Nodes))
+	for _, n := range sg.Nodes {
+		if _, ok := seen[n.ID]; ok {
+			continue
+		}
+		seen[n.ID] = struct{}{}
+		d := visit(n.ID)
+		for len(plan.Levels) <= d {
+			plan.Levels = append(plan.Levels, []string{})
+		}
+		plan.Levels[d] = append(plan.Levels[d], n.ID)
+		if len(preds[n.ID]) == 0 {
+			plan.Sources = append(plan.Sources, n.ID)
+		}
+		if len(adj[n.ID]) == 0 {
+			plan.Sinks = append(plan.Sinks, n.ID)
+		}
+	}
+	for _, level := range plan.Levels {
+		plan.Order = append(plan.Order, level...)
+	}
+
+	// 关键路径：从剩余长度最大的源点出发，每步走向剩余长度最大的后继
+	lengths := criticalPathLengths(sg, adj)
+	cur, best := "", 0
+	for _, id := range plan.Sources {
+		if lengths[id] > best {
+			cur, best = id, lengths[id]
+		}
+	}
+	for cur != "" {
+		plan.CriticalPath = append(plan.CriticalPath, cur)
+		next, nextLen := "", 0
+		for _, nb := range adj[cur] {
+			if lengths[nb] > nextLen {
+				next, nextLen = nb, lengths[nb]
+			}
+		}
+		cur = next
+	}
+	return plan
+}
@@ -0,0 +1,161 @@
+package graphproc
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/eino/adk"
+	"github.com/cloudwego/eino/schema"
+
+	"multi-agent/internal/orchestrator"
+)
+
+// stubAgent 测试用代理：按固定延迟（或 delays 中节点自己的延迟）回复 answer，
+// 并记录各节点的启动、结束顺序与同时执行的节点数
+type stubAgent struct {
+	answer  string
+	latency time.Duration
+	delays  map[string]time.Duration
+
+	mu       sync.Mutex
+	started  []string
+	ended    []string
+	inflight int
+	peak     int
+}
+
+func (a *stubAgent) Name(context.Context) string        { return "stub" }
+func (a *stubAgent) Description(context.Context) string { return "stub" }
+
+func (a *stubAgent) Run(ctx context.Context, input *adk.AgentInput, _ ...adk.AgentRunOption) *adk.AsyncIterator[*adk.AgentEvent] {
+	var id string
+	if n := len(input.Messages); n > 0 {
+		first, _, _ := strings.Cut(input.Messages[n-1].Content, "\n")
+		id = strings.TrimPrefix(first, "处理节点: ")
+	}
+	a.mu.Lock()
+	a.started = append(a.started, id)
+	a.inflight++
+	a.peak = max(a.peak, a.inflight)
+	a.mu.Unlock()
+
+	delay, ok := a.delays[id]
+	if !ok {
+		delay = a.latency
+	}
+
+	iter, gen := adk.NewAsyncIteratorPair[*adk.AgentEvent]()
+	go func() {
+		defer gen.Close()
+		time.Sleep(delay)
+		a.mu.Lock()
+		a.inflight--
+		a.ended = append(a.ended, id)
+		a.mu.Unlock()
+		gen.Send(adk.EventFromMessage(schema.AssistantMessage(a.answer, nil), nil, schema.Assistant, ""))
+	}()
+	return iter
+}
+
+// runStubGraph 以 stubAgent 作为监督者执行图，sub 同时充当文本与视觉子代理
+func runStubGraph(t *testing.T, sg orchestrator.SimpleGraph, sub *stubAgent, opts RunOptions) {
+	t.Helper()
+	supervisor := &stubAgent{answer: `{"used":"text"}`}
+	results := map[string]NodeResult{}
+	if err := ProcessGraph(context.Background(), sg, supervisor, sub, sub, results, nil, opts); err != nil {
+		t.Fatalf("ProcessGraph: %v", err)
+	}
+	if len(results) != len(sg.Nodes) {
+		t.Fatalf("results for %d nodes, want %d", len(results), len(sg.Nodes))
+	}
+}
+
+func TestReadyQueueOrder(t *testing.T) {
+	q := &readyQueue{}
+	for _, it := range []struct {
+		id       string
+		priority int
+	}{{"a", 1}, {"b", 3}, {"c", 2}, {"d", 3}, {"e", 1}} {
+		q.push(it.id, it.priority)
+	}
+	var got []string
+	for q.Len() > 0 {
+		got = append(got, q.pop())
+	}
+	// priority 降序，相同 priority 按入队顺序
+	if want := []string{"b", "d", "c", "a", "e"}; !slices.Equal(got, want) {
+		t.Errorf("pop order = %v, want %v", got, want)
+	}
+}
+
+func TestCriticalPathLengths(t *testing.T) {
+	sg := testGraph([]string{"x", "a", "b", "c", "d"}, "a->b", "a->c", "b->d", "c->d", "a->d")
+	adj := map[string][]string{}
+	for _, e := range sg.Edges {
+		adj[e.From] = append(adj[e.From], e.To)
+	}
+	want := map[string]int{"x": 1, "a": 3, "b": 2, "c": 2, "d": 1}
+	if got := criticalPathLengths(sg, adj); !maps.Equal(got, want) {
+		t.Errorf("lengths = %v, want %v", got, want)
+	}
+}
+
+func TestProcessGraphCriticalPathPriority(t *testing.T) {
+	// x、y 为孤立节点（剩余长度 1），l1->l2->l3 为最长链；并发为 1 时启动顺序即出队顺序
+	sg := testGraph([]string{"x", "y", "l1", "l2", "l3"}, "l1->l2", "l2->l3")
+	tests := []struct {
+		name     string
+		priority bool
+		want     []string
+	}{
+		// 默认按就绪先后：声明顺序的源点先出队，后继在其后入队
+		{name: "fifo", want: []string{"x", "y", "l1", "l2", "l3"}},
+		// 关键路径优先：l1(3) 先于 x、y(1)；l2(2) 就绪后插队；l3 与 x、y 同为 1，按入队顺序排在最后
+		{name: "critical path", priority: true, want: []string{"l1", "l2", "x", "y", "l3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := &stubAgent{answer: "ok"}
+			runStubGraph(t, sg, sub, RunOptions{MaxConcurrency: 1, CriticalPathPriority: tt.priority})
+			if !slices.Equal(sub.started, tt.want) {
+				t.Errorf("start order = %v, want %v", sub.started, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessGraphMaxConcurrency(t *testing.T) {
+	latency := 100 * time.Millisecond
+	ids := []string{"n1", "n2", "n3", "n4", "n5", "n6"}
+	for _, limit := range []int{1, 2, 4} {
+		t.Run(fmt.Sprintf("limit=%d", limit), func(t *testing.T) {
+			started := time.Now()
+			sub := &stubAgent{answer: "ok", latency: latency}
+			runStubGraph(t, testGraph(ids), sub, RunOptions{MaxConcurrency: limit})
+			// 六个互不依赖的节点：并发上限既不能被突破，也应被用满
+			if sub.peak != limit {
+				t.Errorf("peak in-flight nodes = %d, want %d", sub.peak, limit)
+			}
+			waves := (len(ids) + limit - 1) / limit
+			if elapsed, floor := time.Since(started), time.Duration(waves)*latency; elapsed < floor {
+				t.Errorf("elapsed %v < %v: more than %d nodes ran at once", elapsed, floor, limit)
+			}
+		})
+	}
+}
+
+func TestProcessGraphSuccessorStartsBeforeLevelEnds(t *testing.T) {
+	// slow 与 a 同为源点；b 只依赖 a，应在 a 完成后立即启动，而不是等 slow 所在的整层结束
+	sg := testGraph([]string{"slow", "a", "b"}, "a->b")
+	sub := &stubAgent{answer: "ok", latency: 10 * time.Millisecond, delays: map[string]time.Duration{"slow": 300 * time.Millisecond}}
+	runStubGraph(t, sg, sub, RunOptions{MaxConcurrency: 3})
+	if want := []string{"a", "b", "slow"}; !slices.Equal(sub.ended, want) {
+		t.Errorf("end order = %v, want %v", sub.ended, want)
+	}
+}
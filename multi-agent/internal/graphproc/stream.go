@@ -20,6 +20,10 @@ type StreamPrinter struct {
     verbose bool
     // 输出目标（默认 stdout），用于HTTP响应抓取或自定义日志
     w io.Writer
+    // 增量文本回调（结构化事件使用）；current 为当前持锁输出的节点，fresh 表示该段输出尚未产生增量
+    onDelta func(nodeID, text string, reset bool)
+    current string
+    fresh   bool
 }
 
 func NewStreamPrinter() *StreamPrinter {
@@ -35,9 +39,22 @@ func (p *StreamPrinter) IsVerbose() bool { return p.verbose }
 // SetWriter 设置输出目标（默认 stdout）
 func (p *StreamPrinter) SetWriter(w io.Writer) { if w != nil { p.w = w } }
 
+// SetDeltaHandler 设置增量文本回调：每段节点输出的首个增量 reset=true，表示应丢弃该节点此前收到的内容（如重试）
+func (p *StreamPrinter) SetDeltaHandler(fn func(nodeID, text string, reset bool)) { p.onDelta = fn }
+
+// emitDelta 将一段节点输出文本交给增量回调（须在 Begin/End 之间调用）
+func (p *StreamPrinter) emitDelta(text string) {
+    if p.onDelta == nil || text == "" {
+        return
+    }
+    p.onDelta(p.current, text, p.fresh)
+    p.fresh = false
+}
+
 // Begin 在输出节点内容前加上边界与前缀，并持锁，保证该节点的完整输出不被其他节点打断
 func (p *StreamPrinter) Begin(nodeID string) {
     p.mu.Lock()
+    p.current, p.fresh = nodeID, true
     fmt.Fprintf(p.w, "\n=== node=%s ===\n", nodeID)
 }
 
@@ -75,22 +92,23 @@ func (p *StreamPrinter) End() {
 // 已移除工具消息拼接逻辑，专注于内容流打印
 
 // DrainMessageStream 读取消息流并通过 printer 输出（仅内容与工具调用）
-// 返回完整的拼接文本，便于作为 last 输出记录
+// 返回完整的拼接文本，便于作为 last 输出记录；以及流中分片携带的 token 用量（通常在最后一个分片，未上报时为 nil）
 // 为了兼容不同版本的 ADK，这里不直接依赖具体的 MessageStream 类型名，
 // 而是使用一个仅包含 Recv 方法的接口，返回 *schema.Message。
 type messageStream interface {
 	Recv() (*schema.Message, error)
 }
 
-func DrainMessageStream(printer *StreamPrinter, nodeID string, s messageStream) (string, error) {
+func DrainMessageStream(printer *StreamPrinter, nodeID string, s messageStream) (string, *TokenUsage, error) {
 	if s == nil {
-		return "", nil
+		return "", nil, nil
 	}
 	printer.Begin(nodeID)
 	charNumOfOneRow := 0
 	maxCharNumOfOneRow := printer.maxPerLine
 
 	var builder strings.Builder
+	var usage *TokenUsage
 
 	for {
 		chunk, err := s.Recv()
@@ -101,7 +119,10 @@ func DrainMessageStream(printer *StreamPrinter, nodeID string, s messageStream)
 			// 打印错误并结束此节点
             fmt.Fprintf(printer.w, "error: %v", err)
             printer.End()
-            return builder.String(), err
+            return builder.String(), usage, err
+        }
+        if u := usageFromMessage(chunk); u != nil {
+            usage = u
         }
 
         // 打印任何角色的非空内容（包括 assistant 与 tool）
@@ -119,6 +140,7 @@ func DrainMessageStream(printer *StreamPrinter, nodeID string, s messageStream)
                 charNumOfOneRow = 0
             }
             fmt.Fprintf(printer.w, "%v", chunk.Content)
+            printer.emitDelta(chunk.Content)
             builder.WriteString(chunk.Content)
         }
         // 打印工具调用摘要（仅在 verbose 下）
@@ -128,7 +150,7 @@ func DrainMessageStream(printer *StreamPrinter, nodeID string, s messageStream)
     }
 
 	printer.End()
-	return builder.String(), nil
+	return builder.String(), usage, nil
 }
 
 // printToolCallsSummary 尝试打印工具调用摘要（名称与参数），若不可用则忽略
@@ -1,24 +1,71 @@
 package graphproc
 
+// 节点执行状态
+const (
+    NodeStatusSucceeded = "succeeded"
+    NodeStatusFailed    = "failed"
+    NodeStatusCancelled = "cancelled"
+)
+
 // NodeResult holds processing output per node
 type NodeResult struct {
     Kind   string `json:"kind"`
+    // 实际执行的代理类型（text / vision 或节点类型对应的专用代理，见 registry.go）
+    Agent  string `json:"agent,omitempty"`
+    // 该代理使用的模型名称（为空表示未知，例如未提供注册表）
+    Model  string `json:"model,omitempty"`
     Output string `json:"output"`
     Error  string `json:"error,omitempty"`
+    // 节点状态：succeeded / failed / cancelled / skipped / budget_exceeded
+    Status string `json:"status,omitempty"`
+    // skipped 节点的根因：最初失败的上游节点 ID
+    SkipCause string `json:"skip_cause,omitempty"`
+    // 实际做出路由决策的路由器（rule / llm）及理由，见 router.go
+    Router      string `json:"router,omitempty"`
+    RouteReason string `json:"route_reason,omitempty"`
+    // Cached 为 true 表示输出复用自节点输出缓存（未调用子代理），CacheKey 为对应的缓存键（见 cache.go）
+    Cached   bool   `json:"cached,omitempty"`
+    CacheKey string `json:"cache_key,omitempty"`
+    // RouteCached 为 true 表示监督者的路由决策复用自缓存（未调用监督者）
+    RouteCached bool `json:"route_cached,omitempty"`
+    // 渲染本节点提示词的模板名、模板版本与语言（见 prompts.go）
+    PromptTemplate string `json:"prompt_template,omitempty"`
+    PromptVersion  string `json:"prompt_version,omitempty"`
+    Language       string `json:"language,omitempty"`
+    // rerun 时复用上次输出（Reused）或使用手动覆盖的输出（Overridden）的节点，未执行（见 rerun.go）
+    Reused     bool `json:"reused,omitempty"`
+    Overridden bool `json:"overridden,omitempty"`
+    // 子代理阶段与路由阶段各自的尝试次数，以及路由/子代理阶段每次失败尝试的错误
+    Attempts       int            `json:"attempts,omitempty"`
+    RouterAttempts int            `json:"router_attempts,omitempty"`
+    AttemptErrors  []AttemptError `json:"attempt_errors,omitempty"`
     // 记录每个节点的输入/输出/总token，用于费用与优化分析
     PromptTokens     int `json:"prompt_tokens,omitempty"`
     CompletionTokens int `json:"completion_tokens,omitempty"`
     TotalTokens      int `json:"total_tokens,omitempty"`
+    // 子代理阶段 token 的来源：reported（模型上报）/ estimated（按经验规则估算的近似值，见 estimate.go）
+    UsageSource string `json:"usage_source,omitempty"`
     // 监督者路由阶段的token（每节点）
     RouterPromptTokens     int `json:"router_prompt_tokens,omitempty"`
     RouterCompletionTokens int `json:"router_completion_tokens,omitempty"`
     RouterTotalTokens      int `json:"router_total_tokens,omitempty"`
+    // 路由阶段 token 的来源：reported / estimated
+    RouterUsageSource string `json:"router_usage_source,omitempty"`
+    // 监督者路由使用的模型（仅 LLM 路由）
+    RouterModel string `json:"router_model,omitempty"`
+    // 子代理阶段与路由阶段的费用，按执行时价格表中的模型单价计算（见 pricing.go）
+    Cost       float64 `json:"cost,omitempty"`
+    RouterCost float64 `json:"router_cost,omitempty"`
+    // 计入 token 预算与用户配额的用量（路由与子代理的每次尝试；未上报用量的调用按文本估算，见 budget.go）
+    ChargedTokens int `json:"charged_tokens,omitempty"`
+    // 节点执行耗时（含路由、子代理与重试等待）
+    DurationMs int64 `json:"duration_ms,omitempty"`
 }
 
 // FinalResult is the printed output schema
 type FinalResult struct {
     Results map[string]NodeResult `json:"results"`
-    // 保留结构占位，当前不再使用 summary 阶段
+    // 执行结束时由 SummarizeUsage 汇总（见 usage.go）
     UsageSummary UsageSummary     `json:"usage_summary,omitempty"`
 }
 
@@ -27,6 +74,8 @@ type TokenUsage struct {
     PromptTokens     int
     CompletionTokens int
     TotalTokens      int
+    // Estimated 为 true 表示模型未上报用量，由 EstimateUsage 本地估算的近似值（见 estimate.go）
+    Estimated bool
 }
 
 // UsageSummary 汇总整个执行过程的token用量
@@ -45,4 +94,23 @@ type UsageSummary struct {
     TotalPromptTokens     int `json:"total_prompt_tokens"`
     TotalCompletionTokens int `json:"total_completion_tokens"`
     TotalTokens           int `json:"total_tokens"`
+
+    // 各部分用量的来源：reported（均为模型上报）/ estimated（均为本地估算）/ mixed（两者皆有）；无用量时为空
+    SupervisorUsageSource string `json:"supervisor_usage_source,omitempty"`
+    SubAgentUsageSource   string `json:"subagent_usage_source,omitempty"`
+    TotalUsageSource      string `json:"total_usage_source,omitempty"`
+    // 其中由本地估算的 token 数
+    EstimatedTokens int `json:"estimated_tokens"`
+
+    // 费用汇总（见 pricing.go）：监督者路由、子代理与合计
+    SupervisorCost float64 `json:"supervisor_cost"`
+    SubAgentCost   float64 `json:"subagent_cost"`
+    TotalCost      float64 `json:"total_cost"`
+
+    // 路由决策来源统计：规则判定的节点无需监督者往返（即节省的路由 token）
+    RuleRoutedNodes int `json:"rule_routed_nodes"`
+    LLMRoutedNodes  int `json:"llm_routed_nodes"`
+
+    // 复用节点输出缓存（未调用子代理）的节点数
+    CachedNodes int `json:"cached_nodes"`
 }
\ No newline at end of file
@@ -0,0 +1,56 @@
+package graphproc
+
+import "multi-agent/internal/metrics"
+
+// SummarizeUsage 汇总各节点的路由阶段与子代理阶段 token 用量与费用（标记用量来源：上报 / 估算 / 混合），
+// 以及规则/监督者路由与命中缓存的节点数
+func SummarizeUsage(results map[string]NodeResult) UsageSummary {
+	var s UsageSummary
+	for _, r := range results {
+		s.SupervisorPromptTokens += r.RouterPromptTokens
+		s.SupervisorCompletionTokens += r.RouterCompletionTokens
+		s.SupervisorTotalTokens += r.RouterTotalTokens
+		s.SubAgentPromptTokens += r.PromptTokens
+		s.SubAgentCompletionTokens += r.CompletionTokens
+		s.SubAgentTotalTokens += r.TotalTokens
+		s.SupervisorCost += r.RouterCost
+		s.SubAgentCost += r.Cost
+		s.SupervisorUsageSource = mergeUsageSource(s.SupervisorUsageSource, r.RouterUsageSource)
+		s.SubAgentUsageSource = mergeUsageSource(s.SubAgentUsageSource, r.UsageSource)
+		if r.RouterUsageSource == UsageEstimated {
+			s.EstimatedTokens += r.RouterTotalTokens
+		}
+		if r.UsageSource == UsageEstimated {
+			s.EstimatedTokens += r.TotalTokens
+		}
+		switch r.Router {
+		case RouterRule:
+			s.RuleRoutedNodes++
+		case RouterLLM:
+			s.LLMRoutedNodes++
+		}
+		if r.Cached {
+			s.CachedNodes++
+		}
+	}
+	s.TotalPromptTokens = s.SupervisorPromptTokens + s.SubAgentPromptTokens
+	s.TotalCompletionTokens = s.SupervisorCompletionTokens + s.SubAgentCompletionTokens
+	s.TotalTokens = s.SupervisorTotalTokens + s.SubAgentTotalTokens
+	s.TotalUsageSource = mergeUsageSource(s.SupervisorUsageSource, s.SubAgentUsageSource)
+	s.SupervisorCost = RoundCost(s.SupervisorCost)
+	s.SubAgentCost = RoundCost(s.SubAgentCost)
+	s.TotalCost = RoundCost(s.SupervisorCost + s.SubAgentCost)
+	return s
+}
+
+// recordTokenMetrics 将一次模型调用的用量计入 token 指标（见 internal/metrics）；模型名未知时记为 unknown
+func recordTokenMetrics(stage, modelName string, u *TokenUsage) {
+	if u == nil {
+		return
+	}
+	if modelName == "" {
+		modelName = "unknown"
+	}
+	metrics.ModelTokens.Add(float64(u.PromptTokens), stage, modelName, metrics.TokenPrompt, u.Source())
+	metrics.ModelTokens.Add(float64(u.CompletionTokens), stage, modelName, metrics.TokenCompletion, u.Source())
+}
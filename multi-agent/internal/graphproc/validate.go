@@ -0,0 +1,259 @@
+package graphproc
+
+// 本文件负责执行前的图校验：
+// - 重复节点 ID、自环、指向不存在节点的边（悬空边）、环路（给出精确节点路径）视为错误；
+// - 因环路而永远无法执行的节点、孤立节点、多个汇点（无后继节点）、未知的边意图视为警告。
+// ProcessGraph 基于 Kahn 拓扑推进，环上的节点入度永远不会降为 0，因此必须在调用模型前拒绝此类图。
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"multi-agent/internal/orchestrator"
+)
+
+// 诊断严重级别
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// 诊断代码
+const (
+	DiagEmptyGraph      = "empty_graph"
+	DiagEmptyNodeID     = "empty_node_id"
+	DiagDuplicateNodeID = "duplicate_node_id"
+	DiagSelfLoop        = "self_loop"
+	DiagDanglingEdge    = "dangling_edge"
+	DiagCycle           = "cycle"
+	DiagUnreachable     = "unreachable_node"
+	DiagIsolated        = "isolated_node"
+	DiagMultipleSinks   = "multiple_sinks"
+	DiagUnknownIntent   = "unknown_edge_intent"
+)
+
+// Diagnostic 描述一条校验结果
+type Diagnostic struct {
+	Code     string                   `json:"code"`
+	Severity string                   `json:"severity"`
+	Message  string                   `json:"message"`
+	NodeIDs  []string                 `json:"node_ids,omitempty"`
+	Edge     *orchestrator.SimpleEdge `json:"edge,omitempty"`
+}
+
+// GraphValidation 汇总整张图的校验结果
+type GraphValidation struct {
+	Valid    bool         `json:"valid"`
+	Errors   []Diagnostic `json:"errors"`
+	Warnings []Diagnostic `json:"warnings"`
+}
+
+// Error 将所有错误拼接为一行，便于 CLI 与日志输出
+func (v GraphValidation) Error() string {
+	msgs := make([]string, 0, len(v.Errors))
+	for _, d := range v.Errors {
+		msgs = append(msgs, d.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (v *GraphValidation) add(d Diagnostic) {
+	if d.Severity == SeverityError {
+		v.Errors = append(v.Errors, d)
+	} else {
+		v.Warnings = append(v.Warnings, d)
+	}
+}
+
+// ValidateGraph 对最简代理图进行结构校验，返回结构化诊断。
+// 只要存在任一错误，Valid 即为 false；警告不影响执行。
+func ValidateGraph(sg orchestrator.SimpleGraph) GraphValidation {
+	v := GraphValidation{Errors: []Diagnostic{}, Warnings: []Diagnostic{}}
+
+	if len(sg.Nodes) == 0 {
+		v.add(Diagnostic{Code: DiagEmptyGraph, Severity: SeverityError, Message: "graph has no nodes"})
+		v.Valid = false
+		return v
+	}
+
+	// 1) 节点 ID：空 ID 与重复 ID
+	ids := make([]string, 0, len(sg.Nodes))
+	seen := make(map[string]int, len(sg.Nodes))
+	for i, n := range sg.Nodes {
+		if strings.TrimSpace(n.ID) == "" {
+			v.add(Diagnostic{Code: DiagEmptyNodeID, Severity: SeverityError, Message: fmt.Sprintf("node at index %d has empty id", i)})
+			continue
+		}
+		seen[n.ID]++
+		if seen[n.ID] == 1 {
+			ids = append(ids, n.ID)
+		}
+	}
+	for _, id := range ids {
+		if c := seen[id]; c > 1 {
+			v.add(Diagnostic{Code: DiagDuplicateNodeID, Severity: SeverityError, Message: fmt.Sprintf("node id %q appears %d times", id, c), NodeIDs: []string{id}})
+		}
+	}
+
+	// 2) 边：自环与悬空边；合法边构建邻接表（重复边只记一次）
+	adj := make(map[string][]string, len(ids))
+	indeg := make(map[string]int, len(ids))
+	outdeg := make(map[string]int, len(ids))
+	type edgeKey struct{ from, to string }
+	edgeSeen := make(map[edgeKey]struct{}, len(sg.Edges))
+	for _, e := range sg.Edges {
+		_, fromOK := seen[e.From]
+		_, toOK := seen[e.To]
+		if !fromOK || !toOK {
+			missing := make([]string, 0, 2)
+			if !fromOK {
+				missing = append(missing, e.From)
+			}
+			if !toOK && e.To != e.From {
+				missing = append(missing, e.To)
+			}
+			v.add(Diagnostic{Code: DiagDanglingEdge, Severity: SeverityError, Message: fmt.Sprintf("edge %s -> %s references unknown node(s) %v", e.From, e.To, missing), NodeIDs: missing, Edge: &e})
+			continue
+		}
+		if e.From == e.To {
+			v.add(Diagnostic{Code: DiagSelfLoop, Severity: SeverityError, Message: fmt.Sprintf("node %q has an edge to itself", e.From), NodeIDs: []string{e.From}, Edge: &e})
+			continue
+		}
+		if e.Intent != "" && !KnownEdgeIntent(e.Intent) {
+			v.add(Diagnostic{Code: DiagUnknownIntent, Severity: SeverityWarning, Message: fmt.Sprintf("edge %s -> %s has unknown intent %q; its output will be passed as plain predecessor output", e.From, e.To, e.Intent), NodeIDs: []string{e.From, e.To}, Edge: &e})
+		}
+		key := edgeKey{e.From, e.To}
+		if _, dup := edgeSeen[key]; dup {
+			continue
+		}
+		edgeSeen[key] = struct{}{}
+		adj[e.From] = append(adj[e.From], e.To)
+		indeg[e.To]++
+		outdeg[e.From]++
+	}
+
+	// 3) 环路：DFS 三色标记，遇到回边时沿栈截取精确路径
+	for _, c := range findCycles(ids, adj) {
+		v.add(Diagnostic{Code: DiagCycle, Severity: SeverityError, Message: "cycle detected: " + strings.Join(c, " -> "), NodeIDs: c})
+	}
+
+	// 4) 不可达节点：模拟 Kahn 推进，入度始终无法归零的节点永远不会执行
+	//    （环上的节点已在上面报错，这里只报告被环阻塞的下游节点）
+	onCycle := make(map[string]struct{})
+	for _, d := range v.Errors {
+		if d.Code == DiagCycle {
+			for _, id := range d.NodeIDs {
+				onCycle[id] = struct{}{}
+			}
+		}
+	}
+	for _, id := range unreachableNodes(ids, adj, indeg) {
+		if _, ok := onCycle[id]; ok {
+			continue
+		}
+		v.add(Diagnostic{Code: DiagUnreachable, Severity: SeverityWarning, Message: fmt.Sprintf("node %q is blocked by a cycle and will never execute", id), NodeIDs: []string{id}})
+	}
+
+	// 5) 孤立节点与多汇点
+	var sinks []string
+	for _, id := range ids {
+		if indeg[id] == 0 && outdeg[id] == 0 && len(ids) > 1 {
+			v.add(Diagnostic{Code: DiagIsolated, Severity: SeverityWarning, Message: fmt.Sprintf("node %q has no incoming or outgoing edges", id), NodeIDs: []string{id}})
+		}
+		if outdeg[id] == 0 {
+			sinks = append(sinks, id)
+		}
+	}
+	if len(sinks) > 1 {
+		v.add(Diagnostic{Code: DiagMultipleSinks, Severity: SeverityWarning, Message: fmt.Sprintf("graph has %d sink nodes %v; each will produce a final summary", len(sinks), sinks), NodeIDs: sinks})
+	}
+
+	v.Valid = len(v.Errors) == 0
+	return v
+}
+
+// findCycles 返回图中的环路，每条环以起点结尾闭合（a -> b -> a）。
+// 同一组节点构成的环只报告一次。
+func findCycles(ids []string, adj map[string][]string) [][]string {
+	const (
+		white = iota
+		grey
+		black
+	)
+	color := make(map[string]int, len(ids))
+	var stack []string
+	var cycles [][]string
+	reported := make(map[string]struct{})
+
+	var visit func(id string)
+	visit = func(id string) {
+		color[id] = grey
+		stack = append(stack, id)
+		for _, nb := range adj[id] {
+			switch color[nb] {
+			case white:
+				visit(nb)
+			case grey:
+				// 回边：从栈中 nb 的位置截取到栈顶即为环
+				start := len(stack) - 1
+				for start >= 0 && stack[start] != nb {
+					start--
+				}
+				path := append([]string{}, stack[start:]...)
+				key := cycleKey(path)
+				if _, ok := reported[key]; ok {
+					continue
+				}
+				reported[key] = struct{}{}
+				cycles = append(cycles, append(path, nb))
+			}
+		}
+		stack = stack[:len(stack)-1]
+		color[id] = black
+	}
+
+	for _, id := range ids {
+		if color[id] == white {
+			visit(id)
+		}
+	}
+	return cycles
+}
+
+func cycleKey(path []string) string {
+	sorted := append([]string{}, path...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, "\x00")
+}
+
+// unreachableNodes 模拟 Kahn 推进，返回入度始终无法降为 0 的节点（按输入顺序）
+func unreachableNodes(ids []string, adj map[string][]string, indeg map[string]int) []string {
+	remaining := make(map[string]int, len(ids))
+	queue := make([]string, 0, len(ids))
+	for _, id := range ids {
+		remaining[id] = indeg[id]
+		if indeg[id] == 0 {
+			queue = append(queue, id)
+		}
+	}
+	visited := make(map[string]struct{}, len(ids))
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		visited[id] = struct{}{}
+		for _, nb := range adj[id] {
+			remaining[nb]--
+			if remaining[nb] == 0 {
+				queue = append(queue, nb)
+			}
+		}
+	}
+	var out []string
+	for _, id := range ids {
+		if _, ok := visited[id]; !ok {
+			out = append(out, id)
+		}
+	}
+	return out
+}
@@ -0,0 +1,178 @@
+package graphproc
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"multi-agent/internal/orchestrator"
+)
+
+// testGraph 按节点 id 与 "from->to" 形式的边构造图，每个节点带一段文本负载
+func testGraph(ids []string, edges ...string) orchestrator.SimpleGraph {
+	var sg orchestrator.SimpleGraph
+	for _, id := range ids {
+		payload, _ := json.Marshal(map[string]string{"text": "content of " + id})
+		sg.Nodes = append(sg.Nodes, orchestrator.SimpleNode{ID: id, Payload: payload})
+	}
+	for _, e := range edges {
+		from, to, _ := strings.Cut(e, "->")
+		sg.Edges = append(sg.Edges, orchestrator.SimpleEdge{From: from, To: to})
+	}
+	return sg
+}
+
+// diagLines 将诊断格式化为 "<code>: <message>"，便于整体比较
+func diagLines(ds []Diagnostic) []string {
+	out := make([]string, 0, len(ds))
+	for _, d := range ds {
+		out = append(out, d.Code+": "+d.Message)
+	}
+	return out
+}
+
+func TestValidateGraph(t *testing.T) {
+	tests := []struct {
+		name     string
+		graph    orchestrator.SimpleGraph
+		errors   []string
+		warnings []string
+	}{
+		{
+			name:  "valid chain",
+			graph: testGraph([]string{"a", "b", "c"}, "a->b", "b->c"),
+		},
+		{
+			name:  "diamond with repeated edge",
+			graph: testGraph([]string{"a", "b", "c", "d"}, "a->b", "a->c", "b->d", "c->d", "a->b"),
+		},
+		{
+			name:   "empty graph",
+			graph:  orchestrator.SimpleGraph{},
+			errors: []string{`empty_graph: graph has no nodes`},
+		},
+		{
+			name:   "duplicate ids",
+			graph:  testGraph([]string{"a", "b", "a", "c", "a", "c"}, "a->b", "b->c"),
+			errors: []string{`duplicate_node_id: node id "a" appears 3 times`, `duplicate_node_id: node id "c" appears 2 times`},
+		},
+		{
+			name:   "empty id",
+			graph:  testGraph([]string{"a", " "}),
+			errors: []string{`empty_node_id: node at index 1 has empty id`},
+		},
+		{
+			name:  "dangling edges report only missing ids",
+			graph: testGraph([]string{"a", "b"}, "a->b", "a->x", "y->b", "p->q", "z->z"),
+			errors: []string{
+				`dangling_edge: edge a -> x references unknown node(s) [x]`,
+				`dangling_edge: edge y -> b references unknown node(s) [y]`,
+				`dangling_edge: edge p -> q references unknown node(s) [p q]`,
+				`dangling_edge: edge z -> z references unknown node(s) [z]`,
+			},
+		},
+		{
+			name:   "self loop",
+			graph:  testGraph([]string{"a", "b"}, "a->b", "b->b"),
+			errors: []string{`self_loop: node "b" has an edge to itself`},
+		},
+		{
+			name:   "cycle blocks downstream",
+			graph:  testGraph([]string{"s", "a", "b", "c", "d", "e"}, "s->a", "a->b", "b->c", "c->a", "c->d", "d->e"),
+			errors: []string{`cycle: cycle detected: a -> b -> c -> a`},
+			warnings: []string{
+				`unreachable_node: node "d" is blocked by a cycle and will never execute`,
+				`unreachable_node: node "e" is blocked by a cycle and will never execute`,
+			},
+		},
+		{
+			name:     "two cycles",
+			graph:    testGraph([]string{"a", "b", "c", "d", "e"}, "a->b", "b->a", "c->d", "d->c", "d->e"),
+			errors:   []string{`cycle: cycle detected: a -> b -> a`, `cycle: cycle detected: c -> d -> c`},
+			warnings: []string{`unreachable_node: node "e" is blocked by a cycle and will never execute`},
+		},
+		{
+			name:  "isolated node and multiple sinks",
+			graph: testGraph([]string{"a", "b", "c"}, "a->b"),
+			warnings: []string{
+				`isolated_node: node "c" has no incoming or outgoing edges`,
+				`multiple_sinks: graph has 2 sink nodes [b c]; each will produce a final summary`,
+			},
+		},
+		{
+			name: "unknown intent",
+			graph: func() orchestrator.SimpleGraph {
+				sg := testGraph([]string{"a", "b"}, "a->b")
+				sg.Edges[0].Intent = "summon"
+				return sg
+			}(),
+			warnings: []string{`unknown_edge_intent: edge a -> b has unknown intent "summon"; its output will be passed as plain predecessor output`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := ValidateGraph(tt.graph)
+			if got := diagLines(v.Errors); !slices.Equal(got, tt.errors) && len(got)+len(tt.errors) > 0 {
+				t.Errorf("errors:\n got %q\nwant %q", got, tt.errors)
+			}
+			if got := diagLines(v.Warnings); !slices.Equal(got, tt.warnings) && len(got)+len(tt.warnings) > 0 {
+				t.Errorf("warnings:\n got %q\nwant %q", got, tt.warnings)
+			}
+			if v.Valid != (len(tt.errors) == 0) {
+				t.Errorf("Valid = %v with errors %q", v.Valid, tt.errors)
+			}
+		})
+	}
+}
+
+func TestValidateGraphDiagnosticNodes(t *testing.T) {
+	v := ValidateGraph(testGraph([]string{"a", "b", "c"}, "a->b", "b->c", "c->b", "a->ghost"))
+	for _, d := range v.Errors {
+		switch d.Code {
+		case DiagCycle:
+			if !slices.Equal(d.NodeIDs, []string{"b", "c", "b"}) {
+				t.Errorf("cycle node ids = %v, want [b c b]", d.NodeIDs)
+			}
+		case DiagDanglingEdge:
+			if !slices.Equal(d.NodeIDs, []string{"ghost"}) || d.Edge == nil || d.Edge.From != "a" || d.Edge.To != "ghost" {
+				t.Errorf("dangling diag = %+v", d)
+			}
+		default:
+			t.Errorf("unexpected error %s", d.Message)
+		}
+	}
+	if len(v.Errors) != 2 {
+		t.Errorf("errors = %q, want dangling edge and cycle", diagLines(v.Errors))
+	}
+	if got := v.Error(); got != "edge a -> ghost references unknown node(s) [ghost]; cycle detected: b -> c -> b" {
+		t.Errorf("Error() = %q", got)
+	}
+}
+
+func TestFindCycles(t *testing.T) {
+	tests := []struct {
+		name  string
+		ids   []string
+		edges map[string][]string
+		want  []string
+	}{
+		{name: "acyclic", ids: []string{"a", "b", "c"}, edges: map[string][]string{"a": {"b", "c"}, "b": {"c"}}},
+		{name: "two nodes", ids: []string{"a", "b"}, edges: map[string][]string{"a": {"b"}, "b": {"a"}}, want: []string{"a -> b -> a"}},
+		{name: "entered mid cycle", ids: []string{"x", "b", "c", "a"}, edges: map[string][]string{"x": {"b"}, "a": {"b"}, "b": {"c"}, "c": {"a"}}, want: []string{"b -> c -> a -> b"}},
+		{name: "shared node", ids: []string{"a", "b", "c"}, edges: map[string][]string{"a": {"b", "c"}, "b": {"a"}, "c": {"a"}}, want: []string{"a -> b -> a", "a -> c -> a"}},
+		{name: "nested", ids: []string{"a", "b", "c"}, edges: map[string][]string{"a": {"b"}, "b": {"c", "a"}, "c": {"a"}}, want: []string{"a -> b -> c -> a", "a -> b -> a"}},
+		{name: "disjoint", ids: []string{"a", "b", "c", "d"}, edges: map[string][]string{"a": {"b"}, "b": {"a"}, "c": {"d"}, "d": {"c"}}, want: []string{"a -> b -> a", "c -> d -> c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, c := range findCycles(tt.ids, tt.edges) {
+				got = append(got, strings.Join(c, " -> "))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("findCycles = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
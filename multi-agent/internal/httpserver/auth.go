@@ -0,0 +1,84 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"multi-agent/internal/auth"
+	"multi-agent/internal/imagestore"
+)
+
+// publicRoutes 无需认证的路由：健康检查与 Prometheus 指标（不含用户信息）
+var publicRoutes = map[string]bool{
+	"GET /ping":    true,
+	"GET /metrics": true,
+}
+
+// imageContentRoute 图片内容路由：带有效签名（见 imagestore.URLSigner）时无需凭据，
+// 供浏览器 <img> 与 url 模式下的模型服务按签名链接下载；否则按凭据认证并检查图片归属
+const imageContentRoute = "GET /api/images/:id"
+
+// signedRequestKey gin 上下文中标记请求已通过图片签名校验
+const signedRequestKey = "signed_image_request"
+
+// authMiddleware 认证请求并将身份写入请求上下文（图执行与视觉代理据此检查资源归属）；失败时返回 401
+func authMiddleware(svc *auth.Service, signer *imagestore.URLSigner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.Request.Method + " " + c.FullPath()
+		if publicRoutes[route] {
+			c.Next()
+			return
+		}
+		// 签名无效或过期时按普通请求认证（匿名模式下仍可访问）
+		if route == imageContentRoute && c.Query(imagestore.SignatureParam) != "" {
+			if signer.Verify(c.Param("id"), c.Query(imagestore.ExpiresParam), c.Query(imagestore.SignatureParam)) == nil {
+				c.Set(signedRequestKey, true)
+				c.Next()
+				return
+			}
+		}
+		u, err := svc.Authenticate(c.Request)
+		if err != nil {
+			msg := "invalid credentials"
+			if errors.Is(err, auth.ErrNoCredentials) {
+				msg = "authentication required"
+			}
+			c.Header("WWW-Authenticate", `Bearer realm="multi-agent"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": msg, "code": "unauthorized"})
+			return
+		}
+		c.Request = c.Request.WithContext(auth.WithUser(c.Request.Context(), u))
+		c.Next()
+	}
+}
+
+// signedRequest 请求是否通过了图片签名校验（此时没有身份）
+func signedRequest(c *gin.Context) bool {
+	return c.GetBool(signedRequestKey)
+}
+
+// currentUser 返回请求的身份；公开路由与签名请求上为 nil
+func currentUser(c *gin.Context) *auth.User {
+	return auth.FromContext(c.Request.Context())
+}
+
+// requireAdmin 非管理员时返回 403 并返回 false
+func requireAdmin(c *gin.Context) bool {
+	if currentUser(c).IsAdmin() {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "admin role required", "code": "forbidden"})
+	return false
+}
+
+// ownerScope 列表接口的所有者过滤：管理员可按 ?owner 查看任意用户（为空表示全部），其它用户只能看到自己的资源
+func ownerScope(c *gin.Context) string {
+	if u := currentUser(c); u.IsAdmin() {
+		return c.Query("owner")
+	} else if u != nil {
+		return u.ID
+	}
+	return ""
+}
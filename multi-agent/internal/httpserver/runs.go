@@ -0,0 +1,112 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"multi-agent/internal/auth"
+	"multi-agent/internal/graphproc"
+	"multi-agent/internal/runstore"
+)
+
+// runRegistry 记录正在执行的图（run ID -> 取消函数与发起用户），供 /api/runs/:id/cancel 显式取消
+type runRegistry struct {
+	mu      sync.Mutex
+	cancels map[string]runningRun
+}
+
+type runningRun struct {
+	cancel context.CancelFunc
+	owner  string
+}
+
+func newRunRegistry() *runRegistry {
+	return &runRegistry{cancels: make(map[string]runningRun)}
+}
+
+// register 登记一次执行；同一 run ID 正在执行时返回 false
+func (r *runRegistry) register(runID, owner string, cancel context.CancelFunc) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.cancels[runID]; ok {
+		return false
+	}
+	r.cancels[runID] = runningRun{cancel: cancel, owner: owner}
+	return true
+}
+
+// unregister 执行结束后移除登记
+func (r *runRegistry) unregister(runID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cancels, runID)
+}
+
+// running 判断 run 是否正在执行，并返回发起用户
+func (r *runRegistry) running(runID string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	run, ok := r.cancels[runID]
+	return run.owner, ok
+}
+
+// cancel 取消指定 run；run 不存在（未开始或已结束）或 u 无权访问时返回 false
+func (r *runRegistry) cancel(runID string, u *auth.User) bool {
+	r.mu.Lock()
+	run, ok := r.cancels[runID]
+	r.mu.Unlock()
+	if !ok || !u.CanAccess(run.owner) {
+		return false
+	}
+	run.cancel()
+	return true
+}
+
+// parseRunFilter 解析 GET /api/runs 的查询参数
+func parseRunFilter(c *gin.Context) (runstore.Filter, error) {
+	var f runstore.Filter
+	f.Status = strings.ToLower(strings.TrimSpace(c.Query("status")))
+	switch f.Status {
+	case "", graphproc.RunStatusOK, graphproc.RunStatusFailed, graphproc.RunStatusCancelled, graphproc.RunStatusBudgetExceeded:
+	default:
+		return f, fmt.Errorf("unknown status %q (want %s, %s, %s or %s)", f.Status, graphproc.RunStatusOK, graphproc.RunStatusFailed, graphproc.RunStatusCancelled, graphproc.RunStatusBudgetExceeded)
+	}
+	f.Source = strings.ToLower(strings.TrimSpace(c.Query("source")))
+	switch f.Source {
+	case "", runstore.SourceAPI, runstore.SourceCLI:
+	default:
+		return f, fmt.Errorf("unknown source %q (want %s or %s)", f.Source, runstore.SourceAPI, runstore.SourceCLI)
+	}
+	f.ParentID = strings.TrimSpace(c.Query("parent_id"))
+	for _, p := range []struct {
+		key string
+		dst *time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		if v := strings.TrimSpace(c.Query(p.key)); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return f, fmt.Errorf("invalid %s %q (want RFC3339)", p.key, v)
+			}
+			*p.dst = t
+		}
+	}
+	for _, p := range []struct {
+		key string
+		dst *int
+	}{{"limit", &f.Limit}, {"offset", &f.Offset}} {
+		if v := strings.TrimSpace(c.Query(p.key)); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return f, fmt.Errorf("invalid %s %q (want a non-negative integer)", p.key, v)
+			}
+			*p.dst = n
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,54 @@
+package httpserver
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"multi-agent/internal/runstore"
+)
+
+func TestParseRunFilter(t *testing.T) {
+	since := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name   string
+		query  string
+		want   runstore.Filter
+		errSub string
+	}{
+		{name: "empty", query: ""},
+		{name: "status and source normalized", query: "status=%20FAILED&source=Cli", want: runstore.Filter{Status: "failed", Source: "cli"}},
+		{name: "budget exceeded", query: "status=budget_exceeded", want: runstore.Filter{Status: "budget_exceeded"}},
+		{name: "parent", query: "parent_id=r1", want: runstore.Filter{ParentID: "r1"}},
+		{name: "time window", query: "since=2026-01-02T03:04:05Z&until=2026-01-02T05:04:05%2B02:00", want: runstore.Filter{Since: since, Until: since}},
+		{name: "paging", query: "limit=5&offset=10", want: runstore.Filter{Limit: 5, Offset: 10}},
+		{name: "unknown status", query: "status=done", errSub: `unknown status "done"`},
+		{name: "unknown source", query: "source=cron", errSub: `unknown source "cron"`},
+		{name: "bad since", query: "since=2026-01-02", errSub: "invalid since"},
+		{name: "negative limit", query: "limit=-1", errSub: "invalid limit"},
+		{name: "non-numeric offset", query: "offset=x", errSub: "invalid offset"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/api/runs?"+tt.query, nil)
+			got, err := parseRunFilter(c)
+			if tt.errSub != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errSub) {
+					t.Fatalf("err = %v, want %q", err, tt.errSub)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRunFilter: %v", err)
+			}
+			if got.Status != tt.want.Status || got.Source != tt.want.Source || got.ParentID != tt.want.ParentID ||
+				!got.Since.Equal(tt.want.Since) || !got.Until.Equal(tt.want.Until) || got.Limit != tt.want.Limit || got.Offset != tt.want.Offset {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,21 +3,27 @@ package httpserver
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"mime"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
-	"github.com/joho/godotenv"
 
+	"multi-agent/internal/auth"
+	"multi-agent/internal/config"
 	"multi-agent/internal/graphproc"
+	"multi-agent/internal/imagestore"
+	"multi-agent/internal/logs"
+	"multi-agent/internal/metrics"
 	"multi-agent/internal/orchestrator"
+	"multi-agent/internal/quota"
+	"multi-agent/internal/runstore"
 )
 
 // writerFunc 让函数适配 io.Writer
@@ -25,108 +31,194 @@ type writerFunc func(p []byte) (int, error)
 
 func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
 
+// SSE 输出格式
 const (
-	uploadDir  = "uploads"
-	serverPort = 8080
+	streamFormatEvents = "events"
+	streamFormatLegacy = "legacy"
 )
 
-func ensureDir(path string) error {
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return os.MkdirAll(path, 0o755)
+// parseStreamFormat 校验并规范化 stream_format；空字符串视为 events
+func parseStreamFormat(s string) (string, error) {
+	switch f := strings.ToLower(strings.TrimSpace(s)); f {
+	case "":
+		return streamFormatEvents, nil
+	case streamFormatEvents, streamFormatLegacy:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown stream_format %q (want %s or %s)", s, streamFormatEvents, streamFormatLegacy)
 	}
-	return nil
 }
 
-func findFileByID(id string) (string, error) {
-	entries, err := os.ReadDir(uploadDir)
-	if err != nil {
-		return "", err
-	}
-	for _, e := range entries {
-		name := e.Name()
-		if strings.HasPrefix(name, id+".") || name == id {
-			return filepath.Join(uploadDir, name), nil
-		}
-	}
-	return "", os.ErrNotExist
+// execRequest 一次图执行的输出方式，以及 rerun 时与原始 run 的关联
+type execRequest struct {
+	Stream       bool
+	StreamFormat string
+	Verbose      bool
+	NoCache      bool
+	ParentID     string
+	Rerun        *runstore.RerunSpec
 }
 
-// deleteByID deletes file for given id if exists
-func deleteByID(id string) error {
-	path, err := findFileByID(id)
-	if err != nil {
-		return err
+// response 为非流响应补充 rerun 关联信息
+func (e execRequest) response(h gin.H) gin.H {
+	if e.ParentID != "" {
+		h["parent_id"] = e.ParentID
+		h["rerun"] = e.Rerun
 	}
-	return os.Remove(path)
+	return h
 }
 
-func contentTypeFromExt(ext string) string {
-	if ext == "" {
-		return "application/octet-stream"
+// imageError 将存储错误映射为 HTTP 状态：不存在或 ID 非法时 404，其它为 500
+func imageError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, imagestore.ErrNotFound), errors.Is(err, imagestore.ErrInvalidID):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	default:
+		logs.Errorf("[images] %s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "image store error"})
 	}
-	ct := mime.TypeByExtension(ext)
-	if ct == "" {
-		return "application/octet-stream"
-	}
-	return ct
 }
 
-// chooseFileExt chooses a file extension based on content-type header or URL path.
-// It strips parameters from content-type (e.g., "; charset=binary").
-func chooseFileExt(sourceURL, headerContentType string) string {
-	ct := headerContentType
-	if i := strings.Index(ct, ";"); i >= 0 {
-		ct = strings.TrimSpace(ct[:i])
+// publicBaseURL 返回对外访问地址（见 config.Config.BaseURL）
+func publicBaseURL(cfg config.Config, c *gin.Context) string {
+	return cfg.BaseURL(c.Request)
+}
+
+// imageURL 返回图片的绝对访问地址
+func imageURL(cfg config.Config, c *gin.Context, id string) string {
+	return publicBaseURL(cfg, c) + "/api/images/" + id
+}
+
+// Server 注册了所有路由的 Gin 引擎；关闭时须调用 Close 写回未持久化的状态
+type Server struct {
+	*gin.Engine
+	usage *quota.Store
+}
+
+// Close 写回尚未持久化的用户用量（见 quota.Store.Close）；应在 HTTP 服务停止后调用
+func (s *Server) Close() error {
+	return s.usage.Close()
+}
+
+// NewServer 构建 Gin 引擎并注册所有路由（图片服务 + 图执行/总结）；cfg 由 config.Load 加载（已读取 .env）
+func NewServer(cfg config.Config) *Server {
+	// 图片存储：images.store=local（默认，upload_dir）或 s3；与视觉代理的 get_image / inline 图片共享
+	images, err := imagestore.New(cfg.ImageStoreOptions())
+	if err != nil {
+		panic(err)
 	}
-	// Prefer extension from content-type if available
-	if ct != "" {
-		if exts, _ := mime.ExtensionsByType(ct); len(exts) > 0 {
-			return strings.ToLower(exts[0])
-		}
+	initUploadStorageMetric(images)
+	// 视觉节点与 get_image 的图片读取器：字节与边长上限、图片传递方式（url / inline）
+	imageLoader, err := graphproc.NewImageLoader(images, cfg.Images.MaxBytes, cfg.Images.MaxSide, cfg.Images.VisionMode)
+	if err != nil {
+		panic(err)
 	}
-	// Fall back to URL path extension
-	if sourceURL != "" {
-		if uExt := strings.ToLower(filepath.Ext(sourceURL)); uExt != "" {
-			return uExt
-		}
+	// 上传与 URL 导入限制：大小上限、类型白名单、导入的协议/地址/重定向/超时限制
+	uploads, err := newUploadPolicy(cfg.Upload)
+	if err != nil {
+		panic(err)
 	}
-	// Final fallback
-	return ".bin"
-}
 
-// NewServer 构建 Gin 引擎并注册所有路由（图片服务 + 图执行/总结）
-func NewServer() *gin.Engine {
-	if err := ensureDir(uploadDir); err != nil {
+	// 子代理注册表：内置定义 + AGENT_REGISTRY_FILE 中的自定义代理（节点类型 → 代理），模型按 model.* 配置创建
+	agents, err := graphproc.LoadAgentRegistry(cfg.ModelOptions())
+	if err != nil {
 		panic(err)
 	}
-
-	// 尝试加载 .env（若不存在则忽略）
-	_ = godotenv.Load("./.env")
+	// 模型价格表（pricing_file）：节点费用按执行时的单价计算并写入结果与运行历史
+	pricing, err := graphproc.LoadPricingFile(cfg.PricingFile)
+	if err != nil {
+		panic(err)
+	}
+	// 运行历史：每次执行结束后写入 runs_dir（默认 <data_dir>/runs）
+	store, err := runstore.Open(cfg.RunsDir)
+	if err != nil {
+		panic(err)
+	}
+	// 用户 token 用量与日/月额度：持久化到 usage_file（默认 <data_dir>/usage.json）
+	usage, err := quota.Open(cfg.UsageFile, quota.Limits{DailyTokens: cfg.Quota.DailyTokens, MonthlyTokens: cfg.Quota.MonthlyTokens})
+	if err != nil {
+		panic(err)
+	}
+	// 用量在内存中累计，定期写回文件
+	usage.StartFlusher(quota.DefaultFlushInterval)
+	// 认证：API Key / JWT（见 internal/auth）；未配置时为匿名模式，所有请求视为管理员
+	authSvc, err := auth.New(auth.Options{
+		APIKeys:          cfg.Auth.APIKeys,
+		APIKeysFile:      cfg.Auth.APIKeysFile,
+		JWTSecret:        cfg.Auth.JWTSecret,
+		JWTPublicKeyFile: cfg.Auth.JWTPublicKeyFile,
+		JWTIssuer:        cfg.Auth.JWTIssuer,
+		JWTAudience:      cfg.Auth.JWTAudience,
+	})
+	if err != nil {
+		panic(err)
+	}
+	if !authSvc.Enabled() {
+		logs.Infof("[auth] no API keys or JWT configured: all requests are treated as the anonymous admin")
+	}
+	// 图片签名链接：启用认证时，上传/导入响应与 url 模式下发给模型的图片链接带短期签名，无需凭据即可下载
+	signer := cfg.ImageURLSigner()
+	if authSvc.Enabled() {
+		imageLoader.Signer = signer
+	}
+	// 节点输出缓存：跨请求共享，重新执行时复用未变化节点的输出（上限 NODE_CACHE_SIZE）
+	cache := graphproc.LoadOutputCache()
 
 	r := gin.Default()
-	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:5173", "http://127.0.0.1:5173"},
+	corsConfig := cors.Config{
+		AllowOrigins:     cfg.CORSOrigins,
 		AllowMethods:     []string{"GET", "POST", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"*"},
-		ExposeHeaders:    []string{"Content-Type"},
+		ExposeHeaders:    []string{"Content-Type", "X-Run-ID"},
 		AllowCredentials: true,
-	}))
+	}
+	// cors_origins 包含 "*" 时允许任意来源；浏览器不接受携带凭据的通配来源
+	if cfg.AllowAllOrigins() {
+		corsConfig.AllowOrigins = nil
+		corsConfig.AllowAllOrigins = true
+		corsConfig.AllowCredentials = false
+	}
+	r.Use(cors.New(corsConfig))
+	r.Use(authMiddleware(authSvc, signer))
+
+	// 正在执行的图，供显式取消
+	runs := newRunRegistry()
 
 	// Health
 	r.GET("/ping", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "pong"})
 	})
 
-	// ===== 图片资源路由 =====
-	r.POST("/api/images", func(c *gin.Context) {
-		prevId := strings.TrimSpace(c.PostForm("prevId"))
-		if prevId != "" {
-			_ = deleteByID(prevId)
+	// Prometheus 指标（文本暴露格式，见 internal/metrics）
+	r.GET("/metrics", gin.WrapH(metrics.Default.Handler()))
+
+	// 当前请求的身份与已启用的认证方式
+	r.GET("/api/me", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user": currentUser(c), "auth_methods": authSvc.Methods()})
+	})
+
+	// imageResponse 上传与导入的响应：url 为稳定链接（可写入图负载）；启用认证时附带短期签名链接，供 <img> 无凭据显示
+	imageResponse := func(c *gin.Context, meta imagestore.Meta) gin.H {
+		link := imageURL(cfg, c, meta.ID)
+		out := gin.H{"id": meta.ID, "url": link, "image": meta}
+		if imageLoader.Signer != nil {
+			if signed, exp, err := imageLoader.Signer.SignURL(link, meta.ID); err == nil {
+				out["signed_url"], out["signed_url_expires_at"] = signed, exp
+			}
 		}
+		return out
+	}
 
+	// ===== 图片资源路由（存储后端见 imagestore，由 images.store 选择） =====
+	r.POST("/api/images", func(c *gin.Context) {
+		uploads.limitUploadBody(c)
 		fileHeader, err := c.FormFile("file")
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "file field is required"})
+			writeUploadError(c, uploads.formFileError(err))
+			return
+		}
+		if fileHeader.Size > uploads.MaxBytes {
+			writeUploadError(c, rejectUpload(http.StatusRequestEntityTooLarge, codeFileTooLarge, "file too large (%d bytes, max %d)", fileHeader.Size, uploads.MaxBytes))
 			return
 		}
 		src, err := fileHeader.Open()
@@ -136,25 +228,24 @@ func NewServer() *gin.Engine {
 		}
 		defer src.Close()
 
-		id := uuid.New().String()
-		ext := strings.ToLower(filepath.Ext(fileHeader.Filename))
-		if ext == "" {
-			ext = ".bin"
-		}
-		dstPath := filepath.Join(uploadDir, id+ext)
-		dst, err := os.Create(dstPath)
+		// 类型按内容嗅探，忽略客户端文件名
+		img, err := uploads.sniffImage(src)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot create file"})
+			writeUploadError(c, err)
 			return
 		}
-		defer dst.Close()
-
-		if _, err := io.Copy(dst, src); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot save file"})
+		meta, err := images.Put(c.Request.Context(), imagestore.PutInput{ContentType: img.ContentType, Ext: img.Ext, Owner: currentUser(c).ID}, img.Body)
+		if err != nil {
+			logs.Errorf("[images] save upload: %v", err)
+			writeUploadError(c, err)
 			return
 		}
-		url := fmt.Sprintf("http://localhost:%d/api/images/%s", serverPort, id)
-		c.JSON(http.StatusOK, gin.H{"id": id, "url": url})
+		metrics.UploadStorageBytes.Add(float64(meta.Size))
+		// 新图片保存成功后再删除被替换的旧图片（仅限自己的图片）
+		if prevId := strings.TrimSpace(c.PostForm("prevId")); prevId != "" && prevId != meta.ID {
+			deleteOwnedImage(c, images, prevId)
+		}
+		c.JSON(http.StatusOK, imageResponse(c, meta))
 	})
 
 	r.POST("/api/images/url", func(c *gin.Context) {
@@ -163,116 +254,200 @@ func NewServer() *gin.Engine {
 			PrevID string `json:"prevId"`
 		}
 		if err := c.BindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+			writeUploadError(c, rejectUpload(http.StatusBadRequest, codeInvalidRequest, "invalid json"))
 			return
 		}
 		srcURL := strings.TrimSpace(req.URL)
 		if srcURL == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "url is required"})
+			writeUploadError(c, rejectUpload(http.StatusBadRequest, codeInvalidURL, "url is required"))
 			return
 		}
-		if pid := strings.TrimSpace(req.PrevID); pid != "" {
-			_ = deleteByID(pid)
-		}
-		resp, err := http.Get(srcURL)
+		resp, err := uploads.fetchImage(c.Request.Context(), srcURL)
 		if err != nil {
-			c.JSON(http.StatusBadGateway, gin.H{"error": "fetch failed"})
+			logs.Infof("[images] import %s rejected: %v", srcURL, err)
+			writeUploadError(c, err)
 			return
 		}
 		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("upstream status %d", resp.StatusCode)})
+
+		// 类型按内容嗅探，忽略上游 Content-Type 与 URL 扩展名
+		img, err := uploads.sniffImage(resp.Body)
+		if err != nil {
+			writeUploadError(c, err)
 			return
 		}
-
-		ext := chooseFileExt(srcURL, resp.Header.Get("Content-Type"))
-		id := uuid.New().String()
-		dstPath := filepath.Join(uploadDir, id+ext)
-		dst, err := os.Create(dstPath)
+		meta, err := images.Put(c.Request.Context(), imagestore.PutInput{ContentType: img.ContentType, Ext: img.Ext, Owner: currentUser(c).ID}, img.Body)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot create file"})
+			logs.Errorf("[images] save %s: %v", srcURL, err)
+			writeUploadError(c, err)
 			return
 		}
-		defer dst.Close()
-		if _, err := io.Copy(dst, resp.Body); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot save file"})
+		metrics.UploadStorageBytes.Add(float64(meta.Size))
+		if pid := strings.TrimSpace(req.PrevID); pid != "" && pid != meta.ID {
+			deleteOwnedImage(c, images, pid)
+		}
+		c.JSON(http.StatusOK, imageResponse(c, meta))
+	})
+
+	// 图片列表（按创建时间倒序）：普通用户仅列出自己的图片，管理员可按 owner 过滤；支持 limit
+	r.GET("/api/images", func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		list, err := images.List(c.Request.Context(), imagestore.ListFilter{Owner: ownerScope(c), Limit: limit})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		url := fmt.Sprintf("http://localhost:%d/api/images/%s", serverPort, id)
-		c.JSON(http.StatusOK, gin.H{"id": id, "url": url})
+		c.JSON(http.StatusOK, gin.H{"backend": images.Backend(), "images": list})
 	})
 
+	// 图片内容：所有者与管理员，或持有有效签名链接的请求
 	r.GET("/api/images/:id", func(c *gin.Context) {
-		id := c.Param("id")
-		path, err := findFileByID(id)
+		rc, meta, err := images.Get(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			imageError(c, err)
+			return
+		}
+		defer rc.Close()
+		if !signedRequest(c) && !currentUser(c).CanAccess(meta.Owner) {
+			imageError(c, imagestore.ErrNotFound)
+			return
+		}
+		if meta.SHA256 != "" {
+			c.Header("ETag", `"`+meta.SHA256+`"`)
+		}
+		c.DataFromReader(http.StatusOK, meta.Size, meta.ContentType, rc, nil)
+	})
+
+	// 图片元数据（内容类型、大小、SHA-256、创建时间、所有者）
+	r.GET("/api/images/:id/meta", func(c *gin.Context) {
+		meta, err := ownedImage(c, images, c.Param("id"))
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			imageError(c, err)
 			return
 		}
-		ext := strings.ToLower(filepath.Ext(path))
-		c.Header("Content-Type", contentTypeFromExt(ext))
-		c.File(path)
+		c.JSON(http.StatusOK, meta)
 	})
 
+	// 删除图片：仅所有者与管理员
 	r.DELETE("/api/images/:id", func(c *gin.Context) {
-		id := c.Param("id")
-		path, err := findFileByID(id)
+		meta, err := ownedImage(c, images, c.Param("id"))
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			imageError(c, err)
 			return
 		}
-		if err := os.Remove(path); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot delete"})
+		if err := images.Delete(c.Request.Context(), c.Param("id")); err != nil {
+			imageError(c, err)
 			return
 		}
+		metrics.UploadStorageBytes.Add(-float64(meta.Size))
 		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
 	})
 
-	// ===== 图执行与总结路由 =====
-	// 执行最简代理图：支持两种模式
-	// - 非流模式（默认）：仅返回最终 JSON，包含 results，不含 output_text
-	// - 流模式（stream=true）：使用 SSE 连续推送增量文本（不再推送最终结果 JSON）
-	r.POST("/api/graph/process", func(c *gin.Context) {
-		var req struct {
-			File    string                   `json:"file"`
-			Verbose bool                     `json:"verbose"`
-			Stream  bool                     `json:"stream"`
-			Graph   orchestrator.SimpleGraph `json:"graph"`
+	// execute 执行一张已校验的图并按 exec 输出（SSE 事件 / legacy 文本 / 非流 JSON），结束后写入运行历史；
+	// /api/graph/process 与 /api/runs/:id/rerun 共用。opts 中的 RunID 为空时由服务端生成
+	execute := func(c *gin.Context, sg orchestrator.SimpleGraph, opts graphproc.RunOptions, exec execRequest) {
+		// 请求未指定并发上限与 token 预算时使用配置的 max_concurrency / token_budget
+		if opts.MaxConcurrency <= 0 {
+			opts.MaxConcurrency = cfg.MaxConcurrency
 		}
-		if err := c.BindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		if opts.TokenBudget <= 0 {
+			opts.TokenBudget = cfg.TokenBudget
+		}
+		// 失败模式、路由与提示词版本/语言须在写出 SSE 头之前校验，无效时返回 400（rerun 沿用的历史选项同样适用）
+		if err := graphproc.CheckRunOptions(sg, opts); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		var sg orchestrator.SimpleGraph
-		if strings.TrimSpace(req.File) != "" {
-			var err error
-			sg, err = graphproc.ReadSimpleGraph(req.File)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("read agent graph: %v", err)})
-				return
-			}
-		} else if len(req.Graph.Nodes) > 0 || len(req.Graph.Edges) > 0 {
-			sg = req.Graph
-		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "either file or graph must be provided"})
+		// 提示词语言包：请求 prompt_version / language > 图 options > 默认值；子代理指令与节点提示词使用同一语言包
+		prompts, err := graphproc.ResolvePromptSet(opts.PromptVersion, opts.Language, sg.Options)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		supervisorAgent, textAgent, visionAgent, err := graphproc.BuildAgents()
+		opts.PromptVersion, opts.Language = prompts.Version, prompts.Language
+		supervisorAgent, textAgent, visionAgent, err := agents.BuildAgentsWith(prompts)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("build agents: %v", err)})
 			return
 		}
-		ctx := context.Background()
+		// 使用请求上下文：客户端断开（关闭页面/中断 SSE）时自动取消所有进行中的模型调用
+		runID := strings.TrimSpace(opts.RunID)
+		if runID == "" {
+			runID = graphproc.NewRunID()
+		} else if !runstore.ValidID(runID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid run_id %q (letters, digits, '-', '_' and '.' only)", runID)})
+			return
+		} else if _, err := store.Get(runID); err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("run %s already exists in history", runID)})
+			return
+		}
+		owner := currentUser(c).ID
+		// 用户额度已用尽时拒绝执行；执行中每次模型调用前检查剩余额度，用量实时计入
+		if err := usage.Check(owner); err != nil {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "code": "quota_exceeded", "usage": usage.Usage(owner)})
+			return
+		}
+		opts.Quota = usage.Account(owner)
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+		if !runs.register(runID, owner, cancel) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("run %s is already running", runID)})
+			return
+		}
+		defer runs.unregister(runID)
+		c.Header("X-Run-ID", runID)
+
 		sp := graphproc.NewStreamPrinter()
-		sp.EnableVerbose(req.Verbose)
+		sp.EnableVerbose(exec.Verbose)
 
 		results := make(map[string]graphproc.NodeResult, len(sg.Nodes))
+		opts.RunID = runID
+		opts.Agents = agents
+		opts.Images = imageLoader
+		opts.Pricing = pricing
+		if !exec.NoCache {
+			opts.Cache = cache
+		}
+		// 执行结束（含失败/取消）后写入运行历史；写入失败只记录日志，不影响响应
+		startedAt := time.Now()
+		saveRun := func(err error) {
+			run := runstore.NewRun(runID, runstore.SourceAPI, sg, opts, results, err, startedAt, time.Now())
+			run.Owner = owner
+			run.ParentID, run.Rerun = exec.ParentID, exec.Rerun
+			if serr := store.Save(run); serr != nil {
+				logs.Errorf("[runs] save run=%s: %v", runID, serr)
+			}
+		}
 
-		if req.Stream {
-			// SSE 流模式：仅推送增量文本，不推送最终结果事件
+		if exec.Stream {
+			metrics.SSEConnections.Inc()
+			defer metrics.SSEConnections.Dec()
 			c.Header("Content-Type", "text/event-stream; charset=utf-8")
 			c.Header("Cache-Control", "no-cache")
 			c.Header("Connection", "keep-alive")
+			if exec.StreamFormat == streamFormatEvents {
+				// 结构化 SSE：run_start / node_start / delta / node_error / node_end / run_end，
+				// 每条事件带递增 id，run_end 携带全部结果、用量汇总与最终状态
+				sp.SetWriter(io.Discard)
+				opts.OnEvent = func(ev graphproc.Event) {
+					data, err := json.Marshal(ev.Data)
+					if err != nil {
+						return
+					}
+					fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+					c.Writer.Flush()
+				}
+				// 错误经 run_end 事件送达客户端（run 未开始即失败时也有一条 run_end）
+				err := graphproc.ProcessGraph(ctx, sg, supervisorAgent, textAgent, visionAgent, results, sp, opts)
+				saveRun(err)
+				if errors.Is(err, graphproc.ErrInvalidRunOptions) {
+					logs.Errorf("[graph] run=%s not started: %v", runID, err)
+				} else if err != nil {
+					logs.Infof("[graph] run=%s finished: %v", runID, err)
+				}
+				return
+			}
+			// legacy SSE：仅推送增量文本，不推送最终结果事件
 			// 将 StreamPrinter 写入包装为 SSE（只发 data 行）
 			writeData := func(data []byte) {
 				_, _ = c.Writer.Write([]byte("data: "))
@@ -287,7 +462,9 @@ func NewServer() *gin.Engine {
 			}))
 
 			// 执行图，期间将通过 SSE 推送增量内容
-			if err := graphproc.ProcessGraph(ctx, sg, supervisorAgent, textAgent, visionAgent, results, sp); err != nil {
+			err := graphproc.ProcessGraph(ctx, sg, supervisorAgent, textAgent, visionAgent, results, sp, opts)
+			saveRun(err)
+			if err != nil {
 				// 推送错误事件（保留 error 事件便于前端处理）
 				_, _ = c.Writer.Write([]byte("event: error\n"))
 				_, _ = c.Writer.Write([]byte("data: "))
@@ -300,17 +477,365 @@ func NewServer() *gin.Engine {
 			return
 		}
 
-		// 非流模式：不捕捉 output_text，直接返回 results
+		// 非流模式：不捕捉 output_text，直接返回 results 与用量汇总
 		sp.SetWriter(io.Discard)
-		if err := graphproc.ProcessGraph(ctx, sg, supervisorAgent, textAgent, visionAgent, results, sp); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("process graph: %v", err)})
+		err = graphproc.ProcessGraph(ctx, sg, supervisorAgent, textAgent, visionAgent, results, sp, opts)
+		saveRun(err)
+		if err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, graphproc.ErrRunAborted) || errors.Is(err, graphproc.ErrBudgetExceeded) {
+				// 被显式取消、fail-fast 中止或预算用尽：返回已完成节点的结果，未完成节点状态为 cancelled / budget_exceeded
+				c.JSON(http.StatusOK, exec.response(gin.H{
+					"status":         graphproc.RunStatus(err),
+					"error":          err.Error(),
+					"run_id":         runID,
+					"nodes":          len(sg.Nodes),
+					"edges":          len(sg.Edges),
+					"results":        results,
+					"skipped":        graphproc.SkippedNodes(results),
+					"usage_summary":  graphproc.SummarizeUsage(results),
+					"charged_tokens": graphproc.ChargedTokens(results),
+					"token_budget":   opts.TokenBudget,
+				}))
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("process graph: %v", err), "run_id": runID})
+			return
+		}
+		c.JSON(http.StatusOK, exec.response(gin.H{
+			"status":         "ok",
+			"run_id":         runID,
+			"nodes":          len(sg.Nodes),
+			"edges":          len(sg.Edges),
+			"results":        results,
+			"skipped":        graphproc.SkippedNodes(results),
+			"usage_summary":  graphproc.SummarizeUsage(results),
+			"charged_tokens": graphproc.ChargedTokens(results),
+			"token_budget":   opts.TokenBudget,
+		}))
+	}
+
+	// ===== 图执行与总结路由 =====
+	// 执行最简代理图：支持两种模式
+	// - 非流模式（默认）：仅返回最终 JSON，包含 results，不含 output_text
+	// - 流模式（stream=true）：使用 SSE 连续推送增量文本（不再推送最终结果 JSON）
+	r.POST("/api/graph/process", func(c *gin.Context) {
+		var req struct {
+			File    string                   `json:"file"`
+			Verbose bool                     `json:"verbose"`
+			Stream  bool                     `json:"stream"`
+			Graph   orchestrator.SimpleGraph `json:"graph"`
+			// 调度参数：全局并发上限与关键路径优先（可选）
+			MaxConcurrency       int  `json:"max_concurrency"`
+			CriticalPathPriority bool `json:"critical_path_priority"`
+			// 可选：由客户端指定 run ID（便于在流开始前即可调用取消接口）；为空时由服务端生成
+			RunID string `json:"run_id"`
+			// 可选：运行级重试/超时策略（可被图 options 与节点 payload.retry 覆盖）
+			Retry *orchestrator.RetryPolicy `json:"retry"`
+			// 可选：失败传播模式 continue（默认）/ skip-downstream / fail-fast
+			FailureMode string `json:"failure_mode"`
+			// 可选：路由模式 hybrid（默认）/ rule / llm
+			Router string `json:"router"`
+			// 可选：SSE 格式 events（默认，结构化事件）/ legacy（仅 data 文本行）
+			StreamFormat string `json:"stream_format"`
+			// 可选：为 true 时不读写节点输出缓存，所有节点重新执行
+			NoCache bool `json:"no_cache"`
+			// 可选：提示词模板版本（见 GET /api/prompts），为空时使用图 options.prompt_version 或默认版本
+			PromptVersion string `json:"prompt_version"`
+			// 可选：输出与指令语言（如 zh / en），为空时使用图 options.language 或默认语言；节点可用 payload.language 覆盖
+			Language string `json:"language"`
+			// 可选：本次 run 的 token 预算，为 0 时使用配置的 token_budget
+			TokenBudget int `json:"token_budget"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+			return
+		}
+		if !serverFileAllowed(c, req.File) {
+			return
+		}
+		sg, err := resolveSimpleGraph(req.File, req.Graph)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		failureMode, err := graphproc.ParseFailureMode(req.FailureMode)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.TokenBudget < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token_budget must not be negative"})
 			return
 		}
+		routerMode, err := graphproc.ParseRouterMode(req.Router)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		streamFormat, err := parseStreamFormat(req.StreamFormat)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// 执行前校验：存在错误（环、悬空边、重复 ID 等）时拒绝执行，避免调用模型后才发现半张图未执行
+		validation := graphproc.ValidateGraph(sg)
+		if !validation.Valid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid graph: " + validation.Error(), "validation": validation})
+			return
+		}
+		opts := graphproc.RunOptions{
+			RunID:                req.RunID,
+			MaxConcurrency:       req.MaxConcurrency,
+			CriticalPathPriority: req.CriticalPathPriority,
+			Retry:                req.Retry,
+			FailureMode:          failureMode,
+			Router:               routerMode,
+			PromptVersion:        req.PromptVersion,
+			Language:             req.Language,
+			TokenBudget:          req.TokenBudget,
+		}
+		execute(c, sg, opts, execRequest{Stream: req.Stream, StreamFormat: streamFormat, Verbose: req.Verbose, NoCache: req.NoCache})
+	})
+
+	// token 用量与额度：当前用户的当日/当月用量与剩余额度；管理员可用 ?user=<id> 查看指定用户，?all=true 查看全部用户
+	r.GET("/api/usage", func(c *gin.Context) {
+		u := currentUser(c)
+		user := strings.TrimSpace(c.Query("user"))
+		all, _ := strconv.ParseBool(c.Query("all"))
+		if (all || (user != "" && user != u.ID)) && !requireAdmin(c) {
+			return
+		}
+		if all {
+			c.JSON(http.StatusOK, gin.H{"limits": usage.Limits(), "users": usage.All()})
+			return
+		}
+		if user == "" {
+			user = u.ID
+		}
+		c.JSON(http.StatusOK, gin.H{"limits": usage.Limits(), "usage": usage.Usage(user)})
+	})
+
+	// 生效配置（不含密钥）：各项取值与来源（default / file / env / flag）
+	r.GET("/api/config", func(c *gin.Context) {
+		pub := cfg.Public()
+		if pub.MaxConcurrency <= 0 {
+			pub.MaxConcurrency = graphproc.DefaultMaxConcurrency
+		}
 		c.JSON(http.StatusOK, gin.H{
-			"status":  "ok",
-			"nodes":   len(sg.Nodes),
-			"edges":   len(sg.Edges),
-			"results": results,
+			"config":          pub,
+			"base_url":        publicBaseURL(cfg, c),
+			"image_store":     images.Backend(),
+			"upload_max_size": uploads.MaxBytes,
+		})
+	})
+
+	// 列出可用的提示词语言包（内嵌 + PROMPT_TEMPLATE_DIR）及默认版本与语言
+	r.GET("/api/prompts", func(c *gin.Context) {
+		lib, err := graphproc.DefaultPromptLibrary()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"default": lib.DefaultVersion(), "default_language": lib.DefaultLanguage(), "versions": lib.Sets()})
+	})
+
+	// 列出已注册的子代理定义（内置 + 配置文件）
+	r.GET("/api/agents", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"agents": agents.Defs()})
+	})
+
+	// 运行历史列表：支持 status / source / since / until（RFC3339）过滤与 limit / offset 分页
+	r.GET("/api/runs", func(c *gin.Context) {
+		filter, err := parseRunFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filter.Owner = ownerScope(c)
+		page, err := store.List(filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, page)
+	})
+
+	// 费用报表：按 group_by（day / user / graph / model，可逗号组合）汇总运行历史的 token 与费用，
+	// 支持与运行列表相同的 status / source / since / until / owner 过滤；普通用户只统计自己的 run
+	r.GET("/api/costs", func(c *gin.Context) {
+		filter, err := parseRunFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filter.Owner = ownerScope(c)
+		groupBy, err := runstore.ParseGroupBy(c.Query("group_by"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		report, err := store.CostReport(filter, groupBy)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"currency": pricing.Currency, "group_by": report.GroupBy, "rows": report.Rows, "total": report.Total})
+	})
+
+	// 模型价格表（每百万 token 单价）
+	r.GET("/api/pricing", func(c *gin.Context) {
+		c.JSON(http.StatusOK, pricing)
+	})
+
+	// 运行详情：图快照、选项、每节点结果与用量；仍在执行的 run 返回 status=running
+	r.GET("/api/runs/:id", func(c *gin.Context) {
+		id := c.Param("id")
+		run, err := store.Get(id)
+		if errors.Is(err, runstore.ErrNotFound) {
+			if owner, ok := runs.running(id); ok && currentUser(c).CanAccess(owner) {
+				c.JSON(http.StatusOK, gin.H{"id": id, "status": "running"})
+				return
+			}
+			c.JSON(http.StatusNotFound, gin.H{"error": "run not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// 他人的 run 按不存在处理，不暴露其存在
+		if !currentUser(c).CanAccess(run.Owner) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "run not found"})
+			return
+		}
+		c.JSON(http.StatusOK, run)
+	})
+
+	// 基于历史 run 重新执行：start_node 重新执行该节点及其后代，overrides 以手动输出替代指定节点并重算其后代；
+	// 其余节点复用原 run 的输出，执行选项沿用原 run，结果作为新的 run（parent_id 指向原 run）写入历史
+	r.POST("/api/runs/:id/rerun", func(c *gin.Context) {
+		var req struct {
+			StartNode string            `json:"start_node"`
+			Overrides map[string]string `json:"overrides"`
+			// 以下与 /api/graph/process 含义相同
+			RunID        string `json:"run_id"`
+			Verbose      bool   `json:"verbose"`
+			Stream       bool   `json:"stream"`
+			StreamFormat string `json:"stream_format"`
+			NoCache      bool   `json:"no_cache"`
+			// 可选：覆盖原 run 的 token 预算
+			TokenBudget int `json:"token_budget"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+			return
+		}
+		parent, err := store.Get(c.Param("id"))
+		if err == nil && !currentUser(c).CanAccess(parent.Owner) {
+			err = runstore.ErrNotFound
+		}
+		if errors.Is(err, runstore.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "run not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		streamFormat, err := parseStreamFormat(req.StreamFormat)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		startNode := strings.TrimSpace(req.StartNode)
+		preset, recompute, err := graphproc.PlanRerun(parent.Graph, parent.Results, startNode, req.Overrides)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		opts := parent.Options
+		opts.RunID = req.RunID
+		opts.Preset = preset
+		opts.ForceNodes = nil
+		if startNode != "" {
+			opts.ForceNodes = []string{startNode}
+		}
+		if req.TokenBudget > 0 {
+			opts.TokenBudget = req.TokenBudget
+		}
+		execute(c, parent.Graph, opts, execRequest{
+			Stream:       req.Stream,
+			StreamFormat: streamFormat,
+			Verbose:      req.Verbose,
+			NoCache:      req.NoCache,
+			ParentID:     parent.ID,
+			Rerun:        &runstore.RerunSpec{StartNode: startNode, Overrides: req.Overrides, Recomputed: recompute},
+		})
+	})
+
+	// 节点输出缓存：统计与全部条目（最近使用在前）
+	// 缓存条目包含所有用户的节点输出，仅管理员可查看与清理
+	r.GET("/api/cache", func(c *gin.Context) {
+		if !requireAdmin(c) {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"stats": cache.Stats(), "entries": cache.Entries()})
+	})
+
+	// 清空节点输出缓存
+	r.DELETE("/api/cache", func(c *gin.Context) {
+		if !requireAdmin(c) {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "cleared", "removed": cache.Clear()})
+	})
+
+	// 删除单条缓存（按 cache_key）
+	r.DELETE("/api/cache/:key", func(c *gin.Context) {
+		if !requireAdmin(c) {
+			return
+		}
+		if !cache.Delete(c.Param("key")) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "cache entry not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+	})
+
+	// 取消正在执行的图：停止调度新节点并中断进行中的模型调用，未完成节点记为 cancelled
+	r.POST("/api/runs/:id/cancel", func(c *gin.Context) {
+		id := c.Param("id")
+		if !runs.cancel(id, currentUser(c)) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "run not found or already finished"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "cancelling", "run_id": id})
+	})
+
+	// 校验最简代理图：返回环路、悬空边、重复 ID、自环、不可达/孤立节点、多汇点等诊断
+	r.POST("/api/graph/validate", func(c *gin.Context) {
+		var req struct {
+			File  string                   `json:"file"`
+			Graph orchestrator.SimpleGraph `json:"graph"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+			return
+		}
+		if !serverFileAllowed(c, req.File) {
+			return
+		}
+		sg, err := resolveSimpleGraph(req.File, req.Graph)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		validation := graphproc.ValidateGraph(sg)
+		c.JSON(http.StatusOK, gin.H{
+			"status":     "ok",
+			"nodes":      len(sg.Nodes),
+			"edges":      len(sg.Edges),
+			"validation": validation,
 		})
 	})
 
@@ -325,6 +850,9 @@ func NewServer() *gin.Engine {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
 			return
 		}
+		if !serverFileAllowed(c, req.File) || !serverFileAllowed(c, req.AgentOut) {
+			return
+		}
 		var canon orchestrator.Canonical
 		if strings.TrimSpace(req.File) != "" {
 			var err error
@@ -357,13 +885,15 @@ func NewServer() *gin.Engine {
 				if e.From == "" || e.To == "" {
 					continue
 				}
-				canon.Edges = append(canon.Edges, orchestrator.Edge{From: e.From, To: e.To})
+				canon.Edges = append(canon.Edges, orchestrator.Edge{From: e.From, To: e.To, Color: e.Color, Intent: orchestrator.EdgeIntent(e.Intent), Params: orchestrator.EdgeParams(e.Params)})
 			}
 		} else {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "either file or board must be provided"})
 			return
 		}
 		ag := orchestrator.BuildSimpleGraph(canon)
+		// 生成后校验：仅作为警告返回，不阻止写出（便于前端提示用户修正连线）
+		validation := graphproc.ValidateGraph(ag)
 
 		// 可选：写入到文件
 		if p := strings.TrimSpace(req.AgentOut); p != "" {
@@ -382,14 +912,73 @@ func NewServer() *gin.Engine {
 		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"status": "ok",
-			"nodes":  len(ag.Nodes),
-			"edges":  len(ag.Edges),
-			"graph":  ag,
+			"status":     "ok",
+			"nodes":      len(ag.Nodes),
+			"edges":      len(ag.Edges),
+			"graph":      ag,
+			"validation": validation,
 		})
 	})
 
-	return r
+	return &Server{Engine: r, usage: usage}
+}
+
+// ownedImage 返回当前用户可访问的图片元数据；他人的图片按不存在处理（ErrNotFound），不暴露其存在
+func ownedImage(c *gin.Context, images imagestore.ImageStore, id string) (imagestore.Meta, error) {
+	meta, err := images.Stat(c.Request.Context(), id)
+	if err != nil {
+		return meta, err
+	}
+	if !currentUser(c).CanAccess(meta.Owner) {
+		return imagestore.Meta{}, imagestore.ErrNotFound
+	}
+	return meta, nil
+}
+
+// deleteOwnedImage 删除被替换的旧图片；不存在或无权访问时忽略
+func deleteOwnedImage(c *gin.Context, images imagestore.ImageStore, id string) {
+	if meta, err := ownedImage(c, images, id); err == nil && images.Delete(c.Request.Context(), id) == nil {
+		metrics.UploadStorageBytes.Add(-float64(meta.Size))
+	}
+}
+
+// initUploadStorageMetric 按图片存储中的现有图片初始化存储用量指标；之后由上传、导入与删除接口增减
+func initUploadStorageMetric(images imagestore.ImageStore) {
+	list, err := images.List(context.Background(), imagestore.ListFilter{})
+	if err != nil {
+		logs.Errorf("[metrics] list images: %v", err)
+		return
+	}
+	var total int64
+	for _, m := range list {
+		total += m.Size
+	}
+	metrics.UploadStorageBytes.Set(float64(total))
+}
+
+// serverFileAllowed 请求体中的 file / agent_out 为服务端文件路径（读写服务器上保存的图），仅管理员可用；
+// 其它用户须在请求体中直接提交图。不允许时返回 403 并返回 false
+func serverFileAllowed(c *gin.Context, path string) bool {
+	if strings.TrimSpace(path) == "" || currentUser(c).IsAdmin() {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "server-side graph files require the admin role; submit the graph in the request body", "code": "forbidden"})
+	return false
+}
+
+// resolveSimpleGraph 从文件路径或请求体中获取最简代理图（文件优先）
+func resolveSimpleGraph(file string, graph orchestrator.SimpleGraph) (orchestrator.SimpleGraph, error) {
+	if strings.TrimSpace(file) != "" {
+		sg, err := graphproc.ReadSimpleGraph(file)
+		if err != nil {
+			return sg, fmt.Errorf("read agent graph: %w", err)
+		}
+		return sg, nil
+	}
+	if len(graph.Nodes) > 0 || len(graph.Edges) > 0 {
+		return graph, nil
+	}
+	return graph, fmt.Errorf("either file or graph must be provided")
 }
 
 // orchestratorExtractText 复制 orchestrator.extractText 的核心逻辑以便处理前端直接提交的 BoardExport
@@ -0,0 +1,429 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"multi-agent/internal/config"
+	"multi-agent/internal/graphproc"
+	"multi-agent/model"
+)
+
+// 测试用 API Key：alice、bob 为普通用户，root 为管理员
+const (
+	keyAlice = "k-alice"
+	keyBob   = "k-bob"
+	keyRoot  = "k-root"
+)
+
+// fakeScript 所有测试共享的假模型脚本：节点 boom 的子代理调用失败，其余节点回显
+const fakeScript = `{
+  "rules": [
+    {"agent": "text_agent", "match": "处理节点: boom\n", "error": "scripted failure"}
+  ]
+}`
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	dir, err := os.MkdirTemp("", "httpserver-test")
+	if err != nil {
+		panic(err)
+	}
+	script := filepath.Join(dir, "fake.json")
+	if err := os.WriteFile(script, []byte(fakeScript), 0o600); err != nil {
+		panic(err)
+	}
+	// 假模型脚本由环境变量指定（见 model.DefaultFakeScript）；模型类型与图片目录见 newTestServer
+	os.Setenv(model.FakeScriptEnv, script)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestServer 创建启用 API Key 认证的服务，运行历史与用量写入临时目录
+func newTestServer(t *testing.T) http.Handler {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := config.Defaults()
+	cfg.DataDir = dir
+	cfg.UploadDir = filepath.Join(dir, "images")
+	cfg.Model.Type = "fake"
+	cfg.RunsDir = filepath.Join(dir, "runs")
+	cfg.UsageFile = filepath.Join(dir, "usage.json")
+	cfg.Auth.APIKeys = keyAlice + ":alice," + keyBob + ":bob," + keyRoot + ":root:admin"
+	srv := NewServer(cfg)
+	t.Cleanup(func() {
+		if err := srv.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	})
+	return srv
+}
+
+// do 发送请求；key 为空时不带凭据，body 非 nil 时编码为 JSON
+func do(t *testing.T, h http.Handler, method, path, key string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// decode 解析 JSON 响应，状态码不符时失败
+func decode(t *testing.T, w *httptest.ResponseRecorder, status int) map[string]any {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+	var m map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+	return m
+}
+
+// chainGraph 构建 ids[0] -> ids[1] -> ... 的纯文本图
+func chainGraph(ids ...string) map[string]any {
+	var nodes, edges []map[string]any
+	for i, id := range ids {
+		nodes = append(nodes, map[string]any{"id": id, "payload": map[string]string{"text": "content of " + id}})
+		if i > 0 {
+			edges = append(edges, map[string]any{"from": ids[i-1], "to": id})
+		}
+	}
+	return map[string]any{"nodes": nodes, "edges": edges}
+}
+
+// nodeStatuses 返回响应 results 中各节点的状态
+func nodeStatuses(t *testing.T, resp map[string]any) map[string]string {
+	t.Helper()
+	results, ok := resp["results"].(map[string]any)
+	if !ok {
+		t.Fatalf("no results in %v", resp)
+	}
+	out := make(map[string]string, len(results))
+	for id, r := range results {
+		out[id], _ = r.(map[string]any)["status"].(string)
+	}
+	return out
+}
+
+func TestProcessGraphJSON(t *testing.T) {
+	h := newTestServer(t)
+	w := do(t, h, "POST", "/api/graph/process", keyAlice, map[string]any{"graph": chainGraph("a", "b"), "router": "rule"})
+	resp := decode(t, w, http.StatusOK)
+	if resp["status"] != "ok" {
+		t.Fatalf("status = %v, want ok: %v", resp["status"], resp)
+	}
+	runID, _ := resp["run_id"].(string)
+	if runID == "" || w.Header().Get("X-Run-ID") != runID {
+		t.Errorf("run_id %q, X-Run-ID %q", runID, w.Header().Get("X-Run-ID"))
+	}
+	for id, status := range nodeStatuses(t, resp) {
+		if status != graphproc.NodeStatusSucceeded {
+			t.Errorf("%s: status %q, want succeeded", id, status)
+		}
+	}
+	b := resp["results"].(map[string]any)["b"].(map[string]any)
+	if b["output"] != "[fake text_agent] 处理节点: b" || b["router"] != graphproc.RouterRule {
+		t.Errorf("b = %v", b)
+	}
+	if _, ok := resp["usage_summary"].(map[string]any); !ok {
+		t.Errorf("no usage_summary in %v", resp)
+	}
+
+	// 运行历史：仅所有者与管理员可见
+	run := decode(t, do(t, h, "GET", "/api/runs/"+runID, keyAlice, nil), http.StatusOK)
+	if run["owner"] != "alice" {
+		t.Errorf("run owner = %v, want alice", run["owner"])
+	}
+	decode(t, do(t, h, "GET", "/api/runs/"+runID, keyRoot, nil), http.StatusOK)
+	decode(t, do(t, h, "GET", "/api/runs/"+runID, keyBob, nil), http.StatusNotFound)
+}
+
+func TestProcessGraphFailFastJSON(t *testing.T) {
+	h := newTestServer(t)
+	body := map[string]any{
+		"graph":        chainGraph("a", "boom", "c"),
+		"router":       "rule",
+		"failure_mode": "fail-fast",
+		"retry":        map[string]any{"max_attempts": 1},
+	}
+	resp := decode(t, do(t, h, "POST", "/api/graph/process", keyAlice, body), http.StatusOK)
+	if resp["status"] != graphproc.RunStatusFailed || !strings.Contains(fmt.Sprint(resp["error"]), "node boom failed") {
+		t.Fatalf("status=%v error=%v, want failed at boom", resp["status"], resp["error"])
+	}
+	want := map[string]string{"a": graphproc.NodeStatusSucceeded, "boom": graphproc.NodeStatusFailed, "c": graphproc.NodeStatusCancelled}
+	for id, status := range nodeStatuses(t, resp) {
+		if status != want[id] {
+			t.Errorf("%s: status %q, want %q", id, status, want[id])
+		}
+	}
+}
+
+// sseEvent 一条 SSE 事件
+type sseEvent struct {
+	id, event, data string
+}
+
+// parseSSE 按空行切分 SSE 事件
+func parseSSE(t *testing.T, body string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	var cur sseEvent
+	sc := bufio.NewScanner(strings.NewReader(body))
+	sc.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if cur != (sseEvent{}) {
+				events = append(events, cur)
+			}
+			cur = sseEvent{}
+		case strings.HasPrefix(line, "id: "):
+			cur.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			cur.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			cur.data += strings.TrimPrefix(line, "data: ")
+		}
+	}
+	if cur != (sseEvent{}) {
+		events = append(events, cur)
+	}
+	return events
+}
+
+func TestProcessGraphSSEEvents(t *testing.T) {
+	h := newTestServer(t)
+	body := map[string]any{"graph": chainGraph("a", "b"), "router": "rule", "stream": true}
+	w := do(t, h, "POST", "/api/graph/process", keyAlice, body)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("status %d, content type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	events := parseSSE(t, w.Body.String())
+	var lifecycle []string
+	for i, ev := range events {
+		if ev.id != fmt.Sprint(i+1) {
+			t.Errorf("event %d has id %q, want %d", i, ev.id, i+1)
+		}
+		var data map[string]any
+		if err := json.Unmarshal([]byte(ev.data), &data); err != nil {
+			t.Fatalf("event %s data %q: %v", ev.event, ev.data, err)
+		}
+		switch ev.event {
+		case graphproc.EventDelta:
+		case graphproc.EventRunStart, graphproc.EventRunEnd:
+			lifecycle = append(lifecycle, ev.event)
+		default:
+			lifecycle = append(lifecycle, fmt.Sprintf("%s:%v", ev.event, data["node_id"]))
+		}
+	}
+	want := []string{"run_start", "node_start:a", "node_end:a", "node_start:b", "node_end:b", "run_end"}
+	if strings.Join(lifecycle, " ") != strings.Join(want, " ") {
+		t.Fatalf("events:\n got %v\nwant %v", lifecycle, want)
+	}
+	var end map[string]any
+	_ = json.Unmarshal([]byte(events[len(events)-1].data), &end)
+	if end["status"] != graphproc.RunStatusOK || end["run_id"] != w.Header().Get("X-Run-ID") {
+		t.Errorf("run_end = %v", end)
+	}
+	if _, ok := end["usage_summary"].(map[string]any); !ok {
+		t.Errorf("run_end has no usage_summary: %v", end)
+	}
+}
+
+func TestProcessGraphSSELegacy(t *testing.T) {
+	h := newTestServer(t)
+	body := map[string]any{"graph": chainGraph("a", "boom"), "router": "rule", "stream": true, "stream_format": "legacy", "failure_mode": "fail-fast", "retry": map[string]any{"max_attempts": 1}}
+	w := do(t, h, "POST", "/api/graph/process", keyAlice, body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	events := parseSSE(t, w.Body.String())
+	if len(events) < 2 {
+		t.Fatalf("want text chunks and an error event, got %v", events)
+	}
+	var text strings.Builder
+	for _, ev := range events[:len(events)-1] {
+		if ev.event != "" || ev.id != "" {
+			t.Errorf("legacy stream sent a structured event: %+v", ev)
+		}
+		text.WriteString(ev.data)
+	}
+	if !strings.Contains(text.String(), "[fake text_agent] 处理节点: a") {
+		t.Errorf("legacy text %q lacks node a output", text.String())
+	}
+	if last := events[len(events)-1]; last.event != "error" || !strings.Contains(last.data, "node boom failed") {
+		t.Errorf("last event = %+v, want error for boom", last)
+	}
+}
+
+func TestProcessGraphRequiresAuth(t *testing.T) {
+	h := newTestServer(t)
+	body := map[string]any{"graph": chainGraph("a")}
+	resp := decode(t, do(t, h, "POST", "/api/graph/process", "", body), http.StatusUnauthorized)
+	if resp["error"] != "authentication required" {
+		t.Errorf("no credentials: %v", resp)
+	}
+	resp = decode(t, do(t, h, "POST", "/api/graph/process", "wrong-key", body), http.StatusUnauthorized)
+	if resp["error"] != "invalid credentials" {
+		t.Errorf("bad key: %v", resp)
+	}
+	// 公开路由无需凭据
+	decode(t, do(t, h, "GET", "/ping", "", nil), http.StatusOK)
+}
+
+func TestProcessGraphRejectsInvalidRequests(t *testing.T) {
+	h := newTestServer(t)
+	tests := []struct {
+		name   string
+		key    string
+		body   map[string]any
+		status int
+		errSub string
+	}{
+		{name: "server file needs admin", key: keyAlice, body: map[string]any{"file": "agent-graph.json"}, status: http.StatusForbidden, errSub: "admin role"},
+		{name: "cycle", key: keyAlice, body: map[string]any{"graph": map[string]any{
+			"nodes": []map[string]any{{"id": "a"}, {"id": "b"}},
+			"edges": []map[string]any{{"from": "a", "to": "b"}, {"from": "b", "to": "a"}},
+		}}, status: http.StatusBadRequest, errSub: "invalid graph"},
+		{name: "unknown router", key: keyAlice, body: map[string]any{"graph": chainGraph("a"), "router": "coin"}, status: http.StatusBadRequest, errSub: "unknown router"},
+		{name: "unknown stream format", key: keyAlice, body: map[string]any{"graph": chainGraph("a"), "stream_format": "xml"}, status: http.StatusBadRequest, errSub: "unknown stream_format"},
+		{name: "stream with unknown graph prompt version", key: keyAlice, body: map[string]any{"graph": func() map[string]any {
+			g := chainGraph("a")
+			g["options"] = map[string]any{"prompt_version": "v404"}
+			return g
+		}(), "stream": true}, status: http.StatusBadRequest, errSub: "v404"},
+		{name: "empty", key: keyAlice, body: map[string]any{}, status: http.StatusBadRequest, errSub: "either file or graph"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := decode(t, do(t, h, "POST", "/api/graph/process", tt.key, tt.body), tt.status)
+			if !strings.Contains(fmt.Sprint(resp["error"]), tt.errSub) {
+				t.Errorf("error = %v, want %q", resp["error"], tt.errSub)
+			}
+		})
+	}
+}
+
+func TestRunOwnership(t *testing.T) {
+	h := newTestServer(t)
+	resp := decode(t, do(t, h, "POST", "/api/graph/process", keyAlice, map[string]any{"graph": chainGraph("a", "b"), "router": "rule", "run_id": "alice-run"}), http.StatusOK)
+	if resp["run_id"] != "alice-run" {
+		t.Fatalf("run_id = %v", resp["run_id"])
+	}
+	// 他人的 run 按不存在处理：不可查看、重新执行或取消
+	decode(t, do(t, h, "POST", "/api/runs/alice-run/rerun", keyBob, map[string]any{"start_node": "b"}), http.StatusNotFound)
+	decode(t, do(t, h, "POST", "/api/runs/alice-run/cancel", keyBob, nil), http.StatusNotFound)
+	list := decode(t, do(t, h, "GET", "/api/runs", keyBob, nil), http.StatusOK)
+	if runs, _ := list["runs"].([]any); len(runs) != 0 {
+		t.Errorf("bob sees %d runs, want none", len(runs))
+	}
+	// 同一 run ID 不可重复使用
+	decode(t, do(t, h, "POST", "/api/graph/process", keyBob, map[string]any{"graph": chainGraph("a"), "run_id": "alice-run"}), http.StatusConflict)
+
+	// 所有者可基于历史重新执行，新 run 指向原 run
+	rerun := decode(t, do(t, h, "POST", "/api/runs/alice-run/rerun", keyAlice, map[string]any{"start_node": "b", "run_id": "alice-rerun"}), http.StatusOK)
+	if rerun["parent_id"] != "alice-run" || rerun["status"] != "ok" {
+		t.Errorf("rerun = %v", rerun)
+	}
+	// 缓存包含所有用户的输出，仅管理员可查看
+	decode(t, do(t, h, "GET", "/api/cache", keyAlice, nil), http.StatusForbidden)
+	decode(t, do(t, h, "GET", "/api/cache", keyRoot, nil), http.StatusOK)
+}
+
+// uploadPNG 以 key 的身份上传一张 2x2 PNG，返回上传响应
+func uploadPNG(t *testing.T, h http.Handler, key string) map[string]any {
+	t.Helper()
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "pixel.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(img.Bytes())
+	mw.Close()
+	req := httptest.NewRequest("POST", "/api/images", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+key)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return decode(t, w, http.StatusOK)
+}
+
+func TestImageAccess(t *testing.T) {
+	h := newTestServer(t)
+	up := uploadPNG(t, h, keyAlice)
+	id, _ := up["id"].(string)
+	signed, _ := up["signed_url"].(string)
+	if id == "" || signed == "" || up["signed_url_expires_at"] == nil {
+		t.Fatalf("upload response = %v", up)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(up["url"].(string), "/api/images/"+id) || u.Path != "/api/images/"+id {
+		t.Fatalf("url = %v, signed_url = %s", up["url"], signed)
+	}
+	path := "/api/images/" + id
+
+	tests := []struct {
+		name   string
+		path   string
+		key    string
+		status int
+	}{
+		{name: "no credentials", path: path, status: http.StatusUnauthorized},
+		{name: "owner", path: path, key: keyAlice, status: http.StatusOK},
+		{name: "other user", path: path, key: keyBob, status: http.StatusNotFound},
+		{name: "admin", path: path, key: keyRoot, status: http.StatusOK},
+		{name: "signed link", path: u.RequestURI(), status: http.StatusOK},
+		{name: "signature for another image", path: "/api/images/other?" + u.RawQuery, status: http.StatusUnauthorized},
+		{name: "tampered expiry", path: path + "?exp=99999999999&sig=" + u.Query().Get("sig"), status: http.StatusUnauthorized},
+		// 签名无效时按凭据认证
+		{name: "bad signature with owner key", path: path + "?exp=1&sig=00", key: keyAlice, status: http.StatusOK},
+		{name: "signed link does not open metadata", path: path + "/meta?" + u.RawQuery, status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(t, h, "GET", tt.path, tt.key, nil)
+			if w.Code != tt.status {
+				t.Fatalf("GET %s: status %d, want %d: %s", tt.path, w.Code, tt.status, w.Body.String())
+			}
+			if tt.status == http.StatusOK && w.Header().Get("Content-Type") != "image/png" {
+				t.Errorf("Content-Type = %q", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
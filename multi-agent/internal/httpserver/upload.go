@@ -0,0 +1,312 @@
+package httpserver
+
+// 本文件实现图片上传与 URL 导入的安全限制：
+// - 上传大小上限（upload.max_bytes，见 config.UploadConfig），超出时返回 413；
+// - 按内容嗅探 MIME 类型（不信任客户端文件名与上游 Content-Type），仅允许白名单中的图片类型，扩展名由嗅探结果决定；
+// - URL 导入：协议白名单（http/https）、DNS 解析后拒绝私有/回环/链路本地等地址（拨号时再次校验，防止 DNS 重绑定）、
+//   重定向次数上限、超时与响应体大小上限；
+// 每种拒绝返回 {"error": 说明, "code": 错误码}，错误码见下方常量。
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"multi-agent/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// multipartOverhead 上传请求体在文件之外允许的额外字节（表单字段与分隔符）
+const multipartOverhead = 1 << 20
+
+// 上传与导入的错误码
+const (
+	codeInvalidRequest   = "invalid_request"
+	codeFileRequired     = "file_required"
+	codeFileTooLarge     = "file_too_large"
+	codeUnsupportedType  = "unsupported_media_type"
+	codeInvalidURL       = "invalid_url"
+	codeSchemeNotAllowed = "scheme_not_allowed"
+	codeHostNotAllowed   = "host_not_allowed"
+	codeDNSFailed        = "dns_resolution_failed"
+	codeTooManyRedirects = "too_many_redirects"
+	codeFetchTimeout     = "fetch_timeout"
+	codeFetchFailed      = "fetch_failed"
+	codeUpstreamStatus   = "upstream_status"
+	codeStoreFailed      = "store_failed"
+)
+
+// allowedSchemes URL 导入允许的协议
+var allowedSchemes = map[string]bool{"http": true, "https": true}
+
+// imageExts 嗅探类型对应的扩展名（mime.ExtensionsByType 的首选项不稳定，如 jpeg 返回 .jfif）
+var imageExts = map[string]string{
+	"image/jpeg":               ".jpg",
+	"image/png":                ".png",
+	"image/gif":                ".gif",
+	"image/webp":               ".webp",
+	"image/bmp":                ".bmp",
+	"image/x-icon":             ".ico",
+	"image/vnd.microsoft.icon": ".ico",
+}
+
+// uploadError 一次被拒绝的上传或导入
+type uploadError struct {
+	Status int
+	Code   string
+	Msg    string
+}
+
+func (e *uploadError) Error() string { return e.Msg }
+
+func rejectUpload(status int, code, format string, args ...any) *uploadError {
+	return &uploadError{Status: status, Code: code, Msg: fmt.Sprintf(format, args...)}
+}
+
+// writeUploadError 输出 {"error", "code"}；非 uploadError 视为存储失败
+func writeUploadError(c *gin.Context, err error) {
+	var ue *uploadError
+	if !errors.As(err, &ue) {
+		ue = &uploadError{Status: http.StatusInternalServerError, Code: codeStoreFailed, Msg: "cannot save file"}
+	}
+	c.JSON(ue.Status, gin.H{"error": ue.Msg, "code": ue.Code})
+}
+
+// uploadPolicy 上传与 URL 导入的限制
+type uploadPolicy struct {
+	MaxBytes     int64
+	AllowedTypes map[string]bool
+	Timeout      time.Duration
+	MaxRedirects int
+	AllowPrivate bool
+}
+
+// newUploadPolicy 按 upload.* 配置创建限制（取值已由 config 包校验，见 config.UploadConfig）
+func newUploadPolicy(cfg config.UploadConfig) (uploadPolicy, error) {
+	p := uploadPolicy{
+		MaxBytes:     int64(cfg.MaxBytes),
+		AllowedTypes: map[string]bool{},
+		MaxRedirects: cfg.FetchMaxRedirects,
+		AllowPrivate: cfg.FetchAllowPrivate,
+	}
+	if p.MaxBytes <= 0 {
+		return p, fmt.Errorf("invalid upload.max_bytes %d", cfg.MaxBytes)
+	}
+	for _, t := range cfg.AllowedTypes {
+		p.AllowedTypes[strings.ToLower(strings.TrimSpace(t))] = true
+	}
+	d, err := time.ParseDuration(cfg.FetchTimeout)
+	if err != nil || d <= 0 {
+		return p, fmt.Errorf("invalid upload.fetch_timeout %q", cfg.FetchTimeout)
+	}
+	p.Timeout = d
+	return p, nil
+}
+
+// sniffedImage 嗅探后的图片：Body 从头读取完整内容，超过上限时返回 file_too_large
+type sniffedImage struct {
+	ContentType string
+	Ext         string
+	Body        io.Reader
+}
+
+// sniffImage 读取前 512 字节判断 MIME 类型，不在白名单中时拒绝
+func (p uploadPolicy) sniffImage(r io.Reader) (sniffedImage, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return sniffedImage{}, err
+	}
+	head = head[:n]
+	if n == 0 {
+		return sniffedImage{}, rejectUpload(http.StatusBadRequest, codeFileRequired, "empty file")
+	}
+	ct := http.DetectContentType(head)
+	if i := strings.Index(ct, ";"); i >= 0 {
+		ct = strings.TrimSpace(ct[:i])
+	}
+	if !p.AllowedTypes[ct] {
+		return sniffedImage{}, rejectUpload(http.StatusUnsupportedMediaType, codeUnsupportedType, "content type %s is not allowed", ct)
+	}
+	ext, ok := imageExts[ct]
+	if !ok {
+		ext = "." + strings.TrimPrefix(ct, "image/")
+	}
+	body := &cappedReader{r: io.MultiReader(strings.NewReader(string(head)), r), remaining: p.MaxBytes}
+	return sniffedImage{ContentType: ct, Ext: ext, Body: body}, nil
+}
+
+// cappedReader 读取超过 remaining 字节时返回 file_too_large（写入存储的过程随之中止）
+type cappedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.remaining < 0 {
+		return 0, rejectUpload(http.StatusRequestEntityTooLarge, codeFileTooLarge, "file too large")
+	}
+	if int64(len(p)) > c.remaining+1 {
+		p = p[:c.remaining+1]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if c.remaining < 0 {
+		return n, rejectUpload(http.StatusRequestEntityTooLarge, codeFileTooLarge, "file too large")
+	}
+	return n, err
+}
+
+// limitUploadBody 限制上传请求体大小，避免超大表单被完整解析到临时文件
+func (p uploadPolicy) limitUploadBody(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, p.MaxBytes+multipartOverhead)
+}
+
+// formFileError 将 FormFile 的错误映射为上传错误
+func (p uploadPolicy) formFileError(err error) error {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return rejectUpload(http.StatusRequestEntityTooLarge, codeFileTooLarge, "file too large (max %d bytes)", p.MaxBytes)
+	}
+	return rejectUpload(http.StatusBadRequest, codeFileRequired, "file field is required")
+}
+
+// blockedAddrError 目标地址为私有、回环或链路本地等内部地址
+type blockedAddrError struct {
+	IP net.IP
+}
+
+func (e *blockedAddrError) Error() string {
+	return fmt.Sprintf("address %s is not allowed", e.IP)
+}
+
+// cgnatNet 运营商级 NAT 地址段（100.64.0.0/10），同样视为内部地址
+var cgnatNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// internalIP 判断地址是否为私有、回环、链路本地、未指定或组播地址
+func internalIP(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+		if v4[0] == 0 || cgnatNet.Contains(v4) {
+			return true
+		}
+	}
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// checkFetchURL 校验协议与主机，并在 DNS 解析后拒绝内部地址
+func (p uploadPolicy) checkFetchURL(ctx context.Context, u *url.URL) error {
+	if !allowedSchemes[strings.ToLower(u.Scheme)] {
+		return rejectUpload(http.StatusBadRequest, codeSchemeNotAllowed, "scheme %q is not allowed (want http or https)", u.Scheme)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return rejectUpload(http.StatusBadRequest, codeInvalidURL, "url has no host")
+	}
+	if p.AllowPrivate {
+		return nil
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil || len(addrs) == 0 {
+			return rejectUpload(http.StatusBadGateway, codeDNSFailed, "cannot resolve host %s", host)
+		}
+		ips = ips[:0]
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	for _, ip := range ips {
+		if internalIP(ip) {
+			return rejectUpload(http.StatusForbidden, codeHostNotAllowed, "host %s resolves to internal address %s", host, ip)
+		}
+	}
+	return nil
+}
+
+// errTooManyRedirects 超过重定向上限
+var errTooManyRedirects = errors.New("too many redirects")
+
+// fetchClient 构建 URL 导入使用的客户端：不走环境代理（代理会绕过地址校验），拨号时再次校验实际连接的地址
+func (p uploadPolicy) fetchClient() *http.Client {
+	dialer := &net.Dialer{Timeout: p.Timeout}
+	if !p.AllowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || internalIP(ip) {
+				return &blockedAddrError{IP: ip}
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: p.Timeout,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   p.Timeout,
+			ResponseHeaderTimeout: p.Timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > p.MaxRedirects {
+				return errTooManyRedirects
+			}
+			return p.checkFetchURL(req.Context(), req.URL)
+		},
+	}
+}
+
+// fetchImage 下载 URL 指向的图片；返回的 Body 由调用方关闭
+func (p uploadPolicy) fetchImage(ctx context.Context, rawURL string) (*http.Response, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Host == "" {
+		return nil, rejectUpload(http.StatusBadRequest, codeInvalidURL, "invalid url")
+	}
+	if err := p.checkFetchURL(ctx, u); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, rejectUpload(http.StatusBadRequest, codeInvalidURL, "invalid url")
+	}
+	resp, err := p.fetchClient().Do(req)
+	if err != nil {
+		var ue *uploadError
+		var blocked *blockedAddrError
+		var netErr net.Error
+		switch {
+		case errors.As(err, &ue):
+			return nil, ue
+		case errors.As(err, &blocked):
+			return nil, rejectUpload(http.StatusForbidden, codeHostNotAllowed, "%v", blocked)
+		case errors.Is(err, errTooManyRedirects):
+			return nil, rejectUpload(http.StatusBadGateway, codeTooManyRedirects, "more than %d redirects", p.MaxRedirects)
+		case errors.As(err, &netErr) && netErr.Timeout():
+			return nil, rejectUpload(http.StatusGatewayTimeout, codeFetchTimeout, "fetch timed out after %s", p.Timeout)
+		default:
+			return nil, rejectUpload(http.StatusBadGateway, codeFetchFailed, "fetch failed")
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, rejectUpload(http.StatusBadGateway, codeUpstreamStatus, "upstream status %d", resp.StatusCode)
+	}
+	if resp.ContentLength > p.MaxBytes {
+		resp.Body.Close()
+		return nil, rejectUpload(http.StatusRequestEntityTooLarge, codeFileTooLarge, "file too large (%d bytes, max %d)", resp.ContentLength, p.MaxBytes)
+	}
+	return resp, nil
+}
@@ -0,0 +1,343 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pngHeader PNG 文件签名，足以让 http.DetectContentType 识别为 image/png
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func testPolicy() uploadPolicy {
+	return uploadPolicy{
+		MaxBytes:     1024,
+		AllowedTypes: map[string]bool{"image/png": true, "image/jpeg": true},
+		Timeout:      5 * time.Second,
+		MaxRedirects: 2,
+	}
+}
+
+// wantUploadError 断言 err 为指定错误码的 uploadError
+func wantUploadError(t *testing.T, err error, status int, code string) {
+	t.Helper()
+	var ue *uploadError
+	if !errors.As(err, &ue) {
+		t.Fatalf("err = %v (%T), want upload error %s", err, err, code)
+	}
+	if ue.Status != status || ue.Code != code {
+		t.Fatalf("err = %d %s (%s), want %d %s", ue.Status, ue.Code, ue.Msg, status, code)
+	}
+}
+
+func TestInternalIP(t *testing.T) {
+	tests := []struct {
+		ip       string
+		internal bool
+	}{
+		{"127.0.0.1", true},
+		{"127.255.255.254", true},
+		{"10.0.0.1", true},
+		{"172.16.0.1", true},
+		{"172.31.255.255", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"100.127.255.255", true},
+		{"0.0.0.0", true},
+		{"0.1.2.3", true},
+		{"224.0.0.1", true},
+		{"::1", true},
+		{"::", true},
+		{"fe80::1", true},
+		{"fc00::1", true},
+		{"fd12:3456::1", true},
+		{"ff02::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"::ffff:169.254.169.254", true},
+		{"::ffff:100.64.0.1", true},
+		{"8.8.8.8", false},
+		{"93.184.216.34", false},
+		{"100.63.255.255", false},
+		{"100.128.0.0", false},
+		{"172.32.0.1", false},
+		{"192.169.0.1", false},
+		{"::ffff:8.8.8.8", false},
+		{"2001:4860:4860::8888", false},
+	}
+	for _, tt := range tests {
+		ip := net.ParseIP(tt.ip)
+		if ip == nil {
+			t.Fatalf("bad test ip %q", tt.ip)
+		}
+		if got := internalIP(ip); got != tt.internal {
+			t.Errorf("internalIP(%s) = %v, want %v", tt.ip, got, tt.internal)
+		}
+	}
+}
+
+func TestCheckFetchURL(t *testing.T) {
+	tests := []struct {
+		url    string
+		status int
+		code   string
+	}{
+		{url: "http://93.184.216.34/a.png"},
+		{url: "https://[2001:4860:4860::8888]/a.png"},
+		{url: "ftp://93.184.216.34/a.png", status: http.StatusBadRequest, code: codeSchemeNotAllowed},
+		{url: "file:///etc/passwd", status: http.StatusBadRequest, code: codeSchemeNotAllowed},
+		{url: "gopher://127.0.0.1/", status: http.StatusBadRequest, code: codeSchemeNotAllowed},
+		{url: "http:///a.png", status: http.StatusBadRequest, code: codeInvalidURL},
+		{url: "http://127.0.0.1:8080/a.png", status: http.StatusForbidden, code: codeHostNotAllowed},
+		{url: "http://10.1.2.3/a.png", status: http.StatusForbidden, code: codeHostNotAllowed},
+		{url: "http://172.20.0.5/a.png", status: http.StatusForbidden, code: codeHostNotAllowed},
+		{url: "http://192.168.0.10/a.png", status: http.StatusForbidden, code: codeHostNotAllowed},
+		{url: "http://169.254.169.254/latest/meta-data/", status: http.StatusForbidden, code: codeHostNotAllowed},
+		{url: "http://100.100.100.200/a.png", status: http.StatusForbidden, code: codeHostNotAllowed},
+		{url: "http://0.0.0.0/a.png", status: http.StatusForbidden, code: codeHostNotAllowed},
+		{url: "http://[::1]/a.png", status: http.StatusForbidden, code: codeHostNotAllowed},
+		{url: "http://[fe80::1]/a.png", status: http.StatusForbidden, code: codeHostNotAllowed},
+		{url: "http://[fd00::1]/a.png", status: http.StatusForbidden, code: codeHostNotAllowed},
+		{url: "http://[::ffff:127.0.0.1]/a.png", status: http.StatusForbidden, code: codeHostNotAllowed},
+		{url: "http://[::ffff:a9fe:a9fe]/a.png", status: http.StatusForbidden, code: codeHostNotAllowed},
+		// 主机名在 DNS 解析后校验
+		{url: "http://localhost/a.png", status: http.StatusForbidden, code: codeHostNotAllowed},
+	}
+	p := testPolicy()
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.checkFetchURL(context.Background(), u)
+		if tt.code == "" {
+			if err != nil {
+				t.Errorf("%s: %v, want allowed", tt.url, err)
+			}
+			continue
+		}
+		t.Run(tt.url, func(t *testing.T) { wantUploadError(t, err, tt.status, tt.code) })
+	}
+
+	// IMAGE_FETCH_ALLOW_PRIVATE 只放开地址校验，协议白名单仍然生效
+	p.AllowPrivate = true
+	if err := p.checkFetchURL(context.Background(), &url.URL{Scheme: "http", Host: "127.0.0.1"}); err != nil {
+		t.Errorf("allow private: %v", err)
+	}
+	wantUploadError(t, p.checkFetchURL(context.Background(), &url.URL{Scheme: "ftp", Host: "127.0.0.1"}), http.StatusBadRequest, codeSchemeNotAllowed)
+}
+
+func TestFetchClientBlocksInternalDial(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(pngHeader)
+	}))
+	defer srv.Close()
+
+	// 拨号时再次校验实际连接的地址（DNS 重绑定时名称校验通过但连接到内部地址）
+	_, err := testPolicy().fetchClient().Get(srv.URL)
+	var blocked *blockedAddrError
+	if !errors.As(err, &blocked) || !blocked.IP.IsLoopback() {
+		t.Fatalf("Get %s: %v, want blocked loopback dial", srv.URL, err)
+	}
+
+	p := testPolicy()
+	p.AllowPrivate = true
+	resp, err := p.fetchClient().Get(srv.URL)
+	if err != nil {
+		t.Fatalf("allow private: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestFetchClientRedirectToInternalHost(t *testing.T) {
+	client := testPolicy().fetchClient()
+	via := []*http.Request{httptest.NewRequest(http.MethodGet, "http://93.184.216.34/a.png", nil)}
+	for _, target := range []string{"http://169.254.169.254/latest/meta-data/", "http://[::ffff:10.0.0.1]/", "http://localhost:6379/"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		wantUploadError(t, client.CheckRedirect(req, via), http.StatusForbidden, codeHostNotAllowed)
+	}
+	req := httptest.NewRequest(http.MethodGet, "file:///etc/passwd", nil)
+	wantUploadError(t, client.CheckRedirect(req, via), http.StatusBadRequest, codeSchemeNotAllowed)
+	req = httptest.NewRequest(http.MethodGet, "http://93.184.216.35/b.png", nil)
+	if err := client.CheckRedirect(req, via); err != nil {
+		t.Errorf("redirect to public host: %v", err)
+	}
+}
+
+// redirectServer /r/<n> 重定向 n 次后返回 PNG
+func redirectServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/r/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if n > 0 {
+			http.Redirect(w, r, fmt.Sprintf("/r/%d", n-1), http.StatusFound)
+			return
+		}
+		w.Write(pngHeader)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchImageRedirects(t *testing.T) {
+	srv := redirectServer(t)
+	// 测试服务器在回环地址上，放开地址校验后仅验证重定向逻辑
+	p := testPolicy()
+	p.AllowPrivate = true
+
+	// 恰好 MaxRedirects 次重定向：允许
+	resp, err := p.fetchImage(context.Background(), srv.URL+"/r/2")
+	if err != nil {
+		t.Fatalf("2 redirects with max 2: %v", err)
+	}
+	resp.Body.Close()
+
+	// 第 MaxRedirects+1 次重定向（len(via) > MaxRedirects）：拒绝
+	_, err = p.fetchImage(context.Background(), srv.URL+"/r/3")
+	wantUploadError(t, err, http.StatusBadGateway, codeTooManyRedirects)
+
+	p.MaxRedirects = 0
+	_, err = p.fetchImage(context.Background(), srv.URL+"/r/1")
+	wantUploadError(t, err, http.StatusBadGateway, codeTooManyRedirects)
+}
+
+func TestFetchImageRejects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/big":
+			w.Header().Set("Content-Length", "4096")
+			w.Write(bytes.Repeat([]byte{0}, 4096))
+		case "/slow":
+			time.Sleep(500 * time.Millisecond)
+			w.Write(pngHeader)
+		}
+	}))
+	defer srv.Close()
+	p := testPolicy()
+
+	// 默认拒绝回环地址（本地测试服务器）
+	_, err := p.fetchImage(context.Background(), srv.URL+"/missing")
+	wantUploadError(t, err, http.StatusForbidden, codeHostNotAllowed)
+
+	p.AllowPrivate = true
+	_, err = p.fetchImage(context.Background(), srv.URL+"/missing")
+	wantUploadError(t, err, http.StatusBadGateway, codeUpstreamStatus)
+	// Content-Length 超过上限时不读取响应体
+	_, err = p.fetchImage(context.Background(), srv.URL+"/big")
+	wantUploadError(t, err, http.StatusRequestEntityTooLarge, codeFileTooLarge)
+	_, err = p.fetchImage(context.Background(), "::not a url")
+	wantUploadError(t, err, http.StatusBadRequest, codeInvalidURL)
+
+	p.Timeout = 100 * time.Millisecond
+	_, err = p.fetchImage(context.Background(), srv.URL+"/slow")
+	wantUploadError(t, err, http.StatusGatewayTimeout, codeFetchTimeout)
+}
+
+func TestSniffImage(t *testing.T) {
+	p := testPolicy()
+	tests := []struct {
+		name   string
+		body   []byte
+		ct     string
+		ext    string
+		status int
+		code   string
+	}{
+		{name: "png", body: pngHeader, ct: "image/png", ext: ".png"},
+		{name: "jpeg", body: []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), ct: "image/jpeg", ext: ".jpg"},
+		{name: "gif not allowed", body: []byte("GIF89a\x01\x00\x01\x00"), status: http.StatusUnsupportedMediaType, code: codeUnsupportedType},
+		{name: "html", body: []byte("<html><script>alert(1)</script></html>"), status: http.StatusUnsupportedMediaType, code: codeUnsupportedType},
+		{name: "svg", body: []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script/></svg>`), status: http.StatusUnsupportedMediaType, code: codeUnsupportedType},
+		{name: "text with png name", body: []byte("just text"), status: http.StatusUnsupportedMediaType, code: codeUnsupportedType},
+		{name: "empty", body: nil, status: http.StatusBadRequest, code: codeFileRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := p.sniffImage(bytes.NewReader(tt.body))
+			if tt.code != "" {
+				wantUploadError(t, err, tt.status, tt.code)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.ContentType != tt.ct || img.Ext != tt.ext {
+				t.Errorf("sniffed %s %s, want %s %s", img.ContentType, img.Ext, tt.ct, tt.ext)
+			}
+			// Body 从头返回完整内容（含用于嗅探的前 512 字节）
+			data, err := io.ReadAll(img.Body)
+			if err != nil || !bytes.Equal(data, tt.body) {
+				t.Errorf("body = %q, %v; want %q", data, err, tt.body)
+			}
+		})
+	}
+}
+
+func TestSniffImageSizeCap(t *testing.T) {
+	p := testPolicy()
+	for _, size := range []int{100, 600, 1023, 1024, 1025, 5000} {
+		body := append(append([]byte(nil), pngHeader...), bytes.Repeat([]byte{0}, size-len(pngHeader))...)
+		img, err := p.sniffImage(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("size %d: sniff: %v", size, err)
+		}
+		data, err := io.ReadAll(img.Body)
+		if int64(size) <= p.MaxBytes {
+			if err != nil || len(data) != size {
+				t.Errorf("size %d: read %d bytes, %v; want the whole file", size, len(data), err)
+			}
+			continue
+		}
+		wantUploadError(t, err, http.StatusRequestEntityTooLarge, codeFileTooLarge)
+		if int64(len(data)) > p.MaxBytes+1 {
+			t.Errorf("size %d: read %d bytes past the cap", size, len(data))
+		}
+	}
+}
+
+func TestCappedReader(t *testing.T) {
+	tests := []struct {
+		size, limit int
+		tooLarge    bool
+	}{
+		{size: 0, limit: 0},
+		{size: 10, limit: 10},
+		{size: 11, limit: 10, tooLarge: true},
+		{size: 10, limit: 0, tooLarge: true},
+		{size: 100000, limit: 65536, tooLarge: true},
+	}
+	for _, tt := range tests {
+		r := &cappedReader{r: bytes.NewReader(make([]byte, tt.size)), remaining: int64(tt.limit)}
+		data, err := io.ReadAll(r)
+		if !tt.tooLarge {
+			if err != nil || len(data) != tt.size {
+				t.Errorf("size %d limit %d: read %d, %v", tt.size, tt.limit, len(data), err)
+			}
+			continue
+		}
+		var ue *uploadError
+		if !errors.As(err, &ue) || ue.Code != codeFileTooLarge {
+			t.Errorf("size %d limit %d: err = %v, want file_too_large", tt.size, tt.limit, err)
+		}
+		// 超出上限后继续读取仍然返回错误
+		if _, err := r.Read(make([]byte, 8)); err == nil {
+			t.Errorf("size %d limit %d: read after cap succeeded", tt.size, tt.limit)
+		}
+	}
+}
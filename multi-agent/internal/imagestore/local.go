@@ -0,0 +1,302 @@
+package imagestore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// metaDirName 本地存储中元数据子目录
+const metaDirName = ".meta"
+
+// Local 本地目录存储：图片为 <dir>/<id><扩展名>，元数据为 <dir>/.meta/<id>.json。
+// 启动时扫描一次目录建立 ID → 文件名索引，之后按索引查找；索引未命中时重新扫描（兼容外部写入的文件）
+type Local struct {
+	dir string
+
+	mu    sync.Mutex
+	index map[string]string
+}
+
+// NewLocal 打开（必要时创建）本地存储目录；dir 为空时使用 DefaultDir
+func NewLocal(dir string) (*Local, error) {
+	if strings.TrimSpace(dir) == "" {
+		dir = DefaultDir
+	}
+	if err := os.MkdirAll(filepath.Join(dir, metaDirName), 0o755); err != nil {
+		return nil, fmt.Errorf("create image dir: %w", err)
+	}
+	l := &Local{dir: dir}
+	if err := l.rescan(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Backend 返回 local
+func (l *Local) Backend() string { return BackendLocal }
+
+// Dir 返回存储目录
+func (l *Local) Dir() string { return l.dir }
+
+// rescan 重建索引（须持有 mu 或在构造期间调用）
+func (l *Local) rescan() error {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return fmt.Errorf("read image dir: %w", err)
+	}
+	index := make(map[string]string, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		id := strings.TrimSuffix(name, filepath.Ext(name))
+		if ValidID(id) {
+			index[id] = name
+		}
+	}
+	l.index = index
+	return nil
+}
+
+// path 按索引返回图片文件路径；未命中时重新扫描一次
+func (l *Local) path(id string) (string, error) {
+	if !ValidID(id) {
+		return "", ErrInvalidID
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	name, ok := l.index[id]
+	if !ok {
+		if err := l.rescan(); err != nil {
+			return "", err
+		}
+		if name, ok = l.index[id]; !ok {
+			return "", ErrNotFound
+		}
+	}
+	return filepath.Join(l.dir, name), nil
+}
+
+func (l *Local) metaPath(id string) string {
+	return filepath.Join(l.dir, metaDirName, id+".json")
+}
+
+// Put 写入临时文件后重命名，同时计算大小与 SHA-256
+func (l *Local) Put(ctx context.Context, in PutInput, r io.Reader) (Meta, error) {
+	id := strings.TrimSpace(in.ID)
+	if id == "" {
+		id = uuid.New().String()
+	} else if !ValidID(id) {
+		return Meta{}, ErrInvalidID
+	}
+	ext := strings.ToLower(strings.TrimSpace(in.Ext))
+	if ext == "" {
+		ext = extForType(in.ContentType)
+	}
+	tmp, err := os.CreateTemp(l.dir, ".upload-*")
+	if err != nil {
+		return Meta{}, fmt.Errorf("create image file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return Meta{}, fmt.Errorf("write image file: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return Meta{}, err
+	}
+	meta := Meta{
+		ID:          id,
+		ContentType: in.ContentType,
+		Size:        n,
+		SHA256:      hex.EncodeToString(h.Sum(nil)),
+		CreatedAt:   time.Now().UTC(),
+		Owner:       in.Owner,
+	}
+	if meta.ContentType == "" {
+		meta.ContentType = typeForExt(ext)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// 同 ID 覆盖写入时先移除扩展名不同的旧文件
+	if old, ok := l.index[id]; ok && old != id+ext {
+		_ = os.Remove(filepath.Join(l.dir, old))
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(l.dir, id+ext)); err != nil {
+		return Meta{}, fmt.Errorf("save image file: %w", err)
+	}
+	if err := writeJSONFile(l.metaPath(id), meta); err != nil {
+		return Meta{}, err
+	}
+	l.index[id] = id + ext
+	return meta, nil
+}
+
+// Get 打开图片文件
+func (l *Local) Get(ctx context.Context, id string) (io.ReadCloser, Meta, error) {
+	meta, err := l.Stat(ctx, id)
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	path, err := l.path(id)
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, Meta{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	return f, meta, nil
+}
+
+// Stat 读取元数据文件；旧文件（无元数据）按扩展名、文件大小与修改时间补全，并计算 SHA-256
+func (l *Local) Stat(_ context.Context, id string) (Meta, error) {
+	path, err := l.path(id)
+	if err != nil {
+		return Meta{}, err
+	}
+	fi, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		l.forget(id)
+		return Meta{}, ErrNotFound
+	}
+	if err != nil {
+		return Meta{}, err
+	}
+	var meta Meta
+	if data, err := os.ReadFile(l.metaPath(id)); err == nil && json.Unmarshal(data, &meta) == nil && meta.ID == id {
+		return meta, nil
+	}
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return Meta{}, err
+	}
+	return Meta{
+		ID:          id,
+		ContentType: typeForExt(filepath.Ext(path)),
+		Size:        fi.Size(),
+		SHA256:      sum,
+		CreatedAt:   fi.ModTime().UTC(),
+	}, nil
+}
+
+// Delete 删除图片文件与元数据
+func (l *Local) Delete(_ context.Context, id string) error {
+	path, err := l.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); errors.Is(err, os.ErrNotExist) {
+		l.forget(id)
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	_ = os.Remove(l.metaPath(id))
+	l.forget(id)
+	return nil
+}
+
+// List 列出全部图片（按创建时间倒序）
+func (l *Local) List(ctx context.Context, filter ListFilter) ([]Meta, error) {
+	l.mu.Lock()
+	if err := l.rescan(); err != nil {
+		l.mu.Unlock()
+		return nil, err
+	}
+	ids := make([]string, 0, len(l.index))
+	for id := range l.index {
+		ids = append(ids, id)
+	}
+	l.mu.Unlock()
+	metas := make([]Meta, 0, len(ids))
+	for _, id := range ids {
+		meta, err := l.Stat(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if filter.Owner != "" && meta.Owner != filter.Owner {
+			continue
+		}
+		metas = append(metas, meta)
+	}
+	return sortNewest(metas, filter.Limit), nil
+}
+
+func (l *Local) forget(id string) {
+	l.mu.Lock()
+	delete(l.index, id)
+	l.mu.Unlock()
+}
+
+// writeJSONFile 先写临时文件再重命名，避免并发读取到半截内容
+func writeJSONFile(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write image meta: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write image meta: %w", err)
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// typeForExt 按扩展名推断内容类型
+func typeForExt(ext string) string {
+	if ct := mime.TypeByExtension(strings.ToLower(ext)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// extForType 按内容类型推断扩展名（忽略参数，如 "; charset=binary"）
+func extForType(contentType string) string {
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+		if exts, _ := mime.ExtensionsByType(mt); len(exts) > 0 {
+			return strings.ToLower(exts[0])
+		}
+	}
+	return ".bin"
+}
@@ -0,0 +1,396 @@
+package imagestore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// S3 对象的元数据头
+const (
+	s3MetaSHA256  = "X-Amz-Meta-Sha256"
+	s3MetaOwner   = "X-Amz-Meta-Owner"
+	s3MetaCreated = "X-Amz-Meta-Created-At"
+)
+
+// S3Config S3 兼容存储配置
+type S3Config struct {
+	// Endpoint 服务地址（含协议），如 https://s3.us-east-1.amazonaws.com 或 http://127.0.0.1:9000
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Prefix 对象键前缀（如 "images/"）
+	Prefix string
+	// PathStyle 为 true 时使用 <endpoint>/<bucket>/<key> 寻址（MinIO 等），否则使用 <bucket>.<host>/<key>
+	PathStyle bool
+	// Client 为空时使用带超时的默认客户端
+	Client *http.Client
+}
+
+// S3 S3 兼容对象存储：对象键为 <prefix><id>，请求以 AWS Signature V4 签名
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3 校验配置并创建 S3 存储（不访问网络）
+func NewS3(cfg S3Config) (*S3, error) {
+	cfg.Endpoint = strings.TrimRight(strings.TrimSpace(cfg.Endpoint), "/")
+	cfg.Bucket = strings.TrimSpace(cfg.Bucket)
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 image store requires %s and %s", S3EndpointEnv, S3BucketEnv)
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("s3 image store requires %s and %s", S3AccessKeyEnv, S3SecretKeyEnv)
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid %s %q", S3EndpointEnv, cfg.Endpoint)
+	}
+	if strings.TrimSpace(cfg.Region) == "" {
+		cfg.Region = DefaultS3Region
+	}
+	client := cfg.Client
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+	return &S3{cfg: cfg, endpoint: u, client: client}, nil
+}
+
+// Backend 返回 s3
+func (s *S3) Backend() string { return BackendS3 }
+
+// objectURL 返回对象（key 非空）或桶（key 为空）的地址
+func (s *S3) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	base := strings.TrimRight(u.Path, "/")
+	if s.cfg.PathStyle {
+		u.Path = base + "/" + s.cfg.Bucket
+		if key != "" {
+			u.Path += "/" + key
+		}
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = base + "/" + key
+	}
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+func (s *S3) key(id string) string { return s.cfg.Prefix + id }
+
+// do 签名并发送请求；body 为空时按空负载签名
+func (s *S3) do(ctx context.Context, method string, u *url.URL, header http.Header, body []byte) (*http.Response, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), rd)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	signV4(req, body, s.cfg.AccessKey, s.cfg.SecretKey, s.cfg.Region, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// s3Error 读取错误响应（S3 以 XML 返回 Code / Message）
+func s3Error(op string, resp *http.Response) error {
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return fmt.Errorf("s3 %s: %s (%d): %s", op, e.Code, resp.StatusCode, e.Message)
+	}
+	return fmt.Errorf("s3 %s: status %d", op, resp.StatusCode)
+}
+
+// Put 读取全部内容后上传（签名需要负载摘要），元数据写入 x-amz-meta-* 头
+func (s *S3) Put(ctx context.Context, in PutInput, r io.Reader) (Meta, error) {
+	id := strings.TrimSpace(in.ID)
+	if id == "" {
+		id = uuid.New().String()
+	} else if !ValidID(id) {
+		return Meta{}, ErrInvalidID
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return Meta{}, fmt.Errorf("read image: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	meta := Meta{
+		ID:          id,
+		ContentType: in.ContentType,
+		Size:        int64(len(body)),
+		SHA256:      hex.EncodeToString(sum[:]),
+		CreatedAt:   time.Now().UTC(),
+		Owner:       in.Owner,
+	}
+	if meta.ContentType == "" {
+		meta.ContentType = typeForExt(in.Ext)
+	}
+	h := http.Header{}
+	h.Set("Content-Type", meta.ContentType)
+	h.Set(s3MetaSHA256, meta.SHA256)
+	h.Set(s3MetaCreated, meta.CreatedAt.Format(time.RFC3339Nano))
+	if meta.Owner != "" {
+		h.Set(s3MetaOwner, meta.Owner)
+	}
+	resp, err := s.do(ctx, http.MethodPut, s.objectURL(s.key(id), nil), h, body)
+	if err != nil {
+		return Meta{}, fmt.Errorf("s3 put: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return Meta{}, s3Error("put", resp)
+	}
+	return meta, nil
+}
+
+// metaFromHeader 由对象响应头还原元数据
+func metaFromHeader(id string, h http.Header) Meta {
+	meta := Meta{
+		ID:          id,
+		ContentType: h.Get("Content-Type"),
+		SHA256:      h.Get(s3MetaSHA256),
+		Owner:       h.Get(s3MetaOwner),
+	}
+	meta.Size, _ = strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	if t, err := time.Parse(time.RFC3339Nano, h.Get(s3MetaCreated)); err == nil {
+		meta.CreatedAt = t
+	} else if t, err := http.ParseTime(h.Get("Last-Modified")); err == nil {
+		meta.CreatedAt = t.UTC()
+	}
+	return meta
+}
+
+// Get 下载对象
+func (s *S3) Get(ctx context.Context, id string) (io.ReadCloser, Meta, error) {
+	if !ValidID(id) {
+		return nil, Meta{}, ErrInvalidID
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(s.key(id), nil), nil, nil)
+	if err != nil {
+		return nil, Meta{}, fmt.Errorf("s3 get: %w", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, Meta{}, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, Meta{}, s3Error("get", resp)
+	}
+	return resp.Body, metaFromHeader(id, resp.Header), nil
+}
+
+// Stat 以 HEAD 读取对象元数据
+func (s *S3) Stat(ctx context.Context, id string) (Meta, error) {
+	if !ValidID(id) {
+		return Meta{}, ErrInvalidID
+	}
+	resp, err := s.do(ctx, http.MethodHead, s.objectURL(s.key(id), nil), nil, nil)
+	if err != nil {
+		return Meta{}, fmt.Errorf("s3 head: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return Meta{}, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Meta{}, fmt.Errorf("s3 head: status %d", resp.StatusCode)
+	}
+	return metaFromHeader(id, resp.Header), nil
+}
+
+// Delete 删除对象；S3 删除不存在的对象同样返回成功，因此先 HEAD 确认存在
+func (s *S3) Delete(ctx context.Context, id string) error {
+	if _, err := s.Stat(ctx, id); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(s.key(id), nil), nil, nil)
+	if err != nil {
+		return fmt.Errorf("s3 delete: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return s3Error("delete", resp)
+	}
+	return nil
+}
+
+// listBucketResult ListObjectsV2 响应
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List 以 ListObjectsV2 分页列出前缀下的对象，并逐个 HEAD 读取元数据（所有者与摘要仅保存在对象头中）
+func (s *S3) List(ctx context.Context, filter ListFilter) ([]Meta, error) {
+	var metas []Meta
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {s.cfg.Prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, s.objectURL("", q), nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("s3 list: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := s3Error("list", resp)
+			resp.Body.Close()
+			return nil, err
+		}
+		var page listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3 list: %w", err)
+		}
+		for _, c := range page.Contents {
+			id := strings.TrimPrefix(c.Key, s.cfg.Prefix)
+			if !ValidID(id) {
+				continue
+			}
+			meta, err := s.Stat(ctx, id)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if filter.Owner != "" && meta.Owner != filter.Owner {
+				continue
+			}
+			metas = append(metas, meta)
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+	return sortNewest(metas, filter.Limit), nil
+}
+
+// signV4 按 AWS Signature Version 4 为 S3 请求签名：签名 host 与请求中已设置的全部头
+func signV4(req *http.Request, body []byte, accessKey, secretKey, region string, now time.Time) {
+	payloadHash := sha256.Sum256(body)
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.EscapedPath()),
+		s3CanonicalQuery(req.URL.Query()),
+		canonHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	scope := day + "/" + region + "/s3/aws4_request"
+	reqHash := sha256.Sum256([]byte(canonRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(reqHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// s3EscapePath 将 Go 转义后的路径规范为 S3 要求的 URI 编码（仅保留非保留字符与 '/'）
+func s3EscapePath(escaped string) string {
+	p, err := url.PathUnescape(escaped)
+	if err != nil {
+		p = escaped
+	}
+	if p == "" {
+		return "/"
+	}
+	segs := strings.Split(p, "/")
+	for i, s := range segs {
+		segs[i] = s3Escape(s)
+	}
+	return strings.Join(segs, "/")
+}
+
+// s3CanonicalQuery 按键排序并以 S3 规则编码查询参数
+func s3CanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string(nil), q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape 对非保留字符以外的字节做 %XX 编码（大写十六进制）
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,99 @@
+package imagestore
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 签名链接的查询参数：exp 为过期时间（Unix 秒），sig 为 HMAC-SHA256(key, "<id>.<exp>") 的十六进制
+const (
+	ExpiresParam   = "exp"
+	SignatureParam = "sig"
+)
+
+// DefaultURLTTL 签名链接的默认有效期
+const DefaultURLTTL = 15 * time.Minute
+
+var (
+	// ErrInvalidSignature 签名缺失、格式错误或与图片 ID 不匹配
+	ErrInvalidSignature = errors.New("invalid image url signature")
+	// ErrSignatureExpired 签名已过期
+	ErrSignatureExpired = errors.New("image url signature expired")
+)
+
+// URLSigner 为 /api/images/:id 生成与校验短期签名链接：持有链接者在过期前无需凭据即可下载该图片
+// （供 url 模式下的模型服务与浏览器 <img> 使用）；并发安全
+type URLSigner struct {
+	key []byte
+	ttl time.Duration
+	now func() time.Time
+}
+
+// NewURLSigner 创建签名器；key 为空时生成随机密钥（仅本进程签发的链接有效，重启后失效），ttl <= 0 时使用 DefaultURLTTL
+func NewURLSigner(key []byte, ttl time.Duration) *URLSigner {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+	}
+	if ttl <= 0 {
+		ttl = DefaultURLTTL
+	}
+	return &URLSigner{key: append([]byte(nil), key...), ttl: ttl, now: time.Now}
+}
+
+// TTL 返回签名链接的有效期
+func (s *URLSigner) TTL() time.Duration { return s.ttl }
+
+// Sign 返回 id 的过期时间与签名
+func (s *URLSigner) Sign(id string) (time.Time, string) {
+	exp := s.now().Add(s.ttl).Truncate(time.Second)
+	return exp, s.signature(id, exp.Unix())
+}
+
+// SignURL 在 rawURL 的查询参数中写入 id 的过期时间与签名（替换已有的 exp / sig），返回签名链接与过期时间
+func (s *URLSigner) SignURL(rawURL, id string) (string, time.Time, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	exp, sig := s.Sign(id)
+	q := u.Query()
+	q.Set(ExpiresParam, strconv.FormatInt(exp.Unix(), 10))
+	q.Set(SignatureParam, sig)
+	u.RawQuery = q.Encode()
+	return u.String(), exp, nil
+}
+
+// Verify 校验 id 的签名与过期时间（exp 与 sig 为查询参数原值）
+func (s *URLSigner) Verify(id, exp, sig string) error {
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || sig == "" {
+		return ErrInvalidSignature
+	}
+	want, err := hex.DecodeString(s.signature(id, unix))
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, want) {
+		return ErrInvalidSignature
+	}
+	if !s.now().Before(time.Unix(unix, 0)) {
+		return ErrSignatureExpired
+	}
+	return nil
+}
+
+func (s *URLSigner) signature(id string, exp int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(id + "." + strconv.FormatInt(exp, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
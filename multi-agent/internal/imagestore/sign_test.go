@@ -0,0 +1,76 @@
+package imagestore
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestURLSigner(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	s := NewURLSigner([]byte("secret"), 10*time.Minute)
+	s.now = func() time.Time { return now }
+
+	signed, exp, err := s.SignURL("https://agents.example.com/api/images/img-1?exp=1&sig=stale&v=2", "img-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exp.Equal(now.Add(10 * time.Minute)) {
+		t.Errorf("exp = %v", exp)
+	}
+	u, _ := url.Parse(signed)
+	q := u.Query()
+	if u.Path != "/api/images/img-1" || q.Get("v") != "2" || q.Get(ExpiresParam) != strconv.FormatInt(exp.Unix(), 10) || len(q[SignatureParam]) != 1 {
+		t.Fatalf("signed url = %s", signed)
+	}
+	sig := q.Get(SignatureParam)
+
+	// 同一密钥的另一实例（如 CLI 与服务）可校验
+	other := NewURLSigner([]byte("secret"), time.Minute)
+	other.now = s.now
+	tests := []struct {
+		name   string
+		signer *URLSigner
+		id     string
+		exp    string
+		sig    string
+		at     time.Time
+		want   error
+	}{
+		{name: "valid", signer: s, id: "img-1", exp: q.Get(ExpiresParam), sig: sig, at: now},
+		{name: "same key", signer: other, id: "img-1", exp: q.Get(ExpiresParam), sig: sig, at: now.Add(9 * time.Minute)},
+		{name: "other image", signer: s, id: "img-2", exp: q.Get(ExpiresParam), sig: sig, at: now, want: ErrInvalidSignature},
+		{name: "extended expiry", signer: s, id: "img-1", exp: strconv.FormatInt(exp.Unix()+3600, 10), sig: sig, at: now, want: ErrInvalidSignature},
+		{name: "other key", signer: NewURLSigner([]byte("another"), 0), id: "img-1", exp: q.Get(ExpiresParam), sig: sig, at: now, want: ErrInvalidSignature},
+		{name: "missing signature", signer: s, id: "img-1", exp: q.Get(ExpiresParam), at: now, want: ErrInvalidSignature},
+		{name: "malformed signature", signer: s, id: "img-1", exp: q.Get(ExpiresParam), sig: "zz", at: now, want: ErrInvalidSignature},
+		{name: "malformed expiry", signer: s, id: "img-1", exp: "soon", sig: sig, at: now, want: ErrInvalidSignature},
+		{name: "expired", signer: s, id: "img-1", exp: q.Get(ExpiresParam), sig: sig, at: exp, want: ErrSignatureExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at := tt.at
+			tt.signer.now = func() time.Time { return at }
+			if err := tt.signer.Verify(tt.id, tt.exp, tt.sig); !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewURLSignerDefaults(t *testing.T) {
+	a, b := NewURLSigner(nil, 0), NewURLSigner(nil, 0)
+	if a.TTL() != DefaultURLTTL {
+		t.Errorf("TTL = %v", a.TTL())
+	}
+	// 未配置密钥时各实例使用不同的随机密钥
+	exp, sig := a.Sign("img-1")
+	if err := b.Verify("img-1", strconv.FormatInt(exp.Unix(), 10), sig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify with another random key = %v", err)
+	}
+	if err := a.Verify("img-1", strconv.FormatInt(exp.Unix(), 10), sig); err != nil {
+		t.Errorf("Verify = %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package imagestore
+
+// 本包抽象图片存储（上传、URL 导入的图片，供 /api/images 与视觉代理读取）：
+// - ImageStore 接口：Put / Get / Stat / Delete / List，元数据包含内容类型、大小、SHA-256、创建时间与所有者；
+// - local：本地目录（默认 uploads，文件名 <id><扩展名>，元数据写入 .meta/<id>.json，兼容无元数据的旧文件）；
+// - s3：S3 兼容对象存储（AWS S3 / MinIO 等），元数据写入对象的 x-amz-meta-* 头；
+// 后端由 Options.Backend 选择（local / s3）：服务与 CLI 按 config 包的 images.* 配置调用 New，
+// 未传入配置的代码经 Default() 按环境变量（IMAGE_STORE 等）共享同一实例。
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 存储配置的环境变量
+const (
+	// BackendEnv 存储后端：local（默认）/ s3
+	BackendEnv = "IMAGE_STORE"
+	// DirEnv 本地存储目录，默认 DefaultDir
+	DirEnv = "IMAGE_DIR"
+	// S3 兼容存储：端点（如 http://127.0.0.1:9000）、桶、区域、访问密钥、对象键前缀与路径风格寻址
+	S3EndpointEnv  = "IMAGE_S3_ENDPOINT"
+	S3BucketEnv    = "IMAGE_S3_BUCKET"
+	S3RegionEnv    = "IMAGE_S3_REGION"
+	S3AccessKeyEnv = "IMAGE_S3_ACCESS_KEY"
+	S3SecretKeyEnv = "IMAGE_S3_SECRET_KEY"
+	S3PrefixEnv    = "IMAGE_S3_PREFIX"
+	S3PathStyleEnv = "IMAGE_S3_PATH_STYLE"
+)
+
+// 存储后端
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// DefaultDir 默认本地存储目录（相对 multi-agent 工作目录）
+const DefaultDir = "uploads"
+
+// DefaultS3Region 未配置区域时使用的签名区域
+const DefaultS3Region = "us-east-1"
+
+var (
+	// ErrNotFound 指定的图片不存在
+	ErrNotFound = errors.New("image not found")
+	// ErrInvalidID 图片 ID 含有非法字符
+	ErrInvalidID = errors.New("invalid image id")
+)
+
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ValidID 判断图片 ID 是否合法（字母、数字、'-' 与 '_'），避免路径穿越与对象键注入
+func ValidID(id string) bool {
+	return len(id) <= 128 && idPattern.MatchString(id)
+}
+
+// Meta 图片元数据
+type Meta struct {
+	ID          string    `json:"id"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+	Owner       string    `json:"owner,omitempty"`
+}
+
+// PutInput 写入参数；ID 为空时由存储生成，Ext 为本地文件扩展名提示（为空时按内容类型推断）
+type PutInput struct {
+	ID          string
+	ContentType string
+	Owner       string
+	Ext         string
+}
+
+// ListFilter 列表过滤：Owner 非空时仅返回该所有者的图片，Limit <= 0 表示不限
+type ListFilter struct {
+	Owner string
+	Limit int
+}
+
+// ImageStore 图片存储；实现须并发安全
+type ImageStore interface {
+	// Backend 返回后端名称（local / s3）
+	Backend() string
+	// Put 写入图片并返回元数据（大小与 SHA-256 由存储计算）
+	Put(ctx context.Context, in PutInput, r io.Reader) (Meta, error)
+	// Get 返回图片内容与元数据，调用方负责关闭；不存在时返回 ErrNotFound
+	Get(ctx context.Context, id string) (io.ReadCloser, Meta, error)
+	// Stat 返回元数据；不存在时返回 ErrNotFound
+	Stat(ctx context.Context, id string) (Meta, error)
+	// Delete 删除图片；不存在时返回 ErrNotFound
+	Delete(ctx context.Context, id string) error
+	// List 按创建时间倒序列出图片
+	List(ctx context.Context, filter ListFilter) ([]Meta, error)
+}
+
+// Options 存储配置：Backend 为 local（默认）或 s3，Dir 为本地目录，S3 为 s3 后端的配置
+type Options struct {
+	Backend string
+	Dir     string
+	S3      S3Config
+}
+
+// New 按配置创建存储
+func New(opts Options) (ImageStore, error) {
+	switch b := strings.ToLower(strings.TrimSpace(opts.Backend)); b {
+	case "", BackendLocal:
+		return NewLocal(opts.Dir)
+	case BackendS3:
+		return NewS3(opts.S3)
+	default:
+		return nil, fmt.Errorf("unknown image store %q (want %s or %s)", b, BackendLocal, BackendS3)
+	}
+}
+
+// OptionsFromEnv 按环境变量（IMAGE_STORE、IMAGE_DIR、IMAGE_S3_*）读取存储配置
+func OptionsFromEnv() (Options, error) {
+	opts := Options{
+		Backend: os.Getenv(BackendEnv),
+		Dir:     os.Getenv(DirEnv),
+		S3: S3Config{
+			Endpoint:  os.Getenv(S3EndpointEnv),
+			Bucket:    os.Getenv(S3BucketEnv),
+			Region:    os.Getenv(S3RegionEnv),
+			AccessKey: os.Getenv(S3AccessKeyEnv),
+			SecretKey: os.Getenv(S3SecretKeyEnv),
+			Prefix:    os.Getenv(S3PrefixEnv),
+			PathStyle: true,
+		},
+	}
+	if s := strings.TrimSpace(os.Getenv(S3PathStyleEnv)); s != "" {
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s %q", S3PathStyleEnv, s)
+		}
+		opts.S3.PathStyle = v
+	}
+	return opts, nil
+}
+
+// Open 按环境变量创建存储
+func Open() (ImageStore, error) {
+	opts, err := OptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return New(opts)
+}
+
+var (
+	defaultOnce  sync.Once
+	defaultStore ImageStore
+	defaultErr   error
+)
+
+// Default 返回进程级存储（HTTP 服务与视觉代理共享），首次调用时按环境变量创建
+func Default() (ImageStore, error) {
+	defaultOnce.Do(func() {
+		defaultStore, defaultErr = Open()
+	})
+	return defaultStore, defaultErr
+}
+
+// sortNewest 按创建时间倒序排序并截断
+func sortNewest(metas []Meta, limit int) []Meta {
+	sort.SliceStable(metas, func(i, j int) bool {
+		if !metas[i].CreatedAt.Equal(metas[j].CreatedAt) {
+			return metas[i].CreatedAt.After(metas[j].CreatedAt)
+		}
+		return metas[i].ID < metas[j].ID
+	})
+	if limit > 0 && len(metas) > limit {
+		metas = metas[:limit]
+	}
+	return metas
+}
@@ -0,0 +1,55 @@
+package metrics
+
+// 本包提供服务的 Prometheus 指标（GET /metrics）：
+// - run：按最终状态计数的 run 数与正在执行的 run 数（graphproc.ProcessGraph）；
+// - 节点：按代理类型统计的节点耗时直方图（graphproc.processNode）；
+// - 模型：路由与子代理阶段按模型统计的 token（区分上报与估算），以及按错误类型统计的模型调用错误（graphproc 的 runner）；
+// - 服务：当前 SSE 连接数与上传图片占用的存储空间（httpserver）；
+// - Go 运行时（go_*）与进程（process_*）指标，名称与 Prometheus 官方客户端的同类指标一致（见 runtime.go）。
+// 服务指标注册在 Default 中，名称以 multiagent_ 为前缀。
+//
+// 指标由 registry.go 中的最小实现输出，而不是 Prometheus 官方客户端（client_golang + promhttp）：
+// 服务只需要少量带标签的计数器、仪表与直方图和文本暴露格式，几百行代码即可覆盖，
+// 不必为此引入 client_golang 及其 protobuf、procfs 等依赖；标准面板依赖的运行时与进程指标由 runtime.go 以相同名称提供。
+// 暴露格式（转义、直方图的 _bucket / +Inf / _sum / _count）由 registry_test.go 覆盖。
+// 若需要 OpenMetrics、exemplar 或推送网关等功能，应改用 client_golang。
+
+// Default 服务的全部指标
+var Default = NewRegistry()
+
+// 模型调用阶段（stage 标签）
+const (
+	StageRouter   = "router"
+	StageSubAgent = "subagent"
+)
+
+// token 类型（type 标签）
+const (
+	TokenPrompt     = "prompt"
+	TokenCompletion = "completion"
+)
+
+// nodeDurationBuckets 节点耗时的桶上界（秒）：覆盖规则路由 + 缓存命中的毫秒级到多次重试的分钟级
+var nodeDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+var (
+	// RunsTotal 已结束的 run 数，status 为 ok / failed / cancelled / budget_exceeded
+	RunsTotal = Default.NewCounterVec("multiagent_runs_total", "Graph runs finished, by final status.", "status")
+	// RunsActive 正在执行的 run 数
+	RunsActive = Default.NewGaugeVec("multiagent_runs_active", "Graph runs currently executing.")
+	// NodeDuration 节点耗时（含路由、子代理与重试等待），agent 为实际执行的代理类型；未调用代理的节点为 none
+	NodeDuration = Default.NewHistogramVec("multiagent_node_duration_seconds", "Node execution time including routing and retries, by agent kind.", nodeDurationBuckets, "agent")
+	// ModelTokens 模型调用消耗的 token，stage 为 router / subagent，type 为 prompt / completion，source 为 reported / estimated
+	ModelTokens = Default.NewCounterVec("multiagent_model_tokens_total", "Tokens consumed by model calls, by stage, model, token type and usage source.", "stage", "model", "type", "source")
+	// ModelCallErrors 模型调用错误，type 见 graphproc.ModelErrorType
+	ModelCallErrors = Default.NewCounterVec("multiagent_model_call_errors_total", "Model call errors, by stage and error type.", "stage", "type")
+	// SSEConnections 当前打开的 SSE 连接数（图执行与 rerun 的流式输出）
+	SSEConnections = Default.NewGaugeVec("multiagent_sse_connections_active", "Open server-sent event streams.")
+	// UploadStorageBytes 图片存储中图片的总大小
+	UploadStorageBytes = Default.NewGaugeVec("multiagent_upload_storage_bytes", "Total size of images in the image store.")
+)
+
+func init() {
+	RegisterRuntimeMetrics(Default)
+	RegisterProcessMetrics(Default)
+}
@@ -0,0 +1,317 @@
+package metrics
+
+// 本文件实现最小化的 Prometheus 指标：计数器、仪表与直方图（均支持标签）、抓取时求值的函数指标，
+// 以及文本暴露格式（text/plain; version=0.0.4）的输出。所有指标并发安全。
+// 注册时的名称错误属于编程错误，直接 panic；记录时标签取值个数不符只丢弃该样本并记录日志，不影响请求处理。
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"multi-agent/internal/logs"
+)
+
+// ContentType Prometheus 文本暴露格式的 Content-Type
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// 指标类型
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// Registry 一组指标，按注册顺序输出
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+	names   map[string]bool
+}
+
+// NewRegistry 创建空的指标集合
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+// metric 一个指标族：名称、说明、类型、标签名，以及各标签取值组合对应的序列
+type metric struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+	// fn 函数指标在抓取时求值；ok 为 false 时不输出该指标（如当前平台不支持）
+	fn func() (v float64, ok bool)
+
+	mu     sync.Mutex
+	series map[string]*series
+	// dropped 因标签取值个数不符而丢弃的样本数；仅首次丢弃时记录日志
+	dropped uint64
+}
+
+// series 一组标签取值对应的数据；直方图的 counts 为各桶（不累计）的观测数
+type series struct {
+	values []string
+	value  float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// register 注册指标；名称重复或不合法时 panic（属于编程错误）
+func (r *Registry) register(name, help, typ string, buckets []float64, labels []string) *metric {
+	if !validName(name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	for _, l := range labels {
+		if !validName(l) || l == "le" {
+			panic(fmt.Sprintf("metrics: invalid label name %q for %s", l, name))
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metrics: duplicate metric %q", name))
+	}
+	r.names[name] = true
+	m := &metric{name: name, help: help, typ: typ, labels: labels, buckets: buckets, series: map[string]*series{}}
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// with 返回标签取值对应的序列（不存在时创建），调用方须持有 m.mu。
+// 标签取值个数与注册时不符时返回 nil，调用方丢弃该样本。
+func (m *metric) with(values []string) *series {
+	if len(values) != len(m.labels) {
+		m.dropped++
+		if m.dropped == 1 {
+			logs.Errorf("[metrics] %s expects %d label values, got %d %q; dropping such samples", m.name, len(m.labels), len(values), values)
+		}
+		return nil
+	}
+	key := strings.Join(values, "\xff")
+	s := m.series[key]
+	if s == nil {
+		s = &series{values: append([]string(nil), values...)}
+		if m.typ == typeHistogram {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// CounterVec 只增不减的计数器
+type CounterVec struct{ m *metric }
+
+// NewCounterVec 注册计数器；labels 为标签名
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{m: r.register(name, help, typeCounter, nil, labels)}
+}
+
+// Inc 计数加 1
+func (c *CounterVec) Inc(values ...string) { c.Add(1, values...) }
+
+// Add 计数加 v；v 为负数时忽略
+func (c *CounterVec) Add(v float64, values ...string) {
+	if v < 0 {
+		return
+	}
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	if s := c.m.with(values); s != nil {
+		s.value += v
+	}
+}
+
+// GaugeVec 可增可减的仪表
+type GaugeVec struct{ m *metric }
+
+// NewGaugeVec 注册仪表；labels 为标签名
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{m: r.register(name, help, typeGauge, nil, labels)}
+}
+
+// Set 设置当前值
+func (g *GaugeVec) Set(v float64, values ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	if s := g.m.with(values); s != nil {
+		s.value = v
+	}
+}
+
+// Add 当前值加 v（v 可为负）
+func (g *GaugeVec) Add(v float64, values ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	if s := g.m.with(values); s != nil {
+		s.value += v
+	}
+}
+
+// Inc 当前值加 1
+func (g *GaugeVec) Inc(values ...string) { g.Add(1, values...) }
+
+// Dec 当前值减 1
+func (g *GaugeVec) Dec(values ...string) { g.Add(-1, values...) }
+
+// NewGaugeFunc 注册无标签的仪表，其值在每次抓取时由 fn 求得；fn 返回 ok 为 false 时不输出该指标
+func (r *Registry) NewGaugeFunc(name, help string, fn func() (float64, bool)) {
+	r.register(name, help, typeGauge, nil, nil).fn = fn
+}
+
+// NewCounterFunc 注册无标签的计数器，其值在每次抓取时由 fn 求得（须单调不减，如进程累计 CPU 时间）
+func (r *Registry) NewCounterFunc(name, help string, fn func() (float64, bool)) {
+	r.register(name, help, typeCounter, nil, nil).fn = fn
+}
+
+// HistogramVec 按桶统计观测值的直方图
+type HistogramVec struct{ m *metric }
+
+// NewHistogramVec 注册直方图；buckets 为升序的桶上界（+Inf 自动追加）
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &HistogramVec{m: r.register(name, help, typeHistogram, b, labels)}
+}
+
+// Observe 记录一次观测值
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+	s := h.m.with(values)
+	if s == nil {
+		return
+	}
+	if i := sort.SearchFloat64s(h.m.buckets, v); i < len(s.counts) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+// WriteText 按 Prometheus 文本暴露格式输出全部指标；无标签的指标在未记录时输出 0
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]*metric(nil), r.metrics...)
+	r.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler 返回输出全部指标的 HTTP 处理器
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		_ = r.WriteText(w)
+	})
+}
+
+// write 输出一个指标族（序列按标签取值排序，保证输出稳定）
+func (m *metric) write(w *bufio.Writer) {
+	if m.fn != nil {
+		v, ok := m.fn()
+		if ok {
+			fmt.Fprintf(w, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+			fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+			fmt.Fprintf(w, "%s %s\n", m.name, formatFloat(v))
+		}
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+	if len(m.labels) == 0 {
+		m.with(nil)
+	}
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := m.series[k]
+		if m.typ != typeHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, m.labelString(s.values, ""), formatFloat(s.value))
+			continue
+		}
+		var cum uint64
+		for i, ub := range m.buckets {
+			cum += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.labelString(s.values, formatFloat(ub)), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.labelString(s.values, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, m.labelString(s.values, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, m.labelString(s.values, ""), s.count)
+	}
+}
+
+// labelString 生成 {a="x",b="y"}；le 非空时追加直方图桶标签
+func (m *metric) labelString(values []string, le string) string {
+	if len(values) == 0 && le == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", m.labels[i], escapeLabel(v))
+	}
+	if le != "" {
+		if len(values) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "le=\"%s\"", le)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// formatFloat 按暴露格式输出浮点数
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// validName 指标与标签名须匹配 [a-zA-Z_][a-zA-Z0-9_]*
+func validName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		ok := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9')
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,273 @@
+package metrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func writeText(t *testing.T, r *Registry) string {
+	t.Helper()
+	var sb strings.Builder
+	if err := r.WriteText(&sb); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	return sb.String()
+}
+
+func TestWriteTextExposition(t *testing.T) {
+	r := NewRegistry()
+	runs := r.NewCounterVec("test_runs_total", "Runs finished.\nBy status, with a \\ backslash.", "status")
+	active := r.NewGaugeVec("test_active", "Active runs.")
+	tokens := r.NewCounterVec("test_tokens_total", "Tokens.", "stage", "model")
+	latency := r.NewHistogramVec("test_latency_seconds", "Latency.", []float64{1, 0.1, 0.5}, "agent")
+	r.NewGaugeVec("test_idle", "Never set, has labels.", "kind")
+
+	runs.Inc("ok")
+	runs.Add(2, "failed")
+	runs.Inc("ok")
+	runs.Add(-5, "ok") // 计数器忽略负增量
+	active.Inc()
+	active.Inc()
+	active.Dec()
+	tokens.Add(1.5, "router", `m"1\n`)
+	tokens.Add(3, "subagent", "gpt\nx")
+	latency.Observe(0.05, "text")
+	latency.Observe(0.1, "text") // 等于桶上界时计入该桶（le 语义）
+	latency.Observe(0.7, "text")
+	latency.Observe(7, "text")
+	latency.Observe(0.3, "vision")
+
+	want := `# HELP test_runs_total Runs finished.\nBy status, with a \\ backslash.
+# TYPE test_runs_total counter
+test_runs_total{status="failed"} 2
+test_runs_total{status="ok"} 2
+# HELP test_active Active runs.
+# TYPE test_active gauge
+test_active 1
+# HELP test_tokens_total Tokens.
+# TYPE test_tokens_total counter
+test_tokens_total{stage="router",model="m\"1\\n"} 1.5
+test_tokens_total{stage="subagent",model="gpt\nx"} 3
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{agent="text",le="0.1"} 2
+test_latency_seconds_bucket{agent="text",le="0.5"} 2
+test_latency_seconds_bucket{agent="text",le="1"} 3
+test_latency_seconds_bucket{agent="text",le="+Inf"} 4
+test_latency_seconds_sum{agent="text"} 7.85
+test_latency_seconds_count{agent="text"} 4
+test_latency_seconds_bucket{agent="vision",le="0.1"} 0
+test_latency_seconds_bucket{agent="vision",le="0.5"} 1
+test_latency_seconds_bucket{agent="vision",le="1"} 1
+test_latency_seconds_bucket{agent="vision",le="+Inf"} 1
+test_latency_seconds_sum{agent="vision"} 0.3
+test_latency_seconds_count{agent="vision"} 1
+# HELP test_idle Never set, has labels.
+# TYPE test_idle gauge
+`
+	if got := writeText(t, r); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// sampleLine 文本暴露格式的样本行：名称、可选标签集与数值
+var sampleLine = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\{([a-zA-Z_][a-zA-Z0-9_]*="([^"\\\n]|\\[\\"n])*",?)*\})? ([-+]?[0-9.e+-]+|[+-]Inf|NaN)$`)
+
+func TestDefaultRegistryExposition(t *testing.T) {
+	// 服务的全部指标都须能被 Prometheus 解析：每个指标族先 HELP 再 TYPE，样本行格式合法
+	RunsTotal.Inc("ok")
+	NodeDuration.Observe(0.2, "text")
+	ModelTokens.Add(10, StageSubAgent, "model \"x\"", TokenPrompt, "reported")
+	out := writeText(t, Default)
+	families := map[string]bool{}
+	var lastHelp string
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "# HELP "):
+			lastHelp = strings.Fields(line)[2]
+		case strings.HasPrefix(line, "# TYPE "):
+			f := strings.Fields(line)
+			if len(f) != 4 || f[2] != lastHelp {
+				t.Errorf("TYPE without preceding HELP: %q", line)
+			}
+			if families[f[2]] {
+				t.Errorf("metric family %s written twice", f[2])
+			}
+			families[f[2]] = true
+		default:
+			if !sampleLine.MatchString(line) {
+				t.Errorf("malformed sample line: %q", line)
+			}
+			if !strings.HasPrefix(line, "multiagent_") && !strings.HasPrefix(line, "go_") && !strings.HasPrefix(line, "process_") {
+				t.Errorf("sample without multiagent_, go_ or process_ prefix: %q", line)
+			}
+		}
+	}
+	want := []string{"multiagent_runs_total", "multiagent_runs_active", "multiagent_node_duration_seconds", "multiagent_model_tokens_total", "multiagent_model_call_errors_total", "multiagent_sse_connections_active", "multiagent_upload_storage_bytes",
+		"go_info", "go_goroutines", "go_threads", "go_memstats_alloc_bytes", "go_memstats_heap_inuse_bytes", "go_gc_cycles_total"}
+	if runtime.GOOS == "linux" {
+		want = append(want, "process_cpu_seconds_total", "process_resident_memory_bytes", "process_open_fds", "process_max_fds", "process_start_time_seconds")
+	}
+	for _, name := range want {
+		if !families[name] {
+			t.Errorf("metric %s missing from /metrics", name)
+		}
+	}
+}
+
+func TestLabelMismatchDropsSample(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_calls_total", "Calls.", "stage", "type")
+	g := r.NewGaugeVec("test_level", "Level.", "pool")
+	h := r.NewHistogramVec("test_size", "Size.", []float64{1}, "kind")
+
+	c.Inc("router", "timeout")
+	// 标签个数不符：不 panic，丢弃样本，已有序列不受影响
+	c.Inc("router")
+	c.Add(3, "router", "timeout", "extra")
+	g.Set(5)
+	g.Inc("a", "b")
+	h.Observe(0.5)
+	h.Observe(0.5, "img")
+
+	want := `# HELP test_calls_total Calls.
+# TYPE test_calls_total counter
+test_calls_total{stage="router",type="timeout"} 1
+# HELP test_level Level.
+# TYPE test_level gauge
+# HELP test_size Size.
+# TYPE test_size histogram
+test_size_bucket{kind="img",le="1"} 1
+test_size_bucket{kind="img",le="+Inf"} 1
+test_size_sum{kind="img"} 0.5
+test_size_count{kind="img"} 1
+`
+	if got := writeText(t, r); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+	if c.m.dropped != 2 || g.m.dropped != 2 || h.m.dropped != 1 {
+		t.Errorf("dropped = %d/%d/%d, want 2/2/1", c.m.dropped, g.m.dropped, h.m.dropped)
+	}
+}
+
+func TestRegisterRejectsProgrammingErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		reg  func(r *Registry)
+	}{
+		{"invalid metric name", func(r *Registry) { r.NewCounterVec("bad-name", "x") }},
+		{"leading digit", func(r *Registry) { r.NewCounterVec("1abc", "x") }},
+		{"invalid label", func(r *Registry) { r.NewGaugeVec("ok_name", "x", "bad label") }},
+		{"reserved le label", func(r *Registry) { r.NewHistogramVec("ok_name", "x", nil, "le") }},
+		{"duplicate", func(r *Registry) { r.NewCounterVec("dup", "x"); r.NewGaugeVec("dup", "y") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("register did not panic")
+				}
+			}()
+			tt.reg(NewRegistry())
+		})
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	for v, want := range map[float64]string{0: "0", 1.5: "1.5", 1e21: "1e+21", -2: "-2", math.Inf(1): "+Inf", math.Inf(-1): "-Inf", math.NaN(): "NaN"} {
+		if got := formatFloat(v); got != want {
+			t.Errorf("formatFloat(%v) = %q, want %q", v, got, want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_total", "Total.").Inc()
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "\ntest_total 1\n") {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestFuncMetrics(t *testing.T) {
+	r := NewRegistry()
+	level := 2.5
+	r.NewGaugeFunc("test_level", "Level.", func() (float64, bool) { return level, true })
+	r.NewGaugeFunc("test_unsupported", "Not available here.", func() (float64, bool) { return 0, false })
+	r.NewCounterFunc("test_ticks_total", "Ticks.", func() (float64, bool) { return 7, true })
+	level = 3
+	// 抓取时求值；ok 为 false 的指标连同 HELP / TYPE 一起省略
+	want := `# HELP test_level Level.
+# TYPE test_level gauge
+test_level 3
+# HELP test_ticks_total Ticks.
+# TYPE test_ticks_total counter
+test_ticks_total 7
+`
+	if got := writeText(t, r); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestProcessMetrics(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		// comm 含空格与括号；utime=250 stime=150 starttime=12345 vsize=1048576 rss=300
+		"self/stat":   "4242 (agent (x) srv) S 1 4242 4242 0 -1 4194304 100 0 0 0 250 150 0 0 20 0 12 0 12345 1048576 300 18446744073709551615\n",
+		"stat":        "cpu  1 2 3 4\nbtime 1760000000\nprocesses 10\n",
+		"self/limits": "Limit                     Soft Limit           Hard Limit           Units\nMax cpu time              unlimited            unlimited            seconds\nMax open files            1024                 524288               files\n",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "self", "fd"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, fd := range []string{"0", "1", "2"} {
+		if err := os.WriteFile(filepath.Join(dir, "self", "fd", fd), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := procDir
+	t.Cleanup(func() { procDir = old })
+	procDir = dir
+
+	r := NewRegistry()
+	RegisterProcessMetrics(r)
+	out := writeText(t, r)
+	for _, line := range []string{
+		"# TYPE process_cpu_seconds_total counter\nprocess_cpu_seconds_total 4\n",
+		"process_resident_memory_bytes " + formatFloat(float64(300*os.Getpagesize())) + "\n",
+		"process_virtual_memory_bytes 1.048576e+06\n",
+		"process_start_time_seconds 1.76000012345e+09\n",
+		"process_open_fds 3\n",
+		"process_max_fds 1024\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+
+	// 没有 /proc 时不输出进程指标
+	procDir = filepath.Join(dir, "missing")
+	if out := writeText(t, r); out != "" {
+		t.Errorf("process metrics without /proc:\n%s", out)
+	}
+}
@@ -0,0 +1,185 @@
+package metrics
+
+// 本文件提供 Go 运行时与进程指标，名称与含义与 Prometheus 官方客户端的 Go / process collector 一致，
+// 以便直接使用现成的面板与告警规则：
+// - go_*：goroutine 与线程数、堆与 GC 统计（runtime.ReadMemStats，每秒最多读取一次，同一次抓取共享快照）；
+// - process_*：CPU 时间、常驻与虚拟内存、打开的文件描述符及上限、启动时间，读取自 /proc/self；
+//   没有 /proc 的平台（如 macOS、Windows）不输出这些指标。
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memStatsMaxAge 运行时统计快照的有效期：ReadMemStats 会短暂暂停所有 goroutine，同一次抓取的各指标共享快照
+const memStatsMaxAge = time.Second
+
+// memStats 缓存的运行时内存统计
+type memStats struct {
+	mu    sync.Mutex
+	at    time.Time
+	stats runtime.MemStats
+}
+
+// gauge 返回读取快照中某一字段的函数指标
+func (s *memStats) gauge(field func(m *runtime.MemStats) float64) func() (float64, bool) {
+	return func() (float64, bool) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if now := time.Now(); now.Sub(s.at) > memStatsMaxAge {
+			runtime.ReadMemStats(&s.stats)
+			s.at = now
+		}
+		return field(&s.stats), true
+	}
+}
+
+// RegisterRuntimeMetrics 在 r 中注册 Go 运行时指标（go_*）
+func RegisterRuntimeMetrics(r *Registry) {
+	r.NewGaugeVec("go_info", "Information about the Go environment.", "version").Set(1, runtime.Version())
+	r.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() (float64, bool) {
+		return float64(runtime.NumGoroutine()), true
+	})
+	threads := pprof.Lookup("threadcreate")
+	r.NewGaugeFunc("go_threads", "Number of OS threads created.", func() (float64, bool) {
+		return float64(threads.Count()), true
+	})
+	ms := &memStats{}
+	r.NewGaugeFunc("go_memstats_alloc_bytes", "Number of bytes allocated in heap and currently in use.",
+		ms.gauge(func(m *runtime.MemStats) float64 { return float64(m.Alloc) }))
+	r.NewGaugeFunc("go_memstats_sys_bytes", "Number of bytes obtained from system.",
+		ms.gauge(func(m *runtime.MemStats) float64 { return float64(m.Sys) }))
+	r.NewGaugeFunc("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.",
+		ms.gauge(func(m *runtime.MemStats) float64 { return float64(m.HeapInuse) }))
+	r.NewGaugeFunc("go_memstats_heap_objects", "Number of currently allocated objects.",
+		ms.gauge(func(m *runtime.MemStats) float64 { return float64(m.HeapObjects) }))
+	r.NewGaugeFunc("go_memstats_next_gc_bytes", "Number of heap bytes when next garbage collection will take place.",
+		ms.gauge(func(m *runtime.MemStats) float64 { return float64(m.NextGC) }))
+	r.NewGaugeFunc("go_memstats_last_gc_time_seconds", "Number of seconds since 1970 of last garbage collection.",
+		ms.gauge(func(m *runtime.MemStats) float64 { return float64(m.LastGC) / 1e9 }))
+	r.NewCounterFunc("go_gc_cycles_total", "Number of completed GC cycles.",
+		ms.gauge(func(m *runtime.MemStats) float64 { return float64(m.NumGC) }))
+	r.NewCounterFunc("go_gc_pause_seconds_total", "Total time spent in stop-the-world GC pauses.",
+		ms.gauge(func(m *runtime.MemStats) float64 { return float64(m.PauseTotalNs) / 1e9 }))
+}
+
+// procDir 进程信息目录（测试时可替换）
+var procDir = "/proc"
+
+// userHZ /proc/<pid>/stat 中 CPU 时间的时钟频率（Linux 上几乎总是 100，与官方客户端的假设相同）
+const userHZ = 100
+
+// RegisterProcessMetrics 在 r 中注册进程指标（process_*）；读取失败（如没有 /proc）时不输出
+func RegisterProcessMetrics(r *Registry) {
+	r.NewCounterFunc("process_cpu_seconds_total", "Total user and system CPU time spent in seconds.", func() (float64, bool) {
+		f, ok := procStat()
+		if !ok {
+			return 0, false
+		}
+		utime, err1 := strconv.ParseFloat(f[11], 64)
+		stime, err2 := strconv.ParseFloat(f[12], 64)
+		return (utime + stime) / userHZ, err1 == nil && err2 == nil
+	})
+	r.NewGaugeFunc("process_resident_memory_bytes", "Resident memory size in bytes.", func() (float64, bool) {
+		f, ok := procStat()
+		if !ok {
+			return 0, false
+		}
+		pages, err := strconv.ParseFloat(f[21], 64)
+		return pages * float64(os.Getpagesize()), err == nil
+	})
+	r.NewGaugeFunc("process_virtual_memory_bytes", "Virtual memory size in bytes.", func() (float64, bool) {
+		f, ok := procStat()
+		if !ok {
+			return 0, false
+		}
+		v, err := strconv.ParseFloat(f[20], 64)
+		return v, err == nil
+	})
+	r.NewGaugeFunc("process_start_time_seconds", "Start time of the process since unix epoch in seconds.", func() (float64, bool) {
+		f, ok := procStat()
+		if !ok {
+			return 0, false
+		}
+		ticks, err := strconv.ParseFloat(f[19], 64)
+		boot, ok := bootTime()
+		return boot + ticks/userHZ, err == nil && ok
+	})
+	r.NewGaugeFunc("process_open_fds", "Number of open file descriptors.", func() (float64, bool) {
+		entries, err := os.ReadDir(procDir + "/self/fd")
+		if err != nil {
+			return 0, false
+		}
+		return float64(len(entries)), true
+	})
+	r.NewGaugeFunc("process_max_fds", "Maximum number of open file descriptors.", func() (float64, bool) {
+		return maxFDs()
+	})
+}
+
+// procStat 返回 /proc/self/stat 中进程名（comm）之后的字段：下标 0 为第 3 个字段（state）
+func procStat() ([]string, bool) {
+	data, err := os.ReadFile(procDir + "/self/stat")
+	if err != nil {
+		return nil, false
+	}
+	// comm 可能含空格与括号，从最后一个 ')' 之后开始切分
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return nil, false
+	}
+	f := strings.Fields(string(data[i+1:]))
+	if len(f) < 22 {
+		return nil, false
+	}
+	return f, true
+}
+
+// bootTime 返回系统启动时间（/proc/stat 的 btime，Unix 秒）
+func bootTime() (float64, bool) {
+	data, err := os.ReadFile(procDir + "/stat")
+	if err != nil {
+		return 0, false
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "btime "); ok {
+			n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+// maxFDs 返回 /proc/self/limits 中打开文件数的软限制；unlimited 时为 +Inf
+func maxFDs() (float64, bool) {
+	data, err := os.ReadFile(procDir + "/self/limits")
+	if err != nil {
+		return 0, false
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		v, ok := strings.CutPrefix(sc.Text(), "Max open files")
+		if !ok {
+			continue
+		}
+		f := strings.Fields(v)
+		if len(f) == 0 {
+			return 0, false
+		}
+		if f[0] == "unlimited" {
+			return math.Inf(1), true
+		}
+		n, err := strconv.ParseFloat(f[0], 64)
+		return n, err == nil
+	}
+	return 0, false
+}
@@ -8,17 +8,27 @@ import (
 
 type SimpleNode struct {
     ID      string          `json:"id"`
+    // Type is the board module type (ExportNode.Type), used to pick a type-specific agent
+    Type    string          `json:"type,omitempty"`
     Payload json.RawMessage `json:"payload,omitempty"`
 }
 
 type SimpleEdge struct {
 	From string `json:"from"`
 	To   string `json:"to"`
+	// Color is the edge color drawn on the board (informational)
+	Color string `json:"color,omitempty"`
+	// Intent tells the executor how the predecessor output should be used (critique, expand, translate, contrast, ...)
+	Intent string `json:"intent,omitempty"`
+	// Params carries intent-specific parameters, e.g. {"target_lang":"en"} for translate
+	Params map[string]interface{} `json:"params,omitempty"`
 }
 
 type SimpleGraph struct {
 	Nodes []SimpleNode `json:"nodes"`
 	Edges []SimpleEdge `json:"edges"`
+	// Options optional per-graph execution overrides (retry policy, ...)
+	Options *GraphOptions `json:"options,omitempty"`
 }
 
 // BuildSimpleGraph converts Canonical into SimpleGraph using only enabled nodes
@@ -33,7 +43,7 @@ func BuildSimpleGraph(c Canonical) SimpleGraph {
         if !n.Enabled {
             continue
         }
-        sn := SimpleNode{ID: id, Payload: n.RawPayload}
+        sn := SimpleNode{ID: id, Type: n.Type, Payload: n.RawPayload}
         sg.Nodes = append(sg.Nodes, sn)
         enabled[id] = struct{}{}
     }
@@ -45,7 +55,7 @@ func BuildSimpleGraph(c Canonical) SimpleGraph {
 		if _, ok := enabled[e.To]; !ok {
 			continue
 		}
-		sg.Edges = append(sg.Edges, SimpleEdge{From: e.From, To: e.To})
+		sg.Edges = append(sg.Edges, SimpleEdge{From: e.From, To: e.To, Color: e.Color, Intent: e.Intent, Params: e.Params})
 	}
 
 	return sg
@@ -49,8 +49,11 @@ type Node struct {
 }
 
 type Edge struct {
-	From string
-	To   string
+	From   string
+	To     string
+	Color  string
+	Intent string                 // normalized edge intent (see EdgeIntent), empty = plain predecessor output
+	Params map[string]interface{} // intent parameters (e.g. target language for translate)
 }
 
 type Canonical struct {
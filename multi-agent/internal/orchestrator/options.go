@@ -0,0 +1,39 @@
+package orchestrator
+
+// Graph-level execution options carried alongside a SimpleGraph.
+// All fields are optional; the executor (graphproc) merges them over its run defaults.
+
+// RetryPolicy describes how a node's model calls are retried on transient errors.
+// Zero values mean "inherit from the enclosing policy"; a negative numeric value
+// explicitly turns the setting off (e.g. jitter -1 disables jitter even if the
+// enclosing policy sets it). MaxAttempts cannot go below 1.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one
+	MaxAttempts int `json:"max_attempts,omitempty"`
+	// InitialBackoffMs is the delay before the second attempt
+	InitialBackoffMs int `json:"initial_backoff_ms,omitempty"`
+	// MaxBackoffMs caps the exponential backoff delay
+	MaxBackoffMs int `json:"max_backoff_ms,omitempty"`
+	// Multiplier grows the delay after each failed attempt
+	Multiplier float64 `json:"multiplier,omitempty"`
+	// Jitter randomizes each delay by +/- Jitter (0..1) of its value
+	Jitter float64 `json:"jitter,omitempty"`
+	// AttemptTimeoutMs bounds a single attempt; 0 means no per-attempt timeout
+	AttemptTimeoutMs int `json:"attempt_timeout_ms,omitempty"`
+	// RetryOn lists extra error substrings treated as retryable
+	RetryOn []string `json:"retry_on,omitempty"`
+}
+
+// GraphOptions holds per-graph overrides of run settings
+type GraphOptions struct {
+	// Name labels the graph in run history and cost reports; unnamed graphs are grouped by a digest of their structure
+	Name string `json:"name,omitempty"`
+	// Retry overrides the run-level retry policy for every node of this graph
+	Retry *RetryPolicy `json:"retry,omitempty"`
+	// NodeRetry overrides the retry policy of individual nodes, keyed by node id
+	NodeRetry map[string]RetryPolicy `json:"node_retry,omitempty"`
+	// PromptVersion selects the prompt template version for this graph; the run-level version wins
+	PromptVersion string `json:"prompt_version,omitempty"`
+	// Language selects the prompt language pack (e.g. "zh", "en"); the run level and node payload.language win
+	Language string `json:"language,omitempty"`
+}
@@ -47,12 +47,36 @@ func ParseBoardExport(filePath string) (Canonical, error) {
 		if e.From == "" || e.To == "" {
 			continue
 		}
-		canon.Edges = append(canon.Edges, Edge{From: e.From, To: e.To})
+		canon.Edges = append(canon.Edges, Edge{From: e.From, To: e.To, Color: e.Color, Intent: EdgeIntent(e.Intent), Params: EdgeParams(e.Params)})
 	}
 
 	return canon, nil
 }
 
+// EdgeIntent normalizes ExportEdge.Intent, which the board may send either as a
+// plain string or as an object such as {"type":"critique"}; the result is lower-cased.
+func EdgeIntent(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return strings.ToLower(strings.TrimSpace(t))
+	case map[string]interface{}:
+		for _, k := range []string{"type", "name", "kind", "intent"} {
+			if s, ok := t[k].(string); ok && strings.TrimSpace(s) != "" {
+				return strings.ToLower(strings.TrimSpace(s))
+			}
+		}
+	}
+	return ""
+}
+
+// EdgeParams keeps ExportEdge.Params when it is a JSON object; other values are dropped.
+func EdgeParams(v interface{}) map[string]interface{} {
+	if m, ok := v.(map[string]interface{}); ok && len(m) > 0 {
+		return m
+	}
+	return nil
+}
+
 // extractText pulls meaningful string values from payload while avoiding binary fields.
 func extractText(payload map[string]interface{}) string {
 	if payload == nil {
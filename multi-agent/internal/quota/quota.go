@@ -0,0 +1,361 @@
+package quota
+
+// 本包按用户记录 token 用量（按自然日与自然月累计，持久化到数据目录下的 JSON 文件），并执行日/月额度：
+// - 执行前 Check：额度已用尽时拒绝新的 run；
+// - 执行中 Account 作为 graphproc.TokenQuota：每次模型调用前 Reserve 原子地检查并预留估算用量，
+//   调用结束后 Settle 释放预留并计入实际用量；同一用户并发执行的多个 run 共享同一额度，
+//   进行中调用的预留互相可见，不会因同时通过检查而共同超额；
+// - Usage / All 报告用量与剩余额度（GET /api/usage）。
+// 用量在内存中累计，记账只标记为待写入；StartFlusher 定期写回文件，Close 停止定期写入并写回剩余用量
+// （服务关闭时调用），因此每次模型调用结清时不会在锁内重写整个文件。进程异常退出时最多丢失一个写入周期的用量。
+// 未配置额度时仍记录用量，只是不做限制。日期按服务器本地时区划分。
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"multi-agent/internal/logs"
+)
+
+// 用量文件中日期与月份的格式
+const (
+	dayLayout   = "2006-01-02"
+	monthLayout = "2006-01"
+)
+
+// DefaultFlushInterval 定期写回用量文件的默认间隔
+const DefaultFlushInterval = 5 * time.Second
+
+// ErrQuotaExceeded 用户的日或月额度已用尽
+var ErrQuotaExceeded = errors.New("token quota exceeded")
+
+// Limits 每个用户的 token 额度；0 表示不限
+type Limits struct {
+	DailyTokens   int `json:"daily_tokens"`
+	MonthlyTokens int `json:"monthly_tokens"`
+}
+
+// Period 一个计量周期（日或月）的用量
+type Period struct {
+	// Period 周期标识：日为 2006-01-02，月为 2006-01
+	Period string `json:"period"`
+	Used   int    `json:"used"`
+	// Limit 额度；0 表示不限，此时不返回 Remaining
+	Limit     int  `json:"limit"`
+	Remaining *int `json:"remaining,omitempty"`
+}
+
+// Usage 用户当前的日/月用量
+type Usage struct {
+	User    string `json:"user"`
+	Daily   Period `json:"daily"`
+	Monthly Period `json:"monthly"`
+	// Exceeded 任一额度已用尽（新的 run 将被拒绝）
+	Exceeded bool `json:"exceeded"`
+}
+
+// userUsage 一个用户的历史用量：日期 / 月份 → token
+type userUsage struct {
+	Daily   map[string]int `json:"daily"`
+	Monthly map[string]int `json:"monthly"`
+}
+
+// usageFile 用量文件内容
+type usageFile struct {
+	Users map[string]*userUsage `json:"users"`
+}
+
+// Store 用户用量与额度，可被多个 goroutine 并发使用
+type Store struct {
+	path   string
+	limits Limits
+	now    func() time.Time
+
+	mu    sync.Mutex
+	users map[string]*userUsage
+	// reserved 各用户进行中的模型调用预留的 token（仅在内存中，不写入文件）
+	reserved map[string]int
+	// dirty 有尚未写回文件的用量
+	dirty bool
+
+	// saveMu 串行化文件写入（写入时不持有 mu）
+	saveMu sync.Mutex
+	// stop / done 定期写入的 goroutine（见 StartFlusher）
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open 读取（不存在时创建）用量文件
+func Open(path string, limits Limits) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create usage dir: %w", err)
+	}
+	s := &Store{path: path, limits: limits, now: time.Now, users: map[string]*userUsage{}, reserved: map[string]int{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read usage file: %w", err)
+	}
+	var f usageFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse usage file %s: %w", path, err)
+	}
+	for id, u := range f.Users {
+		if u == nil {
+			continue
+		}
+		if u.Daily == nil {
+			u.Daily = map[string]int{}
+		}
+		if u.Monthly == nil {
+			u.Monthly = map[string]int{}
+		}
+		s.users[id] = u
+	}
+	return s, nil
+}
+
+// Path 返回用量文件路径
+func (s *Store) Path() string { return s.path }
+
+// Limits 返回配置的额度
+func (s *Store) Limits() Limits { return s.limits }
+
+// Usage 返回用户当前的日/月用量与剩余额度
+func (s *Store) Usage(user string) Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage(user, s.now())
+}
+
+// All 返回所有有用量记录的用户（按用户 ID 排序）
+func (s *Store) All() []Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	out := make([]Usage, 0, len(s.users))
+	for id := range s.users {
+		out = append(out, s.usage(id, now))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].User < out[j].User })
+	return out
+}
+
+// Check 用户的任一额度已用尽时返回包装了 ErrQuotaExceeded 的错误
+func (s *Store) Check(user string) error {
+	u := s.Usage(user)
+	switch {
+	case u.Daily.Remaining != nil && *u.Daily.Remaining <= 0:
+		return fmt.Errorf("%w: daily limit of %d tokens used up (%s)", ErrQuotaExceeded, u.Daily.Limit, u.Daily.Period)
+	case u.Monthly.Remaining != nil && *u.Monthly.Remaining <= 0:
+		return fmt.Errorf("%w: monthly limit of %d tokens used up (%s)", ErrQuotaExceeded, u.Monthly.Limit, u.Monthly.Period)
+	}
+	return nil
+}
+
+// Remaining 返回用户当日与当月剩余额度中较小者；ok 为 false 表示不限
+func (s *Store) Remaining(user string) (int, bool) {
+	u := s.Usage(user)
+	left, ok := 0, false
+	for _, p := range []Period{u.Daily, u.Monthly} {
+		if p.Remaining != nil && (!ok || *p.Remaining < left) {
+			left, ok = *p.Remaining, true
+		}
+	}
+	return left, ok
+}
+
+// Reserve 原子地检查并预留 n 个 token：剩余额度（扣除其他进行中调用的预留）不足 n 时
+// 返回包装了 ErrQuotaExceeded 的错误且不预留。预留须由 Settle 结清。
+func (s *Store) Reserve(user string, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.usage(user, s.now())
+	for _, p := range []Period{u.Daily, u.Monthly} {
+		if p.Remaining == nil {
+			continue
+		}
+		if left := *p.Remaining - s.reserved[user]; n > left {
+			return fmt.Errorf("%w: %d tokens left (%d reserved by calls in flight), next call needs about %d", ErrQuotaExceeded, max(left, 0), s.reserved[user], n)
+		}
+	}
+	s.reserved[user] += n
+	return nil
+}
+
+// Settle 结清一次 Reserve：释放预留的 reserved 个 token，并将实际消耗的 actual 个计入用量
+func (s *Store) Settle(user string, reserved, actual int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reserved > 0 {
+		if left := s.reserved[user] - reserved; left > 0 {
+			s.reserved[user] = left
+		} else {
+			delete(s.reserved, user)
+		}
+	}
+	s.charge(user, actual)
+}
+
+// Charge 将 n 个 token 计入用户当日与当月的用量（由 Flush 写回文件）
+func (s *Store) Charge(user string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.charge(user, n)
+}
+
+// charge 在持有锁时计入用量并标记为待写入
+func (s *Store) charge(user string, n int) {
+	if n <= 0 {
+		return
+	}
+	now := s.now()
+	u := s.users[user]
+	if u == nil {
+		u = &userUsage{Daily: map[string]int{}, Monthly: map[string]int{}}
+		s.users[user] = u
+	}
+	u.Daily[now.Format(dayLayout)] += n
+	u.Monthly[now.Format(monthLayout)] += n
+	s.dirty = true
+}
+
+// Flush 将待写入的用量写回文件；没有新用量时不写。写入失败时用量仍标记为待写入，下次重试
+func (s *Store) Flush() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(usageFile{Users: s.users}, "", "  ")
+	s.dirty = false
+	s.mu.Unlock()
+	if err == nil {
+		err = s.save(data)
+	} else {
+		err = fmt.Errorf("encode usage: %w", err)
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// StartFlusher 每隔 interval（<=0 时为 DefaultFlushInterval）写回待写入的用量，直到 Close；重复调用无效
+func (s *Store) StartFlusher(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		return
+	}
+	s.stop, s.done = make(chan struct{}), make(chan struct{})
+	go func(stop <-chan struct{}, done chan<- struct{}) {
+		defer close(done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := s.Flush(); err != nil {
+					logs.Errorf("[quota] %v", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}(s.stop, s.done)
+}
+
+// Close 停止定期写入并写回剩余用量；之后的记账只保存在内存中，直到再次 Flush
+func (s *Store) Close() error {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	return s.Flush()
+}
+
+// Account 返回用户的额度账户，作为 graphproc.RunOptions.Quota 使用
+func (s *Store) Account(user string) *Account {
+	return &Account{store: s, user: user}
+}
+
+// Account 一个用户的额度账户（实现 graphproc.TokenQuota）
+type Account struct {
+	store *Store
+	user  string
+}
+
+// Remaining 返回剩余额度；ok 为 false 表示不限
+func (a *Account) Remaining() (int, bool) { return a.store.Remaining(a.user) }
+
+// Reserve 原子地检查并预留 n 个 token，见 Store.Reserve
+func (a *Account) Reserve(n int) error { return a.store.Reserve(a.user, n) }
+
+// Settle 释放预留并计入实际消耗，见 Store.Settle
+func (a *Account) Settle(reserved, actual int) { a.store.Settle(a.user, reserved, actual) }
+
+// usage 在持有锁时计算用户用量
+func (s *Store) usage(user string, now time.Time) Usage {
+	out := Usage{
+		User:    user,
+		Daily:   Period{Period: now.Format(dayLayout), Limit: s.limits.DailyTokens},
+		Monthly: Period{Period: now.Format(monthLayout), Limit: s.limits.MonthlyTokens},
+	}
+	if u := s.users[user]; u != nil {
+		out.Daily.Used = u.Daily[out.Daily.Period]
+		out.Monthly.Used = u.Monthly[out.Monthly.Period]
+	}
+	for _, p := range []*Period{&out.Daily, &out.Monthly} {
+		if p.Limit > 0 {
+			left := max(p.Limit-p.Used, 0)
+			p.Remaining = &left
+			if left == 0 {
+				out.Exceeded = true
+			}
+		}
+	}
+	return out
+}
+
+// save 在持有 saveMu 时写回用量文件：先写临时文件再重命名，避免读到半个文件
+func (s *Store) save(data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".usage-*.tmp")
+	if err != nil {
+		return fmt.Errorf("save usage: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("save usage: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("save usage: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("save usage: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package quota
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T, limits Limits) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "usage.json"), limits)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	s.now = func() time.Time { return time.Date(2026, 3, 15, 12, 0, 0, 0, time.Local) }
+	return s
+}
+
+func TestReserveIsAtomicAcrossAccounts(t *testing.T) {
+	// 同一用户的多个 run（多个 Account）并发预留：日额度 100，每次约 10，只有 10 次能通过
+	s := openTestStore(t, Limits{DailyTokens: 100, MonthlyTokens: 1000})
+	var ok atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := range 40 {
+		acct := s.Account("alice")
+		if i%2 == 1 {
+			acct = s.Account("bob")
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if err := acct.Reserve(10); err == nil {
+				ok.Add(1)
+			} else if !errors.Is(err, ErrQuotaExceeded) {
+				t.Errorf("Reserve: %v", err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if got := ok.Load(); got != 20 {
+		t.Fatalf("%d reservations succeeded, want 10 per user", got)
+	}
+	// 预留不计入用量，也不写入文件
+	if u := s.Usage("alice"); u.Daily.Used != 0 {
+		t.Errorf("reservations counted as usage: %+v", u.Daily)
+	}
+	reopened, err := Open(s.Path(), s.Limits())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if u := reopened.Usage("alice"); u.Daily.Used != 0 {
+		t.Errorf("reservations persisted: %+v", u.Daily)
+	}
+}
+
+func TestSettleChargesActualUsage(t *testing.T) {
+	s := openTestStore(t, Limits{DailyTokens: 100})
+	a := s.Account("alice")
+	if err := a.Reserve(60); err != nil {
+		t.Fatalf("Reserve(60): %v", err)
+	}
+	if err := a.Reserve(50); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Reserve(50) with 40 unreserved: err = %v", err)
+	}
+	// 实际用量 25：释放 60 的预留，计入 25
+	a.Settle(60, 25)
+	if left, ok := a.Remaining(); !ok || left != 75 {
+		t.Fatalf("Remaining = %d, %v; want 75, true", left, ok)
+	}
+	if err := a.Reserve(75); err != nil {
+		t.Fatalf("Reserve(75) after settle: %v", err)
+	}
+	a.Settle(75, 80)
+	u := s.Usage("alice")
+	if u.Daily.Used != 105 || !u.Exceeded {
+		t.Errorf("usage = %+v, want 105 used and exceeded", u)
+	}
+	if err := s.Check("alice"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Check = %v, want quota exceeded", err)
+	}
+	if s.reserved["alice"] != 0 {
+		t.Errorf("reservation left behind: %d", s.reserved["alice"])
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	reopened, err := Open(s.Path(), s.Limits())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	reopened.now = s.now
+	if u := reopened.Usage("alice"); u.Daily.Used != 105 || u.Monthly.Used != 105 {
+		t.Errorf("persisted usage = %+v", u)
+	}
+}
+
+func TestReserveUnlimited(t *testing.T) {
+	s := openTestStore(t, Limits{})
+	a := s.Account("alice")
+	for range 5 {
+		if err := a.Reserve(1 << 20); err != nil {
+			t.Fatalf("Reserve without limits: %v", err)
+		}
+	}
+	if _, ok := a.Remaining(); ok {
+		t.Error("Remaining reports a limit without configured quotas")
+	}
+}
+
+func TestChargeDefersWrites(t *testing.T) {
+	s := openTestStore(t, Limits{})
+	reopen := func() Usage {
+		t.Helper()
+		r, err := Open(s.Path(), s.Limits())
+		if err != nil {
+			t.Fatalf("reopen: %v", err)
+		}
+		r.now = s.now
+		return r.Usage("alice")
+	}
+	// 记账只更新内存，不写文件
+	for range 100 {
+		s.Settle("alice", 0, 1)
+	}
+	if _, err := os.Stat(s.Path()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("usage file written on settle: %v", err)
+	}
+	if u := s.Usage("alice"); u.Daily.Used != 100 {
+		t.Fatalf("in-memory usage = %+v", u.Daily)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if u := reopen(); u.Daily.Used != 100 {
+		t.Fatalf("flushed usage = %+v", u.Daily)
+	}
+	// 没有新用量时不写
+	if err := os.Remove(s.Path()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if _, err := os.Stat(s.Path()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("clean store rewrote the usage file: %v", err)
+	}
+}
+
+func TestFlushRetriesAfterWriteError(t *testing.T) {
+	s := openTestStore(t, Limits{})
+	s.Charge("alice", 5)
+	// 用量文件所在目录不存在时写入失败，用量保持待写入
+	dir := filepath.Dir(s.Path())
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err == nil {
+		t.Fatal("Flush into a missing directory succeeded")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush after recovery: %v", err)
+	}
+	if _, err := os.Stat(s.Path()); err != nil {
+		t.Fatalf("usage not written after recovery: %v", err)
+	}
+}
+
+func TestFlusherWritesPeriodicallyAndOnClose(t *testing.T) {
+	s := openTestStore(t, Limits{})
+	s.StartFlusher(10 * time.Millisecond)
+	s.StartFlusher(time.Hour) // 重复调用无效
+	s.Charge("alice", 7)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(s.Path()); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("flusher did not write the usage file")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	s.Charge("alice", 3)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	r, err := Open(s.Path(), s.Limits())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	r.now = s.now
+	if u := r.Usage("alice"); u.Daily.Used != 10 || u.Monthly.Used != 10 {
+		t.Errorf("usage after Close = %+v", u)
+	}
+	// 再次 Close 无副作用
+	if err := s.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package runstore
+
+// 本文件基于运行历史生成费用报表：按日期、用户、图与模型任意组合分组，
+// 汇总各节点执行时记录的 token 与费用（子代理阶段与监督者路由阶段分别计入各自的模型）。
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"multi-agent/internal/graphproc"
+	"multi-agent/internal/orchestrator"
+)
+
+// 费用报表的分组维度
+const (
+	GroupDay   = "day"
+	GroupUser  = "user"
+	GroupGraph = "graph"
+	GroupModel = "model"
+)
+
+// ParseGroupBy 解析逗号分隔的分组维度（去重、保持顺序）；为空时按日期分组
+func ParseGroupBy(s string) ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+	for _, g := range strings.Split(s, ",") {
+		g = strings.ToLower(strings.TrimSpace(g))
+		switch g {
+		case "":
+			continue
+		case GroupDay, GroupUser, GroupGraph, GroupModel:
+		default:
+			return nil, fmt.Errorf("unknown group_by %q (want %s, %s, %s or %s)", g, GroupDay, GroupUser, GroupGraph, GroupModel)
+		}
+		if !seen[g] {
+			seen[g] = true
+			out = append(out, g)
+		}
+	}
+	if len(out) == 0 {
+		out = []string{GroupDay}
+	}
+	return out, nil
+}
+
+// GraphKey 返回图在报表中的标识：options.name，未命名时为节点 ID 与边结构的摘要（修改节点内容不改变标识）
+func GraphKey(sg orchestrator.SimpleGraph) string {
+	if sg.Options != nil && strings.TrimSpace(sg.Options.Name) != "" {
+		return strings.TrimSpace(sg.Options.Name)
+	}
+	h := sha256.New()
+	for _, n := range sg.Nodes {
+		fmt.Fprintf(h, "n:%d:%s\n", len(n.ID), n.ID)
+	}
+	for _, e := range sg.Edges {
+		fmt.Fprintf(h, "e:%d:%s:%d:%s\n", len(e.From), e.From, len(e.To), e.To)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// CostRow 报表中的一行（或合计）
+type CostRow struct {
+	// Key 分组维度 → 取值（CLI 执行的 user 为空）
+	Key              map[string]string `json:"key,omitempty"`
+	Runs             int               `json:"runs"`
+	PromptTokens     int               `json:"prompt_tokens"`
+	CompletionTokens int               `json:"completion_tokens"`
+	TotalTokens      int               `json:"total_tokens"`
+	// EstimatedTokens TotalTokens 中模型未上报、本地估算的部分（近似值）
+	EstimatedTokens int     `json:"estimated_tokens"`
+	Cost            float64 `json:"cost"`
+
+	runs map[string]bool
+}
+
+// CostReport 费用报表
+type CostReport struct {
+	GroupBy []string  `json:"group_by"`
+	Rows    []CostRow `json:"rows"`
+	Total   CostRow   `json:"total"`
+}
+
+// costItem 一次模型调用阶段（某节点的子代理或路由）的用量与费用
+type costItem struct {
+	model                     string
+	prompt, completion, total int
+	// estimated 用量为估算值时等于 total，否则为 0
+	estimated int
+	cost      float64
+}
+
+// runCostItems 列出 run 中各节点子代理阶段与路由阶段的用量与费用
+func runCostItems(run *Run) []costItem {
+	var items []costItem
+	for _, r := range run.Results {
+		if r.TotalTokens > 0 || r.Cost > 0 {
+			items = append(items, costItem{model: r.Model, prompt: r.PromptTokens, completion: r.CompletionTokens, total: r.TotalTokens, estimated: estimatedPart(r.UsageSource, r.TotalTokens), cost: r.Cost})
+		}
+		if r.RouterTotalTokens > 0 || r.RouterCost > 0 {
+			items = append(items, costItem{model: r.RouterModel, prompt: r.RouterPromptTokens, completion: r.RouterCompletionTokens, total: r.RouterTotalTokens, estimated: estimatedPart(r.RouterUsageSource, r.RouterTotalTokens), cost: r.RouterCost})
+		}
+	}
+	return items
+}
+
+// estimatedPart 用量来源为 estimated 时返回 total，否则为 0
+func estimatedPart(source string, total int) int {
+	if source == graphproc.UsageEstimated {
+		return total
+	}
+	return 0
+}
+
+// CostReport 按过滤条件（Limit / Offset 不生效）汇总运行历史的 token 与费用；日期按服务器本地时区的开始时间划分
+func (s *Store) CostReport(f Filter, groupBy []string) (CostReport, error) {
+	report := CostReport{GroupBy: groupBy, Rows: []CostRow{}, Total: CostRow{runs: map[string]bool{}}}
+	groups := map[string]*CostRow{}
+	err := s.each(func(run *Run) {
+		if !f.match(run) {
+			return
+		}
+		for _, it := range runCostItems(run) {
+			key := make(map[string]string, len(groupBy))
+			parts := make([]string, len(groupBy))
+			for i, g := range groupBy {
+				switch g {
+				case GroupDay:
+					key[g] = run.StartedAt.Local().Format("2006-01-02")
+				case GroupUser:
+					key[g] = run.Owner
+				case GroupGraph:
+					key[g] = GraphKey(run.Graph)
+				case GroupModel:
+					key[g] = it.model
+				}
+				parts[i] = key[g]
+			}
+			id := strings.Join(parts, "\x00")
+			row := groups[id]
+			if row == nil {
+				row = &CostRow{Key: key, runs: map[string]bool{}}
+				groups[id] = row
+			}
+			for _, r := range []*CostRow{row, &report.Total} {
+				r.runs[run.ID] = true
+				r.PromptTokens += it.prompt
+				r.CompletionTokens += it.completion
+				r.TotalTokens += it.total
+				r.EstimatedTokens += it.estimated
+				r.Cost += it.cost
+			}
+		}
+	})
+	if err != nil {
+		return report, err
+	}
+	ids := make([]string, 0, len(groups))
+	for id := range groups {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		row := groups[id]
+		row.Runs, row.Cost = len(row.runs), graphproc.RoundCost(row.Cost)
+		report.Rows = append(report.Rows, *row)
+	}
+	report.Total.Runs, report.Total.Cost = len(report.Total.runs), graphproc.RoundCost(report.Total.Cost)
+	return report, nil
+}
@@ -0,0 +1,179 @@
+package runstore
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"multi-agent/internal/graphproc"
+	"multi-agent/internal/orchestrator"
+)
+
+func TestParseGroupBy(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+		err  string
+	}{
+		{in: "", want: []string{GroupDay}},
+		{in: " , ", want: []string{GroupDay}},
+		{in: "model", want: []string{GroupModel}},
+		{in: "User, MODEL ,user,day", want: []string{GroupUser, GroupModel, GroupDay}},
+		{in: "day,graph", want: []string{GroupDay, GroupGraph}},
+		{in: "day,node", err: `unknown group_by "node"`},
+	}
+	for _, tt := range tests {
+		got, err := ParseGroupBy(tt.in)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseGroupBy(%q) err = %v, want %q", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("ParseGroupBy(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestGraphKey(t *testing.T) {
+	graph := func(payload string, edges ...orchestrator.SimpleEdge) orchestrator.SimpleGraph {
+		return orchestrator.SimpleGraph{
+			Nodes: []orchestrator.SimpleNode{{ID: "a", Payload: []byte(payload)}, {ID: "b"}},
+			Edges: edges,
+		}
+	}
+	ab := orchestrator.SimpleEdge{From: "a", To: "b"}
+	base := GraphKey(graph(`{"text":"v1"}`, ab))
+	if !strings.HasPrefix(base, "sha256:") || len(base) != len("sha256:")+12 {
+		t.Fatalf("GraphKey = %q", base)
+	}
+	// 修改节点内容或边颜色不改变标识，修改结构则改变
+	if got := GraphKey(graph(`{"text":"v2"}`, orchestrator.SimpleEdge{From: "a", To: "b", Color: "red"})); got != base {
+		t.Errorf("payload change: %q != %q", got, base)
+	}
+	if got := GraphKey(graph(`{"text":"v1"}`)); got == base {
+		t.Error("removing an edge kept the key")
+	}
+	if got := GraphKey(graph(`{"text":"v1"}`, orchestrator.SimpleEdge{From: "b", To: "a"})); got == base {
+		t.Error("reversing an edge kept the key")
+	}
+	// 节点 ID 带长度前缀，拼接歧义不会碰撞
+	x := GraphKey(orchestrator.SimpleGraph{Nodes: []orchestrator.SimpleNode{{ID: "ab"}, {ID: "c"}}})
+	y := GraphKey(orchestrator.SimpleGraph{Nodes: []orchestrator.SimpleNode{{ID: "a"}, {ID: "bc"}}})
+	if x == y {
+		t.Error("ambiguous node ids collide")
+	}
+	named := graph(`{}`, ab)
+	named.Options = &orchestrator.GraphOptions{Name: "  weekly-report "}
+	if got := GraphKey(named); got != "weekly-report" {
+		t.Errorf("named graph key = %q", got)
+	}
+	named.Options.Name = " "
+	if got := GraphKey(named); got != base {
+		t.Errorf("blank name key = %q, want %q", got, base)
+	}
+}
+
+// costRun 一条带用量的记录：节点 a 由 model 执行，节点 b 另有监督者路由的用量
+func costRun(id, owner, graphName, model string, started time.Time) *Run {
+	run := testRun(id, "ok", SourceAPI, owner, "", 0)
+	run.StartedAt = started
+	run.Graph.Options = &orchestrator.GraphOptions{Name: graphName}
+	run.Results = map[string]graphproc.NodeResult{
+		"a": {Model: model, PromptTokens: 100, CompletionTokens: 20, TotalTokens: 120, UsageSource: graphproc.UsageReported, Cost: 0.001},
+		"b": {
+			Model: model, PromptTokens: 50, CompletionTokens: 10, TotalTokens: 60, UsageSource: graphproc.UsageEstimated, Cost: 0.0005,
+			RouterModel: "router", RouterPromptTokens: 30, RouterCompletionTokens: 5, RouterTotalTokens: 35, RouterUsageSource: graphproc.UsageReported, RouterCost: 0.0002,
+		},
+		// 未调用模型的节点（如复用结果）不计入
+		"c": {Model: model, Reused: true},
+	}
+	return run
+}
+
+func TestCostReport(t *testing.T) {
+	day1 := time.Date(2026, 1, 2, 10, 0, 0, 0, time.Local)
+	day2 := day1.AddDate(0, 0, 1)
+	s := seedStore(t,
+		costRun("r1", "alice", "g1", "gpt-4o", day1),
+		costRun("r2", "bob", "g1", "gpt-4o-mini", day1.Add(time.Hour)),
+		costRun("r3", "alice", "g2", "gpt-4o", day2),
+	)
+	key := func(kv ...string) map[string]string {
+		m := map[string]string{}
+		for i := 0; i < len(kv); i += 2 {
+			m[kv[i]] = kv[i+1]
+		}
+		return m
+	}
+	tests := []struct {
+		name    string
+		filter  Filter
+		groupBy []string
+		rows    []CostRow
+		total   CostRow
+	}{
+		{
+			name: "by day", groupBy: []string{GroupDay},
+			rows: []CostRow{
+				{Key: key(GroupDay, "2026-01-02"), Runs: 2, PromptTokens: 360, CompletionTokens: 70, TotalTokens: 430, EstimatedTokens: 120, Cost: 0.0034},
+				{Key: key(GroupDay, "2026-01-03"), Runs: 1, PromptTokens: 180, CompletionTokens: 35, TotalTokens: 215, EstimatedTokens: 60, Cost: 0.0017},
+			},
+			total: CostRow{Runs: 3, PromptTokens: 540, CompletionTokens: 105, TotalTokens: 645, EstimatedTokens: 180, Cost: 0.0051},
+		},
+		{
+			// 路由阶段计入监督者的模型
+			name: "by model", groupBy: []string{GroupModel},
+			rows: []CostRow{
+				{Key: key(GroupModel, "gpt-4o"), Runs: 2, PromptTokens: 300, CompletionTokens: 60, TotalTokens: 360, EstimatedTokens: 120, Cost: 0.003},
+				{Key: key(GroupModel, "gpt-4o-mini"), Runs: 1, PromptTokens: 150, CompletionTokens: 30, TotalTokens: 180, EstimatedTokens: 60, Cost: 0.0015},
+				{Key: key(GroupModel, "router"), Runs: 3, PromptTokens: 90, CompletionTokens: 15, TotalTokens: 105, Cost: 0.0006},
+			},
+			total: CostRow{Runs: 3, PromptTokens: 540, CompletionTokens: 105, TotalTokens: 645, EstimatedTokens: 180, Cost: 0.0051},
+		},
+		{
+			name: "by user and graph", filter: Filter{Owner: "alice"}, groupBy: []string{GroupUser, GroupGraph},
+			rows: []CostRow{
+				{Key: key(GroupUser, "alice", GroupGraph, "g1"), Runs: 1, PromptTokens: 180, CompletionTokens: 35, TotalTokens: 215, EstimatedTokens: 60, Cost: 0.0017},
+				{Key: key(GroupUser, "alice", GroupGraph, "g2"), Runs: 1, PromptTokens: 180, CompletionTokens: 35, TotalTokens: 215, EstimatedTokens: 60, Cost: 0.0017},
+			},
+			total: CostRow{Runs: 2, PromptTokens: 360, CompletionTokens: 70, TotalTokens: 430, EstimatedTokens: 120, Cost: 0.0034},
+		},
+		{
+			// Limit 不限制报表范围
+			name: "filtered window", filter: Filter{Since: day2, Limit: 1}, groupBy: []string{GroupGraph},
+			rows: []CostRow{
+				{Key: key(GroupGraph, "g2"), Runs: 1, PromptTokens: 180, CompletionTokens: 35, TotalTokens: 215, EstimatedTokens: 60, Cost: 0.0017},
+			},
+			total: CostRow{Runs: 1, PromptTokens: 180, CompletionTokens: 35, TotalTokens: 215, EstimatedTokens: 60, Cost: 0.0017},
+		},
+		{
+			name: "no match", filter: Filter{Owner: "carol"}, groupBy: []string{GroupDay},
+			rows: []CostRow{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := s.CostReport(tt.filter, tt.groupBy)
+			if err != nil {
+				t.Fatalf("CostReport: %v", err)
+			}
+			for i := range report.Rows {
+				report.Rows[i].runs = nil
+			}
+			report.Total.runs = nil
+			if !reflect.DeepEqual(report.Rows, tt.rows) {
+				t.Errorf("rows:\n got %+v\nwant %+v", report.Rows, tt.rows)
+			}
+			if !reflect.DeepEqual(report.Total, tt.total) {
+				t.Errorf("total = %+v, want %+v", report.Total, tt.total)
+			}
+			if !slices.Equal(report.GroupBy, tt.groupBy) {
+				t.Errorf("group_by = %v", report.GroupBy)
+			}
+		})
+	}
+}
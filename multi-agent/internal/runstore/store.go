@@ -0,0 +1,348 @@
+package runstore
+
+// 本包将每次图执行（HTTP 与 CLI）持久化为数据目录下的一个 JSON 文件（<run_id>.json），
+// 记录图快照、起止时间、执行选项、每节点 NodeResult、使用的模型与用量汇总，供历史查询使用。
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"multi-agent/internal/graphproc"
+	"multi-agent/internal/orchestrator"
+)
+
+// DirEnv 指定运行历史目录的环境变量；未设置时使用 DefaultDir
+const DirEnv = "RUN_STORE_DIR"
+
+// DefaultDir 默认运行历史目录（相对 multi-agent 工作目录）
+const DefaultDir = "data/runs"
+
+// 运行来源
+const (
+	SourceAPI = "api"
+	SourceCLI = "cli"
+)
+
+// 分页参数
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// ErrNotFound 指定的 run 不存在
+var ErrNotFound = errors.New("run not found")
+
+// Run 一次图执行的完整记录
+type Run struct {
+	ID     string `json:"id"`
+	Source string `json:"source"`
+	// Owner 发起执行的用户（HTTP 认证身份）；CLI 执行为空，仅管理员可见
+	Owner string `json:"owner,omitempty"`
+	// Status 结束状态：ok / failed / cancelled（见 graphproc.RunStatus）
+	Status       string                          `json:"status"`
+	Error        string                          `json:"error,omitempty"`
+	StartedAt    time.Time                       `json:"started_at"`
+	EndedAt      time.Time                       `json:"ended_at"`
+	DurationMs   int64                           `json:"duration_ms"`
+	Options      graphproc.RunOptions            `json:"options"`
+	Graph        orchestrator.SimpleGraph        `json:"graph"`
+	Models       []string                        `json:"models,omitempty"`
+	Results      map[string]graphproc.NodeResult `json:"results"`
+	UsageSummary graphproc.UsageSummary          `json:"usage_summary"`
+	Skipped      []graphproc.SkippedNode         `json:"skipped,omitempty"`
+	// ParentID 由 rerun 产生时指向原始 run，Rerun 记录重新执行的方式
+	ParentID string     `json:"parent_id,omitempty"`
+	Rerun    *RerunSpec `json:"rerun,omitempty"`
+}
+
+// RerunSpec 重新执行的参数与实际重新计算的节点
+type RerunSpec struct {
+	StartNode  string            `json:"start_node,omitempty"`
+	Overrides  map[string]string `json:"overrides,omitempty"`
+	Recomputed []string          `json:"recomputed"`
+}
+
+// Summary 列表接口返回的精简记录（不含图快照与节点输出）
+type Summary struct {
+	ID          string    `json:"id"`
+	Source      string    `json:"source"`
+	Owner       string    `json:"owner,omitempty"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	EndedAt     time.Time `json:"ended_at"`
+	DurationMs  int64     `json:"duration_ms"`
+	Nodes       int       `json:"nodes"`
+	Edges       int       `json:"edges"`
+	FailedNodes int       `json:"failed_nodes"`
+	Models      []string  `json:"models,omitempty"`
+	TotalTokens int       `json:"total_tokens"`
+	// UsageSource total_tokens 的来源：reported / estimated / mixed；EstimatedTokens 为其中估算（近似）的部分
+	UsageSource     string `json:"usage_source,omitempty"`
+	EstimatedTokens int    `json:"estimated_tokens"`
+	ParentID        string `json:"parent_id,omitempty"`
+}
+
+// Filter 列表过滤与分页条件；零值字段不参与过滤
+type Filter struct {
+	Status string
+	Source string
+	// Owner 仅列出指定用户的 run
+	Owner string
+	// ParentID 仅列出由指定 run 重新执行产生的 run
+	ParentID string
+	// Since / Until 按开始时间过滤（闭区间）
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+	Offset int
+}
+
+// Page 一页列表结果，按开始时间倒序
+type Page struct {
+	Runs   []Summary `json:"runs"`
+	Total  int       `json:"total"`
+	Limit  int       `json:"limit"`
+	Offset int       `json:"offset"`
+}
+
+// NewRun 根据执行结果构建记录；err 为 ProcessGraph 的返回值
+func NewRun(runID, source string, sg orchestrator.SimpleGraph, opts graphproc.RunOptions, results map[string]graphproc.NodeResult, err error, startedAt, endedAt time.Time) *Run {
+	run := &Run{
+		ID:           runID,
+		Source:       source,
+		Status:       graphproc.RunStatus(err),
+		StartedAt:    startedAt,
+		EndedAt:      endedAt,
+		DurationMs:   endedAt.Sub(startedAt).Milliseconds(),
+		Options:      opts,
+		Graph:        sg,
+		Results:      make(map[string]graphproc.NodeResult, len(results)),
+		UsageSummary: graphproc.SummarizeUsage(results),
+		Skipped:      graphproc.SkippedNodes(results),
+	}
+	run.Options.RunID = runID
+	if err != nil {
+		run.Error = err.Error()
+	}
+	seen := make(map[string]bool)
+	for id, r := range results {
+		run.Results[id] = r
+		if r.Model != "" && !seen[r.Model] {
+			seen[r.Model] = true
+			run.Models = append(run.Models, r.Model)
+		}
+	}
+	sort.Strings(run.Models)
+	return run
+}
+
+// Summarize 生成列表用的精简记录
+func (r *Run) Summarize() Summary {
+	s := Summary{
+		ID:              r.ID,
+		Source:          r.Source,
+		Owner:           r.Owner,
+		Status:          r.Status,
+		Error:           r.Error,
+		StartedAt:       r.StartedAt,
+		EndedAt:         r.EndedAt,
+		DurationMs:      r.DurationMs,
+		Nodes:           len(r.Graph.Nodes),
+		Edges:           len(r.Graph.Edges),
+		Models:          r.Models,
+		TotalTokens:     r.UsageSummary.TotalTokens,
+		UsageSource:     r.UsageSummary.TotalUsageSource,
+		EstimatedTokens: r.UsageSummary.EstimatedTokens,
+		ParentID:        r.ParentID,
+	}
+	for _, nr := range r.Results {
+		if nr.Status == graphproc.NodeStatusFailed {
+			s.FailedNodes++
+		}
+	}
+	return s
+}
+
+// Store 基于 JSON 文件的运行历史存储，可被多个 goroutine 并发使用
+type Store struct {
+	mu  sync.RWMutex
+	dir string
+}
+
+// Open 打开（必要时创建）运行历史目录；dir 为空时依次使用 RUN_STORE_DIR 与 DefaultDir
+func Open(dir string) (*Store, error) {
+	if strings.TrimSpace(dir) == "" {
+		dir = os.Getenv(DirEnv)
+	}
+	if strings.TrimSpace(dir) == "" {
+		dir = DefaultDir
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create run store dir: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Dir 返回存储目录
+func (s *Store) Dir() string { return s.dir }
+
+// Save 写入（或覆盖）一条记录：先写临时文件再重命名，避免读到半个文件
+func (s *Store) Save(run *Run) error {
+	if !ValidID(run.ID) {
+		return fmt.Errorf("invalid run id %q", run.ID)
+	}
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode run: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tmp, err := os.CreateTemp(s.dir, ".run-*.tmp")
+	if err != nil {
+		return fmt.Errorf("save run: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("save run: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("save run: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(run.ID)); err != nil {
+		return fmt.Errorf("save run: %w", err)
+	}
+	return nil
+}
+
+// Get 读取一条记录；不存在时返回 ErrNotFound
+func (s *Store) Get(id string) (*Run, error) {
+	if !ValidID(id) {
+		return nil, ErrNotFound
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.read(s.path(id))
+}
+
+// List 按条件列出记录（开始时间倒序），损坏的文件会被跳过
+func (s *Store) List(f Filter) (Page, error) {
+	if f.Limit <= 0 {
+		f.Limit = DefaultLimit
+	}
+	if f.Limit > MaxLimit {
+		f.Limit = MaxLimit
+	}
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+	matched := make([]Summary, 0)
+	err := s.each(func(run *Run) {
+		if f.match(run) {
+			matched = append(matched, run.Summarize())
+		}
+	})
+	if err != nil {
+		return Page{}, err
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].StartedAt.Equal(matched[j].StartedAt) {
+			return matched[i].StartedAt.After(matched[j].StartedAt)
+		}
+		return matched[i].ID < matched[j].ID
+	})
+	page := Page{Runs: []Summary{}, Total: len(matched), Limit: f.Limit, Offset: f.Offset}
+	if f.Offset < len(matched) {
+		end := f.Offset + f.Limit
+		if end > len(matched) {
+			end = len(matched)
+		}
+		page.Runs = matched[f.Offset:end]
+	}
+	return page, nil
+}
+
+// each 依次读取全部记录，损坏的文件会被跳过
+func (s *Store) each(fn func(run *Run)) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("list runs: %w", err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".json" {
+			continue
+		}
+		run, err := s.read(filepath.Join(s.dir, name))
+		if err != nil {
+			continue
+		}
+		fn(run)
+	}
+	return nil
+}
+
+func (f Filter) match(run *Run) bool {
+	if f.Status != "" && run.Status != f.Status {
+		return false
+	}
+	if f.Source != "" && run.Source != f.Source {
+		return false
+	}
+	if f.Owner != "" && run.Owner != f.Owner {
+		return false
+	}
+	if f.ParentID != "" && run.ParentID != f.ParentID {
+		return false
+	}
+	if !f.Since.IsZero() && run.StartedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && run.StartedAt.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+func (s *Store) read(path string) (*Run, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("read run: %w", err)
+	}
+	var run Run
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("decode run %s: %w", filepath.Base(path), err)
+	}
+	return &run, nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// validID 拒绝可能逃逸出存储目录的 run ID（run ID 可由客户端指定）
+func ValidID(id string) bool {
+	if id == "" || len(id) > 128 || strings.HasPrefix(id, ".") {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+package runstore
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"multi-agent/internal/orchestrator"
+)
+
+// seedStore 写入一组开始时间各不相同的记录（t0 起每条晚一分钟）
+func seedStore(t *testing.T, runs ...*Run) *Store {
+	t.Helper()
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for _, run := range runs {
+		if err := s.Save(run); err != nil {
+			t.Fatalf("Save %s: %v", run.ID, err)
+		}
+	}
+	return s
+}
+
+var t0 = time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+
+func testRun(id, status, source, owner, parent string, minute int) *Run {
+	return &Run{
+		ID: id, Status: status, Source: source, Owner: owner, ParentID: parent,
+		StartedAt: t0.Add(time.Duration(minute) * time.Minute),
+		Graph:     orchestrator.SimpleGraph{Nodes: []orchestrator.SimpleNode{{ID: "a"}}},
+	}
+}
+
+func ids(p Page) []string {
+	var out []string
+	for _, r := range p.Runs {
+		out = append(out, r.ID)
+	}
+	return out
+}
+
+func TestStoreList(t *testing.T) {
+	s := seedStore(t,
+		testRun("r1", "ok", SourceAPI, "alice", "", 0),
+		testRun("r2", "failed", SourceAPI, "bob", "", 1),
+		testRun("r3", "ok", SourceCLI, "", "", 2),
+		testRun("r4", "ok", SourceAPI, "alice", "r1", 3),
+		testRun("r5", "cancelled", SourceAPI, "alice", "r1", 4),
+	)
+	// 损坏的文件、临时文件与非 JSON 文件均被跳过
+	for name, data := range map[string]string{"bad.json": "{", ".run-1.tmp": "{}", "notes.txt": "x"} {
+		if err := os.WriteFile(filepath.Join(s.Dir(), name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+		total  int
+	}{
+		{name: "all newest first", want: []string{"r5", "r4", "r3", "r2", "r1"}, total: 5},
+		{name: "status", filter: Filter{Status: "ok"}, want: []string{"r4", "r3", "r1"}, total: 3},
+		{name: "source", filter: Filter{Source: SourceCLI}, want: []string{"r3"}, total: 1},
+		{name: "owner", filter: Filter{Owner: "alice"}, want: []string{"r5", "r4", "r1"}, total: 3},
+		{name: "parent", filter: Filter{ParentID: "r1"}, want: []string{"r5", "r4"}, total: 2},
+		{name: "combined", filter: Filter{Owner: "alice", Status: "ok"}, want: []string{"r4", "r1"}, total: 2},
+		{name: "since inclusive", filter: Filter{Since: t0.Add(3 * time.Minute)}, want: []string{"r5", "r4"}, total: 2},
+		{name: "until inclusive", filter: Filter{Until: t0.Add(time.Minute)}, want: []string{"r2", "r1"}, total: 2},
+		{name: "window", filter: Filter{Since: t0.Add(time.Minute), Until: t0.Add(3 * time.Minute)}, want: []string{"r4", "r3", "r2"}, total: 3},
+		{name: "no match", filter: Filter{Status: "budget_exceeded"}, total: 0},
+		{name: "limit", filter: Filter{Limit: 2}, want: []string{"r5", "r4"}, total: 5},
+		{name: "offset", filter: Filter{Limit: 2, Offset: 2}, want: []string{"r3", "r2"}, total: 5},
+		{name: "last page", filter: Filter{Limit: 2, Offset: 4}, want: []string{"r1"}, total: 5},
+		{name: "offset past end", filter: Filter{Offset: 9}, total: 5},
+		{name: "filter then page", filter: Filter{Status: "ok", Limit: 1, Offset: 1}, want: []string{"r3"}, total: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := s.List(tt.filter)
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if got := ids(page); !slices.Equal(got, tt.want) || page.Total != tt.total {
+				t.Errorf("got %v total=%d, want %v total=%d", got, page.Total, tt.want, tt.total)
+			}
+			if page.Runs == nil {
+				t.Error("runs is nil, want an empty list")
+			}
+		})
+	}
+}
+
+func TestStoreListNormalizesPaging(t *testing.T) {
+	s := seedStore(t)
+	for _, tt := range []struct {
+		in         Filter
+		limit, off int
+	}{
+		{Filter{}, DefaultLimit, 0},
+		{Filter{Limit: -1, Offset: -3}, DefaultLimit, 0},
+		{Filter{Limit: MaxLimit + 1}, MaxLimit, 0},
+		{Filter{Limit: 5, Offset: 7}, 5, 7},
+	} {
+		page, err := s.List(tt.in)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if page.Limit != tt.limit || page.Offset != tt.off {
+			t.Errorf("List(%+v): limit=%d offset=%d, want %d/%d", tt.in, page.Limit, page.Offset, tt.limit, tt.off)
+		}
+	}
+}
+
+func TestStoreSameStartOrderedByID(t *testing.T) {
+	s := seedStore(t, testRun("b", "ok", SourceAPI, "", "", 0), testRun("a", "ok", SourceAPI, "", "", 0))
+	page, err := s.List(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(page); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("got %v, want [a b]", got)
+	}
+}
+
+func TestValidID(t *testing.T) {
+	for id, want := range map[string]bool{
+		"run-1_a.b": true, "": false, ".hidden": false, "../x": false, "a/b": false, "a b": false,
+		strings.Repeat("a", 128): true, strings.Repeat("a", 129): false,
+	} {
+		if got := ValidID(id); got != want {
+			t.Errorf("ValidID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
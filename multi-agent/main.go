@@ -1,14 +1,68 @@
 package main
 
 import (
-    "fmt"
+    "context"
+    "errors"
+    "flag"
+    "log"
+    "net/http"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
+
+    "multi-agent/internal/config"
     "multi-agent/internal/httpserver"
+    "multi-agent/model"
 )
 
 func main() {
-    r := httpserver.NewServer()
-    addr := fmt.Sprintf(":%d", 8080)
-    if err := r.Run(addr); err != nil {
-        panic(err)
+    cassette := flag.String("cassette", "", "Cassette file for recording/replaying model calls (default $"+model.CassetteEnv+")")
+    cassetteMode := flag.String("cassette-mode", "", "Cassette mode: off, record or replay (default $"+model.CassetteModeEnv+")")
+    flags := config.RegisterFlags(flag.CommandLine, "listen", "public-url", "cors-origins", "upload-dir", "vision-image-mode", "data-dir", "runs-dir", "concurrency", "token-budget", "quota-daily", "quota-monthly", "pricing-file", "model-type", "model", "model-base-url", "max-completion-tokens", "auth-keys-file", "jwt-public-key")
+    flag.Parse()
+
+    // config.Load reads .env, the config file, env vars and flags; the resolved
+    // config is handed to the server, which builds the image store, model factory
+    // and upload limits from it
+    cfg, err := config.Load(flags)
+    if err != nil {
+        log.Fatalf("config: %v", err)
+    }
+    if cfg.File != "" {
+        log.Printf("[CONFIG] file=%s", cfg.File)
+    }
+    r := httpserver.NewServer(cfg)
+    if err := model.ConfigureCassette(*cassetteMode, *cassette); err != nil {
+        log.Fatalf("cassette: %v", err)
+    }
+    if mode, path := model.CassetteMode(); mode != model.CassetteOff {
+        log.Printf("[CASSETTE] mode=%s file=%s", mode, path)
+    }
+
+    // Serve until SIGINT/SIGTERM, then stop accepting requests and flush the
+    // server's buffered state (per-user token usage) before exiting
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+    srv := &http.Server{Addr: cfg.Listen, Handler: r}
+    errc := make(chan error, 1)
+    go func() { errc <- srv.ListenAndServe() }()
+    log.Printf("[SERVER] listening on %s", cfg.Listen)
+    select {
+    case err := <-errc:
+        if !errors.Is(err, http.ErrServerClosed) {
+            r.Close()
+            log.Fatalf("server: %v", err)
+        }
+    case <-ctx.Done():
+        log.Printf("[SERVER] shutting down")
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        if err := srv.Shutdown(shutdownCtx); err != nil {
+            log.Printf("[SERVER] shutdown: %v", err)
+        }
+        cancel()
+    }
+    if err := r.Close(); err != nil {
+        log.Printf("[SERVER] close: %v", err)
     }
 }
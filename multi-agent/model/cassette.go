@@ -0,0 +1,534 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// Cassette environment variables; the -cassette / -cassette-mode flags of the
+// server and process-graph take precedence (see ConfigureCassette).
+const (
+	CassetteEnv     = "MODEL_CASSETTE"
+	CassetteModeEnv = "MODEL_CASSETTE_MODE"
+)
+
+// Cassette modes.
+const (
+	CassetteOff    = "off"
+	CassetteRecord = "record"
+	CassetteReplay = "replay"
+)
+
+// Interaction is one recorded chat model call.
+type Interaction struct {
+	Agent string `json:"agent"`
+	Model string `json:"model,omitempty"`
+	// Key identifies the request: agent name plus the request messages.
+	Key      string            `json:"key"`
+	Stream   bool              `json:"stream"`
+	Messages []*schema.Message `json:"messages"`
+	// Response is set for Generate calls, Chunks for Stream calls (the last
+	// chunk usually carries ResponseMeta with usage).
+	Response *schema.Message   `json:"response,omitempty"`
+	Chunks   []*schema.Message `json:"chunks,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// cassetteVersion is the format written by record mode: a header line
+// {"version":2} followed by one JSON-encoded Interaction per line. Replay also
+// reads version 1 files, a single JSON document {"version":1,"interactions":[...]}.
+const cassetteVersion = 2
+
+// cassetteHeader is the first JSON value of a cassette file; Interactions is
+// only set in version 1 files.
+type cassetteHeader struct {
+	Version      int            `json:"version"`
+	Interactions []*Interaction `json:"interactions,omitempty"`
+}
+
+// cassette is the state of one cassette file shared by all models.
+type cassette struct {
+	mu   sync.Mutex
+	mode string
+	path string
+	// record: the cassette file, opened for appending
+	file *os.File
+	// replay: every recorded interaction, and the ones not served yet by key
+	// in recorded order
+	interactions []*Interaction
+	pending      map[string][]*Interaction
+}
+
+var (
+	cassetteMu     sync.Mutex
+	activeCassette *cassette
+)
+
+// ConfigureCassette enables recording or replay for every chat model created
+// afterwards. Empty arguments fall back to MODEL_CASSETTE_MODE / MODEL_CASSETTE.
+// Replay cassettes are loaded eagerly so a missing or corrupt file fails fast;
+// record mode truncates the file and appends one line per call.
+func ConfigureCassette(mode, path string) error {
+	if strings.TrimSpace(mode) == "" {
+		mode = os.Getenv(CassetteModeEnv)
+	}
+	if strings.TrimSpace(path) == "" {
+		path = os.Getenv(CassetteEnv)
+	}
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	path = strings.TrimSpace(path)
+
+	cassetteMu.Lock()
+	defer cassetteMu.Unlock()
+	if activeCassette != nil {
+		activeCassette.close()
+		activeCassette = nil
+	}
+	switch mode {
+	case "", CassetteOff:
+		return nil
+	case CassetteRecord, CassetteReplay:
+	default:
+		return fmt.Errorf("unknown cassette mode %q (want %s, %s or %s)", mode, CassetteOff, CassetteRecord, CassetteReplay)
+	}
+	if path == "" {
+		return fmt.Errorf("cassette mode %s requires a cassette file (%s or -cassette)", mode, CassetteEnv)
+	}
+	c := &cassette{mode: mode, path: path}
+	if mode == CassetteReplay {
+		its, err := loadCassette(path)
+		if err != nil {
+			return err
+		}
+		c.interactions = its
+		c.pending = make(map[string][]*Interaction, len(its))
+		for _, it := range its {
+			c.pending[it.Key] = append(c.pending[it.Key], it)
+		}
+	} else if err := c.create(); err != nil {
+		return err
+	}
+	activeCassette = c
+	return nil
+}
+
+// loadCassette reads the interactions of a version 1 or version 2 cassette.
+func loadCassette(path string) ([]*Interaction, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read cassette: %w", err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	var h cassetteHeader
+	if err := dec.Decode(&h); err != nil {
+		return nil, fmt.Errorf("decode cassette %s: %w", path, err)
+	}
+	its := h.Interactions
+	for {
+		it := new(Interaction)
+		err := dec.Decode(it)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// a crash while recording leaves at most a truncated last line
+			return nil, fmt.Errorf("decode cassette %s: interaction %d: %w", path, len(its)+1, err)
+		}
+		its = append(its, it)
+	}
+	return its, nil
+}
+
+// CassetteMode returns the active cassette mode and file ("off" when disabled).
+func CassetteMode() (string, string) {
+	cassetteMu.Lock()
+	defer cassetteMu.Unlock()
+	if activeCassette == nil {
+		return CassetteOff, ""
+	}
+	return activeCassette.mode, activeCassette.path
+}
+
+// withCassette wraps m with the active cassette, if any.
+func withCassette(agent, name string, m model.ToolCallingChatModel) model.ToolCallingChatModel {
+	cassetteMu.Lock()
+	c := activeCassette
+	cassetteMu.Unlock()
+	if c == nil {
+		return m
+	}
+	return &cassetteChatModel{agent: agent, name: name, inner: m, c: c}
+}
+
+// cassetteChatModel records calls to inner, or serves them from the cassette in replay mode.
+type cassetteChatModel struct {
+	agent string
+	name  string
+	inner model.ToolCallingChatModel
+	c     *cassette
+}
+
+func (m *cassetteChatModel) WithTools(tools []*schema.ToolInfo) (model.ToolCallingChatModel, error) {
+	if m.c.mode == CassetteReplay {
+		return m, nil
+	}
+	inner, err := m.inner.WithTools(tools)
+	if err != nil {
+		return nil, err
+	}
+	return &cassetteChatModel{agent: m.agent, name: m.name, inner: inner, c: m.c}, nil
+}
+
+func (m *cassetteChatModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	key := interactionKey(m.agent, input)
+	if m.c.mode == CassetteReplay {
+		it, err := m.c.take(m.agent, key, input)
+		if err != nil {
+			return nil, err
+		}
+		if it.Error != "" {
+			return nil, errors.New(it.Error)
+		}
+		if it.Response != nil {
+			return it.Response, nil
+		}
+		return concatChunks(it.Chunks), nil
+	}
+	out, err := m.inner.Generate(ctx, input, opts...)
+	it := &Interaction{Agent: m.agent, Model: m.name, Key: key, Messages: input, Response: out}
+	if err != nil {
+		it.Error = err.Error()
+	}
+	m.c.record(it)
+	return out, err
+}
+
+func (m *cassetteChatModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	key := interactionKey(m.agent, input)
+	if m.c.mode == CassetteReplay {
+		it, err := m.c.take(m.agent, key, input)
+		if err != nil {
+			return nil, err
+		}
+		chunks := it.Chunks
+		if len(chunks) == 0 && it.Response != nil {
+			chunks = []*schema.Message{it.Response}
+		}
+		if it.Error != "" && len(chunks) == 0 {
+			return nil, errors.New(it.Error)
+		}
+		sr, sw := schema.Pipe[*schema.Message](len(chunks) + 1)
+		for _, ch := range chunks {
+			sw.Send(ch, nil)
+		}
+		if it.Error != "" {
+			sw.Send(nil, errors.New(it.Error))
+		}
+		sw.Close()
+		return sr, nil
+	}
+	in, err := m.inner.Stream(ctx, input, opts...)
+	if err != nil {
+		m.c.record(&Interaction{Agent: m.agent, Model: m.name, Key: key, Stream: true, Messages: input, Error: err.Error()})
+		return nil, err
+	}
+	// tee the stream: forward every chunk and record the whole interaction when it ends
+	sr, sw := schema.Pipe[*schema.Message](16)
+	go func() {
+		defer in.Close()
+		defer sw.Close()
+		it := &Interaction{Agent: m.agent, Model: m.name, Key: key, Stream: true, Messages: input}
+		defer m.c.record(it)
+		for {
+			ch, err := in.Recv()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				it.Error = err.Error()
+				sw.Send(nil, err)
+				return
+			}
+			it.Chunks = append(it.Chunks, ch)
+			if sw.Send(ch, nil) {
+				it.Error = "stream closed by consumer"
+				return
+			}
+		}
+	}()
+	return sr, nil
+}
+
+// create truncates the cassette file and writes the header line.
+func (c *cassette) create() error {
+	if dir := filepath.Dir(c.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("write cassette: %w", err)
+		}
+	}
+	f, err := os.OpenFile(c.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("write cassette: %w", err)
+	}
+	c.file = f
+	return c.appendLine(cassetteHeader{Version: cassetteVersion})
+}
+
+// record appends an interaction as one line. Each call is a single write to
+// the file, so a crash or Ctrl+C keeps everything recorded so far without
+// rewriting earlier interactions.
+func (c *cassette) record(it *Interaction) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.appendLine(it); err != nil {
+		fmt.Fprintf(os.Stderr, "[cassette] %v\n", err)
+	}
+}
+
+// appendLine writes v as one JSON line; callers hold c.mu (or own c exclusively).
+func (c *cassette) appendLine(v any) error {
+	if c.file == nil {
+		return fmt.Errorf("write cassette %s: file is closed", c.path)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode cassette: %w", err)
+	}
+	if _, err := c.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write cassette: %w", err)
+	}
+	return nil
+}
+
+// close releases the record file; calls recorded afterwards are reported and dropped.
+func (c *cassette) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file != nil {
+		if err := c.file.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "[cassette] close %s: %v\n", c.path, err)
+		}
+		c.file = nil
+	}
+}
+
+// take serves the next unused interaction recorded for key. Identical requests
+// are served in recorded order, so concurrent nodes replay deterministically
+// regardless of scheduling.
+func (c *cassette) take(agent, key string, input []*schema.Message) (*Interaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if q := c.pending[key]; len(q) > 0 {
+		c.pending[key] = q[1:]
+		return q[0], nil
+	}
+	return nil, c.mismatchLocked(agent, input)
+}
+
+// mismatchLocked builds the replay error, diffing the prompt against the
+// closest recorded prompt of the same agent.
+func (c *cassette) mismatchLocked(agent string, input []*schema.Message) error {
+	actual := renderMessages(input)
+	var best *Interaction
+	bestScore := -1
+	for _, it := range c.interactions {
+		if it.Agent != agent {
+			continue
+		}
+		if score := commonLines(renderMessages(it.Messages), actual); score > bestScore {
+			best, bestScore = it, score
+		}
+	}
+	if best == nil {
+		return fmt.Errorf("cassette replay mismatch: no interaction recorded for agent %s in %s", agent, c.path)
+	}
+	if best.Key == interactionKey(agent, input) {
+		return fmt.Errorf("cassette replay mismatch: agent %s made more identical requests than were recorded in %s", agent, c.path)
+	}
+	return fmt.Errorf("cassette replay mismatch: no recorded interaction for this %s prompt in %s; closest recorded prompt differs:\n%s",
+		agent, c.path, lineDiff(renderMessages(best.Messages), actual))
+}
+
+// interactionKey hashes the agent name and the full JSON encoding of the
+// request, so every field that reaches the model is part of the key: text,
+// UserInputMultiContent (and legacy MultiContent) parts including inline image
+// data, tool calls and tool results.
+func interactionKey(agent string, input []*schema.Message) string {
+	h := sha256.New()
+	h.Write([]byte(agent))
+	h.Write([]byte{0})
+	data, _ := json.Marshal(input)
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// renderMessages flattens the request into lines for diffing. It covers the
+// same content as interactionKey, so two requests with different keys always
+// render differently: multi-content text parts are split into lines and media
+// parts show their URL, or a digest of inline data.
+func renderMessages(msgs []*schema.Message) []string {
+	var lines []string
+	for _, m := range msgs {
+		if m == nil {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("[%s]", m.Role))
+		if m.Content != "" {
+			lines = append(lines, strings.Split(m.Content, "\n")...)
+		}
+		for _, p := range m.UserInputMultiContent {
+			lines = append(lines, renderInputPart(p)...)
+		}
+		for _, p := range m.MultiContent {
+			lines = append(lines, renderLegacyPart(p)...)
+		}
+		for _, tc := range m.ToolCalls {
+			lines = append(lines, fmt.Sprintf("<tool call %s %s(%s)>", tc.ID, tc.Function.Name, tc.Function.Arguments))
+		}
+		if m.ToolCallID != "" {
+			lines = append(lines, fmt.Sprintf("<tool result for %s>", m.ToolCallID))
+		}
+	}
+	return lines
+}
+
+func renderInputPart(p schema.MessageInputPart) []string {
+	var media *schema.MessagePartCommon
+	switch {
+	case p.Image != nil:
+		media = &p.Image.MessagePartCommon
+	case p.Audio != nil:
+		media = &p.Audio.MessagePartCommon
+	case p.Video != nil:
+		media = &p.Video.MessagePartCommon
+	case p.File != nil:
+		media = &p.File.MessagePartCommon
+	}
+	if media == nil {
+		return append([]string{fmt.Sprintf("<%s part>", p.Type)}, strings.Split(p.Text, "\n")...)
+	}
+	var src string
+	switch {
+	case media.URL != nil:
+		src = renderMediaURL(*media.URL)
+	case media.Base64Data != nil:
+		src = renderInline(*media.Base64Data)
+	}
+	return []string{fmt.Sprintf("<%s part %s %s>", p.Type, media.MIMEType, src)}
+}
+
+func renderLegacyPart(p schema.ChatMessagePart) []string {
+	switch {
+	case p.ImageURL != nil:
+		return []string{fmt.Sprintf("<%s part %s>", p.Type, renderMediaURL(p.ImageURL.URL))}
+	case p.AudioURL != nil:
+		return []string{fmt.Sprintf("<%s part %s>", p.Type, renderMediaURL(p.AudioURL.URL))}
+	case p.VideoURL != nil:
+		return []string{fmt.Sprintf("<%s part %s>", p.Type, renderMediaURL(p.VideoURL.URL))}
+	case p.FileURL != nil:
+		return []string{fmt.Sprintf("<%s part %s>", p.Type, renderMediaURL(p.FileURL.URL))}
+	}
+	return append([]string{fmt.Sprintf("<%s part>", p.Type)}, strings.Split(p.Text, "\n")...)
+}
+
+// renderMediaURL shows a URL as is, except data URLs, whose payload is
+// replaced by a digest to keep diffs readable.
+func renderMediaURL(u string) string {
+	if head, data, ok := strings.Cut(u, ","); ok && strings.HasPrefix(head, "data:") {
+		return head + "," + renderInline(data)
+	}
+	return u
+}
+
+func renderInline(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return fmt.Sprintf("sha256:%s (%d bytes)", hex.EncodeToString(sum[:6]), len(data))
+}
+
+func concatChunks(chunks []*schema.Message) *schema.Message {
+	if len(chunks) == 0 {
+		return schema.AssistantMessage("", nil)
+	}
+	msg, err := schema.ConcatMessages(chunks)
+	if err != nil {
+		return chunks[len(chunks)-1]
+	}
+	return msg
+}
+
+// lcs returns the longest-common-subsequence table of two line slices.
+func lcs(a, b []string) [][]int {
+	t := make([][]int, len(a)+1)
+	for i := range t {
+		t[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				t[i][j] = t[i+1][j+1] + 1
+			} else {
+				t[i][j] = max(t[i+1][j], t[i][j+1])
+			}
+		}
+	}
+	return t
+}
+
+func commonLines(a, b []string) int {
+	return lcs(a, b)[0][0]
+}
+
+// lineDiff renders a compact line diff (recorded "-", actual "+") with one
+// line of context around each change.
+func lineDiff(recorded, actual []string) string {
+	t := lcs(recorded, actual)
+	type op struct {
+		kind byte
+		text string
+	}
+	var ops []op
+	i, j := 0, 0
+	for i < len(recorded) || j < len(actual) {
+		switch {
+		case i < len(recorded) && j < len(actual) && recorded[i] == actual[j]:
+			ops = append(ops, op{' ', recorded[i]})
+			i++
+			j++
+		case i < len(recorded) && (j == len(actual) || t[i+1][j] >= t[i][j+1]):
+			ops = append(ops, op{'-', recorded[i]})
+			i++
+		default:
+			ops = append(ops, op{'+', actual[j]})
+			j++
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString("--- recorded\n+++ actual\n")
+	skipped := false
+	for k, o := range ops {
+		near := o.kind != ' ' || (k > 0 && ops[k-1].kind != ' ') || (k+1 < len(ops) && ops[k+1].kind != ' ')
+		if !near {
+			if !skipped {
+				sb.WriteString("  ...\n")
+				skipped = true
+			}
+			continue
+		}
+		skipped = false
+		fmt.Fprintf(&sb, "%c %s\n", o.kind, o.text)
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
@@ -0,0 +1,196 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// useCassette configures the cassette for one test and turns it off afterwards.
+func useCassette(t *testing.T, mode, path string) {
+	t.Helper()
+	t.Setenv(CassetteModeEnv, "")
+	t.Setenv(CassetteEnv, "")
+	if err := ConfigureCassette(mode, path); err != nil {
+		t.Fatalf("ConfigureCassette(%s): %v", mode, err)
+	}
+	t.Cleanup(func() { _ = ConfigureCassette(CassetteOff, "") })
+}
+
+// imageRequest is a vision request whose text is identical for every image.
+func imageRequest(pixels string) []*schema.Message {
+	u := "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte(pixels))
+	return []*schema.Message{
+		schema.SystemMessage("describe images"),
+		{
+			Role: schema.User,
+			UserInputMultiContent: []schema.MessageInputPart{
+				{Type: schema.ChatMessagePartTypeText, Text: "处理节点: img\nwhat is this?"},
+				{Type: schema.ChatMessagePartTypeImageURL, Image: &schema.MessageInputImage{
+					MessagePartCommon: schema.MessagePartCommon{URL: &u, MIMEType: "image/png"},
+				}},
+			},
+		},
+	}
+}
+
+func fakeInner(t *testing.T, answers ...string) model.ToolCallingChatModel {
+	t.Helper()
+	s := &FakeScript{}
+	for _, a := range answers {
+		s.Sequence = append(s.Sequence, FakeResponse{Content: a})
+	}
+	if err := s.Compile(); err != nil {
+		t.Fatalf("compile fake script: %v", err)
+	}
+	return NewFakeChatModel("vision_agent", "fake-vision", s)
+}
+
+func TestCassetteKeyIncludesMultiContentParts(t *testing.T) {
+	cat, dog := imageRequest("cat pixels"), imageRequest("dog pixels")
+	if interactionKey("vision_agent", cat) == interactionKey("vision_agent", dog) {
+		t.Fatal("requests differing only in the image part share a key")
+	}
+	if slices.Equal(renderMessages(cat), renderMessages(dog)) {
+		t.Fatalf("requests differing only in the image part render the same:\n%s", strings.Join(renderMessages(cat), "\n"))
+	}
+	if interactionKey("vision_agent", cat) != interactionKey("vision_agent", imageRequest("cat pixels")) {
+		t.Fatal("identical requests have different keys")
+	}
+}
+
+func TestCassetteRecordAppendsAndReplays(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.cassette.jsonl")
+	useCassette(t, CassetteRecord, path)
+	m := withCassette("vision_agent", "fake-vision", fakeInner(t, "a cat", "a dog", "streamed answer"))
+	ctx := context.Background()
+
+	if _, err := m.Generate(ctx, imageRequest("cat pixels")); err != nil {
+		t.Fatalf("record cat: %v", err)
+	}
+	first, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read cassette: %v", err)
+	}
+	if _, err := m.Generate(ctx, imageRequest("dog pixels")); err != nil {
+		t.Fatalf("record dog: %v", err)
+	}
+	sr, err := m.Stream(ctx, []*schema.Message{schema.UserMessage("处理节点: s\nstream please")})
+	if err != nil {
+		t.Fatalf("record stream: %v", err)
+	}
+	drain(t, sr)
+	// the stream is recorded by the tee goroutine once the consumer has drained it
+	waitForLines(t, path, 4)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read cassette: %v", err)
+	}
+	// append-only: earlier interactions are never rewritten
+	if !bytes.HasPrefix(data, first) {
+		t.Fatal("recording a call rewrote earlier lines of the cassette")
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if lines[0] != `{"version":2}` {
+		t.Errorf("header line = %s", lines[0])
+	}
+
+	// replay in a different order: the image alone selects the recorded answer
+	useCassette(t, CassetteReplay, path)
+	r := withCassette("vision_agent", "fake-vision", nil)
+	for _, c := range []struct{ pixels, want string }{{"dog pixels", "a dog"}, {"cat pixels", "a cat"}} {
+		out, err := r.Generate(ctx, imageRequest(c.pixels))
+		if err != nil {
+			t.Fatalf("replay %s: %v", c.pixels, err)
+		}
+		if out.Content != c.want {
+			t.Errorf("replay %s = %q, want %q", c.pixels, out.Content, c.want)
+		}
+	}
+	sr, err = r.Stream(ctx, []*schema.Message{schema.UserMessage("处理节点: s\nstream please")})
+	if err != nil {
+		t.Fatalf("replay stream: %v", err)
+	}
+	if got := drain(t, sr); got != "streamed answer" {
+		t.Errorf("replayed stream = %q", got)
+	}
+
+	// an unrecorded image fails with a diff that shows the image parts
+	_, err = r.Generate(ctx, imageRequest("bird pixels"))
+	if err == nil {
+		t.Fatal("replay of an unrecorded image succeeded")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "cassette replay mismatch") || !strings.Contains(msg, "- <image_url part image/png data:image/png;base64,sha256:") ||
+		!strings.Contains(msg, "+ <image_url part image/png data:image/png;base64,sha256:") {
+		t.Errorf("mismatch error does not diff the image part:\n%s", msg)
+	}
+}
+
+func TestCassetteReplaysVersion1(t *testing.T) {
+	req := []*schema.Message{schema.UserMessage("hello")}
+	doc := `{"version":1,"interactions":[{"agent":"text_agent","key":"` + interactionKey("text_agent", req) +
+		`","stream":false,"messages":[{"role":"user","content":"hello"}],"response":{"role":"assistant","content":"hi"}}]}`
+	path := filepath.Join(t.TempDir(), "old.cassette.json")
+	if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	useCassette(t, CassetteReplay, path)
+	out, err := withCassette("text_agent", "m", nil).Generate(context.Background(), req)
+	if err != nil || out.Content != "hi" {
+		t.Fatalf("replay version 1 cassette = %v, %v", out, err)
+	}
+}
+
+func TestCassetteRejectsCorruptLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.cassette.jsonl")
+	if err := os.WriteFile(path, []byte("{\"version\":2}\n{\"agent\":\"text_agent\",\"key\":\"k\"}\n{\"agent\":"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(CassetteModeEnv, "")
+	t.Setenv(CassetteEnv, "")
+	err := ConfigureCassette(CassetteReplay, path)
+	t.Cleanup(func() { _ = ConfigureCassette(CassetteOff, "") })
+	if err == nil || !strings.Contains(err.Error(), "interaction 2") {
+		t.Fatalf("ConfigureCassette on a truncated file: %v", err)
+	}
+}
+
+func drain(t *testing.T, sr *schema.StreamReader[*schema.Message]) string {
+	t.Helper()
+	defer sr.Close()
+	var sb strings.Builder
+	for {
+		ch, err := sr.Recv()
+		if errors.Is(err, io.EOF) {
+			return sb.String()
+		}
+		if err != nil {
+			t.Fatalf("stream: %v", err)
+		}
+		sb.WriteString(ch.Content)
+	}
+}
+
+func waitForLines(t *testing.T, path string, n int) {
+	t.Helper()
+	for range 200 {
+		if data, err := os.ReadFile(path); err == nil && bytes.Count(data, []byte("\n")) >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("cassette %s never reached %d lines", path, n)
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/cloudwego/eino-ext/components/model/ark"
@@ -29,15 +30,90 @@ import (
 )
 
 func NewChatModel() model.ToolCallingChatModel {
-	modelType := strings.ToLower(os.Getenv("MODEL_TYPE"))
+	return NewChatModelNamed("")
+}
+
+// NewChatModelNamed creates the configured chat model, overriding the model name
+// (ARK_MODEL / OPENAI_MODEL) when name is not empty.
+func NewChatModelNamed(name string) model.ToolCallingChatModel {
+	return NewAgentChatModel("", name)
+}
+
+// NewAgentChatModel is NewChatModelNamed for a named agent. The fake
+// provider uses the agent name to select scripted responses and the cassette
+// (see cassette.go) to key recorded interactions.
+func NewAgentChatModel(agent, name string) model.ToolCallingChatModel {
+	return ConfigFromEnv().NewAgentChatModel(agent, name)
+}
+
+// Config selects the model provider and its connection settings. Servers and
+// CLIs build it from their resolved configuration; ConfigFromEnv covers code
+// that still configures the model through environment variables.
+type Config struct {
+	// Type is the provider: openai (default), ark or fake.
+	Type string
+	// Name is the default model name, used when an agent does not set one.
+	Name    string
+	BaseURL string
+	APIKey  string
+	// ByAzure targets Azure OpenAI (openai provider only).
+	ByAzure bool
+	// MaxCompletionTokens caps the tokens generated per call (0 = provider
+	// default). Token budgets and quotas reserve this much for every call's
+	// completion on top of the estimated input.
+	MaxCompletionTokens int
+}
+
+// MaxCompletionTokensEnv caps the completion length when the model is
+// configured through the environment.
+const MaxCompletionTokensEnv = "MODEL_MAX_COMPLETION_TOKENS"
+
+// ConfigFromEnv reads MODEL_TYPE, MODEL_MAX_COMPLETION_TOKENS and the
+// provider's ARK_* / OPENAI_* / FAKE_MODEL environment variables.
+func ConfigFromEnv() Config {
+	c := Config{Type: strings.ToLower(strings.TrimSpace(os.Getenv("MODEL_TYPE")))}
+	switch c.Type {
+	case "ark":
+		c.Name, c.BaseURL, c.APIKey = os.Getenv("ARK_MODEL"), os.Getenv("ARK_BASE_URL"), os.Getenv("ARK_API_KEY")
+	case "fake":
+		c.Name = os.Getenv("FAKE_MODEL")
+	default:
+		c.Name, c.BaseURL, c.APIKey = os.Getenv("OPENAI_MODEL"), os.Getenv("OPENAI_BASE_URL"), os.Getenv("OPENAI_API_KEY")
+		c.ByAzure = os.Getenv("OPENAI_BY_AZURE") == "true"
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv(MaxCompletionTokensEnv))); err == nil && n > 0 {
+		c.MaxCompletionTokens = n
+	}
+	return c
+}
+
+// NewAgentChatModel creates the chat model for a named agent, overriding the
+// configured model name when name is not empty.
+func (c Config) NewAgentChatModel(agent, name string) model.ToolCallingChatModel {
+	// Replaying a cassette needs no provider at all (no API keys, no network)
+	if mode, _ := CassetteMode(); mode == CassetteReplay {
+		return withCassette(agent, c.ResolveModelName(name), nil)
+	}
+	return withCassette(agent, c.ResolveModelName(name), c.newProviderChatModel(agent, name))
+}
 
-	// Create Ark ChatModel when MODEL_TYPE is "ark"
-	if modelType == "ark" {
+func (c Config) newProviderChatModel(agent, name string) model.ToolCallingChatModel {
+	// Offline scripted model when the type is "fake" (see fake.go)
+	if c.Type == "fake" {
+		script, err := DefaultFakeScript()
+		if err != nil {
+			log.Fatalf("fake model script: %v", err)
+		}
+		return NewFakeChatModel(agent, c.ResolveModelName(name), script)
+	}
+
+	// Create Ark ChatModel when the type is "ark"
+	if c.Type == "ark" {
 		cm, err := ark.NewChatModel(context.Background(), &ark.ChatModelConfig{
-			// Add Ark-specific configuration from environment variables
-			APIKey:  os.Getenv("ARK_API_KEY"),
-			Model:   os.Getenv("ARK_MODEL"),
-			BaseURL: os.Getenv("ARK_BASE_URL"),
+			APIKey:    c.APIKey,
+			Model:     c.ResolveModelName(name),
+			BaseURL:   c.BaseURL,
+			MaxTokens: c.maxTokens(),
 			Thinking: &arkModel.Thinking{
 				Type: arkModel.ThinkingTypeDisabled,
 			},
@@ -50,15 +126,42 @@ func NewChatModel() model.ToolCallingChatModel {
 
 	// Create OpenAI ChatModel (default)
 	cm, err := openai.NewChatModel(context.Background(), &openai.ChatModelConfig{
-		APIKey:  os.Getenv("OPENAI_API_KEY"),
-		Model:   os.Getenv("OPENAI_MODEL"),
-		BaseURL: os.Getenv("OPENAI_BASE_URL"),
-		ByAzure: func() bool {
-			return os.Getenv("OPENAI_BY_AZURE") == "true"
-		}(),
+		APIKey:              c.APIKey,
+		Model:               c.ResolveModelName(name),
+		BaseURL:             c.BaseURL,
+		ByAzure:             c.ByAzure,
+		MaxCompletionTokens: c.maxTokens(),
 	})
 	if err != nil {
 		log.Fatalf("openai.NewChatModel failed: %v", err)
 	}
 	return cm
 }
+
+// maxTokens returns the completion cap for the provider config (nil = none).
+func (c Config) maxTokens() *int {
+	if c.MaxCompletionTokens <= 0 {
+		return nil
+	}
+	n := c.MaxCompletionTokens
+	return &n
+}
+
+// ResolveModelName returns the model name NewChatModelNamed(name) would use
+// for the configured MODEL_TYPE.
+func ResolveModelName(name string) string {
+	return ConfigFromEnv().ResolveModelName(name)
+}
+
+// ResolveModelName returns the model name NewAgentChatModel(agent, name)
+// uses: name when set, otherwise the configured name ("fake" for an unnamed
+// fake model).
+func (c Config) ResolveModelName(name string) string {
+	if name != "" {
+		return name
+	}
+	if c.Name == "" && c.Type == "fake" {
+		return "fake"
+	}
+	return c.Name
+}
@@ -0,0 +1,412 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// FakeScriptEnv points to the JSON script used by MODEL_TYPE=fake.
+// Without a script every agent answers with a deterministic echo of its input
+// and graph_supervisor answers {"used":"text"}.
+const FakeScriptEnv = "FAKE_MODEL_SCRIPT"
+
+// FakeLatencyEnv overrides the script's latency_ms (Go duration, e.g. "200ms").
+const FakeLatencyEnv = "FAKE_MODEL_LATENCY"
+
+// FakeResponse is one scripted answer.
+type FakeResponse struct {
+	Content string `json:"content,omitempty"`
+	// Error makes the call fail with this message. With ErrorAfterChunks > 0 a
+	// streaming call fails after emitting that many chunks instead of up front.
+	Error            string `json:"error,omitempty"`
+	ErrorAfterChunks int    `json:"error_after_chunks,omitempty"`
+	// LatencyMs overrides the script latency for this response.
+	LatencyMs *int `json:"latency_ms,omitempty"`
+	// Usage overrides the estimated token usage reported in ResponseMeta.
+	Usage *schema.TokenUsage `json:"usage,omitempty"`
+	// ToolCalls makes the model call tools (e.g. get_image). Every tool must be
+	// bound to the model (see WithTools); otherwise the call fails. In streaming
+	// mode the tool calls are sent in the first chunk.
+	ToolCalls []FakeToolCall `json:"tool_calls,omitempty"`
+}
+
+// FakeToolCall is one scripted tool call.
+type FakeToolCall struct {
+	Name string `json:"name"`
+	// Arguments is the JSON arguments object (a JSON string holding the object is accepted too).
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// FakeRule answers calls whose agent and input match.
+type FakeRule struct {
+	// Agent matches the agent name exactly (e.g. "text_agent", "graph_supervisor"); empty matches any.
+	Agent string `json:"agent,omitempty"`
+	// Match is a regular expression applied to the whole input (all message contents joined by newlines).
+	Match string `json:"match,omitempty"`
+	// Times limits how often the rule applies; 0 means unlimited.
+	Times int `json:"times,omitempty"`
+	// Tool matches only calls where a tool with this name is bound.
+	Tool string `json:"tool,omitempty"`
+	FakeResponse
+
+	re   *regexp.Regexp
+	used int
+}
+
+// FakeCall records one call made against a fake model.
+type FakeCall struct {
+	Agent  string `json:"agent"`
+	Model  string `json:"model"`
+	Stream bool   `json:"stream"`
+	Input  string `json:"input"`
+	// Tools are the names of the tools bound to the model for this call.
+	Tools []string `json:"tools,omitempty"`
+	// Source is how the answer was chosen: rule[<index>], sequence[<index>], default or echo.
+	Source string `json:"source"`
+}
+
+// FakeScript describes how fake models answer. Rules are tried in order, then
+// the sequence is consumed one entry per call, then Default applies; when
+// nothing is configured the model echoes its input. A script is safe for
+// concurrent use and its state (rule counters, sequence position, call log)
+// is shared by every model created from it.
+type FakeScript struct {
+	LatencyMs int `json:"latency_ms,omitempty"`
+	// ChunkSize is the number of runes per streamed chunk (default 16).
+	ChunkSize    int            `json:"chunk_size,omitempty"`
+	ChunkDelayMs int            `json:"chunk_delay_ms,omitempty"`
+	Rules        []*FakeRule    `json:"rules,omitempty"`
+	Sequence     []FakeResponse `json:"sequence,omitempty"`
+	Default      *FakeResponse  `json:"default,omitempty"`
+
+	mu    sync.Mutex
+	next  int
+	calls []FakeCall
+}
+
+// LoadFakeScript reads and compiles a script file.
+func LoadFakeScript(path string) (*FakeScript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read fake model script: %w", err)
+	}
+	var s FakeScript
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("decode fake model script %s: %w", path, err)
+	}
+	if err := s.Compile(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Compile validates the rule regular expressions. It must be called on
+// scripts built in code before use.
+func (s *FakeScript) Compile() error {
+	for i, r := range s.Rules {
+		if r.Match == "" {
+			continue
+		}
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return fmt.Errorf("fake model rule %d: %w", i, err)
+		}
+		r.re = re
+	}
+	return nil
+}
+
+// Calls returns the calls made so far, in order.
+func (s *FakeScript) Calls() []FakeCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]FakeCall(nil), s.calls...)
+}
+
+// pick chooses the response for one call and records it.
+func (s *FakeScript) pick(agent, modelName, input string, tools []string, stream bool) FakeResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	call := FakeCall{Agent: agent, Model: modelName, Stream: stream, Input: input, Tools: tools}
+	resp, ok := FakeResponse{}, false
+	for i, r := range s.Rules {
+		if (r.Agent != "" && r.Agent != agent) || (r.re != nil && !r.re.MatchString(input)) || (r.Times > 0 && r.used >= r.Times) {
+			continue
+		}
+		if r.Tool != "" && !slices.Contains(tools, r.Tool) {
+			continue
+		}
+		r.used++
+		resp, ok, call.Source = r.FakeResponse, true, fmt.Sprintf("rule[%d]", i)
+		break
+	}
+	if !ok && s.next < len(s.Sequence) {
+		resp, ok, call.Source = s.Sequence[s.next], true, fmt.Sprintf("sequence[%d]", s.next)
+		s.next++
+	}
+	if !ok && s.Default != nil {
+		resp, ok, call.Source = *s.Default, true, "default"
+	}
+	if !ok {
+		resp, call.Source = echoResponse(agent, input), "echo"
+	}
+	s.calls = append(s.calls, call)
+	return resp
+}
+
+// echoResponse is the built-in answer when the script has nothing to say.
+func echoResponse(agent, input string) FakeResponse {
+	if agent == "graph_supervisor" {
+		return FakeResponse{Content: `{"used":"text"}`}
+	}
+	// graph nodes: echo the "处理节点: <id>" (or English "Processing node: <id>") header so outputs stay distinguishable
+	for _, l := range strings.Split(input, "\n") {
+		if strings.HasPrefix(l, "处理节点: ") || strings.HasPrefix(l, "Processing node: ") {
+			return FakeResponse{Content: fmt.Sprintf("[fake %s] %s", agent, l)}
+		}
+	}
+	return FakeResponse{Content: fmt.Sprintf("[fake %s] %d chars received", agent, len([]rune(input)))}
+}
+
+var (
+	defaultFakeOnce   sync.Once
+	defaultFakeScript *FakeScript
+	defaultFakeErr    error
+)
+
+// DefaultFakeScript returns the process-wide script loaded from FAKE_MODEL_SCRIPT
+// (an empty script when unset).
+func DefaultFakeScript() (*FakeScript, error) {
+	defaultFakeOnce.Do(func() {
+		if path := strings.TrimSpace(os.Getenv(FakeScriptEnv)); path != "" {
+			defaultFakeScript, defaultFakeErr = LoadFakeScript(path)
+		} else {
+			defaultFakeScript = &FakeScript{}
+		}
+		if defaultFakeErr == nil {
+			if v := strings.TrimSpace(os.Getenv(FakeLatencyEnv)); v != "" {
+				d, err := time.ParseDuration(v)
+				if err != nil {
+					defaultFakeErr = fmt.Errorf("invalid %s %q: %w", FakeLatencyEnv, v, err)
+					return
+				}
+				defaultFakeScript.LatencyMs = int(d.Milliseconds())
+			}
+		}
+	})
+	return defaultFakeScript, defaultFakeErr
+}
+
+// FakeChatModel is an offline model.ToolCallingChatModel driven by a FakeScript.
+type FakeChatModel struct {
+	agent  string
+	name   string
+	script *FakeScript
+	tools  []string
+}
+
+// NewFakeChatModel creates a fake model answering as agent; name is reported as the model name.
+func NewFakeChatModel(agent, name string, script *FakeScript) *FakeChatModel {
+	if script == nil {
+		script = &FakeScript{}
+	}
+	return &FakeChatModel{agent: agent, name: name, script: script}
+}
+
+// WithTools returns a copy of the model bound to tools; scripted tool calls may only use bound tools.
+func (m *FakeChatModel) WithTools(tools []*schema.ToolInfo) (model.ToolCallingChatModel, error) {
+	names := make([]string, 0, len(tools))
+	for _, t := range tools {
+		if t != nil {
+			names = append(names, t.Name)
+		}
+	}
+	c := *m
+	c.tools = names
+	return &c, nil
+}
+
+// Tools returns the names of the bound tools.
+func (m *FakeChatModel) Tools() []string {
+	return append([]string(nil), m.tools...)
+}
+
+func (m *FakeChatModel) Generate(ctx context.Context, input []*schema.Message, _ ...model.Option) (*schema.Message, error) {
+	text := joinMessages(input)
+	resp := m.script.pick(m.agent, m.name, text, m.Tools(), false)
+	if err := m.wait(ctx, resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	calls, err := m.toolCalls(resp)
+	if err != nil {
+		return nil, err
+	}
+	msg := schema.AssistantMessage(resp.Content, calls)
+	msg.ResponseMeta = &schema.ResponseMeta{FinishReason: "stop", Usage: fakeUsage(resp, text)}
+	return msg, nil
+}
+
+func (m *FakeChatModel) Stream(ctx context.Context, input []*schema.Message, _ ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	text := joinMessages(input)
+	resp := m.script.pick(m.agent, m.name, text, m.Tools(), true)
+	if err := m.wait(ctx, resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" && resp.ErrorAfterChunks <= 0 {
+		return nil, errors.New(resp.Error)
+	}
+	calls, err := m.toolCalls(resp)
+	if err != nil {
+		return nil, err
+	}
+	chunks := splitRunes(resp.Content, m.script.chunkSize())
+	delay := time.Duration(m.script.ChunkDelayMs) * time.Millisecond
+	sr, sw := schema.Pipe[*schema.Message](len(chunks) + 1)
+	go func() {
+		defer sw.Close()
+		for i, c := range chunks {
+			if resp.Error != "" && i == resp.ErrorAfterChunks {
+				sw.Send(nil, errors.New(resp.Error))
+				return
+			}
+			if i > 0 && delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					sw.Send(nil, ctx.Err())
+					return
+				}
+			}
+			msg := schema.AssistantMessage(c, nil)
+			if i == 0 {
+				msg.ToolCalls = calls
+			}
+			if i == len(chunks)-1 && resp.Error == "" {
+				msg.ResponseMeta = &schema.ResponseMeta{FinishReason: "stop", Usage: fakeUsage(resp, text)}
+			}
+			if sw.Send(msg, nil) {
+				return
+			}
+		}
+		if resp.Error != "" {
+			sw.Send(nil, errors.New(resp.Error))
+		}
+	}()
+	return sr, nil
+}
+
+// toolCalls converts the scripted tool calls, checking that each tool is bound.
+func (m *FakeChatModel) toolCalls(resp FakeResponse) ([]schema.ToolCall, error) {
+	if len(resp.ToolCalls) == 0 {
+		return nil, nil
+	}
+	calls := make([]schema.ToolCall, 0, len(resp.ToolCalls))
+	for i, tc := range resp.ToolCalls {
+		if !slices.Contains(m.tools, tc.Name) {
+			return nil, fmt.Errorf("fake model %s: tool %q is not bound (bound: %s)", m.agent, tc.Name, strings.Join(m.tools, ", "))
+		}
+		args := "{}"
+		if raw := strings.TrimSpace(string(tc.Arguments)); raw != "" {
+			args = raw
+			var str string
+			if json.Unmarshal(tc.Arguments, &str) == nil {
+				args = str
+			}
+		}
+		index := i
+		calls = append(calls, schema.ToolCall{
+			Index:    &index,
+			ID:       fmt.Sprintf("call_%s_%d", tc.Name, i),
+			Type:     "function",
+			Function: schema.FunctionCall{Name: tc.Name, Arguments: args},
+		})
+	}
+	return calls, nil
+}
+
+// wait applies the response latency, honouring cancellation.
+func (m *FakeChatModel) wait(ctx context.Context, resp FakeResponse) error {
+	ms := m.script.LatencyMs
+	if resp.LatencyMs != nil {
+		ms = *resp.LatencyMs
+	}
+	if ms <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-time.After(time.Duration(ms) * time.Millisecond):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *FakeScript) chunkSize() int {
+	if s.ChunkSize <= 0 {
+		return 16
+	}
+	return s.ChunkSize
+}
+
+func joinMessages(input []*schema.Message) string {
+	parts := make([]string, 0, len(input))
+	for _, m := range input {
+		if m == nil {
+			continue
+		}
+		parts = append(parts, m.Content)
+		// multimodal user messages carry their text in parts; images are not matched
+		for _, p := range m.UserInputMultiContent {
+			if p.Type == schema.ChatMessagePartTypeText {
+				parts = append(parts, p.Text)
+			}
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// splitRunes splits s into chunks of n runes; an empty string yields one empty chunk.
+func splitRunes(s string, n int) []string {
+	r := []rune(s)
+	if len(r) == 0 {
+		return []string{""}
+	}
+	out := make([]string, 0, len(r)/n+1)
+	for i := 0; i < len(r); i += n {
+		end := i + n
+		if end > len(r) {
+			end = len(r)
+		}
+		out = append(out, string(r[i:end]))
+	}
+	return out
+}
+
+// fakeUsage returns the scripted usage or a rough estimate of one token per 4 bytes.
+func fakeUsage(resp FakeResponse, input string) *schema.TokenUsage {
+	if resp.Usage != nil {
+		u := *resp.Usage
+		if u.TotalTokens == 0 {
+			u.TotalTokens = u.PromptTokens + u.CompletionTokens
+		}
+		return &u
+	}
+	prompt, completion := (len(input)+3)/4, (len(resp.Content)+3)/4
+	return &schema.TokenUsage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+}
+
+var _ model.ToolCallingChatModel = (*FakeChatModel)(nil)